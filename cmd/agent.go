@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+var agentForwarder *middleware.Agent
+
+// forwardRun pushes the bundle of a finished collection run to the server in agent mode.
+func forwardRun(runPath string) {
+	if agentForwarder == nil {
+		return
+	}
+
+	err := agentForwarder.SendRun(filepath.Base(runPath), func(w io.Writer) error {
+		return writeArtifactArchive(w, runPath)
+	})
+	if err != nil {
+		log.Printf("error while spooling %s for server - %s", filepath.Base(runPath), err)
+	}
+}
+
+// runAgentCommand runs a command of the server on the pots of the sensor.
+func runAgentCommand(ctx context.Context, sensor middleware.Sensor, command middleware.AgentCommand) error {
+	switch command.Action {
+	case middleware.CommandDeploy:
+		if middleware.IsExistPotName(ctx, sensor.Runtime, command.Pot) {
+			return fmt.Errorf("%s pot already exists", command.Pot)
+		}
+		potLabels, dockerPorts, err := readPotLabels(command.Pot, command.Profile, nil, command.Ports, isIngressSupported(sensor), false)
+		if err != nil {
+			return err
+		}
+		spec := middleware.PotSpec{Name: command.Pot, Image: command.Image, Ports: dockerPorts, Environments: command.Environments, Labels: potLabels}
+		if err := sensor.Runtime.CreatePot(ctx, spec); err != nil {
+			middleware.RemovePot(ctx, sensor.Runtime, command.Pot)
+			return err
+		}
+		return nil
+
+	case middleware.CommandReset:
+		if !beginCollecting(command.Pot) {
+			return fmt.Errorf("%s pot is already being collected", command.Pot)
+		}
+		defer endCollecting(command.Pot)
+
+		pot, err := middleware.ReadPot(ctx, sensor.Runtime, command.Pot)
+		if err != nil {
+			return err
+		}
+		return resetPot(ctx, sensor, pot, "server")
+
+	case middleware.CommandCollect:
+		return collectPot(ctx, sensor, command.Pot, middleware.TriggerManual)
+	}
+
+	return fmt.Errorf("unknown %s command", command.Action)
+}
+
+// readPotNames lists the pots of the sensor for heartbeats.
+func readPotNames(ctx context.Context, sensor middleware.Sensor) []string {
+	pots, err := middleware.ReadAllPots(ctx, sensor.Runtime)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, pot := range pots {
+		names = append(names, pot.Name)
+	}
+	return names
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Collect pots of this sensor and push events and runs to a central server",
+	Long: "Capture and collect the pots of the local daemon like collect, pushing events and run\n" +
+		"bundles to honeypot server over mutual TLS. What the server does not accept is spooled\n" +
+		"to disk and pushed when it is back. Heartbeats report the agent and fetch its commands.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		sensor, err := localSensor()
+		if err != nil {
+			panic(err)
+		}
+
+		prepareOutputRoot()
+
+		tlsConfig, err := middleware.NewTLSConfig(agentCA, agentCert, agentKey, false)
+		if err != nil {
+			log.Printf("error while reading agent certificates - %s", err)
+			os.Exit(1)
+		}
+		spoolDir := agentSpool
+		if spoolDir == "" {
+			spoolDir = filepath.Join(outputRoot, "spool", "agent")
+		}
+		hostname, _ := os.Hostname()
+
+		agent, err := middleware.NewAgent(middleware.AgentConfig{
+			URL:               agentServer,
+			TLS:               tlsConfig,
+			Version:           version,
+			Hostname:          hostname,
+			SpoolDir:          spoolDir,
+			HeartbeatInterval: agentHeartbeat,
+			Pots: func() []string {
+				return readPotNames(ctx, sensor)
+			},
+			Execute: func(command middleware.AgentCommand) error {
+				return runAgentCommand(ctx, sensor, command)
+			},
+		})
+		if err != nil {
+			log.Printf("error while starting agent - %s", err)
+			os.Exit(1)
+		}
+		agentForwarder = agent
+		go agent.Run(middleware.SubscribeEvents(1024))
+		log.Printf("Push events and runs to %s", agentServer)
+
+		startCollectDaemon(ctx, []middleware.Sensor{sensor})
+
+		if metricsListen != "" {
+			go serveMetrics(metricsListen)
+		}
+
+		select {}
+	},
+}
+
+var (
+	agentServer    string        // URL of the central server
+	agentCA        string        // CA certificate verifying the server
+	agentCert      string        // Client certificate naming the agent
+	agentKey       string        // Key of the client certificate
+	agentSpool     string        // Directory of events and runs waiting for the server
+	agentHeartbeat time.Duration // Interval of heartbeats
+)
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	agentCmd.Flags().StringVar(&agentServer, "server", "", "URL of honeypot server, e.g. https://collector:8443")
+	agentCmd.Flags().StringVar(&agentCA, "ca", "", "CA certificate verifying the server")
+	agentCmd.Flags().StringVar(&agentCert, "cert", "", "Client certificate of the agent, its common name naming the agent")
+	agentCmd.Flags().StringVar(&agentKey, "key", "", "Key of the client certificate")
+	agentCmd.Flags().StringVar(&agentSpool, "spool", "", "Directory of events and runs waiting for the server (default <path>/spool/agent)")
+	agentCmd.Flags().DurationVar(&agentHeartbeat, "heartbeat", 30*time.Second, "Interval of heartbeats")
+	agentCmd.Flags().StringVar(&metricsListen, "metrics-listen", "", "Address serving prometheus /metrics, e.g. :9150")
+	addCollectFlags(agentCmd)
+
+	agentCmd.MarkFlagRequired("path")
+	agentCmd.MarkFlagRequired("server")
+	agentCmd.MarkFlagRequired("ca")
+	agentCmd.MarkFlagRequired("cert")
+	agentCmd.MarkFlagRequired("key")
+}
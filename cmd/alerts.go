@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+func loadAlertConfig() middleware.AlertConfig {
+	config, err := middleware.LoadAlertConfig(alertRulesFile)
+	if err != nil {
+		log.Printf("error while loading alert rules %s - %s", alertRulesFile, err)
+		os.Exit(1)
+	}
+	return config
+}
+
+// startAlertEngine evaluates published events against the rules of --alerts.
+func startAlertEngine() {
+	if alertRulesFile == "" {
+		return
+	}
+
+	engine, err := middleware.NewAlertEngineFromConfig(loadAlertConfig())
+	if err != nil {
+		log.Printf("error while loading alert rules %s - %s", alertRulesFile, err)
+		os.Exit(1)
+	}
+
+	log.Printf("Loaded alert rules from %s", alertRulesFile)
+	go engine.Run(middleware.SubscribeEvents(1024))
+}
+
+var alertsCmd = &cobra.Command{
+	Use:   "alerts",
+	Short: "Check alert rules and notifiers",
+}
+
+var alertsCheckCmd = &cobra.Command{
+	Use: "check",
+	Run: func(cmd *cobra.Command, args []string) {
+		config := loadAlertConfig()
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Rule", "Kinds", "Threshold", "Window", "Suppress", "Group By", "Notify"})
+		for _, rule := range config.Rules {
+			notify := strings.Join(rule.Notify, ",")
+			if notify == "" {
+				notify = "all"
+			}
+			table.Append([]string{
+				rule.Name,
+				strings.Join(rule.Kinds, ","),
+				strconv.Itoa(rule.Threshold),
+				rule.Window.String(),
+				rule.Suppress.String(),
+				strings.Join(rule.GroupBy, ","),
+				notify,
+			})
+		}
+		table.Render()
+
+		if _, err := middleware.NewAlertEngineFromConfig(config); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d rule(s), %d notifier(s) ok\n", len(config.Rules), len(config.Notifiers))
+	},
+}
+
+var alertsTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a test alert to the notifiers",
+	Run: func(cmd *cobra.Command, args []string) {
+		config := loadAlertConfig()
+
+		alert := middleware.Alert{
+			Rule:     "test",
+			Severity: middleware.SeverityInfo,
+			Count:    1,
+			Time:     time.Now(),
+			Events: []middleware.Event{{
+				Time:    time.Now(),
+				Pot:     "test",
+				Kind:    middleware.EventConnection,
+				Message: "test alert of honeypot",
+			}},
+			Message: "[INFO] test alert of honeypot",
+		}
+
+		failed := false
+		for _, notifierConfig := range config.Notifiers {
+			if testNotifier != "" && notifierConfig.Name != testNotifier {
+				continue
+			}
+
+			notifier, err := middleware.NewNotifier(notifierConfig)
+			if err == nil {
+				err = notifier.Notify(alert)
+			}
+			if err != nil {
+				log.Printf("error while sending test alert to %s - %s", notifierConfig.Name, err)
+				failed = true
+				continue
+			}
+			log.Printf("Send test alert to %s", notifierConfig.Name)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	alertRulesFile string // Path of alert rules YAML
+	testNotifier   string // Notifier receiving the test alert
+)
+
+func init() {
+	rootCmd.AddCommand(alertsCmd)
+
+	alertsCmd.PersistentFlags().StringVarP(&alertRulesFile, "file", "f", "", "Path of alert rules YAML")
+	alertsCmd.MarkPersistentFlagRequired("file")
+	alertsTestCmd.Flags().StringVar(&testNotifier, "notifier", "", "Name of notifier to test (default all)")
+
+	alertsCmd.AddCommand(alertsCheckCmd, alertsTestCmd)
+}
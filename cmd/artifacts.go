@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+func addRetentionFlags(command *cobra.Command) {
+	command.Flags().DurationVar(&retentionMaxAge, "max-age", 0, "Prune runs older than this (0 to disable)")
+	command.Flags().StringVar(&retentionMaxSize, "max-size", "", "Prune oldest runs until total size fits, e.g. 50GB")
+	command.Flags().IntVar(&retentionKeepLast, "keep-last", 0, "Keep only the newest runs of each pot (0 to disable)")
+}
+
+func retentionPolicy() middleware.RetentionPolicy {
+	policy := middleware.RetentionPolicy{
+		MaxAge:   retentionMaxAge,
+		KeepLast: retentionKeepLast,
+	}
+
+	if retentionMaxSize != "" {
+		size, err := units.FromHumanSize(retentionMaxSize)
+		if err != nil {
+			log.Printf("invalid max size %s - %s", retentionMaxSize, err)
+			os.Exit(1)
+		}
+		policy.MaxTotalSize = size
+	}
+
+	return policy
+}
+
+func readArtifactRunArg(args []string) middleware.ArtifactRun {
+	if len(args) != 1 {
+		log.Println("artifact run name is empty. terminating program")
+		os.Exit(1)
+	}
+
+	run, err := middleware.ReadArtifactRun(outputRoot, args[0])
+	if err != nil {
+		log.Printf("%s - %s", args[0], err)
+		os.Exit(1)
+	}
+
+	return run
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+var artifactsCmd = &cobra.Command{
+	Use:   "artifacts",
+	Short: "Manage collected artifact runs",
+}
+
+var artifactsListCmd = &cobra.Command{
+	Use: "list",
+	Run: func(cmd *cobra.Command, args []string) {
+		runs, err := middleware.ReadArtifactRuns(outputRoot)
+		if err != nil {
+			panic(err)
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Run", "Pot", "Collected", "Size", "Compressed", "Flagged"})
+
+		var data [][]string
+		for _, run := range runs {
+			if potName != "" && run.Pot != potName {
+				continue
+			}
+			data = append(data, []string{
+				run.Name,
+				run.Pot,
+				run.Time.Format(time.RFC3339),
+				units.HumanSize(float64(run.Size)),
+				strconv.FormatBool(run.Compressed),
+				strconv.FormatBool(run.Flagged),
+			})
+		}
+
+		table.AppendBulk(data)
+		table.Render()
+	},
+}
+
+var artifactsSizeCmd = &cobra.Command{
+	Use: "size",
+	Run: func(cmd *cobra.Command, args []string) {
+		runs, err := middleware.ReadArtifactRuns(outputRoot)
+		if err != nil {
+			panic(err)
+		}
+
+		potSizes := make(map[string]int64)
+		potCounts := make(map[string]int)
+		var potNames []string
+		var total int64
+
+		for _, run := range runs {
+			if _, found := potSizes[run.Pot]; !found {
+				potNames = append(potNames, run.Pot)
+			}
+			potSizes[run.Pot] += run.Size
+			potCounts[run.Pot]++
+			total += run.Size
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Pot", "Runs", "Size"})
+		for _, name := range potNames {
+			table.Append([]string{name, strconv.Itoa(potCounts[name]), units.HumanSize(float64(potSizes[name]))})
+		}
+		table.SetFooter([]string{"Total", strconv.Itoa(len(runs)), units.HumanSize(float64(total))})
+		table.Render()
+	},
+}
+
+var artifactsPruneCmd = &cobra.Command{
+	Use: "prune",
+	Run: func(cmd *cobra.Command, args []string) {
+		runs, err := middleware.ReadArtifactRuns(outputRoot)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, run := range retentionPolicy().Expired(runs, time.Now()) {
+			if pruneDryRun {
+				log.Printf("Would prune artifact run %s (%s)", run.Name, units.HumanSize(float64(run.Size)))
+				continue
+			}
+			if err := middleware.RemoveArtifactRun(run); err != nil {
+				log.Printf("error while pruning %s - %s", run.Name, err)
+				continue
+			}
+			log.Printf("Prune artifact run %s (%s)", run.Name, units.HumanSize(float64(run.Size)))
+		}
+	},
+}
+
+var artifactsCompressCmd = &cobra.Command{
+	Use: "compress [run]",
+	Run: func(cmd *cobra.Command, args []string) {
+		var runs []middleware.ArtifactRun
+		if len(args) > 0 {
+			runs = append(runs, readArtifactRunArg(args))
+		} else {
+			allRuns, err := middleware.ReadArtifactRuns(outputRoot)
+			if err != nil {
+				panic(err)
+			}
+			for _, run := range allRuns {
+				if time.Since(run.Time) >= compressOlderThan {
+					runs = append(runs, run)
+				}
+			}
+		}
+
+		for _, run := range runs {
+			if run.Compressed {
+				continue
+			}
+			if err := compressArtifactRun(run); err != nil {
+				log.Printf("error while compressing %s - %s", run.Name, err)
+				continue
+			}
+			log.Printf("Compress artifact run %s", run.Name)
+		}
+	},
+}
+
+var artifactsExportCmd = &cobra.Command{
+	Use: "export <run>",
+	Run: func(cmd *cobra.Command, args []string) {
+		run := readArtifactRunArg(args)
+
+		destination := exportOutput
+		if destination == "" {
+			destination = run.Name + middleware.CompressedExtension
+		}
+
+		var err error
+		if run.Compressed {
+			err = copyFile(run.Path, destination)
+		} else {
+			err = compressArtifacts(run.Path, destination)
+		}
+		if err != nil {
+			log.Printf("error while exporting %s - %s", run.Name, err)
+			os.Exit(1)
+		}
+
+		log.Printf("Export artifact run %s to %s", run.Name, destination)
+	},
+}
+
+func flagArtifactCommand(use string, flagged bool) *cobra.Command {
+	return &cobra.Command{
+		Use: use + " <run>",
+		Run: func(cmd *cobra.Command, args []string) {
+			run := readArtifactRunArg(args)
+			if err := middleware.FlagArtifactRun(outputRoot, run.Name, flagged); err != nil {
+				panic(err)
+			}
+			fmt.Printf("%s flagged: %t\n", run.Name, flagged)
+		},
+	}
+}
+
+var (
+	retentionMaxAge   time.Duration // Age of pruned runs
+	retentionMaxSize  string        // Total size of kept runs
+	retentionKeepLast int           // Runs kept per pot
+	lifecycleInterval time.Duration // Interval of retention and compression
+	compressOlderThan time.Duration // Age of runs compressed by artifacts compress
+	pruneDryRun       bool          // Only show runs pruned by artifacts prune
+	exportOutput      string        // Path of exported archive
+)
+
+func init() {
+	rootCmd.AddCommand(artifactsCmd)
+
+	artifactsCmd.PersistentFlags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	artifactsCmd.MarkPersistentFlagRequired("path")
+
+	artifactsListCmd.Flags().StringVarP(&potName, "name", "n", "", "Name of pot")
+	addRetentionFlags(artifactsPruneCmd)
+	artifactsPruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only show runs which would be pruned")
+	artifactsCompressCmd.Flags().DurationVar(&compressOlderThan, "older-than", 0, "Compress runs older than this")
+	artifactsExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Path of exported tar.gz")
+
+	artifactsCmd.AddCommand(artifactsListCmd, artifactsSizeCmd, artifactsPruneCmd, artifactsCompressCmd, artifactsExportCmd,
+		flagArtifactCommand("flag", true), flagArtifactCommand("unflag", false))
+}
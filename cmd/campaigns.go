@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// correlateEvents correlates every event of the time range of the filter, whatever the pot, kind
+// and source of the filter, and sets the actor and campaign of the events.
+func correlateEvents(store *middleware.Store, filter middleware.EventFilter, events []middleware.Event) (*middleware.Correlation, error) {
+	window := middleware.EventFilter{Since: filter.Since, Until: filter.Until}
+	all, err := store.QueryEvents(window)
+	if err != nil {
+		return nil, err
+	}
+	enrichEvents(all)
+
+	correlation := middleware.Correlate(all)
+	correlation.Annotate(events)
+	return correlation, nil
+}
+
+func actorRows(actors []middleware.Actor) [][]string {
+	var rows [][]string
+	for _, actor := range actors {
+		rows = append(rows, []string{
+			actor.ID, strings.Join(actor.IPs, ", "), strings.Join(actor.Countries, ", "), strings.Join(actor.Pots, ", "),
+			strconv.Itoa(actor.Events), strconv.Itoa(actor.Credentials), strings.Join(actor.HASSH, ", "), actor.Campaign,
+			formatTime(actor.First), formatTime(actor.Last),
+		})
+	}
+	return rows
+}
+
+func campaignRows(campaigns []middleware.Campaign) [][]string {
+	var rows [][]string
+	for _, campaign := range campaigns {
+		rows = append(rows, []string{
+			campaign.ID, strconv.Itoa(len(campaign.Actors)), strconv.Itoa(len(campaign.IPs)), strings.Join(campaign.Pots, ", "),
+			strconv.Itoa(campaign.Events), strings.Join(campaign.Playbooks, ", "), strconv.Itoa(len(campaign.Files)), strconv.Itoa(len(campaign.URLs)),
+			formatTime(campaign.First), formatTime(campaign.Last),
+		})
+	}
+	return rows
+}
+
+var campaignsCmd = &cobra.Command{
+	Use:   "campaigns",
+	Short: "Correlate attackers across pots into actors and campaigns",
+	Long: `Group source addresses running the same credential list into actors, and actors sharing
+dropped files, download urls or command playbooks into campaigns.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		filter := readEventFilter()
+		correlation, err := correlateEvents(middleware.NewStore(eventDBPath()), filter, nil)
+		if err != nil {
+			log.Printf("error while querying events - %s", err)
+			os.Exit(1)
+		}
+
+		if campaignActors {
+			actors := []middleware.Actor{}
+			for _, actor := range correlation.Actors {
+				if len(actor.Pots) >= campaignMinPots && (potName == "" || containsString(actor.Pots, potName)) {
+					actors = append(actors, actor)
+				}
+			}
+			err = writeRecords(os.Stdout, campaignFormat, []string{"Actor", "IPs", "Countries", "Pots", "Events", "Credentials", "HASSH", "Campaign", "First Seen", "Last Seen"}, actorRows(actors), actors)
+		} else {
+			campaigns := []middleware.Campaign{}
+			for _, campaign := range correlation.Campaigns {
+				if len(campaign.Pots) >= campaignMinPots && (potName == "" || containsString(campaign.Pots, potName)) {
+					campaigns = append(campaigns, campaign)
+				}
+			}
+			err = writeRecords(os.Stdout, campaignFormat, []string{"Campaign", "Actors", "IPs", "Pots", "Events", "Playbooks", "Files", "URLs", "First Seen", "Last Seen"}, campaignRows(campaigns), campaigns)
+		}
+
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	campaignActors  bool   // List actors instead of campaigns
+	campaignMinPots int    // Minimum pots of listed rows
+	campaignFormat  string // Output format
+)
+
+func init() {
+	rootCmd.AddCommand(campaignsCmd)
+
+	campaignsCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	campaignsCmd.Flags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	campaignsCmd.Flags().StringVarP(&potName, "name", "n", "", "Only actors or campaigns seen on this pot")
+	campaignsCmd.Flags().StringVar(&querySince, "since", "168h", "Start of time range (RFC3339, YYYY-MM-DD or duration like 168h)")
+	campaignsCmd.Flags().StringVar(&queryUntil, "until", "", "End of time range (RFC3339, YYYY-MM-DD or duration like 24h)")
+	campaignsCmd.Flags().BoolVar(&campaignActors, "actors", false, "List actors instead of campaigns")
+	campaignsCmd.Flags().IntVar(&campaignMinPots, "min-pots", 0, "Only rows seen on at least this many pots")
+	campaignsCmd.Flags().StringVarP(&campaignFormat, "format", "o", "table", "Output format (table, json, csv)")
+}
@@ -13,6 +13,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -22,26 +24,97 @@ import (
 	"github.com/bunseokbot/Honey-V/middleware"
 )
 
-func captureNetworkPacket(ctx context.Context, cli *client.Client, stopCapture chan string, resumeCapture chan string) {
-	managedPots := make(map[string]types.NetworkResource)
+func startPotCapture(network middleware.PotNetwork) {
+	if !middleware.HasCollector(network.Labels, "pcap") {
+		return
+	}
+
+	if _, err := os.Stat(filepath.Join(outputRoot, network.Name)); os.IsNotExist(err) {
+		_ = os.Mkdir(filepath.Join(outputRoot, network.Name), os.ModePerm)
+	}
+
+	if err := middleware.StartCapture(filepath.Join(outputRoot, network.Name, "network.pcap"), network); err != nil {
+		log.Printf("error while starting %s network packet capture - %s", network.Name, err)
+	}
+}
+
+func startPotIngress(ctx context.Context, cli *client.Client, network middleware.PotNetwork) {
+	if cli == nil || !middleware.IsIngressPot(network.Labels) || middleware.IsIngressStarted(network.Name) {
+		return
+	}
+
+	ports, err := middleware.ParseIngressPorts(strings.Split(network.Labels[middleware.IngressLabel], ","))
+	if err != nil {
+		log.Printf("error while reading %s pot ingress - %s", network.Name, err)
+		return
+	}
+
+	ipAddress, err := readPotAddress(ctx, cli, network.Name)
+	if err != nil {
+		log.Printf("error while reading %s pot address - %s", network.Name, err)
+		return
+	}
+
+	if err := middleware.StartIngress(network.Name, ports, ipAddress); err != nil {
+		log.Printf("error while starting %s pot ingress - %s", network.Name, err)
+		return
+	}
+
+	log.Printf("serving %s pot ingress to %s", network.Name, ipAddress)
+}
+
+// readPotAddress returns the address of the running container of the pot.
+func readPotAddress(ctx context.Context, cli *client.Client, potName string) (string, error) {
+	pot, err := middleware.ReadPot(ctx, middleware.DockerRuntime{Client: cli}, potName)
+	if err != nil {
+		return "", err
+	}
+
+	for _, container := range pot.Containers {
+		if container.State == "running" {
+			return middleware.ReadContainerAddress(ctx, cli, container.ID, potName)
+		}
+	}
+
+	return "", fmt.Errorf("running container of %s pot not found", potName)
+}
+
+func switchPotIngress(ctx context.Context, cli *client.Client, potName string) {
+	if !middleware.IsIngressStarted(potName) {
+		return
+	}
+
+	ipAddress, err := readPotAddress(ctx, cli, potName)
+	if err != nil {
+		log.Printf("error while reading %s pot address - %s", potName, err)
+		return
+	}
+
+	_ = middleware.SwitchIngress(potName, ipAddress)
+}
 
-	go manageNetworkPacketCapture(ctx, cli, stopCapture, resumeCapture)
+func captureNetworkPacket(ctx context.Context, sensor middleware.Sensor) {
+	managedPots := make(map[string]middleware.PotNetwork)
 
 	for {
 		timer := time.NewTimer(time.Second * 5)
-		networks, _ := middleware.ReadAllPotNetworks(ctx, cli)
+		networks, err := sensor.Runtime.ReadPotNetworks(ctx)
+		if err != nil {
+			// keep capturing known pots until the runtime answers again
+			<-timer.C
+			continue
+		}
 		for _, network := range networks {
 			if _, found := managedPots[network.ID]; !found {
 				// new pot added
 				log.Printf("new %s pot detected\n", network.Name)
 				managedPots[network.ID] = network
 
-				if _, err := os.Stat(filepath.Join(outputRoot, network.Name)); os.IsNotExist(err) {
-					_ = os.Mkdir(filepath.Join(outputRoot, network.Name), os.ModePerm)
-				}
-
-				go middleware.DumpNetwork(stopCapture, filepath.Join(outputRoot, network.Name, "network.pcap"), network)
+				startPotCapture(network)
 			}
+
+			// pot container may not be running yet when detected
+			startPotIngress(ctx, sensor.Client, network)
 		}
 
 		for _, pot := range managedPots {
@@ -49,9 +122,10 @@ func captureNetworkPacket(ctx context.Context, cli *client.Client, stopCapture c
 				// old pot found
 				log.Printf("old %s pot detected\n", pot.Name)
 				delete(managedPots, pot.ID)
-				// send signal to stop capturing dump
-				log.Println("send signal to stop dumping network packet.")
-				stopCapture <- pot.Name
+				// stop capturing dump
+				log.Println("stop dumping network packet.")
+				middleware.StopCapture(pot.Name)
+				middleware.StopIngress(pot.Name)
 			}
 		}
 
@@ -59,42 +133,24 @@ func captureNetworkPacket(ctx context.Context, cli *client.Client, stopCapture c
 	}
 }
 
-func manageNetworkPacketCapture(ctx context.Context, cli *client.Client, stopCapture chan string, resumeCapture chan string) {
-	for {
-		select {
-		case message := <-resumeCapture:
-			log.Printf("resume %s network packet capture", message)
-			network, err := middleware.ReadPotNetwork(ctx, cli, message)
-			if err != nil {
-				panic(err)
-			}
-
-			if _, err := os.Stat(filepath.Join(outputRoot, message)); os.IsNotExist(err) {
-				_ = os.Mkdir(filepath.Join(outputRoot, message), os.ModePerm)
-			}
-
-			go middleware.DumpNetwork(stopCapture, filepath.Join(outputRoot, message, "network.pcap"), network)
-		}
-	}
-}
-
-func compressArtifacts(potName string) error {
-	artifactPath := filepath.Join(outputRoot, potName)
+func compressArtifacts(artifactPath string, tarPath string) error {
 	if _, err := os.Stat(artifactPath); os.IsNotExist(err) {
 		return err
 	}
 
-	tarFile, err := os.Create(filepath.Join(
-		outputRoot,
-		fmt.Sprintf("%s_%d.tar.gz", potName, time.Now().Unix())))
+	tarFile, err := os.Create(tarPath)
 	if err != nil {
 		return err
 	}
 	defer tarFile.Close()
 
-	var gzipWriter *gzip.Writer
+	return writeArtifactArchive(tarFile, artifactPath)
+}
 
-	if gzipWriter, err = gzip.NewWriterLevel(tarFile, gzip.BestCompression); err != nil {
+// writeArtifactArchive streams the directory as tar.gz with names relative to its parent.
+func writeArtifactArchive(w io.Writer, artifactPath string) error {
+	gzipWriter, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
 		return err
 	}
 	defer gzipWriter.Close()
@@ -102,7 +158,9 @@ func compressArtifacts(potName string) error {
 	tw := tar.NewWriter(gzipWriter)
 	defer tw.Close()
 
-	_ = filepath.Walk(artifactPath, func(path string, info os.FileInfo, err error) error {
+	rootPath := filepath.Dir(artifactPath)
+
+	return filepath.Walk(artifactPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -116,7 +174,11 @@ func compressArtifacts(potName string) error {
 			return err
 		}
 
-		header.Name = filepath.ToSlash(path)
+		relativePath, err := filepath.Rel(rootPath, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relativePath)
 
 		// write header
 		if err := tw.WriteHeader(header); err != nil {
@@ -128,21 +190,78 @@ func compressArtifacts(potName string) error {
 		if err != nil {
 			return err
 		}
+		defer data.Close()
+
 		if _, err := io.Copy(tw, data); err != nil {
 			return err
 		}
 
 		return nil
 	})
+}
 
-	return err
+// compressArtifactRun replaces the run directory with a tar.gz archive of it.
+func compressArtifactRun(run middleware.ArtifactRun) error {
+	if run.Compressed {
+		return nil
+	}
+
+	tarPath := run.Path + middleware.CompressedExtension
+	if err := compressArtifacts(run.Path, tarPath+".tmp"); err != nil {
+		_ = os.Remove(tarPath + ".tmp")
+		return err
+	}
+
+	if err := os.Rename(tarPath+".tmp", tarPath); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(run.Path)
 }
 
-func renameDirectory(potName string) error {
-	artifactPath := filepath.Join(outputRoot, potName)
-	renamePath := filepath.Join(outputRoot, fmt.Sprintf("%s_%d", potName, time.Now().Unix()))
-	err := os.Rename(artifactPath, renamePath)
-	return err
+// manageArtifactLifecycle compresses old runs and prunes runs expired by the retention policy.
+func manageArtifactLifecycle(root string, policy middleware.RetentionPolicy, compressAfter time.Duration) {
+	runs, err := middleware.ReadArtifactRuns(root)
+	if err != nil {
+		log.Printf("error while reading artifact runs - %s", err)
+		return
+	}
+
+	for _, run := range policy.Expired(runs, time.Now()) {
+		if err := middleware.RemoveArtifactRun(run); err != nil {
+			log.Printf("error while pruning %s - %s", run.Name, err)
+			continue
+		}
+		log.Printf("Prune artifact run %s", run.Name)
+	}
+
+	if compressAfter <= 0 {
+		return
+	}
+
+	runs, _ = middleware.ReadArtifactRuns(root)
+	for _, run := range runs {
+		if run.Compressed || time.Since(run.Time) < compressAfter {
+			continue
+		}
+		if err := compressArtifactRun(run); err != nil {
+			log.Printf("error while compressing %s - %s", run.Name, err)
+			continue
+		}
+		log.Printf("Compress artifact run %s", run.Name)
+	}
+}
+
+func runArtifactLifecycle(sensors []middleware.Sensor, policy middleware.RetentionPolicy, compressAfter time.Duration) {
+	for {
+		timer := time.NewTimer(lifecycleInterval)
+		for _, sensor := range sensors {
+			if _, err := os.Stat(sensor.ArtifactRoot(outputRoot)); err == nil {
+				manageArtifactLifecycle(sensor.ArtifactRoot(outputRoot), policy, compressAfter)
+			}
+		}
+		<-timer.C
+	}
 }
 
 func calculateFileHash(filePath string) error {
@@ -167,150 +286,378 @@ func calculateFileHash(filePath string) error {
 	return err
 }
 
-func collectContainerArtifact(ctx context.Context, cli *client.Client, stopCapture chan string, resumeCapture chan string, container types.Container, pot middleware.Pot) {
-	if _, err := os.Stat(pot.Name); os.IsNotExist(err) {
-		_ = os.Mkdir(filepath.Join(outputRoot, pot.Name), os.ModePerm)
+func collectContainerArtifact(ctx context.Context, sensor middleware.Sensor, container types.Container, pot middleware.Pot, trigger string) {
+	cli := sensor.Client
+	runPath := filepath.Join(sensor.ArtifactRoot(outputRoot), fmt.Sprintf("%s_%d", pot.Name, time.Now().Unix()))
+	if err := os.MkdirAll(runPath, os.ModePerm); err != nil {
+		log.Printf("error while creating %s artifact directory - %s", pot.Name, err)
+		return
 	}
 
-	// collect logs
-	err := middleware.CollectContainerLog(ctx, cli, container.ID, filepath.Join(outputRoot, pot.Name, "container.log"))
-	if err != nil {
-		log.Println("error while collecting container log")
-		panic(err)
+	profile, collectorNames := middleware.ReadPotProfile(container.Labels)
+	if middleware.IsCheckpointPot(container.Labels) && cli != nil && middleware.IsCheckpointSupported(ctx, cli) && !containsString(collectorNames, "memory") {
+		// memory of checkpoint pots is saved before anything else
+		collectorNames = append([]string{"memory"}, collectorNames...)
 	}
 
-	log.Printf("Collect container stdout/stderr log from %s pot\n", pot.Name)
+	manifest := middleware.Manifest{
+		Host:      sensor.EventHost(),
+		Pot:       pot.Name,
+		Container: container.ID,
+		Image:     container.Image,
+		Profile:   profile,
+		Trigger:   trigger,
+		Reset:     "restart",
+		StartedAt: time.Now(),
+	}
 
-	// collect diff
-	err = middleware.CollectContainerDiff(ctx, cli, container.ID, filepath.Join(outputRoot, pot.Name, "container.diff"))
-	if err != nil {
-		log.Println("error while collecting container diff")
-		panic(err)
+	if networks, err := sensor.Runtime.ReadPotNetworks(ctx); err == nil {
+		for _, network := range networks {
+			if network.Name == pot.Name {
+				manifest.Subnets = network.Subnets
+			}
+		}
 	}
 
-	log.Printf("Collect container diff log from %s pot\n", pot.Name)
+	// swap in clean container first and collect from the paused one
+	if cli != nil && middleware.CanSwapPot(container) {
+		// memory is checkpointed before the pause, which CRIU cannot checkpoint, and the capture
+		// is cut before the clean container gets traffic
+		var beforeSwap []string
+		beforeSwap, collectorNames = splitCollectors(collectorNames, "memory", "pcap")
+		manifest.Collectors = middleware.RunCollectors(ctx, sensor.Runtime, pot, container, runPath, beforeSwap)
 
-	// collect top
-	err = middleware.CollectContainerTop(ctx, cli, container.ID, filepath.Join(outputRoot, pot.Name, "container.top"))
-	if err != nil {
-		log.Println("error while collecting container top")
-		panic(err)
+		newContainerId, err := middleware.SwapCleanPot(ctx, cli, container, pot, swapTimeout)
+		if err != nil {
+			log.Printf("error while swapping %s pot, restarting after collection - %s", pot.Name, err)
+		} else {
+			manifest.Reset = "swap"
+			log.Printf("Swap clean %s container into %s pot\n", newContainerId[:12], pot.Name)
+		}
 	}
 
-	log.Printf("Collect container top from %s pot\n", pot.Name)
+	// run collectors of pot profile
+	manifest.Collectors = append(manifest.Collectors, middleware.RunCollectors(ctx, sensor.Runtime, pot, container, runPath, collectorNames)...)
+	for _, result := range manifest.Collectors {
+		if result.Success {
+			log.Printf("Collect %s from %s pot\n", result.Name, pot.Name)
+		} else {
+			log.Printf("error while collecting %s from %s pot - %s\n", result.Name, pot.Name, result.Error)
+		}
+	}
 
-	// collect container dump
-	err = middleware.CollectContainerDump(ctx, cli, container.ID, filepath.Join(outputRoot, pot.Name, "dump.tar"))
-	if err != nil {
-		panic(err)
+	manifest.FinishedAt = time.Now()
+	if err := middleware.WriteManifest(runPath, manifest); err != nil {
+		log.Printf("error while writing manifest - %s", err)
 	}
 
-	log.Printf("Collect container image from %s pot\n", pot.Name)
+	// cleanup pot container before the saved run is analysed
+	if err := cleanupCollectedContainer(ctx, sensor, pot, container, manifest.Reset); err != nil {
+		log.Printf("error while replacing %s pot - %s", pot.Name, err)
+	} else {
+		middleware.PublishEvent(middleware.Event{
+			Host:      sensor.EventHost(),
+			Pot:       pot.Name,
+			Container: container.ID,
+			Kind:      middleware.EventReset,
+			Message:   fmt.Sprintf("replaced with clean container by %s", manifest.Reset),
+		})
+		log.Printf("Successfully replaced %s pot to clean container", pot.Name)
+	}
 
-	stopCapture <- pot.Name
+	queueRunAnalysis(runPath, manifest)
+}
 
-	// calculate hash value
-	err = calculateFileHash(filepath.Join(outputRoot, pot.Name))
-	if err != nil {
-		log.Println("error while calculating hash")
-		panic(err)
+// cleanupCollectedContainer removes the paused container of a swapped pot, or restarts the
+// container from its clean state.
+func cleanupCollectedContainer(ctx context.Context, sensor middleware.Sensor, pot middleware.Pot, container types.Container, reset string) error {
+	if reset == "swap" {
+		if err := middleware.RemovePotContainer(ctx, sensor.Client, container.ID); err != nil {
+			return err
+		}
+		log.Printf("Remove paused container from %s pot\n", pot.Name)
+		return nil
 	}
 
-	log.Printf("Calculate hash from %s pot\n", pot.Name)
+	if err := sensor.Runtime.ResetContainer(ctx, pot, container); err != nil {
+		return err
+	}
+	if sensor.EventHost() == "" {
+		switchPotIngress(ctx, sensor.Client, pot.Name)
+	}
+	log.Printf("Restart clean %s pot\n", pot.Name)
+	return nil
+}
 
-	// compress artifacts
-	/* err = compressArtifacts(pot.Name)
-	if err != nil {
-		log.Println("error while compressing artifact")
-		panic(err)
+// runAnalysis analyses saved runs one at a time in background, so pots are reset without
+// waiting for scans of their exports.
+var runAnalysis = struct {
+	once    sync.Once
+	pending sync.WaitGroup
+	runs    chan func()
+}{runs: make(chan func(), 64)}
+
+// queueRunAnalysis hashes, scans, quarantines, triages and records the saved run in background,
+// then rewrites its manifest and exports, forwards and indexes it.
+func queueRunAnalysis(runPath string, manifest middleware.Manifest) {
+	runAnalysis.once.Do(func() {
+		go func() {
+			for analyse := range runAnalysis.runs {
+				analyse()
+				runAnalysis.pending.Done()
+			}
+		}()
+	})
+
+	runAnalysis.pending.Add(1)
+	runAnalysis.runs <- func() {
+		// calculate hash value
+		if err := calculateFileHash(runPath); err != nil {
+			log.Printf("error while calculating hash - %s", err)
+		} else {
+			log.Printf("Calculate hash from %s pot\n", manifest.Pot)
+		}
+
+		scanCollectedRun(runPath, &manifest)
+		quarantineCollectedRun(runPath, &manifest)
+		triageCollectedRun(runPath, &manifest)
+		recordCollectedRun(runPath, &manifest)
+
+		if err := middleware.WriteManifest(runPath, manifest); err != nil {
+			log.Printf("error while writing manifest - %s", err)
+		}
+		exportManifest(runPath, manifest)
+		forwardRun(runPath)
+
+		indexArtifactRun(runPath)
 	}
+}
 
-	log.Printf("Compress artifact from %s pot\n", pot.Name)
-	*/
+// waitRunAnalysis blocks until the queued runs are analysed.
+func waitRunAnalysis() {
+	runAnalysis.pending.Wait()
+}
 
-	err = renameDirectory(pot.Name)
-	if err != nil {
-		log.Println("error while renaming directory")
-		panic(err)
+// splitCollectors returns the collectors of names found in first, in the order of names, and the others.
+func splitCollectors(names []string, first ...string) ([]string, []string) {
+	var selected, others []string
+	for _, name := range names {
+		if containsString(first, name) {
+			selected = append(selected, name)
+		} else {
+			others = append(others, name)
+		}
 	}
+	return selected, others
+}
 
-	log.Printf("Rename directory from %s pot\n", pot.Name)
+// collectPot collects artifacts from every container of the pot and replaces them with clean ones.
+func collectPot(ctx context.Context, sensor middleware.Sensor, potName string, trigger string) error {
+	potKey := middleware.PotKey(sensor.EventHost(), potName)
+	if !beginCollecting(potKey) {
+		return fmt.Errorf("%s pot is already being collected", potKey)
+	}
+	defer endCollecting(potKey)
 
-	// cleanup pot container
-	err = middleware.RestartCleanPot(ctx, cli, container, pot)
+	pot, err := middleware.ReadPot(ctx, sensor.Runtime, potName)
 	if err != nil {
-		log.Println("error while restarting pot")
-		panic(err)
+		return err
 	}
 
-	log.Printf("Restart clean %s pot\n", pot.Name)
+	for _, container := range pot.Containers {
+		collectContainerArtifact(ctx, sensor, container, pot, trigger)
+	}
 
-	_ = os.RemoveAll(filepath.Join(outputRoot, pot.Name))
+	return nil
+}
 
-	resumeCapture <- pot.Name
+// resetPot replaces the containers of the pot with clean ones without collecting them.
+func resetPot(ctx context.Context, sensor middleware.Sensor, pot middleware.Pot, by string) error {
+	for _, container := range pot.Containers {
+		if err := sensor.Runtime.ResetContainer(ctx, pot, container); err != nil {
+			return err
+		}
+
+		middleware.PublishEvent(middleware.Event{
+			Host:      sensor.EventHost(),
+			Pot:       pot.Name,
+			Container: container.ID,
+			Kind:      middleware.EventReset,
+			Message:   "replaced with clean container by " + by,
+		})
+	}
+	if sensor.EventHost() == "" {
+		switchPotIngress(ctx, sensor.Client, pot.Name)
+	}
 
-	log.Printf("Successfully replaced %s pot to clean container", pot.Name)
+	log.Printf("Restart clean %s pot\n", middleware.PotKey(sensor.EventHost(), pot.Name))
+	return nil
 }
 
-func manageContainerArtifact(ctx context.Context, cli *client.Client, stopCapture chan string, resumeCapture chan string) {
-	pots, err := middleware.ReadAllPots(ctx, cli)
+func manageContainerArtifact(ctx context.Context, sensor middleware.Sensor) {
+	pots, err := middleware.ReadAllPots(ctx, sensor.Runtime)
 	if err != nil {
-		panic(err)
+		log.Printf("error while reading pots of %s host - %s", sensor.Host.Name, err)
+		return
 	}
 
-	log.Printf("Read %d count pot(s)", len(pots))
+	log.Printf("Read %d count pot(s) of %s host", len(pots), sensor.Host.Name)
 
 	for _, pot := range pots {
-		for _, container := range pot.Containers {
-			go collectContainerArtifact(ctx, cli, stopCapture, resumeCapture, container, pot)
-		}
+		go func(potName string) {
+			if err := collectPot(ctx, sensor, potName, "interval"); err != nil {
+				log.Printf("error while collecting %s pot - %s", middleware.PotKey(sensor.EventHost(), potName), err)
+			}
+		}(pot.Name)
 	}
 }
 
-var collectCmd = &cobra.Command{
-	Use: "collect",
-	Run: func(cmd *cobra.Command, args []string) {
-		log.Println("Starting capturing network traffic...")
+// startCollectDaemon captures, records and collects pots of the sensors in background until
+// the process exits. Packet capture and ingress only serve pots of the local daemon.
+func startCollectDaemon(ctx context.Context, sensors []middleware.Sensor) {
+	registerMetrics(ctx, sensors)
+	startAlertEngine()
+	startOutputs(ctx, sensors)
+	loadYaraRules()
+	if quarantine {
+		sampleVault = openVault()
+	}
+	go middleware.RecordEvents(eventStore, middleware.SubscribeEvents(1024), 2*time.Second)
 
-		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			panic(err)
+	for _, sensor := range sensors {
+		if sensor.Client != nil {
+			go middleware.WatchDockerEvents(ctx, sensor.Client, sensor.EventHost())
 		}
-
-		if _, err := os.Stat(outputRoot); os.IsNotExist(err) {
-			_ = os.Mkdir(outputRoot, os.ModePerm)
+		if sensor.EventHost() == "" {
+			go captureNetworkPacket(ctx, sensor)
 		}
+	}
+	go listenCollectRequest(ctx, sensors)
+	go runArtifactLifecycle(sensors, retentionPolicy(), compressAfter)
 
-		stopCapture := make(chan string, 1)
-		resumeCapture := make(chan string, 1)
+	var triggers []string
+	for _, trigger := range collectTriggers {
+		if trigger != "none" {
+			triggers = append(triggers, trigger)
+		}
+	}
+	if len(triggers) > 0 {
+		limiter := middleware.NewLimiter(triggerCooldown, triggerRate, triggerWindow)
+		go dispatchTriggers(ctx, sensors, limiter, triggers)
+
+		for _, sensor := range sensors {
+			watcher := middleware.NewWatcher(watchInterval, cpuThreshold, triggers)
+			watcher.Host = sensor.EventHost()
+			go watcher.Run(ctx, sensor.Runtime)
+		}
+	}
 
+	go func() {
 		count := 0
-
-		go captureNetworkPacket(ctx, cli, stopCapture, resumeCapture)
-
 		for {
 			collectTimer := time.NewTimer(time.Hour * time.Duration(collectInterval))
 			if count > 0 {
 				log.Println("Start collecting artifacts from containers...")
-				manageContainerArtifact(ctx, cli, stopCapture, resumeCapture)
+				for _, sensor := range sensors {
+					manageContainerArtifact(ctx, sensor)
+				}
 			}
 			count++
 			<-collectTimer.C
 		}
+	}()
+}
+
+func prepareOutputRoot() {
+	if _, err := os.Stat(outputRoot); os.IsNotExist(err) {
+		_ = os.Mkdir(outputRoot, os.ModePerm)
+	}
+
+	eventStore = middleware.NewStore(eventDBPath())
+}
+
+var collectCmd = &cobra.Command{
+	Use: "collect",
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("Starting capturing network traffic...")
+
+		ctx := context.Background()
+		sensors := connectSensors()
+
+		prepareOutputRoot()
+
+		if collectNow {
+			requestCollectNow(ctx, sensors, potName)
+			return
+		}
+
+		startCollectDaemon(ctx, sensors)
+
+		if metricsListen != "" {
+			go serveMetrics(metricsListen)
+		}
+
+		select {}
 	},
 }
 
+// addCollectFlags binds the collection settings to a command running the collect daemon.
+func addCollectFlags(command *cobra.Command) {
+	command.Flags().IntVarP(&collectInterval, "interval", "i", 1, "Interval of artifact collection")
+	command.Flags().StringSliceVar(&collectTriggers, "triggers", []string{middleware.TriggerDiff, middleware.TriggerOutbound},
+		"Events starting an immediate collection (diff, process, outbound, cpu, login), none to collect on the interval only")
+	command.Flags().DurationVar(&watchInterval, "watch-interval", 30*time.Second, "Interval of polling pots for trigger events")
+	command.Flags().Float64Var(&cpuThreshold, "cpu-threshold", 80, "CPU percent firing the cpu trigger")
+	command.Flags().DurationVar(&triggerCooldown, "cooldown", 10*time.Minute, "Minimum time between triggered collections of a pot")
+	command.Flags().IntVar(&triggerRate, "rate-limit", 6, "Maximum triggered collections of a pot per window (0 for unlimited)")
+	command.Flags().DurationVar(&triggerWindow, "rate-window", time.Hour, "Window of trigger rate limit")
+	command.Flags().DurationVar(&compressAfter, "compress-after", 0, "Compress runs older than this in background (0 to disable)")
+	command.Flags().DurationVar(&lifecycleInterval, "lifecycle-interval", time.Hour, "Interval of retention and compression")
+	addRetentionFlags(command)
+	command.Flags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	command.Flags().DurationVar(&swapTimeout, "swap-timeout", time.Minute, "Time for a clean replacement to become healthy")
+	command.Flags().StringVar(&alertRulesFile, "alerts", "", "Path of alert rules YAML")
+	addYaraFlags(command)
+	addVaultFlags(command)
+	addTriageFlags(command)
+	addReplayFlags(command)
+	addOutputFlags(command)
+}
+
 var (
 	outputRoot      string
 	collectInterval int
+	collectNow      bool          // Collect and reset one pot immediately
+	collectTriggers []string      // Events starting an immediate collection
+	watchInterval   time.Duration // Interval of polling pots for trigger events
+	cpuThreshold    float64       // CPU percent firing the cpu trigger
+	triggerCooldown time.Duration // Minimum time between triggered collections of a pot
+	triggerRate     int           // Maximum triggered collections of a pot per window
+	triggerWindow   time.Duration // Window of trigger rate limit
+	swapTimeout     time.Duration // Time for a clean replacement to become healthy
+	compressAfter   time.Duration // Age of runs compressed in background
+	eventDB         string        // Path of event database
+	metricsListen   string        // Address of prometheus metrics
 )
 
 func init() {
 	rootCmd.AddCommand(collectCmd)
 
 	collectCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
-	collectCmd.Flags().IntVarP(&collectInterval, "interval", "i", 1, "Interval of artifact collection")
+	collectCmd.Flags().BoolVar(&collectNow, "now", false, "Collect and reset the pot given by --name now")
+	collectCmd.Flags().StringVarP(&potName, "name", "n", "", "Name of pot")
+	collectCmd.Flags().StringVar(&metricsListen, "metrics-listen", "", "Address serving prometheus /metrics, e.g. :9150")
+	addHostFlags(collectCmd)
+	addCollectFlags(collectCmd)
 
 	collectCmd.MarkFlagRequired("path")
 }
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/spf13/cobra"
@@ -15,10 +19,7 @@ var deployCmd = &cobra.Command{
 	Use: "deploy",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			panic(err)
-		}
+		sensors := connectSensors()
 
 		if potComposeFile != "" {
 			// compose mode
@@ -29,29 +30,153 @@ var deployCmd = &cobra.Command{
 			os.Exit(1)
 
 		} else {
-			log.Printf("Generating %s pot...", potName)
-			response, err := middleware.MakeNewPot(ctx, cli, potName, potImage, potPorts, potDockerFile, potEnvironments)
-			if err != nil {
-				middleware.RemovePot(ctx, cli, potName)
-				panic(err)
-			}
+			failed := false
+			for _, sensor := range sensors {
+				// ports go through the ingress proxy wherever it can serve them, so resets swap
+				// without downtime, unless --ingress is given explicitly
+				ingress := potIngress
+				if !cmd.Flags().Changed("ingress") {
+					ingress = potIngress && isIngressSupported(sensor)
+				}
+				potLabels, dockerPorts, err := readPotLabels(potName, potProfile, potCollectors, potPorts, ingress, potCheckpoint)
+				if err != nil {
+					log.Println(err)
+					os.Exit(1)
+				}
 
-			log.Printf("Successfully generated %s pot\n", potName)
-			log.Printf("Pot Name: %s\n", response.Name)
-			for _, container := range response.Containers {
-				log.Printf("[%s] Contaier Name: %s", container.ID, container.Names[0])
+				if err := deployPot(ctx, sensor, potLabels, dockerPorts); err != nil {
+					log.Printf("error while generating %s pot on %s host - %s", potName, sensor.Host.Name, err)
+					failed = true
+				}
+			}
+			if failed {
+				os.Exit(1)
 			}
 		}
 	},
 }
 
+// deployPot generates the pot on the host of the sensor, removing what was created when it fails.
+func deployPot(ctx context.Context, sensor middleware.Sensor, potLabels map[string]string, dockerPorts []string) error {
+	cli := sensor.Client
+	if middleware.IsIngressPot(potLabels) && sensor.EventHost() != "" {
+		// the ingress proxy of collect only reaches pots of the local daemon
+		return fmt.Errorf("ingress not supported on fleet host %s", sensor.Host.Name)
+	}
+	if cli == nil && (potDockerFile != "" || middleware.IsIngressPot(potLabels)) {
+		return fmt.Errorf("dockerfile and ingress not supported by %s runtime", sensor.Runtime.Name())
+	}
+	if middleware.IsCheckpointPot(potLabels) && sensor.Runtime.Name() != middleware.RuntimeDocker {
+		return fmt.Errorf("checkpoint not supported by %s runtime", sensor.Runtime.Name())
+	}
+
+	log.Printf("Generating %s pot on %s host...", potName, sensor.Host.Name)
+	var response middleware.Pot
+	var err error
+	if cli != nil {
+		response, err = middleware.MakeNewPot(ctx, cli, potName, potImage, dockerPorts, potDockerFile, potEnvironments, potLabels)
+	} else if err = sensor.Runtime.CreatePot(ctx, middleware.PotSpec{Name: potName, Image: potImage, Ports: dockerPorts, Environments: potEnvironments, Labels: potLabels}); err == nil {
+		// containers of some runtimes are listed only once scheduled
+		if response, err = middleware.ReadPot(ctx, sensor.Runtime, potName); err != nil {
+			response, err = middleware.Pot{Name: potName}, nil
+		}
+	}
+	if err != nil {
+		middleware.RemovePot(ctx, sensor.Runtime, potName)
+		return err
+	}
+
+	if middleware.IsCheckpointPot(potLabels) {
+		createCleanCheckpoint(ctx, cli, potName, potLabels[middleware.CheckpointLabel])
+	}
+
+	log.Printf("Successfully generated %s pot\n", potName)
+	log.Printf("Pot Name: %s\n", response.Name)
+	for _, container := range response.Containers {
+		log.Printf("[%s] Contaier Name: %s", container.ID, container.Names[0])
+	}
+	return nil
+}
+
+// isIngressSupported reports whether the ingress proxy of collect can serve pots of the sensor.
+func isIngressSupported(sensor middleware.Sensor) bool {
+	return sensor.Client != nil && sensor.EventHost() == ""
+}
+
+// readPotLabels returns the labels describing how the pot is collected and reset, with the ports published by docker.
+func readPotLabels(name string, profile string, collectors []string, ports []string, ingress bool, checkpoint bool) (map[string]string, []string, error) {
+	if profile != "" && !middleware.IsExistProfile(profile) {
+		return nil, nil, fmt.Errorf("%s profile not found. available profiles: %s", profile, strings.Join(middleware.ListProfiles(), ", "))
+	}
+
+	potLabels := make(map[string]string)
+	if profile != "" {
+		potLabels[middleware.ProfileLabel] = profile
+	}
+	if len(collectors) > 0 {
+		potLabels[middleware.CollectorsLabel] = strings.Join(collectors, ",")
+	}
+
+	dockerPorts := ports
+	if ingress && len(ports) > 0 {
+		// ports are served by the ingress proxy of collect, so clean containers can be swapped in
+		if _, err := middleware.ParseIngressPorts(ports); err != nil {
+			return nil, nil, err
+		}
+		potLabels[middleware.IngressLabel] = strings.Join(ports, ",")
+		dockerPorts = []string{}
+	}
+
+	if checkpoint {
+		checkpointDir, err := filepath.Abs(filepath.Join(checkpointRoot, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		potLabels[middleware.CheckpointLabel] = checkpointDir
+	}
+
+	return potLabels, dockerPorts, nil
+}
+
+// createCleanCheckpoint checkpoints the freshly deployed pot so resets restore it instead of booting the image.
+func createCleanCheckpoint(ctx context.Context, cli *client.Client, potName string, checkpointDir string) {
+	if !middleware.IsCheckpointSupported(ctx, cli) {
+		log.Println("checkpoint not supported by docker daemon, resets will recreate pot from image")
+		return
+	}
+
+	// let services of pot finish starting
+	time.Sleep(checkpointDelay)
+
+	pot, err := middleware.ReadPot(ctx, middleware.DockerRuntime{Client: cli}, potName)
+	if err != nil {
+		log.Printf("error while reading %s pot - %s", potName, err)
+		return
+	}
+
+	for _, container := range pot.Containers {
+		if err := middleware.CreateCleanCheckpoint(ctx, cli, container.ID, checkpointDir); err != nil {
+			log.Printf("error while creating clean checkpoint, resets will recreate pot from image - %s", err)
+			return
+		}
+	}
+
+	log.Printf("Create clean checkpoint of %s pot in %s", potName, checkpointDir)
+}
+
 var (
-	potName         string   // Name of pot (required)
-	potImage        string   // Name of docker base image if you want to deploy pot as single mode (optional)
-	potPorts        []string // Port forwarding mapper (optional)
-	potEnvironments []string // Environment variable config (optional)
-	potComposeFile  string   // Path of docker-compose.yml file if you want to deploy pot as compose mode (optional)
-	potDockerFile   string   // Path of Dockerfile if you want to deployt pot with building Dockerfile (optional)
+	potName         string        // Name of pot (required)
+	potImage        string        // Name of docker base image if you want to deploy pot as single mode (optional)
+	potPorts        []string      // Port forwarding mapper (optional)
+	potEnvironments []string      // Environment variable config (optional)
+	potComposeFile  string        // Path of docker-compose.yml file if you want to deploy pot as compose mode (optional)
+	potDockerFile   string        // Path of Dockerfile if you want to deployt pot with building Dockerfile (optional)
+	potProfile      string        // Name of artifact collection profile (optional)
+	potCollectors   []string      // Collector names overriding the profile (optional)
+	potIngress      bool          // Serve ports through the ingress proxy instead of docker (optional)
+	potCheckpoint   bool          // Reset pot by restoring a clean checkpoint (optional)
+	checkpointRoot  string        // Directory of pot checkpoints (optional)
+	checkpointDelay time.Duration // Time for pot to settle before the clean checkpoint (optional)
 )
 
 func init() {
@@ -63,6 +188,12 @@ func init() {
 	deployCmd.Flags().StringArrayVarP(&potEnvironments, "environments", "e", []string{}, "Environment Variables options")
 	deployCmd.Flags().StringVarP(&potComposeFile, "compose", "c", "", "Path of docker-compose.yml")
 	deployCmd.Flags().StringVarP(&potDockerFile, "dockerfile", "f", "", "Path of Dockerfile")
+	deployCmd.Flags().StringVar(&potProfile, "profile", "", "Artifact collection profile (light, standard, full)")
+	deployCmd.Flags().BoolVar(&potIngress, "ingress", true, "Serve ports through the ingress proxy of collect for zero-downtime reset (default on the local Docker or Podman daemon)")
+	deployCmd.Flags().BoolVar(&potCheckpoint, "checkpoint", false, "Reset pot by restoring a clean CRIU checkpoint and save its memory on collection")
+	deployCmd.Flags().DurationVar(&checkpointDelay, "checkpoint-delay", 10*time.Second, "Time for pot to settle before the clean checkpoint")
+	deployCmd.Flags().StringSliceVar(&potCollectors, "collectors", []string{}, "Artifact collectors overriding the profile")
+	addHostFlags(deployCmd)
 
 	deployCmd.MarkFlagRequired("name")
 }
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// readRunIndicators reads the indicators of one collection run.
+func readRunIndicators(name string) (*middleware.IndicatorSet, string, error) {
+	run, err := middleware.ReadArtifactRun(outputRoot, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if run.Compressed {
+		return nil, "", fmt.Errorf("%s is compressed, extract it first", run.Name)
+	}
+
+	set := middleware.NewIndicatorSet()
+	if err := set.AddRun(run.Path); err != nil {
+		return nil, "", err
+	}
+	return set, fmt.Sprintf("Honey-V honeypot %s collection run", run.Name), nil
+}
+
+// readRangeIndicators reads the indicators of recorded events and of the artifacts of runs in the time range.
+func readRangeIndicators() (*middleware.IndicatorSet, string, error) {
+	filter := readEventFilter()
+	set := middleware.NewIndicatorSet()
+
+	events, err := middleware.NewStore(eventDBPath()).QueryEvents(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	set.AddEvents(events)
+
+	runs, err := middleware.ReadArtifactRuns(outputRoot)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, run := range runs {
+		if (filter.Pot != "" && run.Pot != filter.Pot) || run.Time.Before(filter.Since) || (!filter.Until.IsZero() && run.Time.After(filter.Until)) {
+			continue
+		}
+		if run.Compressed {
+			log.Printf("Skip compressed artifact run %s", run.Name)
+			continue
+		}
+		if err := set.AddRunArtifacts(run.Path); err != nil {
+			log.Printf("error while reading indicators of %s - %s", run.Name, err)
+		}
+	}
+
+	pots := "all pots"
+	if filter.Pot != "" {
+		pots = filter.Pot + " pot"
+	}
+	info := "Honey-V honeypot indicators of " + pots
+	if !filter.Since.IsZero() {
+		info += " since " + filter.Since.UTC().Format(time.RFC3339)
+	}
+	if !filter.Until.IsZero() {
+		info += " until " + filter.Until.UTC().Format(time.RFC3339)
+	}
+	return set, info, nil
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export attacker IPs, URLs, file hashes and credentials as STIX 2.1 or MISP",
+	Run: func(cmd *cobra.Command, args []string) {
+		var set *middleware.IndicatorSet
+		var info string
+		var err error
+
+		if exportRun != "" {
+			set, info, err = readRunIndicators(exportRun)
+		} else {
+			set, info, err = readRangeIndicators()
+		}
+		if err != nil {
+			log.Printf("error while reading indicators - %s", err)
+			os.Exit(1)
+		}
+		indicators := set.Indicators()
+
+		var document interface{}
+		switch strings.ToLower(exportFormat) {
+		case "stix":
+			document, err = middleware.STIXBundle(indicators, exportTLP, time.Now())
+		case "misp":
+			document, err = middleware.MISPEvent(indicators, info, exportTLP, time.Now())
+		default:
+			err = fmt.Errorf("unknown %s format, expected stix or misp", exportFormat)
+		}
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+
+		var writer io.Writer = os.Stdout
+		if exportIntelOutput != "" {
+			file, err := os.Create(exportIntelOutput)
+			if err != nil {
+				log.Printf("error while creating %s - %s", exportIntelOutput, err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			writer = file
+		}
+
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(document); err != nil {
+			log.Printf("error while writing %s - %s", exportFormat, err)
+			os.Exit(1)
+		}
+
+		if exportIntelOutput != "" {
+			log.Printf("Export %d indicator(s) to %s", len(indicators), exportIntelOutput)
+		}
+	},
+}
+
+var (
+	exportFormat      string // Format of exported indicators
+	exportRun         string // Collection run of exported indicators
+	exportTLP         string // Traffic light protocol marking
+	exportIntelOutput string // Path of exported indicators
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	exportCmd.Flags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "stix", "Format of export (stix, misp)")
+	exportCmd.Flags().StringVar(&exportRun, "run", "", "Export a single collection run instead of a time range")
+	exportCmd.Flags().StringVarP(&potName, "name", "n", "", "Name of pot")
+	exportCmd.Flags().StringVar(&querySince, "since", "", "Start of time range (RFC3339, YYYY-MM-DD or duration like 168h)")
+	exportCmd.Flags().StringVar(&queryUntil, "until", "", "End of time range (RFC3339, YYYY-MM-DD or duration like 24h)")
+	exportCmd.Flags().StringVar(&exportTLP, "tlp", "amber", "TLP marking (white, green, amber, red)")
+	exportCmd.Flags().StringVarP(&exportIntelOutput, "output", "o", "", "Path of exported JSON (default stdout)")
+
+	exportCmd.MarkFlagRequired("path")
+}
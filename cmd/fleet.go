@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// connectSensors returns the hosts selected by --host or --all-hosts, the local daemon when
+// neither is given. The fleet file is only read when a fleet host is selected.
+func connectSensors() []middleware.Sensor {
+	var fleet middleware.Fleet
+	if allHosts || !onlyLocalHost(fleetHosts) {
+		var err error
+		if fleet, err = middleware.LoadFleet(fleetFile); err != nil {
+			log.Printf("error while reading fleet %s - %s", fleetFile, err)
+			os.Exit(1)
+		}
+	}
+
+	hosts, err := fleet.Select(fleetHosts, allHosts)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	for index := range hosts {
+		if hosts[index].Name == middleware.LocalHostName {
+			hosts[index] = localHost()
+		}
+	}
+
+	sensors, err := middleware.ConnectHosts(hosts)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	return sensors
+}
+
+func onlyLocalHost(names []string) bool {
+	for _, name := range names {
+		if name != middleware.LocalHostName {
+			return false
+		}
+	}
+	return true
+}
+
+// localHost is the runtime of the environment selected by --runtime or $HONEYPOT_RUNTIME.
+func localHost() middleware.Host {
+	runtime := localRuntime
+	if runtime == "" {
+		runtime = os.Getenv(runtimeEnv)
+	}
+	return middleware.Host{Name: middleware.LocalHostName, Runtime: runtime}
+}
+
+// localSensor connects to the runtime of the environment.
+func localSensor() (middleware.Sensor, error) {
+	sensors, err := middleware.ConnectHosts([]middleware.Host{localHost()})
+	if err != nil {
+		return middleware.Sensor{}, err
+	}
+	return sensors[0], nil
+}
+
+// findSensor returns the sensor of the host of an event, the local daemon for an empty host.
+func findSensor(sensors []middleware.Sensor, host string) (middleware.Sensor, bool) {
+	for _, sensor := range sensors {
+		if sensor.EventHost() == host || sensor.Host.Name == host {
+			return sensor, true
+		}
+	}
+	return middleware.Sensor{}, false
+}
+
+// splitPotKey splits <host>/<pot> into the host and pot, the host being empty for a local pot.
+func splitPotKey(key string) (string, string) {
+	if parts := strings.SplitN(key, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", key
+}
+
+const runtimeEnv = "HONEYPOT_RUNTIME"
+
+var (
+	localRuntime string // Runtime of the local host
+
+	fleetFile  string   // Path of fleet file of sensor hosts
+	fleetHosts []string // Hosts of the fleet managed by the command
+	allHosts   bool     // Manage every host of the fleet
+)
+
+// addHostFlags binds the fleet host selection to a command managing pots.
+func addHostFlags(command *cobra.Command) {
+	command.Flags().StringVar(&fleetFile, "fleet", "fleet.yml", "Path of fleet file of sensor hosts")
+	command.Flags().StringSliceVar(&fleetHosts, "host", []string{}, "Hosts of the fleet to manage (default local daemon)")
+	command.Flags().BoolVar(&allHosts, "all-hosts", false, "Manage every host of the fleet")
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+const (
+	geoIPCityEnv = "HONEYPOT_GEOIP_CITY"
+	geoIPASNEnv  = "HONEYPOT_GEOIP_ASN"
+)
+
+// openGeoIP enriches events from the MaxMind format databases of the flags or environment.
+// Lookups never leave the host, a missing database only disables enrichment.
+func openGeoIP(cmd *cobra.Command, args []string) {
+	if geoIPCity == "" {
+		geoIPCity = os.Getenv(geoIPCityEnv)
+	}
+	if geoIPASN == "" {
+		geoIPASN = os.Getenv(geoIPASNEnv)
+	}
+	if geoIPCity == "" && geoIPASN == "" {
+		return
+	}
+
+	db, err := middleware.OpenGeoIP(geoIPCity, geoIPASN)
+	if err != nil {
+		log.Printf("error while opening geoip database - %s", err)
+		return
+	}
+	middleware.SetGeoIP(db)
+}
+
+// enrichEvents sets the geo of events recorded before a database was given.
+func enrichEvents(events []middleware.Event) {
+	for index := range events {
+		middleware.EnrichEvent(&events[index])
+	}
+}
+
+var (
+	geoIPCity string // Path of GeoIP city database
+	geoIPASN  string // Path of GeoIP ASN database
+)
+
+func init() {
+	rootCmd.PersistentPreRun = openGeoIP
+
+	rootCmd.PersistentFlags().StringVar(&geoIPCity, "geoip-city", "", "Path of GeoLite2-City or Country MMDB (default $"+geoIPCityEnv+")")
+	rootCmd.PersistentFlags().StringVar(&geoIPASN, "geoip-asn", "", "Path of GeoLite2-ASN MMDB (default $"+geoIPASNEnv+")")
+}
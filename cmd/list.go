@@ -2,10 +2,10 @@ package cmd
 
 import (
 	"context"
+	"log"
 	"os"
 	"strings"
 
-	"github.com/docker/docker/client"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
@@ -16,18 +16,18 @@ var listCmd = &cobra.Command{
 	Use: "list",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			panic(err)
-		}
+		sensors := connectSensors()
 
-		pots, err := middleware.ReadAllPots(ctx, cli)
+		pots, err := middleware.ReadFleetPots(ctx, sensors)
 		if err != nil {
-			panic(err)
+			if len(sensors) == 1 {
+				panic(err)
+			}
+			log.Printf("error while reading pots - %s", err)
 		}
 
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Name", "Containers", "Status", "Uptime/Downtime"})
+		table.SetHeader([]string{"Host", "Name", "Containers", "Status", "Uptime/Downtime"})
 
 		var data [][]string
 		var status string
@@ -40,7 +40,7 @@ var listCmd = &cobra.Command{
 				status = container.Status
 				state = container.State
 			}
-			data = append(data, []string{pot.Name, strings.Join(containerNames, ","), state, status})
+			data = append(data, []string{pot.Host, pot.Name, strings.Join(containerNames, ","), state, status})
 		}
 
 		table.AppendBulk(data)
@@ -50,4 +50,6 @@ var listCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+
+	addHostFlags(listCmd)
 }
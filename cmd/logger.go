@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+var containerdLoggerCmd = &cobra.Command{
+	Use:    middleware.ContainerdLoggerCommand + " DIRECTORY",
+	Short:  "Log output of a pot container as the logging binary of containerd",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := middleware.WriteContainerdLog(args[0]); err != nil {
+			log.Printf("error while logging container - %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(containerdLoggerCmd)
+}
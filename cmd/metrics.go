@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	connectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "honeypot_connections_total",
+		Help: "Inbound connections to the pot.",
+	}, []string{"pot"})
+	credentialsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "honeypot_credentials_total",
+		Help: "Login attempts with credentials on the pot.",
+	}, []string{"pot"})
+	collectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "honeypot_collections_total",
+		Help: "Collection runs of the pot.",
+	}, []string{"pot"})
+	collectionFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "honeypot_collection_failures_total",
+		Help: "Collection runs of the pot with a failed collector.",
+	}, []string{"pot"})
+	eventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "honeypot_events_total",
+		Help: "Events observed on the pot by kind.",
+	}, []string{"pot", "kind"})
+	uniqueAttackers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "honeypot_unique_attackers",
+		Help: "Distinct source addresses seen on the pot since start.",
+	}, []string{"pot"})
+)
+
+var (
+	potUpDesc            = prometheus.NewDesc("honeypot_pot_up", "Whether a container of the pot is running.", []string{"pot"}, nil)
+	potCPUDesc           = prometheus.NewDesc("honeypot_pot_cpu_percent", "CPU usage of the pot.", []string{"pot"}, nil)
+	potMemoryPercentDesc = prometheus.NewDesc("honeypot_pot_memory_percent", "Memory usage of the pot against its limit.", []string{"pot"}, nil)
+	potMemoryDesc        = prometheus.NewDesc("honeypot_pot_memory_usage_bytes", "Memory usage of the pot.", []string{"pot"}, nil)
+	potNetworkRxDesc     = prometheus.NewDesc("honeypot_pot_network_receive_bytes_total", "Bytes received by the pot container.", []string{"pot"}, nil)
+	potNetworkTxDesc     = prometheus.NewDesc("honeypot_pot_network_transmit_bytes_total", "Bytes sent by the pot container.", []string{"pot"}, nil)
+	potBlockReadDesc     = prometheus.NewDesc("honeypot_pot_block_read_bytes_total", "Bytes read from block devices by the pot container.", []string{"pot"}, nil)
+	potBlockWriteDesc    = prometheus.NewDesc("honeypot_pot_block_write_bytes_total", "Bytes written to block devices by the pot container.", []string{"pot"}, nil)
+	captureReceivedDesc  = prometheus.NewDesc("honeypot_capture_packets_received_total", "Packets received by the pot network capture.", []string{"pot"}, nil)
+	captureDroppedDesc   = prometheus.NewDesc("honeypot_capture_packets_dropped_total", "Packets dropped by the pot network capture.", []string{"pot", "reason"}, nil)
+	artifactBytesDesc    = prometheus.NewDesc("honeypot_artifact_bytes", "Disk usage of the collected artifacts of the pot.", []string{"pot"}, nil)
+	artifactRunsDesc     = prometheus.NewDesc("honeypot_artifact_runs", "Collection runs of the pot kept on disk.", []string{"pot"}, nil)
+	artifactFlaggedDesc  = prometheus.NewDesc("honeypot_artifact_flagged_runs", "Collection runs of the pot kept forever.", []string{"pot"}, nil)
+	potMetricsErrorsDesc = prometheus.NewDesc("honeypot_scrape_error", "Whether reading pots from the container runtime failed during the scrape.", nil, nil)
+)
+
+// potMetrics reads the figures of monitor, captures and artifacts on every scrape.
+type potMetrics struct {
+	ctx     context.Context
+	sensors []middleware.Sensor
+}
+
+func (m potMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		potUpDesc, potCPUDesc, potMemoryPercentDesc, potMemoryDesc, potNetworkRxDesc, potNetworkTxDesc, potBlockReadDesc, potBlockWriteDesc,
+		captureReceivedDesc, captureDroppedDesc, artifactBytesDesc, artifactRunsDesc, artifactFlaggedDesc, potMetricsErrorsDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (m potMetrics) Collect(ch chan<- prometheus.Metric) {
+	scrapeError := 0.0
+
+	if pots, err := middleware.ReadFleetPots(m.ctx, m.sensors); err != nil {
+		log.Printf("error while reading pots - %s", err)
+		scrapeError = 1
+	} else {
+		for _, pot := range pots {
+			up := 0.0
+			for _, container := range pot.Containers {
+				if container.State == "running" {
+					up = 1
+				}
+			}
+			ch <- prometheus.MustNewConstMetric(potUpDesc, prometheus.GaugeValue, up, middleware.PotKey(pot.Host, pot.Name))
+		}
+	}
+
+	for _, sensor := range m.sensors {
+		stats, err := readAllPotStats(m.ctx, sensor.Runtime)
+		if err != nil {
+			continue
+		}
+		for _, stat := range stats {
+			potKey := middleware.PotKey(sensor.EventHost(), stat.Pot)
+			ch <- prometheus.MustNewConstMetric(potCPUDesc, prometheus.GaugeValue, stat.CPUPercent, potKey)
+			ch <- prometheus.MustNewConstMetric(potMemoryPercentDesc, prometheus.GaugeValue, stat.MemoryPercent, potKey)
+			ch <- prometheus.MustNewConstMetric(potMemoryDesc, prometheus.GaugeValue, float64(stat.MemoryUsage), potKey)
+			ch <- prometheus.MustNewConstMetric(potNetworkRxDesc, prometheus.CounterValue, stat.NetworkRx, potKey)
+			ch <- prometheus.MustNewConstMetric(potNetworkTxDesc, prometheus.CounterValue, stat.NetworkTx, potKey)
+			ch <- prometheus.MustNewConstMetric(potBlockReadDesc, prometheus.CounterValue, float64(stat.BlockRead), potKey)
+			ch <- prometheus.MustNewConstMetric(potBlockWriteDesc, prometheus.CounterValue, float64(stat.BlockWrite), potKey)
+		}
+	}
+
+	for potName, stats := range middleware.ReadCaptureStats() {
+		ch <- prometheus.MustNewConstMetric(captureReceivedDesc, prometheus.CounterValue, float64(stats.Received), potName)
+		ch <- prometheus.MustNewConstMetric(captureDroppedDesc, prometheus.CounterValue, float64(stats.Dropped), potName, "buffer")
+		ch <- prometheus.MustNewConstMetric(captureDroppedDesc, prometheus.CounterValue, float64(stats.InterfaceDropped), potName, "interface")
+	}
+
+	sizes := make(map[string]int64)
+	counts := make(map[string]int)
+	flagged := make(map[string]int)
+	for _, sensor := range m.sensors {
+		runs, err := middleware.ReadArtifactRuns(sensor.ArtifactRoot(outputRoot))
+		if err != nil {
+			continue
+		}
+		for _, run := range runs {
+			potKey := middleware.PotKey(sensor.EventHost(), run.Pot)
+			sizes[potKey] += run.Size
+			counts[potKey]++
+			if run.Flagged {
+				flagged[potKey]++
+			}
+		}
+	}
+	for potName, size := range sizes {
+		ch <- prometheus.MustNewConstMetric(artifactBytesDesc, prometheus.GaugeValue, float64(size), potName)
+		ch <- prometheus.MustNewConstMetric(artifactRunsDesc, prometheus.GaugeValue, float64(counts[potName]), potName)
+		ch <- prometheus.MustNewConstMetric(artifactFlaggedDesc, prometheus.GaugeValue, float64(flagged[potName]), potName)
+	}
+
+	ch <- prometheus.MustNewConstMetric(potMetricsErrorsDesc, prometheus.GaugeValue, scrapeError)
+}
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics registers the pot metrics and starts counting published events.
+func registerMetrics(ctx context.Context, sensors []middleware.Sensor) {
+	registerMetricsOnce.Do(func() {
+		metricsRegistry.MustRegister(
+			prometheus.NewGoCollector(),
+			prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+			connectionsTotal, credentialsTotal, collectionsTotal, collectionFailuresTotal, eventsTotal, uniqueAttackers,
+			potMetrics{ctx: ctx, sensors: sensors},
+		)
+
+		go countEventMetrics(middleware.SubscribeEvents(1024))
+	})
+}
+
+func countEventMetrics(events <-chan middleware.Event) {
+	attackers := make(map[string]map[string]struct{})
+
+	for event := range events {
+		if event.Pot == "" {
+			continue
+		}
+		event.Pot = middleware.PotKey(event.Host, event.Pot)
+
+		eventsTotal.WithLabelValues(event.Pot, event.Kind).Inc()
+
+		switch event.Kind {
+		case middleware.EventConnection:
+			connectionsTotal.WithLabelValues(event.Pot).Inc()
+		case middleware.EventLogin:
+			credentialsTotal.WithLabelValues(event.Pot).Inc()
+		case middleware.EventCollect:
+			collectionsTotal.WithLabelValues(event.Pot).Inc()
+			if event.Fields["failed"] == "true" {
+				collectionFailuresTotal.WithLabelValues(event.Pot).Inc()
+			}
+		}
+
+		if event.SourceIP != "" && (event.Kind == middleware.EventConnection || event.Kind == middleware.EventLogin || event.Kind == middleware.EventCommand) {
+			if attackers[event.Pot] == nil {
+				attackers[event.Pot] = make(map[string]struct{})
+			}
+			attackers[event.Pot][event.SourceIP] = struct{}{}
+			uniqueAttackers.WithLabelValues(event.Pot).Set(float64(len(attackers[event.Pot])))
+		}
+	}
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// serveMetrics serves /metrics alone for collect, which has no API server.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+
+	log.Printf("Serving metrics on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Printf("error while serving metrics - %s", err)
+	}
+}
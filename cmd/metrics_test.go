@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+func scrapeMetrics() string {
+	recorder := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+	return string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	// a daemon nobody listens on, so reading the pots fails on every scrape
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerMetrics(context.Background(), []middleware.Sensor{{Host: middleware.Host{Name: middleware.LocalHostName}, Runtime: middleware.DockerRuntime{Client: cli}, Client: cli}})
+
+	// the registry lives as long as the process, so every run counts on a pot of its own
+	pot := fmt.Sprintf("metrics%d", time.Now().UnixNano())
+	middleware.PublishEvent(middleware.Event{Pot: pot, Kind: middleware.EventConnection, SourceIP: "192.0.2.1"})
+	middleware.PublishEvent(middleware.Event{Pot: pot, Kind: middleware.EventLogin, SourceIP: "192.0.2.1", Username: "root"})
+	middleware.PublishEvent(middleware.Event{Pot: pot, Kind: middleware.EventLogin, SourceIP: "192.0.2.2", Username: "admin"})
+	middleware.PublishEvent(middleware.Event{Pot: pot, Kind: middleware.EventCollect, Fields: map[string]string{"failed": "true"}})
+	middleware.PublishEvent(middleware.Event{Pot: pot, Host: "edge", Kind: middleware.EventConnection, SourceIP: "192.0.2.3"})
+
+	expected := []string{
+		`honeypot_connections_total{pot="` + pot + `"} 1`,
+		`honeypot_connections_total{pot="edge/` + pot + `"} 1`,
+		`honeypot_credentials_total{pot="` + pot + `"} 2`,
+		`honeypot_collections_total{pot="` + pot + `"} 1`,
+		`honeypot_collection_failures_total{pot="` + pot + `"} 1`,
+		`honeypot_events_total{kind="login",pot="` + pot + `"} 2`,
+		`honeypot_unique_attackers{pot="` + pot + `"} 2`,
+		`# HELP honeypot_scrape_error Whether reading pots from the container runtime failed during the scrape.`,
+		`honeypot_scrape_error 1`,
+	}
+
+	// events are counted in background, so scrape until the last one shows up; collectors are
+	// gathered concurrently, so the metrics are read again once it has
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(scrapeMetrics(), expected[1]) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	body := scrapeMetrics()
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Errorf("scraped metric not match\nexpected: %s, actual:\n%s", line, body)
+		}
+	}
+}
@@ -10,10 +10,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 	"github.com/spf13/cobra"
@@ -159,9 +159,9 @@ func calculateHostCpuPercent() (idle, total uint64) {
 	return
 }
 
-func getPotsName(context context.Context, client *client.Client) ([]string, []string, []string) {
+func getPotsName(context context.Context, sensors []middleware.Sensor) ([]string, []string, []string) {
 
-	pots, _ := middleware.ReadAllPots(context, client)
+	pots, _ := middleware.ReadFleetPots(context, sensors)
 	var status string
 	var state string
 	var potNameList []string
@@ -175,7 +175,7 @@ func getPotsName(context context.Context, client *client.Client) ([]string, []st
 			status = container.Status
 			state = container.State
 		}
-		potNameList = append(potNameList, ("[" + strconv.Itoa(index) + "]" + pot.Name))
+		potNameList = append(potNameList, ("[" + strconv.Itoa(index) + "]" + middleware.PotKey(pot.Host, pot.Name)))
 		runningTimeList = append(runningTimeList, status)
 		stateList = append(stateList, state)
 	}
@@ -183,17 +183,23 @@ func getPotsName(context context.Context, client *client.Client) ([]string, []st
 	return potNameList, runningTimeList, stateList
 }
 
-func PotsStatusLoad(context context.Context, client *client.Client, PotsCpu, PotsMemory, PotsNetowrk *[]string, runningCheck *bool) {
+func PotsStatusLoad(context context.Context, sensors []middleware.Sensor, PotsCpu, PotsMemory, PotsNetowrk *[]string, runningCheck *bool) {
 
 	*runningCheck = true
 
-	stats, err := middleware.ReadAllPotStatus(context, client)
+	stats := make(map[string]types.ContainerStats)
+	for _, sensor := range sensors {
+		hostStats, err := middleware.ReadAllPotStatus(context, sensor.Runtime)
+		if err != nil {
+			log.Fatalf("middlware ReadAllPotStatus Error %v", err)
+		}
+		for potName, stat := range hostStats {
+			stats[middleware.PotKey(sensor.EventHost(), potName)] = stat
+		}
+	}
 	var CpuList []string
 	var MemoryList []string
 	var Network []string
-	if err != nil {
-		log.Fatalf("middlware ReadAllPotStatus Error %v", err)
-	}
 	*PotsCpu = CpuList
 	*PotsMemory = MemoryList
 	*PotsNetowrk = Network
@@ -302,6 +308,81 @@ func calculateHostNetworkTotal() (uint64, uint64, uint64, uint64) {
 	return BytesRecv, PacketsRecv, BytesSent, PacketsSent
 }
 
+// monitorWorldRows is the number of countries and autonomous systems shown.
+const monitorWorldRows = 10
+
+// worldBreakdown returns the top countries and autonomous systems of attacks recorded in the last day.
+func worldBreakdown() ([]string, []string) {
+	if outputRoot == "" && eventDB == "" {
+		return []string{"Run monitor with --path"}, []string{"Run monitor with --path"}
+	}
+
+	events, err := middleware.NewStore(eventDBPath()).QueryEvents(middleware.EventFilter{
+		Kinds: []string{middleware.EventConnection, middleware.EventLogin, middleware.EventCommand},
+		Since: time.Now().Add(-24 * time.Hour),
+	})
+	if err != nil {
+		return []string{err.Error()}, []string{err.Error()}
+	}
+	enrichEvents(events)
+
+	rows := func(field string) []string {
+		var total int
+		counts := middleware.CountEvents(events, field)
+		for _, count := range counts {
+			total += count.Count
+		}
+		if total == 0 {
+			return []string{"No enriched events"}
+		}
+
+		var result []string
+		for index, count := range counts {
+			if index == monitorWorldRows {
+				break
+			}
+			result = append(result, fmt.Sprintf("%-32.32s %6d %5.1f%%", count.Key, count.Count, float64(count.Count)/float64(total)*100))
+		}
+		return result
+	}
+	return rows("country"), rows("asn")
+}
+
+// worldLoad keeps the last world breakdown, loaded in background as the event database may be
+// locked by collect for a while.
+type worldLoad struct {
+	sync.Mutex
+	running   bool
+	countries []string
+	asns      []string
+}
+
+// load reads the world breakdown in background unless a load is still running.
+func (w *worldLoad) load() {
+	w.Lock()
+	defer w.Unlock()
+	if w.running {
+		return
+	}
+	w.running = true
+
+	go func() {
+		countries, asns := worldBreakdown()
+
+		w.Lock()
+		defer w.Unlock()
+		w.countries, w.asns = countries, asns
+		w.running = false
+	}()
+}
+
+// rows returns the last world breakdown loaded.
+func (w *worldLoad) rows() ([]string, []string) {
+	w.Lock()
+	defer w.Unlock()
+	return w.countries, w.asns
+}
+
 func makeInitDotList(n int) []float64 {
 	ps := make([]float64, n)
 	for i := range ps {
@@ -316,7 +397,7 @@ func drawInitUI(potNameList, runningTimeList, stateList, potsCpuList, potsMemory
 
 	info := widgets.NewParagraph()
 	info.Title = "Honey Pot Moniter"
-	info.Text = "Stop : PRESS q or ESC "
+	info.Text = "Stop : PRESS q or ESC \nAttacks by Country and ASN : PRESS w"
 	info.SetRect(0, 0, 50, 5)
 	info.TextStyle.Fg = ui.ColorWhite
 	info.BorderStyle.Fg = ui.ColorWhite
@@ -417,20 +498,52 @@ func drawInitUI(potNameList, runningTimeList, stateList, potsCpuList, potsMemory
 	return info, PotsName, PotsRunningTime, PotsState, PotsCpu, PotsMem, PotsNet, NetworkTraffic1, NetworkTraffic2, NetworkTraffic3, NetworkTraffic4, DevInfo, MemoryUsed, CpuUsed
 }
 
-func showTable(context context.Context, client *client.Client) {
+// drawWorldUI returns the panels of the world tab, which has the terminal to itself.
+func drawWorldUI(countryList, asnList []string) (*widgets.List, *widgets.List) {
+
+	WorldCountry := widgets.NewList()
+	WorldCountry.Title = "Attacks by Country (24h) - PRESS w to go back"
+	WorldCountry.Rows = countryList
+	WorldCountry.TextStyle.Fg = ui.ColorYellow
+	WorldCountry.BorderStyle.Fg = ui.ColorBlue
+
+	WorldASN := widgets.NewList()
+	WorldASN.Title = "Attacks by ASN (24h)"
+	WorldASN.Rows = asnList
+	WorldASN.TextStyle.Fg = ui.ColorYellow
+	WorldASN.BorderStyle.Fg = ui.ColorBlue
+
+	resizeWorldUI(WorldCountry, WorldASN)
+	return WorldCountry, WorldASN
+}
+
+// resizeWorldUI splits the terminal between the panels of the world tab, side by side when wide
+// enough for both and stacked otherwise.
+func resizeWorldUI(WorldCountry, WorldASN *widgets.List) {
+	width, height := ui.TerminalDimensions()
+	if width >= 100 {
+		WorldCountry.SetRect(0, 0, width/2, height)
+		WorldASN.SetRect(width/2, 0, width, height)
+	} else {
+		WorldCountry.SetRect(0, 0, width, height/2)
+		WorldASN.SetRect(0, height/2, width, height)
+	}
+}
+
+func showTable(context context.Context, sensors []middleware.Sensor) {
 	if err := ui.Init(); err != nil {
 		log.Fatalf("failed to initialize termui: %v", err)
 	}
 	defer ui.Close()
 
-	potNameList, runningTimeList, stateList := getPotsName(context, client)
+	potNameList, runningTimeList, stateList := getPotsName(context, sensors)
 	potsCpuList := []string{"Wait...", "Loading.."}
 	potsMemoryList := []string{"For...", "Loading.."}
 	potsNetworkList := []string{"Seconds...", "Loading.."}
 
 	// PotsStatusLoad Thread Running Check.
 	var runningCheck = false
-	go PotsStatusLoad(context, client, &potsCpuList, &potsMemoryList, &potsNetworkList, &runningCheck)
+	go PotsStatusLoad(context, sensors, &potsCpuList, &potsMemoryList, &potsNetworkList, &runningCheck)
 
 	MemoryGraphDot := makeInitDotList(222)
 	NetWorkGrapDot1 := makeInitDotList(222)
@@ -439,6 +552,10 @@ func showTable(context context.Context, client *client.Client) {
 	NetWorkGrapDot4 := makeInitDotList(222)
 
 	var info, PotsName, PotsRunningTime, PotsState, PotsCpu, PotsMemory, PotsNetowrk, NetworkTraffic1, NetworkTraffic2, NetworkTraffic3, NetworkTraffic4, DevInfo, MemoryUsed, CpuUsed = drawInitUI(potNameList, runningTimeList, stateList, potsCpuList, potsMemoryList, potsNetworkList, MemoryGraphDot, NetWorkGrapDot1, NetWorkGrapDot2, NetWorkGrapDot3, NetWorkGrapDot4)
+	world := &worldLoad{countries: []string{"Loading.."}, asns: []string{"Loading.."}}
+	world.load()
+	var WorldCountry, WorldASN = drawWorldUI(world.rows())
+	showWorld := false
 
 	// Host Network Amount Get
 	NetworkSentBytesBefore, NetworkRecvBytesBefore, NetworkSentPacketBefore, NetworkRecvPacketBefore := calculateHostNetworkTotal()
@@ -477,6 +594,15 @@ func showTable(context context.Context, client *client.Client) {
 		}
 	}
 
+	// Render the dashboard, or the world tab
+	render := func() {
+		if showWorld {
+			ui.Render(WorldCountry, WorldASN)
+			return
+		}
+		ui.Render(info, PotsName, PotsCpu, PotsMemory, PotsNetowrk, PotsRunningTime, PotsState, NetworkTraffic1, NetworkTraffic2, NetworkTraffic3, NetworkTraffic4, DevInfo, MemoryUsed, CpuUsed)
+	}
+
 	// Change information Control Function
 	draw := func(count int) {
 		networkStartIndex = count % 200
@@ -514,9 +640,15 @@ func showTable(context context.Context, client *client.Client) {
 		PotsState.Rows = stateList[count%len(stateList):]
 
 		if runningCheck == false {
-			go PotsStatusLoad(context, client, &potsCpuList, &potsMemoryList, &potsNetworkList, &runningCheck)
+			go PotsStatusLoad(context, sensors, &potsCpuList, &potsMemoryList, &potsNetworkList, &runningCheck)
 		}
 
+		// World breakdown reads the event database, refresh it every 10 seconds
+		if count%10 == 0 {
+			world.load()
+		}
+		WorldCountry.Rows, WorldASN.Rows = world.rows()
+
 		if count%2 == 0 {
 			idleNow, totalNow = calculateHostCpuPercent()
 			idleTicks = float64(idleNow - idleBefore)
@@ -531,7 +663,7 @@ func showTable(context context.Context, client *client.Client) {
 		NetworkTraffic3.Data[0] = NetWorkGrapDot3[networkStartIndex:]
 		NetworkTraffic4.Data[0] = NetWorkGrapDot4[networkStartIndex:]
 
-		ui.Render(info, PotsName, PotsCpu, PotsMemory, PotsNetowrk, PotsRunningTime, PotsState, NetworkTraffic1, NetworkTraffic2, NetworkTraffic3, NetworkTraffic4, DevInfo, MemoryUsed, CpuUsed)
+		render()
 	}
 
 	tickerCount := 1
@@ -547,6 +679,14 @@ func showTable(context context.Context, client *client.Client) {
 			switch e.ID {
 			case "q", "<C-c>":
 				return
+			case "w":
+				showWorld = !showWorld
+				ui.Clear()
+				render()
+			case "<Resize>":
+				resizeWorldUI(WorldCountry, WorldASN)
+				ui.Clear()
+				render()
 			}
 		case <-ticker:
 			drawUpdateColor(tickerCount)
@@ -557,17 +697,18 @@ func showTable(context context.Context, client *client.Client) {
 }
 
 var monitorCmd = &cobra.Command{
-	Use: "monitor",
+	Use:   "monitor",
+	Short: "Monitor pots, host usage and attack origins in the terminal",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			panic(err)
-		}
-		showTable(ctx, cli)
+		showTable(ctx, connectSensors())
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(monitorCmd)
+
+	monitorCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output, for the world breakdown of recorded events")
+	monitorCmd.Flags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	addHostFlags(monitorCmd)
 }
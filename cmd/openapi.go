@@ -0,0 +1,299 @@
+package cmd
+
+// openAPIDocument describes the REST API served by serve.
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Honey-V API",
+    "description": "Manage pots, collected artifacts and events of the collect daemon.",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"bearer": []}, {"query": []}],
+  "paths": {
+    "/pots": {
+      "get": {
+        "summary": "List pots",
+        "responses": {
+          "200": {"description": "Pots", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Pot"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Deploy a new pot",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeployRequest"}}}},
+        "responses": {
+          "201": {"description": "Deployed pot", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pot"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pots/{name}": {
+      "parameters": [{"$ref": "#/components/parameters/PotName"}],
+      "get": {
+        "summary": "Read a pot",
+        "responses": {
+          "200": {"description": "Pot", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pot"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Remove a pot with its containers and network",
+        "responses": {
+          "204": {"description": "Removed"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pots/{name}/stats": {
+      "parameters": [{"$ref": "#/components/parameters/PotName"}],
+      "get": {
+        "summary": "Read resource usage of a pot",
+        "responses": {
+          "200": {"description": "Stats", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PotStats"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/stats": {
+      "get": {
+        "summary": "Read resource usage of every running pot",
+        "responses": {
+          "200": {"description": "Stats", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/PotStats"}}}}}
+        }
+      }
+    },
+    "/pots/{name}/collect": {
+      "parameters": [{"$ref": "#/components/parameters/PotName"}],
+      "post": {
+        "summary": "Collect artifacts of a pot now and reset it",
+        "responses": {
+          "202": {"description": "Collection started"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pots/{name}/reset": {
+      "parameters": [{"$ref": "#/components/parameters/PotName"}],
+      "post": {
+        "summary": "Replace a pot with a clean container without collecting",
+        "responses": {
+          "200": {"description": "Reset pot", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pot"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/artifacts": {
+      "get": {
+        "summary": "List collection runs, oldest first",
+        "parameters": [{"name": "pot", "in": "query", "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Runs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/ArtifactRun"}}}}}
+        }
+      }
+    },
+    "/artifacts/{run}": {
+      "parameters": [{"$ref": "#/components/parameters/RunName"}],
+      "get": {
+        "summary": "Read a collection run with its manifest and files",
+        "responses": {
+          "200": {"description": "Run", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ArtifactRun"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/artifacts/{run}/download": {
+      "parameters": [
+        {"$ref": "#/components/parameters/RunName"},
+        {"name": "file", "in": "query", "description": "Single file of the run instead of the archive", "schema": {"type": "string"}}
+      ],
+      "get": {
+        "summary": "Download a collection run as tar.gz",
+        "responses": {
+          "200": {"description": "Archive or file", "content": {"application/gzip": {}, "application/octet-stream": {}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "summary": "Query recorded events in time order",
+        "parameters": [
+          {"name": "pot", "in": "query", "schema": {"type": "string"}},
+          {"name": "ip", "in": "query", "schema": {"type": "string"}},
+          {"name": "kind", "in": "query", "schema": {"type": "string"}, "description": "Comma separated event kinds"},
+          {"name": "since", "in": "query", "schema": {"type": "string"}, "description": "RFC3339 time, date or duration before now"},
+          {"name": "until", "in": "query", "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer"}},
+          {"name": "count_by", "in": "query", "schema": {"type": "string"}, "description": "Aggregate by ip, port, credential, username, password, host, pot, kind, country, city, asn, org, actor, campaign or a field"},
+          {"name": "correlate", "in": "query", "schema": {"type": "boolean"}, "description": "Set actor and campaign of events correlated across pots"}
+        ],
+        "responses": {
+          "200": {"description": "Events or counts", "content": {"application/json": {"schema": {"oneOf": [
+            {"type": "array", "items": {"$ref": "#/components/schemas/Event"}},
+            {"type": "array", "items": {"$ref": "#/components/schemas/EventCount"}}
+          ]}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/campaigns": {
+      "get": {
+        "summary": "Correlate attackers of a time range across pots into actors and campaigns",
+        "parameters": [
+          {"name": "since", "in": "query", "schema": {"type": "string"}, "description": "RFC3339 time, date or duration before now"},
+          {"name": "until", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Actors and campaigns", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "actors": {"type": "array", "items": {"type": "object"}},
+            "campaigns": {"type": "array", "items": {"type": "object"}}
+          }}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/events/stream": {
+      "get": {
+        "summary": "Stream live events as server-sent events named by event kind",
+        "parameters": [
+          {"name": "pot", "in": "query", "schema": {"type": "string"}},
+          {"name": "ip", "in": "query", "schema": {"type": "string"}},
+          {"name": "kind", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Event stream", "content": {"text/event-stream": {}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"},
+      "query": {"type": "apiKey", "in": "query", "name": "token"}
+    },
+    "parameters": {
+      "PotName": {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+      "RunName": {"name": "run", "in": "path", "required": true, "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"type": "object", "properties": {"error": {"type": "string"}}}}}}
+    },
+    "schemas": {
+      "Pot": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "state": {"type": "string"},
+          "status": {"type": "string"},
+          "profile": {"type": "string"},
+          "collectors": {"type": "array", "items": {"type": "string"}},
+          "ingress": {"type": "boolean"},
+          "checkpoint": {"type": "boolean"},
+          "collecting": {"type": "boolean"},
+          "containers": {"type": "array", "items": {"type": "object", "properties": {
+            "id": {"type": "string"},
+            "name": {"type": "string"},
+            "image": {"type": "string"},
+            "state": {"type": "string"},
+            "status": {"type": "string"},
+            "created": {"type": "string", "format": "date-time"}
+          }}}
+        }
+      },
+      "DeployRequest": {
+        "type": "object",
+        "required": ["name", "image"],
+        "properties": {
+          "name": {"type": "string"},
+          "image": {"type": "string"},
+          "ports": {"type": "array", "items": {"type": "string"}, "example": ["2222:22"]},
+          "environments": {"type": "array", "items": {"type": "string"}},
+          "profile": {"type": "string", "enum": ["light", "standard", "full"]},
+          "collectors": {"type": "array", "items": {"type": "string"}},
+          "ingress": {"type": "boolean"},
+          "checkpoint": {"type": "boolean"}
+        }
+      },
+      "PotStats": {
+        "type": "object",
+        "properties": {
+          "pot": {"type": "string"},
+          "cpu_percent": {"type": "number"},
+          "memory_percent": {"type": "number"},
+          "memory_usage": {"type": "integer"},
+          "memory_limit": {"type": "integer"},
+          "network_rx": {"type": "number"},
+          "network_tx": {"type": "number"},
+          "block_read": {"type": "integer"},
+          "block_write": {"type": "integer"}
+        }
+      },
+      "ArtifactRun": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "pot": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"},
+          "path": {"type": "string"},
+          "size": {"type": "integer"},
+          "compressed": {"type": "boolean"},
+          "flagged": {"type": "boolean"},
+          "manifest": {"type": "object"},
+          "files": {"type": "array", "items": {"type": "object", "properties": {
+            "name": {"type": "string"},
+            "size": {"type": "integer"},
+            "sha256": {"type": "string"}
+          }}}
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "host": {"type": "string", "description": "Fleet host of the pot, absent for the local daemon"},
+          "pot": {"type": "string"},
+          "container": {"type": "string"},
+          "kind": {"type": "string", "enum": ["connection", "outbound", "file", "process", "cpu", "login", "command", "collect", "reset", "yara", "docker"]},
+          "severity": {"type": "integer"},
+          "protocol": {"type": "string"},
+          "source_ip": {"type": "string"},
+          "source_port": {"type": "integer"},
+          "destination_ip": {"type": "string"},
+          "destination_port": {"type": "integer"},
+          "username": {"type": "string"},
+          "password": {"type": "string"},
+          "command": {"type": "string"},
+          "path": {"type": "string"},
+          "hash": {"type": "string"},
+          "message": {"type": "string"},
+          "geo": {"type": "object", "properties": {
+            "country_code": {"type": "string"},
+            "country": {"type": "string"},
+            "city": {"type": "string"},
+            "latitude": {"type": "number"},
+            "longitude": {"type": "number"},
+            "asn": {"type": "integer"},
+            "organization": {"type": "string"}
+          }},
+          "actor": {"type": "string", "description": "Actor of the source address, set when correlated"},
+          "campaign": {"type": "string", "description": "Campaign of the actor or dropped file, set when correlated"},
+          "fields": {"type": "object", "additionalProperties": {"type": "string"}}
+        }
+      },
+      "EventCount": {
+        "type": "object",
+        "properties": {
+          "key": {"type": "string"},
+          "count": {"type": "integer"},
+          "pots": {"type": "integer"},
+          "first": {"type": "string", "format": "date-time"},
+          "last": {"type": "string", "format": "date-time"}
+        }
+      }
+    }
+  }
+}
+`
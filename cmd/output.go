@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// syslogConfig parses the syslog output flags.
+func syslogConfig() (middleware.SyslogConfig, error) {
+	target, err := url.Parse(syslogTarget)
+	if err != nil {
+		return middleware.SyslogConfig{}, err
+	}
+	if target.Host == "" {
+		return middleware.SyslogConfig{}, fmt.Errorf("expected udp://, tcp:// or tls://host:port, got %s", syslogTarget)
+	}
+
+	config := middleware.SyslogConfig{
+		Network:    target.Scheme,
+		Address:    target.Host,
+		Format:     syslogFormat,
+		BufferSize: syslogBuffer,
+	}
+
+	if len(syslogFields) > 0 {
+		defaults := middleware.DefaultCEFMapping
+		if strings.EqualFold(syslogFormat, "leef") {
+			defaults = middleware.DefaultLEEFMapping
+		}
+
+		config.Mapping = make(map[string]string)
+		for field, key := range defaults {
+			config.Mapping[field] = key
+		}
+		for field, key := range syslogFields {
+			if key == "" {
+				delete(config.Mapping, field)
+				continue
+			}
+			config.Mapping[field] = key
+		}
+	}
+
+	if config.Network == "tls" {
+		config.TLS = &tls.Config{ServerName: target.Hostname()}
+		if syslogCA != "" {
+			pem, err := ioutil.ReadFile(syslogCA)
+			if err != nil {
+				return middleware.SyslogConfig{}, err
+			}
+			config.TLS.RootCAs = x509.NewCertPool()
+			if !config.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return middleware.SyslogConfig{}, errors.New("no certificate found in " + syslogCA)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+const elasticAPIKeyEnv = "HONEYPOT_ELASTIC_API_KEY"
+
+// elasticExporter ships events and run manifests when --elastic is given.
+var elasticExporter *middleware.ElasticExporter
+
+// containerImageResolver returns a cached lookup of the image name of a container on any of the sensors.
+func containerImageResolver(ctx context.Context, sensors []middleware.Sensor) func(string) string {
+	var mutex sync.Mutex
+	images := make(map[string]string)
+
+	return func(containerID string) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if image, found := images[containerID]; found {
+			return image
+		}
+
+		var image string
+		for _, sensor := range sensors {
+			if sensor.Client == nil {
+				// other runtimes describe images in their container list
+				containers, _ := sensor.Runtime.ListContainers(ctx)
+				for _, container := range containers {
+					if container.ID == containerID {
+						image = container.Image
+					}
+				}
+			} else if container, err := sensor.Client.ContainerInspect(ctx, containerID); err == nil {
+				image = container.Config.Image
+			}
+			if image != "" {
+				break
+			}
+		}
+		images[containerID] = image
+		return image
+	}
+}
+
+func startElasticExporter(ctx context.Context, sensors []middleware.Sensor) {
+	apiKey := elasticAPIKey
+	if apiKey == "" {
+		apiKey = os.Getenv(elasticAPIKeyEnv)
+	}
+	spoolDir := elasticSpool
+	if spoolDir == "" {
+		spoolDir = filepath.Join(outputRoot, "spool", "elastic")
+	}
+
+	exporter, err := middleware.NewElasticExporter(middleware.ElasticConfig{
+		URL:            elasticURL,
+		Username:       elasticUser,
+		Password:       elasticPassword,
+		APIKey:         apiKey,
+		IndexPrefix:    elasticIndex,
+		BatchSize:      elasticBatch,
+		FlushInterval:  elasticFlush,
+		SpoolDir:       spoolDir,
+		ContainerImage: containerImageResolver(ctx, sensors),
+	})
+	if err != nil {
+		log.Printf("error while starting elasticsearch output %s - %s", elasticURL, err)
+		os.Exit(1)
+	}
+
+	if elasticTemplate {
+		if err := exporter.InstallTemplate(); err != nil {
+			log.Printf("error while installing elasticsearch index template - %s", err)
+		} else {
+			log.Printf("Install %s index template to elasticsearch", elasticIndex)
+		}
+	}
+
+	elasticExporter = exporter
+	log.Printf("Export events to elasticsearch %s", elasticURL)
+	go exporter.Run(middleware.SubscribeEvents(1024))
+}
+
+// exportManifest ships the manifest of a finished collection run.
+func exportManifest(runPath string, manifest middleware.Manifest) {
+	if elasticExporter != nil {
+		elasticExporter.SendManifest(filepath.Base(runPath), manifest)
+	}
+}
+
+// startOutputs forwards published events to the configured outputs.
+func startOutputs(ctx context.Context, sensors []middleware.Sensor) {
+	if elasticURL != "" {
+		startElasticExporter(ctx, sensors)
+	}
+	if syslogTarget == "" {
+		return
+	}
+
+	config, err := syslogConfig()
+	if err == nil {
+		var sink *middleware.SyslogSink
+		if sink, err = middleware.NewSyslogSink(config); err == nil {
+			log.Printf("Forward events to %s as %s syslog", syslogTarget, sink.Format())
+			go sink.Run(middleware.SubscribeEvents(1024))
+		}
+	}
+	if err != nil {
+		log.Printf("error while starting syslog output %s - %s", syslogTarget, err)
+		os.Exit(1)
+	}
+}
+
+func addOutputFlags(command *cobra.Command) {
+	command.Flags().StringVar(&syslogTarget, "syslog", "", "Forward events to RFC 5424 syslog, e.g. tcp://siem:6514 (udp, tcp or tls)")
+	command.Flags().StringVar(&syslogFormat, "syslog-format", "cef", "Syslog message format (cef or leef)")
+	command.Flags().StringToStringVar(&syslogFields, "syslog-field", nil, "Override event field mapping, e.g. pot=cs5 (empty key to drop)")
+	command.Flags().StringVar(&syslogCA, "syslog-ca", "", "CA certificate verifying the tls syslog collector")
+	command.Flags().IntVar(&syslogBuffer, "syslog-buffer", 10000, "Events kept while the syslog collector is unreachable")
+
+	command.Flags().StringVar(&elasticURL, "elastic", "", "Export events and run manifests to elasticsearch _bulk API, e.g. http://localhost:9200")
+	command.Flags().StringVar(&elasticIndex, "elastic-index", "honeypot", "Prefix of elasticsearch indices")
+	command.Flags().StringVar(&elasticUser, "elastic-user", "", "Username of elasticsearch basic auth")
+	command.Flags().StringVar(&elasticPassword, "elastic-password", "", "Password of elasticsearch basic auth")
+	command.Flags().StringVar(&elasticAPIKey, "elastic-api-key", "", "Elasticsearch API key (default $"+elasticAPIKeyEnv+")")
+	command.Flags().IntVar(&elasticBatch, "elastic-batch", 500, "Documents per bulk request")
+	command.Flags().DurationVar(&elasticFlush, "elastic-flush", 5*time.Second, "Interval of sending incomplete batches")
+	command.Flags().StringVar(&elasticSpool, "elastic-spool", "", "Directory of batches kept during outages (default <path>/spool/elastic)")
+	command.Flags().BoolVar(&elasticTemplate, "elastic-template", true, "Install the index template on start")
+}
+
+var (
+	syslogTarget string            // URL of syslog collector
+	syslogFormat string            // Syslog message format
+	syslogFields map[string]string // Event field -> CEF/LEEF key overrides
+	syslogCA     string            // CA certificate of tls syslog collector
+	syslogBuffer int               // Events kept while the collector is unreachable
+
+	elasticURL      string        // Base URL of elasticsearch
+	elasticIndex    string        // Prefix of elasticsearch indices
+	elasticUser     string        // Username of elasticsearch basic auth
+	elasticPassword string        // Password of elasticsearch basic auth
+	elasticAPIKey   string        // Elasticsearch API key
+	elasticBatch    int           // Documents per bulk request
+	elasticFlush    time.Duration // Interval of sending incomplete batches
+	elasticSpool    string        // Directory of batches kept during outages
+	elasticTemplate bool          // Install the index template on start
+)
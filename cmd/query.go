@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+var eventStore *middleware.Store
+
+func eventDBPath() string {
+	if eventDB != "" {
+		return eventDB
+	}
+	return filepath.Join(outputRoot, middleware.EventDBFileName)
+}
+
+// indexArtifactRun stores the events found in the run and publishes the collection.
+func indexArtifactRun(runPath string) {
+	events, err := middleware.IndexArtifactRun(runPath)
+	if err != nil {
+		log.Printf("error while indexing %s - %s", filepath.Base(runPath), err)
+		return
+	}
+
+	if eventStore != nil {
+		if err := eventStore.PutEvents(events); err != nil {
+			log.Printf("error while indexing %s - %s", filepath.Base(runPath), err)
+		}
+	}
+
+	// first event is the collection run itself, yara matches are raised with it
+	middleware.PublishEvent(events[0])
+	for _, event := range events[1:] {
+		if event.Kind == middleware.EventYara {
+			middleware.PublishEvent(event)
+		}
+	}
+}
+
+// parseTimeFlag accepts RFC3339 time, a date or a duration before now.
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return parsed, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %s, expected RFC3339, YYYY-MM-DD or duration", value)
+}
+
+func readEventFilter() middleware.EventFilter {
+	since, err := parseTimeFlag(querySince)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	until, err := parseTimeFlag(queryUntil)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	return middleware.EventFilter{
+		Pot:      potName,
+		SourceIP: querySourceIP,
+		Kinds:    queryKinds,
+		Since:    since,
+		Until:    until,
+	}
+}
+
+func writeRecords(w io.Writer, format string, header []string, rows [][]string, value interface{}) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "csv":
+		writer := csv.NewWriter(w)
+		_ = writer.Write(header)
+		_ = writer.WriteAll(rows)
+		return writer.Error()
+	case "table":
+		table := tablewriter.NewWriter(w)
+		table.SetHeader(header)
+		table.AppendBulk(rows)
+		table.Render()
+		return nil
+	}
+	return fmt.Errorf("unknown %s format, expected table, json or csv", format)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func eventRow(event middleware.Event) []string {
+	var source, destination string
+	if event.SourceIP != "" {
+		source = fmt.Sprintf("%s:%d", event.SourceIP, event.SourcePort)
+		if event.Geo != nil && event.Geo.CountryCode != "" {
+			source += " (" + event.Geo.CountryCode + ")"
+		}
+	}
+	if event.DestinationPort != 0 {
+		destination = fmt.Sprintf("%s:%d", event.DestinationIP, event.DestinationPort)
+	}
+
+	detail := event.Message
+	if event.Username != "" || event.Password != "" {
+		detail = fmt.Sprintf("%s:%s", event.Username, event.Password)
+	} else if event.Command != "" {
+		detail = event.Command
+	} else if event.Path != "" && event.Kind == middleware.EventFileChange {
+		detail = event.Path
+	}
+
+	return []string{formatTime(event.Time), event.Pot, event.Kind, strconv.Itoa(event.Severity), source, destination, detail}
+}
+
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Query events recorded from every pot",
+	Run: func(cmd *cobra.Command, args []string) {
+		eventStore = middleware.NewStore(eventDBPath())
+
+		if queryIndex {
+			runs, err := middleware.ReadArtifactRuns(outputRoot)
+			if err != nil {
+				panic(err)
+			}
+			for _, run := range runs {
+				if !run.Compressed {
+					indexArtifactRun(run.Path)
+				}
+			}
+			log.Printf("Index %d artifact run(s)", len(runs))
+		}
+
+		filter := readEventFilter()
+		if queryCountBy == "" {
+			filter.Limit = queryLimit
+		}
+
+		events, err := eventStore.QueryEvents(filter)
+		if err != nil {
+			log.Printf("error while querying events - %s", err)
+			os.Exit(1)
+		}
+		enrichEvents(events)
+		if queryCorrelate || queryCountBy == "actor" || queryCountBy == "campaign" {
+			if _, err := correlateEvents(eventStore, filter, events); err != nil {
+				log.Printf("error while correlating events - %s", err)
+				os.Exit(1)
+			}
+		}
+
+		if queryCountBy == "" {
+			var rows [][]string
+			for _, event := range events {
+				rows = append(rows, eventRow(event))
+			}
+			err = writeRecords(os.Stdout, queryFormat, []string{"Time", "Pot", "Kind", "Severity", "Source", "Destination", "Detail"}, rows, events)
+		} else {
+			var counts []middleware.EventCount
+			for _, count := range middleware.CountEvents(events, queryCountBy) {
+				if count.Pots >= queryMinPots {
+					counts = append(counts, count)
+				}
+			}
+			if queryLimit > 0 && len(counts) > queryLimit {
+				counts = counts[:queryLimit]
+			}
+
+			var rows [][]string
+			for _, count := range counts {
+				rows = append(rows, []string{count.Key, strconv.Itoa(count.Count), strconv.Itoa(count.Pots), formatTime(count.First), formatTime(count.Last)})
+			}
+			err = writeRecords(os.Stdout, queryFormat, []string{strings.Title(queryCountBy), "Events", "Pots", "First Seen", "Last Seen"}, rows, counts)
+		}
+
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	querySourceIP  string   // Source IP of queried events
+	queryKinds     []string // Kinds of queried events
+	querySince     string   // Start of queried time range
+	queryUntil     string   // End of queried time range
+	queryCountBy   string   // Field of aggregation
+	queryMinPots   int      // Minimum pots of aggregated rows
+	queryLimit     int      // Maximum rows
+	queryFormat    string   // Output format
+	queryIndex     bool     // Index artifact runs before querying
+	queryCorrelate bool     // Set actor and campaign of events
+)
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+
+	queryCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	queryCmd.Flags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	queryCmd.Flags().StringVarP(&potName, "name", "n", "", "Name of pot")
+	queryCmd.Flags().StringVar(&querySourceIP, "ip", "", "Source IP")
+	queryCmd.Flags().StringSliceVarP(&queryKinds, "kind", "k", []string{}, "Event kinds (connection, outbound, file, process, cpu, login, command, collect, reset, yara, docker)")
+	queryCmd.Flags().StringVar(&querySince, "since", "", "Start of time range (RFC3339, YYYY-MM-DD or duration like 168h)")
+	queryCmd.Flags().StringVar(&queryUntil, "until", "", "End of time range (RFC3339, YYYY-MM-DD or duration like 24h)")
+	queryCmd.Flags().StringVar(&queryCountBy, "count-by", "", "Aggregate by ip, port, credential, username, password, host, pot, kind, country, city, asn, org, actor or campaign")
+	queryCmd.Flags().IntVar(&queryMinPots, "min-pots", 0, "Only aggregated rows seen on at least this many pots")
+	queryCmd.Flags().IntVar(&queryLimit, "limit", 0, "Maximum rows (0 for unlimited)")
+	queryCmd.Flags().StringVarP(&queryFormat, "format", "o", "table", "Output format (table, json, csv)")
+	queryCmd.Flags().BoolVar(&queryIndex, "index", false, "Index artifact runs under --path before querying")
+	queryCmd.Flags().BoolVar(&queryCorrelate, "correlate", false, "Set actor and campaign of events correlated across pots")
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"log"
 
-	"github.com/docker/docker/client"
 	"github.com/spf13/cobra"
 
 	"github.com/bunseokbot/Honey-V/middleware"
@@ -14,18 +13,16 @@ var removeCmd = &cobra.Command{
 	Use: "remove",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			panic(err)
-		}
 
-		if removeAllPots {
-			if result := middleware.RemoveAllPots(ctx, cli); result {
-				log.Println("Successfully remove all pots")
-			}
-		} else {
-			if result := middleware.RemovePot(ctx, cli, potName); result {
-				log.Printf("Successfully remove %s pot\n", potName)
+		for _, sensor := range connectSensors() {
+			if removeAllPots {
+				if result := middleware.RemoveAllPots(ctx, sensor.Runtime); result {
+					log.Printf("Successfully remove all pots of %s host\n", sensor.Host.Name)
+				}
+			} else {
+				if result := middleware.RemovePot(ctx, sensor.Runtime, potName); result {
+					log.Printf("Successfully remove %s pot of %s host\n", potName, sensor.Host.Name)
+				}
 			}
 		}
 	},
@@ -40,4 +37,5 @@ func init() {
 
 	removeCmd.Flags().BoolVarP(&removeAllPots, "all", "a", false, "Remove all pots")
 	removeCmd.Flags().StringVarP(&potName, "name", "n", "", "name of pot")
+	addHostFlags(removeCmd)
 }
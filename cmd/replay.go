@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// recordCollectedRun records the terminal sessions of the run and lists them in the manifest.
+func recordCollectedRun(runPath string, manifest *middleware.Manifest) {
+	if !recordSessions {
+		return
+	}
+
+	sessions, err := middleware.RecordRun(runPath)
+	if err != nil {
+		log.Printf("error while recording sessions of %s - %s", filepath.Base(runPath), err)
+	}
+	manifest.Sessions = sessions
+	if len(sessions) > 0 {
+		log.Printf("Record %d session(s) of %s pot", len(sessions), manifest.Pot)
+	}
+}
+
+// findRecording returns the recording file of a session given as a .cast file, <run>/<session>
+// or a session name searched in every run under the output path.
+func findRecording(session string) (string, error) {
+	if info, err := os.Stat(session); err == nil && info.Mode().IsRegular() {
+		return session, nil
+	}
+
+	session = strings.TrimSuffix(session, middleware.CastExtension)
+	if parts := strings.SplitN(session, "/", 2); len(parts) == 2 {
+		fileName := filepath.Join(outputRoot, parts[0], middleware.TTYDirName, parts[1]+middleware.CastExtension)
+		if _, err := os.Stat(fileName); err != nil {
+			return "", fmt.Errorf("session %s not found", session)
+		}
+		return fileName, nil
+	}
+
+	runs, err := middleware.ReadArtifactRuns(outputRoot)
+	if err != nil {
+		return "", err
+	}
+	for index := len(runs) - 1; index >= 0; index-- {
+		fileName := filepath.Join(runs[index].Path, middleware.TTYDirName, session+middleware.CastExtension)
+		if _, err := os.Stat(fileName); err == nil {
+			return fileName, nil
+		}
+	}
+	return "", fmt.Errorf("session %s not found under %s", session, outputRoot)
+}
+
+type sessionRecord struct {
+	Run      string    `json:"run"`
+	Session  string    `json:"session"`
+	Title    string    `json:"title"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"`
+	Events   int       `json:"events"`
+	Path     string    `json:"path"`
+}
+
+// listRecordings lists the sessions recorded in the runs of the pot, recording runs collected
+// without recordings when record is set.
+func listRecordings(record bool) []sessionRecord {
+	runs, err := middleware.ReadArtifactRuns(outputRoot)
+	if err != nil {
+		log.Printf("error while reading %s - %s", outputRoot, err)
+		os.Exit(1)
+	}
+
+	records := []sessionRecord{}
+	for _, run := range runs {
+		if (potName != "" && run.Pot != potName) || run.Compressed {
+			continue
+		}
+
+		sessions, err := middleware.ReadRunRecordings(run.Path)
+		if err != nil {
+			log.Printf("error while reading %s - %s", run.Name, err)
+			continue
+		}
+		if record && len(sessions) == 0 {
+			if sessions, err = middleware.RecordRun(run.Path); err != nil {
+				log.Printf("error while recording sessions of %s - %s", run.Name, err)
+				continue
+			}
+			if manifest, err := middleware.ReadManifest(run.Path); err == nil && len(sessions) > 0 {
+				manifest.Sessions = sessions
+				if err := middleware.WriteManifest(run.Path, manifest); err != nil {
+					log.Printf("error while writing manifest of %s - %s", run.Name, err)
+				}
+			}
+		}
+
+		for _, session := range sessions {
+			fileName := filepath.Join(run.Path, middleware.TTYDirName, session+middleware.CastExtension)
+			recording, err := middleware.ReadCast(fileName)
+			if err != nil {
+				log.Printf("error while reading %s - %s", session, err)
+				continue
+			}
+			records = append(records, sessionRecord{
+				Run:      run.Name,
+				Session:  session,
+				Title:    recording.Header.Title,
+				Start:    recording.Start(),
+				Duration: recording.Duration().Seconds(),
+				Events:   len(recording.Events),
+				Path:     fileName,
+			})
+		}
+	}
+	return records
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <session>",
+	Short: "Play back a recorded terminal session of an attacker",
+	Long: "Play back a recorded terminal session of an attacker. The session is a .cast file,\n" +
+		"<run>/<session> or a session name searched in the runs under the output path.\n" +
+		"--list lists the recorded sessions instead.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if replayList {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if replayList {
+			records := listRecordings(replayRecord)
+			var rows [][]string
+			for _, record := range records {
+				rows = append(rows, []string{
+					record.Run, record.Session, record.Title, formatTime(record.Start),
+					(time.Duration(record.Duration * float64(time.Second))).Round(time.Second).String(), strconv.Itoa(record.Events),
+				})
+			}
+			if err := writeRecords(os.Stdout, replayFormat, []string{"Run", "Session", "Title", "Start", "Duration", "Events"}, rows, records); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		fileName, err := findRecording(args[0])
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		recording, err := middleware.ReadCast(fileName)
+		if err != nil {
+			log.Printf("error while reading %s - %s", fileName, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s, %s, %dx%d\n", recording.Header.Title, formatTime(recording.Start()), recording.Header.Width, recording.Header.Height)
+		if err := recording.Play(context.Background(), os.Stdout, replaySpeed, replayMaxIdle); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println()
+	},
+}
+
+var (
+	recordSessions bool          // Record terminal sessions of collected runs
+	replaySpeed    float64       // Playback speed factor
+	replayMaxIdle  time.Duration // Longest pause of playback
+	replayList     bool          // List sessions instead of playing one
+	replayRecord   bool          // Record runs collected without recordings
+	replayFormat   string        // Output format of the list
+)
+
+// addReplayFlags binds the session recording settings to the collect daemon.
+func addReplayFlags(command *cobra.Command) {
+	command.Flags().BoolVar(&recordSessions, "tty", true, "Record terminal sessions of pots as asciinema casts")
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	replayCmd.Flags().StringVarP(&potName, "name", "n", "", "Only sessions of this pot, with --list")
+	replayCmd.Flags().Float64VarP(&replaySpeed, "speed", "s", 1, "Playback speed factor, 2 for twice as fast")
+	replayCmd.Flags().DurationVarP(&replayMaxIdle, "max-idle", "i", 2*time.Second, "Longest pause of playback (0 to keep every pause)")
+	replayCmd.Flags().BoolVarP(&replayList, "list", "l", false, "List recorded sessions")
+	replayCmd.Flags().BoolVar(&replayRecord, "record", false, "Record sessions of runs collected without recordings, with --list")
+	replayCmd.Flags().StringVarP(&replayFormat, "format", "o", "table", "Output format of the list (table, json, csv)")
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// reportSection is a part of a report rendered as a paragraph, a table and code lines.
+type reportSection struct {
+	Title  string
+	Text   string
+	Header []string
+	Rows   [][]string
+	Code   []string
+}
+
+type reportDocument struct {
+	Title     string
+	Generated string
+	Sections  []reportSection
+}
+
+func markdownCell(value string) string {
+	return strings.NewReplacer("|", `\|`, "<", "&lt;", ">", "&gt;", "\n", "<br>").Replace(value)
+}
+
+var markdownReport = template.Must(template.New("markdown").Funcs(template.FuncMap{"cell": markdownCell}).Parse(`# {{.Title}}
+
+Generated {{.Generated}}
+{{range .Sections}}
+## {{.Title}}
+{{if .Text}}
+{{.Text}}
+{{end}}{{if .Header}}
+|{{range .Header}} {{cell .}} |{{end}}
+|{{range .Header}} --- |{{end}}
+{{range .Rows}}|{{range .}} {{cell .}} |{{end}}
+{{end}}{{end}}{{if .Code}}
+` + "```" + `
+{{range .Code}}{{.}}
+{{end}}` + "```" + `
+{{end}}{{end}}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; white-space: pre-wrap; }
+th { background: #f0f0f0; }
+pre { background: #f6f6f6; padding: 8px; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}</p>
+{{range .Sections}}<h2>{{.Title}}</h2>
+{{if .Text}}<p>{{.Text}}</p>
+{{end}}{{if .Header}}<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}{{if .Code}}<pre>{{range .Code}}{{.}}
+{{end}}</pre>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// writeReport renders the report document as markdown or html, or the report itself as json.
+func writeReport(w io.Writer, format string, document reportDocument, value interface{}) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "markdown", "md":
+		return markdownReport.Execute(w, document)
+	case "html":
+		return htmlReport.Execute(w, document)
+	}
+	return fmt.Errorf("unknown %s format, expected markdown, html or json", format)
+}
+
+func reportRange(since time.Time, until time.Time) string {
+	from, to := "the beginning", "now"
+	if !since.IsZero() {
+		from = formatTime(since)
+	}
+	if !until.IsZero() {
+		to = formatTime(until)
+	}
+	return fmt.Sprintf("From %s to %s", from, to)
+}
+
+func countRows(counts []middleware.EventCount) [][]string {
+	var rows [][]string
+	for _, count := range counts {
+		rows = append(rows, []string{count.Key, strconv.Itoa(count.Count), strconv.Itoa(count.Pots), formatTime(count.First), formatTime(count.Last)})
+	}
+	return rows
+}
+
+func credentialDocument(report middleware.CredentialReport) reportDocument {
+	countHeader := func(name string) []string {
+		return []string{name, "Attempts", "Pots", "First Seen", "Last Seen"}
+	}
+
+	var sourceRows [][]string
+	for _, source := range report.SourceList {
+		sourceRows = append(sourceRows, []string{
+			source.IP, source.Country, strconv.Itoa(source.Attempts), strconv.Itoa(source.Successes),
+			strings.Join(source.Usernames, ", "), strings.Join(source.Passwords, ", "), formatTime(source.First), formatTime(source.Last),
+		})
+	}
+
+	return reportDocument{
+		Title:     "Credential report",
+		Generated: formatTime(time.Now()),
+		Sections: []reportSection{
+			{
+				Title: "Summary",
+				Text: fmt.Sprintf("%s, %d login attempt(s) from %d source(s), %d succeeded. %d username and password pair(s), %d never tried before.",
+					reportRange(report.Since, report.Until), report.Attempts, report.Sources, report.Successes, report.Pairs, report.NewPairs),
+			},
+			{Title: "Top usernames", Header: countHeader("Username"), Rows: countRows(report.Usernames)},
+			{Title: "Top passwords", Header: countHeader("Password"), Rows: countRows(report.Passwords)},
+			{Title: "Top pairs", Header: countHeader("Credential"), Rows: countRows(report.TopPairs)},
+			{Title: "New pairs", Text: "Pairs never tried before the range.", Header: countHeader("Credential"), Rows: countRows(report.FirstSeen)},
+			{
+				Title:  "Password lists by source",
+				Text:   "Passwords in the order each source tried them.",
+				Header: []string{"IP", "Country", "Attempts", "Successes", "Usernames", "Passwords", "First Seen", "Last Seen"},
+				Rows:   sourceRows,
+			},
+		},
+	}
+}
+
+func commandDocument(report middleware.CommandReport) reportDocument {
+	sections := []reportSection{
+		{
+			Title: "Summary",
+			Text: fmt.Sprintf("%s, %d command(s) in %d session(s), clustered in %d playbook(s) shown.",
+				reportRange(report.Since, report.Until), report.Commands, report.Sessions, len(report.Playbooks)),
+		},
+		{Title: "Top commands", Header: []string{"Command", "Count", "Pots", "First Seen", "Last Seen"}, Rows: countRows(report.Top)},
+	}
+
+	for _, playbook := range report.Playbooks {
+		sections = append(sections, reportSection{
+			Title: "Playbook " + playbook.ID,
+			Text: fmt.Sprintf("%d session(s) from %d source(s) on %s, %s to %s.",
+				playbook.Sessions, len(playbook.Sources), strings.Join(playbook.Pots, ", "), formatTime(playbook.First), formatTime(playbook.Last)),
+			Header: []string{"Normalised", "Example"},
+			Rows:   playbookRows(playbook),
+			Code:   playbook.URLs,
+		})
+	}
+
+	return reportDocument{Title: "Command report", Generated: formatTime(time.Now()), Sections: sections}
+}
+
+func playbookRows(playbook middleware.Playbook) [][]string {
+	var rows [][]string
+	for index, command := range playbook.Commands {
+		rows = append(rows, []string{command, playbook.Example[index]})
+	}
+	return rows
+}
+
+// queryReportEvents returns the events of the kind in the range of the flags, and the events before it.
+func queryReportEvents(kind string, history bool) ([]middleware.Event, []middleware.Event, middleware.EventFilter) {
+	filter := readEventFilter()
+	filter.Kinds = []string{kind}
+
+	store := middleware.NewStore(eventDBPath())
+	events, err := store.QueryEvents(filter)
+	if err != nil {
+		log.Printf("error while querying events - %s", err)
+		os.Exit(1)
+	}
+	enrichEvents(events)
+
+	var before []middleware.Event
+	if history && !filter.Since.IsZero() {
+		previous := filter
+		previous.Since, previous.Until = time.Time{}, filter.Since.Add(-time.Nanosecond)
+		if before, err = store.QueryEvents(previous); err != nil {
+			log.Printf("error while querying events - %s", err)
+			os.Exit(1)
+		}
+	}
+	return events, before, filter
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarise credentials and commands of attackers for threat reports",
+}
+
+var reportCredentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Report top usernames, passwords and pairs, and password lists by source",
+	Run: func(cmd *cobra.Command, args []string) {
+		events, before, filter := queryReportEvents(middleware.EventLogin, true)
+		report := middleware.BuildCredentialReport(events, before, filter.Since, filter.Until, reportTop)
+		if err := writeReport(os.Stdout, reportFormat, credentialDocument(report), report); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var reportCommandsCmd = &cobra.Command{
+	Use:   "commands",
+	Short: "Report top commands and playbooks of sessions typing the same commands",
+	Run: func(cmd *cobra.Command, args []string) {
+		events, _, filter := queryReportEvents(middleware.EventCommand, false)
+		report := middleware.BuildCommandReport(events, filter.Since, filter.Until, reportTop)
+		if err := writeReport(os.Stdout, reportFormat, commandDocument(report), report); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	reportTop    int    // Maximum rows of each list
+	reportFormat string // Output format
+)
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(reportCredentialsCmd, reportCommandsCmd)
+
+	reportCmd.PersistentFlags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	reportCmd.PersistentFlags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	reportCmd.PersistentFlags().StringVarP(&potName, "name", "n", "", "Name of pot")
+	reportCmd.PersistentFlags().StringVar(&querySince, "since", "168h", "Start of time range (RFC3339, YYYY-MM-DD or duration like 168h)")
+	reportCmd.PersistentFlags().StringVar(&queryUntil, "until", "", "End of time range (RFC3339, YYYY-MM-DD or duration like 24h)")
+	reportCmd.PersistentFlags().IntVar(&reportTop, "top", 10, "Maximum rows of each list (0 for unlimited)")
+	reportCmd.PersistentFlags().StringVarP(&reportFormat, "format", "o", "markdown", "Output format (markdown, html, json)")
+}
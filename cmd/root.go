@@ -1,14 +1,21 @@
 package cmd
 
 import (
+	"log"
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
 )
 
+// version is reported by agents to the server, set with -ldflags "-X github.com/bunseokbot/Honey-V/cmd.version=<version>".
+var version = "dev"
+
 var rootCmd = &cobra.Command{
-	Use:   "honeypot [OPTIONS] COMMAND [ARG...]",
-	Short: "CLI for Honeypot Management Framework",
+	Use:     "honeypot [OPTIONS] COMMAND [ARG...]",
+	Short:   "CLI for Honeypot Management Framework",
+	Version: version,
 }
 
 func Execute() {
@@ -16,3 +23,14 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&localRuntime, "runtime", "", "Runtime of the local host: docker, podman, containerd or kubernetes (default $"+runtimeEnv+" or docker)")
+	rootCmd.PersistentFlags().StringVar(&checkpointRoot, "checkpoint-dir", "checkpoints", "Directory of pot checkpoints")
+
+	cobra.OnInitialize(func() {
+		if err := middleware.SetCheckpointRoot(checkpointRoot); err != nil {
+			log.Printf("error while reading checkpoint directory - %s", err)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+const vaultPasswordEnv = "HONEYPOT_VAULT_PASSWORD"
+
+var sampleVault *middleware.Vault
+
+func vaultPath() string {
+	if vaultRoot != "" {
+		return vaultRoot
+	}
+	return filepath.Join(outputRoot, "vault")
+}
+
+// openVault opens the quarantine vault of the flags, exiting on an invalid format.
+func openVault() *middleware.Vault {
+	if vaultPassword == "" {
+		vaultPassword = os.Getenv(vaultPasswordEnv)
+	}
+
+	vault, err := middleware.NewVault(vaultPath(), vaultFormat, vaultPassword)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	return vault
+}
+
+// quarantineCollectedRun moves the files attackers added to the run into the vault and lists
+// their hashes in the manifest.
+func quarantineCollectedRun(runPath string, manifest *middleware.Manifest) {
+	if sampleVault == nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(runPath, "dump.tar")); err != nil {
+		return
+	}
+
+	samples, err := middleware.QuarantineRun(sampleVault, runPath, *manifest, yaraScanOptions().MaxFileSize)
+	if err != nil {
+		log.Printf("error while quarantining samples of %s - %s", filepath.Base(runPath), err)
+	}
+	for _, sample := range samples {
+		manifest.Samples = append(manifest.Samples, sample.SHA256)
+	}
+	if len(samples) > 0 {
+		log.Printf("Quarantine %d sample(s) from %s pot", len(samples), manifest.Pot)
+	}
+}
+
+var samplesCmd = &cobra.Command{
+	Use:   "samples",
+	Short: "Browse and export files quarantined from pots",
+}
+
+var samplesListCmd = &cobra.Command{
+	Use: "list",
+	Run: func(cmd *cobra.Command, args []string) {
+		samples, err := openVault().Samples()
+		if err != nil {
+			log.Printf("error while reading vault - %s", err)
+			os.Exit(1)
+		}
+
+		var rows [][]string
+		filtered := []middleware.Sample{}
+		for _, sample := range samples {
+			if potName != "" && !containsString(sample.Pots, potName) {
+				continue
+			}
+			filtered = append(filtered, sample)
+			rows = append(rows, []string{
+				sample.SHA256, sample.FileType, units.HumanSize(float64(sample.Size)),
+				strings.Join(sample.Pots, ","), formatTime(sample.FirstSeen), formatTime(sample.LastSeen),
+				strings.Join(sample.YaraRules, ","),
+			})
+		}
+
+		if err := writeRecords(os.Stdout, samplesFormat, []string{"SHA256", "Type", "Size", "Pots", "First Seen", "Last Seen", "YARA"}, rows, filtered); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var samplesShowCmd = &cobra.Command{
+	Use:  "show <sha256>",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sample, err := openVault().Sample(args[0])
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+
+		rows := [][]string{
+			{"SHA256", sample.SHA256},
+			{"SHA1", sample.SHA1},
+			{"MD5", sample.MD5},
+			{"Size", strconv.FormatInt(sample.Size, 10)},
+			{"Type", sample.FileType},
+			{"Stored", sample.Format},
+			{"First Seen", formatTime(sample.FirstSeen)},
+			{"Last Seen", formatTime(sample.LastSeen)},
+			{"Pots", strings.Join(sample.Pots, "\n")},
+			{"Paths", strings.Join(sample.Paths, "\n")},
+			{"Runs", strings.Join(sample.Runs, "\n")},
+			{"YARA", strings.Join(sample.YaraRules, "\n")},
+		}
+		if err := writeRecords(os.Stdout, samplesFormat, []string{"Field", "Value"}, rows, sample); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var samplesExportCmd = &cobra.Command{
+	Use:   "export <sha256>",
+	Short: "Export a sample as a password protected zip, or as is with --raw",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vault := openVault()
+		sample, err := vault.Sample(args[0])
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+
+		var writer io.Writer = os.Stdout
+		if samplesOutput != "" {
+			file, err := os.OpenFile(samplesOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				log.Printf("error while creating %s - %s", samplesOutput, err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			writer = file
+		}
+
+		if samplesRaw {
+			var data []byte
+			if data, err = vault.Read(sample); err == nil {
+				_, err = writer.Write(data)
+			}
+		} else {
+			err = vault.ExportZip(writer, sample)
+		}
+		if err != nil {
+			log.Printf("error while exporting sample %s - %s", sample.SHA256, err)
+			os.Exit(1)
+		}
+
+		if samplesOutput != "" {
+			format := fmt.Sprintf("zip with password %q", vault.Password)
+			if samplesRaw {
+				format = "raw file, handle with care"
+			}
+			log.Printf("Export sample %s to %s as %s", sample.SHA256, samplesOutput, format)
+		}
+	},
+}
+
+var (
+	vaultRoot     string // Path of quarantine vault
+	vaultFormat   string // Storage of quarantined samples
+	vaultPassword string // Password of zip or xor key
+	quarantine    bool   // Quarantine samples of collected runs
+	samplesFormat string // Output format
+	samplesOutput string // Path of exported sample
+	samplesRaw    bool   // Export the sample as is
+)
+
+// addVaultFlags binds the quarantine settings to the collect daemon.
+func addVaultFlags(command *cobra.Command) {
+	command.Flags().BoolVar(&quarantine, "quarantine", true, "Quarantine files added to pots in the vault")
+	command.Flags().StringVar(&vaultRoot, "vault", "", "Path of quarantine vault (default <path>/vault)")
+	command.Flags().StringVar(&vaultFormat, "vault-format", middleware.VaultZip, "Storage of quarantined samples (zip, xor)")
+	command.Flags().StringVar(&vaultPassword, "vault-password", "", "Password of zip or key of xor (default $"+vaultPasswordEnv+" or "+middleware.DefaultVaultPassword+")")
+}
+
+func init() {
+	rootCmd.AddCommand(samplesCmd)
+	samplesCmd.AddCommand(samplesListCmd, samplesShowCmd, samplesExportCmd)
+
+	samplesCmd.PersistentFlags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	samplesCmd.PersistentFlags().StringVar(&vaultRoot, "vault", "", "Path of quarantine vault (default <path>/vault)")
+	samplesCmd.PersistentFlags().StringVar(&vaultPassword, "vault-password", "", "Password of zip or key of xor (default $"+vaultPasswordEnv+" or "+middleware.DefaultVaultPassword+")")
+	samplesListCmd.Flags().StringVarP(&potName, "name", "n", "", "Name of pot")
+	samplesListCmd.Flags().StringVarP(&samplesFormat, "format", "o", "table", "Output format (table, json, csv)")
+	samplesShowCmd.Flags().StringVarP(&samplesFormat, "format", "o", "table", "Output format (table, json, csv)")
+	samplesExportCmd.Flags().StringVarP(&samplesOutput, "output", "o", "", "Path of exported sample (default stdout)")
+	samplesExportCmd.Flags().BoolVar(&samplesRaw, "raw", false, "Export the original file instead of a password protected zip")
+}
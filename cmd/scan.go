@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+var yaraRules *middleware.YaraRules
+
+// loadYaraRules compiles the rules given by --yara-rules, or checks them with --yara-binary,
+// exiting when none can be loaded.
+func loadYaraRules() {
+	if yaraRulesPath == "" {
+		return
+	}
+
+	var rules *middleware.YaraRules
+	var err error
+	if yaraBinary != "" {
+		rules, err = middleware.LoadYaraBinaryRules(yaraRulesPath, yaraBinary)
+	} else {
+		rules, err = middleware.LoadYaraRules(yaraRulesPath)
+	}
+	if err != nil {
+		log.Printf("error while loading yara rules - %s", err)
+		os.Exit(1)
+	}
+
+	yaraRules = rules
+	log.Printf("Loaded %d yara rule(s) from %s", rules.Len(), yaraRulesPath)
+}
+
+func yaraScanOptions() middleware.YaraScanOptions {
+	options := middleware.YaraScanOptions{Export: yaraExport}
+	if yaraMaxSize != "" {
+		size, err := units.FromHumanSize(yaraMaxSize)
+		if err != nil {
+			log.Printf("invalid yara max size %s - %s", yaraMaxSize, err)
+			os.Exit(1)
+		}
+		options.MaxFileSize = size
+	}
+	return options
+}
+
+// scanCollectedRun records the yara matches of the container export of the run in the manifest.
+func scanCollectedRun(runPath string, manifest *middleware.Manifest) {
+	if yaraRules == nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(runPath, "dump.tar")); err != nil {
+		return
+	}
+
+	matches, err := middleware.ScanArtifactRun(yaraRules, runPath, yaraScanOptions())
+	if err != nil {
+		log.Printf("error while scanning %s - %s", filepath.Base(runPath), err)
+	}
+	manifest.YaraMatches = matches
+	manifest.YaraSkipped = yaraRules.Skipped()
+
+	incomplete := 0
+	for _, match := range matches {
+		if match.Incomplete {
+			incomplete++
+		}
+	}
+	if len(matches) > incomplete {
+		log.Printf("Match %d yara rule(s) in %s pot", len(matches)-incomplete, manifest.Pot)
+	}
+	if incomplete > 0 {
+		log.Printf("Leave %d yara rule(s) incomplete in %s pot", incomplete, manifest.Pot)
+	}
+}
+
+// scanRunPaths returns the run directory given, or every run under an output directory.
+func scanRunPaths(path string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(path, middleware.ManifestFileName)); err == nil {
+		return []string{path}, nil
+	}
+
+	runs, err := middleware.ReadArtifactRuns(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, run := range runs {
+		if run.Compressed {
+			log.Printf("Skip compressed artifact run %s", run.Name)
+			continue
+		}
+		paths = append(paths, run.Path)
+	}
+	return paths, nil
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan <artifact-dir>",
+	Short: "Scan collected container exports with yara rules",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		loadYaraRules()
+		options := yaraScanOptions()
+
+		runPaths, err := scanRunPaths(args[0])
+		if err != nil {
+			log.Printf("error while reading %s - %s", args[0], err)
+			os.Exit(1)
+		}
+
+		var store *middleware.Store
+		if eventDB != "" {
+			store = middleware.NewStore(eventDB)
+		}
+
+		var rows [][]string
+		var allMatches []middleware.YaraMatch
+		for _, runPath := range runPaths {
+			manifest, err := middleware.ReadManifest(runPath)
+			if err != nil {
+				log.Printf("error while reading manifest of %s - %s", filepath.Base(runPath), err)
+				continue
+			}
+
+			matches, err := middleware.ScanArtifactRun(yaraRules, runPath, options)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				log.Printf("error while scanning %s - %s", filepath.Base(runPath), err)
+				continue
+			}
+
+			manifest.YaraMatches = matches
+			manifest.YaraSkipped = yaraRules.Skipped()
+			if err := middleware.WriteManifest(runPath, manifest); err != nil {
+				log.Printf("error while writing manifest of %s - %s", filepath.Base(runPath), err)
+			}
+			if store != nil {
+				if err := store.PutEvents(middleware.YaraEvents(manifest)); err != nil {
+					log.Printf("error while recording events of %s - %s", filepath.Base(runPath), err)
+				}
+			}
+
+			for _, match := range matches {
+				rows = append(rows, []string{filepath.Base(runPath), match.Rule, strings.Join(match.Tags, ","), match.Path, match.SHA256, strconv.FormatInt(match.Size, 10), strconv.FormatBool(match.Incomplete)})
+			}
+			allMatches = append(allMatches, matches...)
+		}
+
+		if allMatches == nil {
+			allMatches = []middleware.YaraMatch{}
+		}
+		if err := writeRecords(os.Stdout, scanFormat, []string{"Run", "Rule", "Tags", "Path", "SHA256", "Size", "Incomplete"}, rows, allMatches); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	yaraRulesPath string // Path of yara rule directory or file
+	yaraBinary    string // Path of yara binary scanning instead of the built-in matcher
+	yaraExport    bool   // Scan every file of the container export
+	yaraMaxSize   string // Size above which files are not scanned
+	scanFormat    string // Output format
+)
+
+// addYaraFlags binds the scanning settings to the collect daemon.
+func addYaraFlags(command *cobra.Command) {
+	command.Flags().StringVar(&yaraRulesPath, "yara-rules", "", "Directory of yara rules scanning collected files")
+	command.Flags().StringVar(&yaraBinary, "yara-binary", "", "yara binary scanning with the full rule language instead of the built-in matcher")
+	command.Flags().BoolVar(&yaraExport, "yara-export", false, "Scan every file of dump.tar, not only added and changed files")
+	command.Flags().StringVar(&yaraMaxSize, "yara-max-size", "32MB", "Size above which files are not scanned")
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+
+	scanCmd.Flags().StringVarP(&yaraRulesPath, "rules", "r", "", "Directory or file of yara rules")
+	scanCmd.Flags().StringVar(&yaraBinary, "binary", "", "yara binary scanning with the full rule language instead of the built-in matcher")
+	scanCmd.Flags().BoolVar(&yaraExport, "export", false, "Scan every file of dump.tar, not only added and changed files")
+	scanCmd.Flags().StringVar(&yaraMaxSize, "max-size", "32MB", "Size above which files are not scanned")
+	scanCmd.Flags().StringVar(&eventDB, "db", "", "Path of event database recording matches as events")
+	scanCmd.Flags().StringVarP(&scanFormat, "format", "o", "table", "Output format (table, json, csv)")
+
+	scanCmd.MarkFlagRequired("rules")
+}
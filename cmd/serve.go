@@ -0,0 +1,735 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+const apiTokenEnv = "HONEYPOT_API_TOKEN"
+
+// apiServer serves the pots, artifacts and events of the collect daemon over HTTP.
+type apiServer struct {
+	ctx    context.Context
+	sensor middleware.Sensor
+	token  string
+}
+
+type apiContainer struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Image   string    `json:"image"`
+	State   string    `json:"state"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+}
+
+type apiPot struct {
+	Name       string         `json:"name"`
+	State      string         `json:"state"`
+	Status     string         `json:"status"`
+	Profile    string         `json:"profile"`
+	Collectors []string       `json:"collectors"`
+	Ingress    bool           `json:"ingress"`
+	Checkpoint bool           `json:"checkpoint"`
+	Collecting bool           `json:"collecting"`
+	Containers []apiContainer `json:"containers"`
+}
+
+// apiDeployRequest is the body of POST /pots, mirroring the flags of deploy.
+type apiDeployRequest struct {
+	Name         string   `json:"name"`
+	Image        string   `json:"image"`
+	Ports        []string `json:"ports"`
+	Environments []string `json:"environments"`
+	Profile      string   `json:"profile"`
+	Collectors   []string `json:"collectors"`
+	Ingress      *bool    `json:"ingress"` // default where the ingress proxy can serve the pot
+	Checkpoint   bool     `json:"checkpoint"`
+}
+
+type apiPotStats struct {
+	Pot           string  `json:"pot"`
+	CPUPercent    float64 `json:"cpu_percent"`
+	MemoryPercent float64 `json:"memory_percent"`
+	MemoryUsage   uint64  `json:"memory_usage"`
+	MemoryLimit   uint64  `json:"memory_limit"`
+	NetworkRx     float64 `json:"network_rx"`
+	NetworkTx     float64 `json:"network_tx"`
+	BlockRead     uint64  `json:"block_read"`
+	BlockWrite    uint64  `json:"block_write"`
+}
+
+type apiArtifactFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+type apiArtifactRun struct {
+	middleware.ArtifactRun
+	Manifest *middleware.Manifest `json:"manifest,omitempty"`
+	Files    []apiArtifactFile    `json:"files,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func newPotView(pot middleware.Pot) apiPot {
+	view := apiPot{Name: pot.Name, Collecting: isCollecting(pot.Name), Containers: []apiContainer{}}
+
+	for _, container := range pot.Containers {
+		var name string
+		if len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		view.Containers = append(view.Containers, apiContainer{
+			ID:      container.ID,
+			Name:    name,
+			Image:   container.Image,
+			State:   container.State,
+			Status:  container.Status,
+			Created: time.Unix(container.Created, 0),
+		})
+
+		view.State = container.State
+		view.Status = container.Status
+		view.Profile, view.Collectors = middleware.ReadPotProfile(container.Labels)
+		view.Ingress = middleware.IsIngressPot(container.Labels)
+		view.Checkpoint = middleware.IsCheckpointPot(container.Labels)
+	}
+
+	return view
+}
+
+// authenticate accepts the token as bearer authorization, or as token query for EventSource clients.
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimPrefix(header, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("invalid api token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *apiServer) routes() *mux.Router {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/", s.handleWebUI).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/openapi.json", s.handleOpenAPI).Methods(http.MethodGet)
+
+	if publicMetrics {
+		router.Handle("/metrics", metricsHandler()).Methods(http.MethodGet)
+	} else {
+		router.Handle("/metrics", s.authenticate(metricsHandler())).Methods(http.MethodGet)
+	}
+
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(s.authenticate)
+
+	api.HandleFunc("/pots", s.handleListPots).Methods(http.MethodGet)
+	api.HandleFunc("/pots", s.handleDeployPot).Methods(http.MethodPost)
+	api.HandleFunc("/pots/{name}", s.handleReadPot).Methods(http.MethodGet)
+	api.HandleFunc("/pots/{name}", s.handleRemovePot).Methods(http.MethodDelete)
+	api.HandleFunc("/pots/{name}/stats", s.handlePotStats).Methods(http.MethodGet)
+	api.HandleFunc("/stats", s.handleListStats).Methods(http.MethodGet)
+	api.HandleFunc("/pots/{name}/collect", s.handleCollectPot).Methods(http.MethodPost)
+	api.HandleFunc("/pots/{name}/reset", s.handleResetPot).Methods(http.MethodPost)
+
+	api.HandleFunc("/artifacts", s.handleListArtifacts).Methods(http.MethodGet)
+	api.HandleFunc("/artifacts/{run}", s.handleReadArtifact).Methods(http.MethodGet)
+	api.HandleFunc("/artifacts/{run}/download", s.handleDownloadArtifact).Methods(http.MethodGet)
+
+	api.HandleFunc("/events", s.handleQueryEvents).Methods(http.MethodGet)
+	api.HandleFunc("/events/stream", s.handleStreamEvents).Methods(http.MethodGet)
+	api.HandleFunc("/campaigns", s.handleListCampaigns).Methods(http.MethodGet)
+
+	return router
+}
+
+func (s *apiServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(openAPIDocument))
+}
+
+func (s *apiServer) handleWebUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	kinds, _ := json.Marshal(middleware.EventKinds)
+	_, _ = w.Write([]byte(strings.Replace(webUIDocument, webUIEventKinds, string(kinds), 1)))
+}
+
+func (s *apiServer) handleListPots(w http.ResponseWriter, r *http.Request) {
+	pots, err := middleware.ReadAllPots(s.ctx, s.sensor.Runtime)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	views := []apiPot{}
+	for _, pot := range pots {
+		views = append(views, newPotView(pot))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *apiServer) readPot(w http.ResponseWriter, r *http.Request) (middleware.Pot, bool) {
+	pot, err := middleware.ReadPot(s.ctx, s.sensor.Runtime, mux.Vars(r)["name"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return pot, false
+	}
+	return pot, true
+}
+
+func (s *apiServer) handleReadPot(w http.ResponseWriter, r *http.Request) {
+	if pot, found := s.readPot(w, r); found {
+		writeJSON(w, http.StatusOK, newPotView(pot))
+	}
+}
+
+func (s *apiServer) handleDeployPot(w http.ResponseWriter, r *http.Request) {
+	var request apiDeployRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if request.Name == "" || request.Image == "" {
+		writeError(w, http.StatusBadRequest, errors.New("name and image required"))
+		return
+	}
+
+	if middleware.IsExistPotName(s.ctx, s.sensor.Runtime, request.Name) {
+		writeError(w, http.StatusConflict, errors.New("pot name already exist"))
+		return
+	}
+
+	ingress := isIngressSupported(s.sensor)
+	if request.Ingress != nil {
+		ingress = *request.Ingress
+	}
+	potLabels, dockerPorts, err := readPotLabels(request.Name, request.Profile, request.Collectors, request.Ports, ingress, request.Checkpoint)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	log.Printf("Generating %s pot...", request.Name)
+	if _, err := middleware.MakeNewPot(s.ctx, s.sensor.Client, request.Name, request.Image, dockerPorts, "", request.Environments, potLabels); err != nil {
+		middleware.RemovePot(s.ctx, s.sensor.Runtime, request.Name)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	if middleware.IsCheckpointPot(potLabels) {
+		go createCleanCheckpoint(s.ctx, s.sensor.Client, request.Name, potLabels[middleware.CheckpointLabel])
+	}
+
+	log.Printf("Successfully generated %s pot\n", request.Name)
+
+	pot, err := middleware.ReadPot(s.ctx, s.sensor.Runtime, request.Name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newPotView(pot))
+}
+
+func (s *apiServer) handleRemovePot(w http.ResponseWriter, r *http.Request) {
+	pot, found := s.readPot(w, r)
+	if !found {
+		return
+	}
+
+	if !middleware.RemovePot(s.ctx, s.sensor.Runtime, pot.Name) {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("error while removing %s pot", pot.Name))
+		return
+	}
+
+	log.Printf("Successfully remove %s pot\n", pot.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handlePotStats(w http.ResponseWriter, r *http.Request) {
+	pot, found := s.readPot(w, r)
+	if !found {
+		return
+	}
+
+	stats, err := readPotStats(s.ctx, s.sensor.Runtime, pot.Name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *apiServer) handleListStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := readAllPotStats(s.ctx, s.sensor.Runtime)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func readPotStats(ctx context.Context, runtime middleware.Runtime, potName string) (apiPotStats, error) {
+	stats, err := middleware.ReadPotStatus(ctx, runtime, potName)
+	if err != nil {
+		return apiPotStats{}, err
+	}
+	defer stats.Body.Close()
+
+	var containerStat types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&containerStat); err != nil {
+		return apiPotStats{}, err
+	}
+
+	return calculatePotStats(potName, &containerStat), nil
+}
+
+// readAllPotStats reads the stats of running pots in parallel, as docker takes a second or more for each.
+func readAllPotStats(ctx context.Context, runtime middleware.Runtime) ([]apiPotStats, error) {
+	pots, err := middleware.ReadAllPots(ctx, runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mutex sync.Mutex
+		wait  sync.WaitGroup
+	)
+	result := []apiPotStats{}
+
+	for _, pot := range pots {
+		running := false
+		for _, container := range pot.Containers {
+			running = running || container.State == "running"
+		}
+		if !running {
+			continue
+		}
+
+		wait.Add(1)
+		go func(potName string) {
+			defer wait.Done()
+
+			stats, err := readPotStats(ctx, runtime, potName)
+			if err != nil {
+				log.Printf("error while reading %s pot stats - %s", potName, err)
+				return
+			}
+
+			mutex.Lock()
+			result = append(result, stats)
+			mutex.Unlock()
+		}(pot.Name)
+	}
+	wait.Wait()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Pot < result[j].Pot
+	})
+
+	return result, nil
+}
+
+func calculatePotStats(potName string, containerStat *types.StatsJSON) apiPotStats {
+	rx, tx := calculatePotNetwork(containerStat.Networks)
+	blkRead, blkWrite := calculatePotBlockIO(containerStat.BlkioStats)
+
+	return apiPotStats{
+		Pot:           potName,
+		CPUPercent:    calculatePotCpuPercent(containerStat.PreCPUStats.CPUUsage.TotalUsage, containerStat.PreCPUStats.SystemUsage, containerStat),
+		MemoryPercent: calculatePotMemoryPercent(containerStat.MemoryStats),
+		MemoryUsage:   containerStat.MemoryStats.Usage,
+		MemoryLimit:   containerStat.MemoryStats.Limit,
+		NetworkRx:     rx,
+		NetworkTx:     tx,
+		BlockRead:     blkRead,
+		BlockWrite:    blkWrite,
+	}
+}
+
+func (s *apiServer) handleCollectPot(w http.ResponseWriter, r *http.Request) {
+	pot, found := s.readPot(w, r)
+	if !found {
+		return
+	}
+
+	if isCollecting(pot.Name) {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s pot is already being collected", pot.Name))
+		return
+	}
+
+	log.Printf("%s trigger on %s pot", middleware.TriggerManual, pot.Name)
+	go func(potName string) {
+		if err := collectPot(s.ctx, s.sensor, potName, middleware.TriggerManual); err != nil {
+			log.Printf("error while collecting %s pot - %s", potName, err)
+		}
+	}(pot.Name)
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"pot": pot.Name, "status": "collecting"})
+}
+
+func (s *apiServer) handleResetPot(w http.ResponseWriter, r *http.Request) {
+	pot, found := s.readPot(w, r)
+	if !found {
+		return
+	}
+
+	// reset without collection must not race a running one
+	if !beginCollecting(pot.Name) {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s pot is already being collected", pot.Name))
+		return
+	}
+	defer endCollecting(pot.Name)
+
+	if err := resetPot(s.ctx, s.sensor, pot, "api"); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	pot, _ = middleware.ReadPot(s.ctx, s.sensor.Runtime, pot.Name)
+	writeJSON(w, http.StatusOK, newPotView(pot))
+}
+
+func (s *apiServer) handleListArtifacts(w http.ResponseWriter, r *http.Request) {
+	runs, err := middleware.ReadArtifactRuns(outputRoot)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	pot := r.URL.Query().Get("pot")
+	result := []middleware.ArtifactRun{}
+	for _, run := range runs {
+		if pot == "" || run.Pot == pot {
+			result = append(result, run)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *apiServer) readArtifactRun(w http.ResponseWriter, r *http.Request) (middleware.ArtifactRun, bool) {
+	run, err := middleware.ReadArtifactRun(outputRoot, mux.Vars(r)["run"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return run, false
+	}
+	return run, true
+}
+
+func (s *apiServer) handleReadArtifact(w http.ResponseWriter, r *http.Request) {
+	run, found := s.readArtifactRun(w, r)
+	if !found {
+		return
+	}
+
+	view := apiArtifactRun{ArtifactRun: run}
+	if !run.Compressed {
+		if manifest, err := middleware.ReadManifest(run.Path); err == nil {
+			view.Manifest = &manifest
+		}
+
+		hashes := make(map[string]string)
+		if data, err := ioutil.ReadFile(filepath.Join(run.Path, "hash.json")); err == nil {
+			_ = json.Unmarshal(data, &hashes)
+		}
+
+		_ = filepath.Walk(run.Path, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			relativePath, _ := filepath.Rel(run.Path, path)
+			view.Files = append(view.Files, apiArtifactFile{
+				Name:   filepath.ToSlash(relativePath),
+				Size:   info.Size(),
+				SHA256: hashes[filepath.Base(path)],
+			})
+			return nil
+		})
+	}
+
+	writeJSON(w, http.StatusOK, view)
+}
+
+// handleDownloadArtifact streams the run as tar.gz, or a single file of the run given by the file query.
+func (s *apiServer) handleDownloadArtifact(w http.ResponseWriter, r *http.Request) {
+	run, found := s.readArtifactRun(w, r)
+	if !found {
+		return
+	}
+
+	if file := r.URL.Query().Get("file"); file != "" {
+		if run.Compressed {
+			writeError(w, http.StatusBadRequest, errors.New("files of compressed run are only downloaded as archive"))
+			return
+		}
+
+		// symbolic links are resolved, so no link in the run leads out of it
+		filePath := filepath.Join(run.Path, filepath.FromSlash(file))
+		runPath, err := filepath.EvalSymlinks(run.Path)
+		if err == nil {
+			filePath, err = filepath.EvalSymlinks(filePath)
+		}
+		if err != nil {
+			writeError(w, http.StatusNotFound, errors.New("file not found"))
+			return
+		}
+		if relativePath, err := filepath.Rel(runPath, filePath); err != nil || relativePath == "." || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+			writeError(w, http.StatusBadRequest, errors.New("invalid file"))
+			return
+		}
+		if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+			writeError(w, http.StatusNotFound, errors.New("file not found"))
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(filePath)))
+		http.ServeFile(w, r, filePath)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", run.Name+middleware.CompressedExtension))
+	if run.Compressed {
+		http.ServeFile(w, r, run.Path)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	if err := writeArtifactArchive(w, run.Path); err != nil {
+		log.Printf("error while downloading %s - %s", run.Name, err)
+	}
+}
+
+func readEventQuery(r *http.Request) (middleware.EventFilter, error) {
+	query := r.URL.Query()
+
+	since, err := parseTimeFlag(query.Get("since"))
+	if err != nil {
+		return middleware.EventFilter{}, err
+	}
+	until, err := parseTimeFlag(query.Get("until"))
+	if err != nil {
+		return middleware.EventFilter{}, err
+	}
+
+	filter := middleware.EventFilter{
+		Pot:      query.Get("pot"),
+		SourceIP: query.Get("ip"),
+		Since:    since,
+		Until:    until,
+	}
+	for _, kinds := range query["kind"] {
+		filter.Kinds = append(filter.Kinds, strings.Split(kinds, ",")...)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return middleware.EventFilter{}, fmt.Errorf("invalid limit %s", limit)
+		}
+	}
+
+	return filter, nil
+}
+
+func (s *apiServer) handleQueryEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := readEventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	events, err := eventStore.QueryEvents(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	enrichEvents(events)
+	field := r.URL.Query().Get("count_by")
+	if correlate, _ := strconv.ParseBool(r.URL.Query().Get("correlate")); correlate || field == "actor" || field == "campaign" {
+		if _, err := correlateEvents(eventStore, filter, events); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if field != "" {
+		counts := middleware.CountEvents(events, field)
+		if counts == nil {
+			counts = []middleware.EventCount{}
+		}
+		writeJSON(w, http.StatusOK, counts)
+		return
+	}
+
+	if events == nil {
+		events = []middleware.Event{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *apiServer) handleListCampaigns(w http.ResponseWriter, r *http.Request) {
+	filter, err := readEventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	correlation, err := correlateEvents(eventStore, filter, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"actors": correlation.Actors, "campaigns": correlation.Campaigns})
+}
+
+// handleStreamEvents sends published events as server-sent events until the client goes away.
+func (s *apiServer) handleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	filter, err := readEventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	events := middleware.SubscribeEvents(256)
+	defer middleware.UnsubscribeEvents(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			if !filter.Match(event) {
+				continue
+			}
+			data, _ := json.Marshal(event)
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func readAPIToken() string {
+	if apiToken != "" {
+		return apiToken
+	}
+	if token := os.Getenv(apiTokenEnv); token != "" {
+		return token
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(random)
+	log.Printf("API token not given, generated token: %s", token)
+	return token
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run collect daemon with REST API",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		sensor, err := localSensor()
+		if err != nil {
+			panic(err)
+		}
+
+		prepareOutputRoot()
+
+		server := &apiServer{ctx: ctx, sensor: sensor, token: readAPIToken()}
+
+		log.Println("Starting capturing network traffic...")
+		startCollectDaemon(ctx, []middleware.Sensor{server.sensor})
+
+		httpServer := &http.Server{Addr: serveListen, Handler: server.routes()}
+		log.Printf("Serving API on %s", serveListen)
+		if serveTLSCert != "" || serveTLSKey != "" {
+			err = httpServer.ListenAndServeTLS(serveTLSCert, serveTLSKey)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		log.Fatalf("error while serving API - %s", err)
+	},
+}
+
+var (
+	serveListen   string // Address of API server
+	apiToken      string // Bearer token of API
+	serveTLSCert  string // Certificate of API server
+	serveTLSKey   string // Private key of API server
+	publicMetrics bool   // Serve /metrics without token
+)
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	serveCmd.Flags().StringVarP(&serveListen, "listen", "l", "127.0.0.1:8080", "Address of API server")
+	serveCmd.Flags().StringVar(&apiToken, "token", "", "Bearer token of API (default $"+apiTokenEnv+" or generated)")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "Certificate file serving API over TLS")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "Private key file serving API over TLS")
+	serveCmd.Flags().BoolVar(&publicMetrics, "public-metrics", false, "Serve prometheus /metrics without token")
+	serveCmd.Flags().DurationVar(&checkpointDelay, "checkpoint-delay", 10*time.Second, "Time for pot to settle before the clean checkpoint")
+	addCollectFlags(serveCmd)
+
+	serveCmd.MarkFlagRequired("path")
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// newTestAPIServer serves the API over an output root holding one run of the ssh pot, and a
+// secret file next to the output root.
+func newTestAPIServer(t *testing.T) (*httptest.Server, string, func()) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "output")
+	runPath := filepath.Join(root, "ssh_1600000000")
+	_ = os.MkdirAll(runPath, os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(runPath, "container.log"), []byte("login root"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644)
+	_ = os.Symlink(filepath.Join(dir, "secret"), filepath.Join(runPath, "leak"))
+
+	previousRoot := outputRoot
+	outputRoot = root
+	server := httptest.NewServer((&apiServer{ctx: context.Background(), token: "s3cret"}).routes())
+	return server, dir, func() {
+		server.Close()
+		outputRoot = previousRoot
+		os.RemoveAll(dir)
+	}
+}
+
+func getWithToken(t *testing.T, url string, token string) *http.Response {
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestServeAuthentication(t *testing.T) {
+	server, _, cleanup := newTestAPIServer(t)
+	defer cleanup()
+
+	cases := []struct {
+		path   string
+		token  string
+		status int
+	}{
+		{"/api/v1/artifacts", "", http.StatusUnauthorized},
+		{"/api/v1/artifacts", "wrong", http.StatusUnauthorized},
+		{"/api/v1/artifacts", "s3cret", http.StatusOK},
+		{"/api/v1/artifacts?token=", "", http.StatusUnauthorized},
+		{"/api/v1/artifacts?token=wrong", "", http.StatusUnauthorized},
+		{"/api/v1/artifacts?token=s3cre", "", http.StatusUnauthorized},
+		{"/api/v1/artifacts?token=s3cret", "", http.StatusOK},
+		// the header wins over the query
+		{"/api/v1/artifacts?token=s3cret", "wrong", http.StatusUnauthorized},
+		{"/api/v1/artifacts/ssh_1600000000/download?file=container.log", "", http.StatusUnauthorized},
+		{"/api/v1/events/stream?token=wrong", "", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		response := getWithToken(t, server.URL+c.path, c.token)
+		response.Body.Close()
+		if response.StatusCode != c.status {
+			t.Errorf("status of %s with %q not match\nexpected: %d, actual: %d", c.path, c.token, c.status, response.StatusCode)
+		}
+		if c.status == http.StatusUnauthorized && response.Header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("authenticate header of %s not match\nexpected: Bearer, actual: %q", c.path, response.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestServeArtifactDownload(t *testing.T) {
+	server, _, cleanup := newTestAPIServer(t)
+	defer cleanup()
+
+	response := getWithToken(t, server.URL+"/api/v1/artifacts/ssh_1600000000/download?file=container.log", "s3cret")
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != "login root" {
+		t.Errorf("file download not match\nexpected: 200 login root, actual: %d %s", response.StatusCode, body)
+	}
+
+	for _, file := range []string{"../../secret", "..%2F..%2Fsecret", "%2E%2E/%2E%2E/secret", "/../../secret", "leak", "..", "."} {
+		response := getWithToken(t, server.URL+"/api/v1/artifacts/ssh_1600000000/download?file="+file, "s3cret")
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode == http.StatusOK || strings.Contains(string(body), "secret") {
+			t.Errorf("download of %s not match\nexpected: rejected, actual: %d %s", file, response.StatusCode, body)
+		}
+	}
+
+	for _, run := range []string{"..", "..%2Fsecret", "..%2F..%2Fsecret"} {
+		response := getWithToken(t, server.URL+"/api/v1/artifacts/"+run+"/download", "s3cret")
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode == http.StatusOK || strings.Contains(string(body), "secret") {
+			t.Errorf("download of %s run not match\nexpected: rejected, actual: %d %s", run, response.StatusCode, body)
+		}
+	}
+}
+
+func TestServeEventStream(t *testing.T) {
+	server, _, cleanup := newTestAPIServer(t)
+	defer cleanup()
+
+	response := getWithToken(t, server.URL+"/api/v1/events/stream?token=s3cret&kind=login", "")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream not match\nexpected: 200 text/event-stream, actual: %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	// the stream subscribed before answering, so both events reach it
+	middleware.PublishEvent(middleware.Event{Pot: "ssh", Kind: middleware.EventConnection, Message: "filtered out"})
+	middleware.PublishEvent(middleware.Event{Pot: "ssh", Kind: middleware.EventLogin, Username: "root", Message: "login root"})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var kind string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before event")
+			}
+			if strings.HasPrefix(line, "event: ") {
+				kind = strings.TrimPrefix(line, "event: ")
+			}
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var event middleware.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+			if kind != middleware.EventLogin || event.Kind != middleware.EventLogin || event.Pot != "ssh" || event.Username != "root" {
+				t.Errorf("streamed event not match\nexpected: login of root on ssh, actual: %s %+v", kind, event)
+			}
+			return
+		case <-timeout:
+			t.Fatal("event not streamed")
+		}
+	}
+}
+
+func TestServeWebUIEventKinds(t *testing.T) {
+	server, _, cleanup := newTestAPIServer(t)
+	defer cleanup()
+
+	response := getWithToken(t, server.URL+"/", "")
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	// the dashboard listens for every kind the stream sends
+	kinds, _ := json.Marshal(middleware.EventKinds)
+	if strings.Contains(string(body), webUIEventKinds) || !strings.Contains(string(body), string(kinds)+".forEach") {
+		t.Errorf("stream listeners not match\nexpected: %s, actual: placeholder left or kinds missing", kinds)
+	}
+}
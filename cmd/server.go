@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+func serverSocketPath() string {
+	return filepath.Join(outputRoot, "server.sock")
+}
+
+// serveAgentAdmin serves the agents and their command queues to honeypot agents on the local socket.
+func serveAgentAdmin(server *middleware.AgentServer) {
+	socketPath := serverSocketPath()
+	_ = os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Printf("error while listening agent admin - %s", err)
+		return
+	}
+	if err := http.Serve(listener, server.AdminHandler()); err != nil {
+		log.Printf("error while serving agent admin - %s", err)
+	}
+}
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Receive events and runs of honeypot agents over mutual TLS",
+	Long: "Receive events and run bundles pushed by honeypot agents authenticated by their client\n" +
+		"certificate. Runs of an agent are stored under <path>/<agent> and events in the event\n" +
+		"database with the agent as host. Commands queued with honeypot agents are fetched by agents\n" +
+		"with their heartbeats.",
+	Run: func(cmd *cobra.Command, args []string) {
+		prepareOutputRoot()
+
+		tlsConfig, err := middleware.NewTLSConfig(agentCA, agentCert, agentKey, true)
+		if err != nil {
+			log.Printf("error while reading server certificates - %s", err)
+			os.Exit(1)
+		}
+
+		startAlertEngine()
+		startOutputs(context.Background(), nil)
+		go middleware.RecordEvents(eventStore, middleware.SubscribeEvents(1024), 2*time.Second)
+
+		server, err := middleware.NewAgentServer(middleware.AgentServerConfig{
+			Root:      outputRoot,
+			StateFile: filepath.Join(outputRoot, "agents.json"),
+			OnEvents: func(agent string, events []middleware.Event) {
+				for _, event := range events {
+					middleware.PublishEvent(event)
+				}
+			},
+			OnRun: func(agent string, runPath string) {
+				indexArtifactRun(runPath)
+			},
+		})
+		if err != nil {
+			log.Printf("error while starting server - %s", err)
+			os.Exit(1)
+		}
+		go serveAgentAdmin(server)
+
+		httpServer := &http.Server{Addr: serverListen, Handler: server, TLSConfig: tlsConfig}
+		log.Printf("Serving agents on %s", serverListen)
+		if err := httpServer.ListenAndServeTLS("", ""); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// adminRequest sends a request to the agent admin of a running server.
+func adminRequest(method string, path string, body interface{}, value interface{}) error {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", serverSocketPath())
+		},
+	}}
+
+	data, _ := json.Marshal(body)
+	request, err := http.NewRequest(method, "http://server"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("server not running under %s - %s", outputRoot, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		var failure map[string]string
+		_ = json.NewDecoder(response.Body).Decode(&failure)
+		return errors.New(failure["error"])
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+var agentsCmd = &cobra.Command{
+	Use:   "agents",
+	Short: "List agents of the running server and send them commands",
+	Run: func(cmd *cobra.Command, args []string) {
+		var agents []middleware.AgentStatus
+		if err := adminRequest(http.MethodGet, "/agents", nil, &agents); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+
+		now := time.Now()
+		var rows [][]string
+		for _, agent := range agents {
+			status := "offline"
+			if agent.Online(now, agentTimeout) {
+				status = "online"
+			}
+			rows = append(rows, []string{
+				agent.Name, status, agent.Version, agent.Hostname, agent.Address, strings.Join(agent.Pots, ","),
+				strconv.Itoa(agent.Spooled), strconv.Itoa(agent.Events), strconv.Itoa(agent.Runs), strconv.Itoa(len(agent.Pending)), formatTime(agent.LastSeen),
+			})
+		}
+		header := []string{"Agent", "Status", "Version", "Hostname", "Address", "Pots", "Spooled", "Events", "Runs", "Pending", "Last Seen"}
+		if err := writeRecords(os.Stdout, agentsFormat, header, rows, agents); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// agentCommand queues the action for the agent given as argument on the running server.
+func agentCommand(action string, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " <agent>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			command := middleware.AgentCommand{Action: action, Pot: potName}
+			if action == middleware.CommandDeploy {
+				command.Image = potImage
+				command.Ports = potPorts
+				command.Environments = potEnvironments
+				command.Profile = potProfile
+			}
+
+			var queued middleware.AgentCommand
+			if err := adminRequest(http.MethodPost, "/agents/"+args[0]+"/commands", command, &queued); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			log.Printf("Queue %s command %s for %s agent", action, queued.ID, args[0])
+		},
+	}
+}
+
+var (
+	serverListen string        // Address serving agents
+	agentTimeout time.Duration // Time without heartbeat before an agent is offline
+	agentsFormat string        // Output format of agents
+)
+
+func init() {
+	rootCmd.AddCommand(serverCmd)
+	rootCmd.AddCommand(agentsCmd)
+
+	serverCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	serverCmd.Flags().StringVar(&serverListen, "listen", ":8443", "Address serving agents")
+	serverCmd.Flags().StringVar(&agentCA, "ca", "", "CA certificate verifying agent certificates")
+	serverCmd.Flags().StringVar(&agentCert, "cert", "", "Certificate of the server")
+	serverCmd.Flags().StringVar(&agentKey, "key", "", "Key of the server certificate")
+	serverCmd.Flags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	serverCmd.Flags().StringVar(&alertRulesFile, "alerts", "", "Path of alert rules YAML")
+	addOutputFlags(serverCmd)
+	serverCmd.MarkFlagRequired("path")
+	serverCmd.MarkFlagRequired("ca")
+	serverCmd.MarkFlagRequired("cert")
+	serverCmd.MarkFlagRequired("key")
+
+	agentsCmd.PersistentFlags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output of the server")
+	agentsCmd.Flags().DurationVar(&agentTimeout, "timeout", 2*time.Minute, "Time without heartbeat before an agent is offline")
+	agentsCmd.Flags().StringVarP(&agentsFormat, "format", "o", "table", "Output format (table, json, csv)")
+	agentsCmd.MarkPersistentFlagRequired("path")
+
+	deployAgentCmd := agentCommand(middleware.CommandDeploy, "Deploy a pot on the agent")
+	deployAgentCmd.Flags().StringVarP(&potImage, "image", "i", "", "Name of pot image")
+	deployAgentCmd.Flags().StringArrayVarP(&potPorts, "ports", "P", []string{}, "Port forwarding options")
+	deployAgentCmd.Flags().StringArrayVarP(&potEnvironments, "environments", "e", []string{}, "Environment Variables options")
+	deployAgentCmd.Flags().StringVar(&potProfile, "profile", "", "Artifact collection profile (light, standard, full)")
+	deployAgentCmd.MarkFlagRequired("image")
+
+	for _, command := range []*cobra.Command{
+		deployAgentCmd,
+		agentCommand(middleware.CommandReset, "Replace a pot of the agent with a clean container"),
+		agentCommand(middleware.CommandCollect, "Collect and reset a pot of the agent now"),
+	} {
+		command.Flags().StringVarP(&potName, "name", "n", "", "Name of pot")
+		command.MarkFlagRequired("name")
+		agentsCmd.AddCommand(command)
+	}
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+var timelineHTML = htmltemplate.Must(htmltemplate.New("timeline").Funcs(htmltemplate.FuncMap{"time": formatTime}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Timeline of {{.Pot}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 3px 8px; text-align: left; vertical-align: top; }
+td.message { font-family: monospace; white-space: pre-wrap; word-break: break-all; }
+tr.medium { background: #fff8e1; }
+tr.high { background: #ffebee; }
+label { margin-right: 1em; }
+</style>
+</head>
+<body>
+<h1>Timeline of {{.Pot}}</h1>
+<p>{{.Range}}, {{len .Entries}} entries.</p>
+<p id="sources">{{range .Sources}}<label><input type="checkbox" value="{{.}}" checked> {{.}}</label>{{end}}</p>
+<table>
+<tr><th>Time</th><th>Source</th><th>Kind</th><th>Severity</th><th>Message</th></tr>
+{{range .Entries}}<tr class="{{if ge .Severity 8}}high{{else if ge .Severity 5}}medium{{end}}" data-source="{{.Source}}"><td>{{time .Time}}</td><td>{{.Source}}</td><td>{{.Kind}}</td><td>{{.Severity}}</td><td class="message">{{.Message}}</td></tr>
+{{end}}</table>
+<script>
+document.querySelectorAll("#sources input").forEach(function (input) {
+  input.addEventListener("change", function () {
+    document.querySelectorAll("tr[data-source='" + input.value + "']").forEach(function (row) {
+      row.style.display = input.checked ? "" : "none";
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// writeTimeline writes the entries as aligned text, json or a self-contained html page.
+func writeTimeline(w io.Writer, format string, timeline *middleware.Timeline, entries []middleware.TimelineEntry) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "text":
+		writer := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, entry := range entries {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", formatTime(entry.Time), entry.Source, entry.Kind, entry.Message)
+		}
+		return writer.Flush()
+	case "html":
+		sources := []string{}
+		for _, entry := range entries {
+			if !containsString(sources, entry.Source) {
+				sources = append(sources, entry.Source)
+			}
+		}
+		sort.Strings(sources)
+		return timelineHTML.Execute(w, map[string]interface{}{
+			"Pot":     timeline.Pot,
+			"Range":   reportRange(timeline.Since, timeline.Until),
+			"Sources": sources,
+			"Entries": entries,
+		})
+	}
+	return fmt.Errorf("unknown %s format, expected text, json or html", format)
+}
+
+var timelineCmd = &cobra.Command{
+	Use:   "timeline",
+	Short: "Merge events and artifacts of a pot into one chronological timeline",
+	Run: func(cmd *cobra.Command, args []string) {
+		filter := readEventFilter()
+		filter.SourceIP, filter.Kinds = "", nil
+		timeline := middleware.NewTimeline(potName, filter.Since, filter.Until)
+
+		events, err := middleware.NewStore(eventDBPath()).QueryEvents(filter)
+		if err != nil {
+			log.Printf("error while querying events - %s", err)
+			os.Exit(1)
+		}
+		timeline.Add(middleware.TimelineEvents, events...)
+
+		runs, err := middleware.ReadArtifactRuns(outputRoot)
+		if err != nil {
+			log.Printf("error while reading %s - %s", outputRoot, err)
+			os.Exit(1)
+		}
+		sort.Slice(runs, func(i, j int) bool {
+			return runs[i].Time.Before(runs[j].Time)
+		})
+
+		for _, run := range runs {
+			if run.Pot != potName || (!filter.Since.IsZero() && run.Time.Before(filter.Since)) {
+				continue
+			}
+			if run.Compressed {
+				log.Printf("Skip compressed artifact run %s", run.Name)
+				continue
+			}
+			if err := timeline.AddRun(run.Path); err != nil {
+				log.Printf("error while reading %s - %s", run.Name, err)
+			}
+			// the capture of the first run after the window still holds packets of the window
+			if !filter.Until.IsZero() && run.Time.After(filter.Until) {
+				break
+			}
+		}
+
+		entries := timeline.Entries()
+		if err := writeTimeline(os.Stdout, timelineFormat, timeline, entries); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var timelineFormat string // Output format
+
+func init() {
+	rootCmd.AddCommand(timelineCmd)
+
+	timelineCmd.Flags().StringVarP(&outputRoot, "path", "p", "", "Path of artifact output")
+	timelineCmd.Flags().StringVar(&eventDB, "db", "", "Path of event database (default <path>/events.db)")
+	timelineCmd.Flags().StringVarP(&potName, "name", "n", "", "Name of pot")
+	timelineCmd.Flags().StringVar(&querySince, "since", "24h", "Start of time range (RFC3339, YYYY-MM-DD or duration like 168h)")
+	timelineCmd.Flags().StringVar(&queryUntil, "until", "", "End of time range (RFC3339, YYYY-MM-DD or duration like 24h)")
+	timelineCmd.Flags().StringVarP(&timelineFormat, "format", "o", "text", "Output format (text, json, html)")
+
+	timelineCmd.MarkFlagRequired("name")
+}
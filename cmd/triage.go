@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+// triageCollectedRun records the static triage of the files attackers added to the run in the manifest.
+func triageCollectedRun(runPath string, manifest *middleware.Manifest) {
+	if !triageFiles {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(runPath, "dump.tar")); err != nil {
+		return
+	}
+
+	options := yaraScanOptions()
+	reports, err := middleware.TriageRun(runPath, false, options.MaxFileSize)
+	if err != nil {
+		log.Printf("error while triaging %s - %s", filepath.Base(runPath), err)
+	}
+	manifest.Triage = reports
+	for _, report := range reports {
+		if contacts := report.Contacts(); len(contacts) > 0 {
+			log.Printf("Triage %s of %s pot contacts %s", report.Path, manifest.Pot, strings.Join(contacts, ", "))
+		}
+	}
+}
+
+func triageRows(run string, reports []middleware.Triage) [][]string {
+	var rows [][]string
+	for _, report := range reports {
+		arch := report.Arch
+		if report.Bits > 0 {
+			arch += " " + strconv.Itoa(report.Bits) + "-bit"
+		}
+		rows = append(rows, []string{
+			run, report.Path, report.FileType, arch, report.Packer,
+			strconv.FormatFloat(report.Entropy, 'f', 2, 64), strings.Join(report.Contacts(), "\n"),
+		})
+	}
+	return rows
+}
+
+var triageCmd = &cobra.Command{
+	Use:   "triage <artifact-dir|file>",
+	Short: "Statically analyse files dropped in pots",
+	Long: "Statically analyse files dropped in pots: architecture, imports, sections, packers, and the\n" +
+		"urls, addresses, wallets and download commands they contain. Given a run directory or the\n" +
+		"output path, the reports are recorded in the manifest of each run. Given any other file,\n" +
+		"the file itself is analysed.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		options := yaraScanOptions()
+		header := []string{"Run", "Path", "Type", "Arch", "Packer", "Entropy", "Contacts"}
+
+		if info, err := os.Stat(args[0]); err == nil && info.Mode().IsRegular() {
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				log.Printf("error while reading %s - %s", args[0], err)
+				os.Exit(1)
+			}
+			report := middleware.TriageFile(args[0], data)
+			if err := writeRecords(os.Stdout, triageFormat, header, triageRows("", []middleware.Triage{report}), report); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		runPaths, err := scanRunPaths(args[0])
+		if err != nil {
+			log.Printf("error while reading %s - %s", args[0], err)
+			os.Exit(1)
+		}
+
+		var rows [][]string
+		allReports := []middleware.Triage{}
+		for _, runPath := range runPaths {
+			manifest, err := middleware.ReadManifest(runPath)
+			if err != nil {
+				log.Printf("error while reading manifest of %s - %s", filepath.Base(runPath), err)
+				continue
+			}
+
+			reports, err := middleware.TriageRun(runPath, options.Export, options.MaxFileSize)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				log.Printf("error while triaging %s - %s", filepath.Base(runPath), err)
+				continue
+			}
+
+			manifest.Triage = reports
+			if err := middleware.WriteManifest(runPath, manifest); err != nil {
+				log.Printf("error while writing manifest of %s - %s", filepath.Base(runPath), err)
+			}
+
+			rows = append(rows, triageRows(filepath.Base(runPath), reports)...)
+			allReports = append(allReports, reports...)
+		}
+
+		if err := writeRecords(os.Stdout, triageFormat, header, rows, allReports); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	triageFiles  bool   // Triage files of collected runs
+	triageFormat string // Output format
+)
+
+// addTriageFlags binds the triage settings to the collect daemon.
+func addTriageFlags(command *cobra.Command) {
+	command.Flags().BoolVar(&triageFiles, "triage", true, "Statically analyse files added to pots")
+}
+
+func init() {
+	rootCmd.AddCommand(triageCmd)
+
+	triageCmd.Flags().BoolVar(&yaraExport, "export", false, "Triage every file of dump.tar, not only added and changed files")
+	triageCmd.Flags().StringVar(&yaraMaxSize, "max-size", "32MB", "Size above which files are not analysed")
+	triageCmd.Flags().StringVarP(&triageFormat, "format", "o", "table", "Output format (table, json, csv)")
+}
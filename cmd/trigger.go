@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bunseokbot/Honey-V/middleware"
+)
+
+var collecting = struct {
+	sync.Mutex
+	pots map[string]struct{}
+}{pots: make(map[string]struct{})}
+
+func beginCollecting(potName string) bool {
+	collecting.Lock()
+	defer collecting.Unlock()
+
+	if _, found := collecting.pots[potName]; found {
+		return false
+	}
+	collecting.pots[potName] = struct{}{}
+	return true
+}
+
+func endCollecting(potName string) {
+	collecting.Lock()
+	defer collecting.Unlock()
+
+	delete(collecting.pots, potName)
+}
+
+func isCollecting(potName string) bool {
+	collecting.Lock()
+	defer collecting.Unlock()
+
+	_, found := collecting.pots[potName]
+	return found
+}
+
+// dispatchTriggers starts a collection of the pot on the host of the event whenever an event of an enabled trigger arrives.
+func dispatchTriggers(ctx context.Context, sensors []middleware.Sensor, limiter *middleware.Limiter, triggers []string) {
+	enabled := make(map[string]string)
+	for _, trigger := range triggers {
+		if kind, found := middleware.TriggerKinds[trigger]; found {
+			enabled[kind] = trigger
+		} else {
+			log.Printf("unknown %s trigger ignored", trigger)
+		}
+	}
+
+	events := middleware.SubscribeEvents(256)
+	defer middleware.UnsubscribeEvents(events)
+
+	for event := range events {
+		trigger, found := enabled[event.Kind]
+		potKey := middleware.PotKey(event.Host, event.Pot)
+		if !found || event.Pot == "" || isCollecting(potKey) {
+			continue
+		}
+		sensor, found := findSensor(sensors, event.Host)
+		if !found {
+			continue
+		}
+
+		if allowed, reason := limiter.Allow(potKey, time.Now()); !allowed {
+			log.Printf("%s trigger on %s pot suppressed - %s", trigger, potKey, reason)
+			continue
+		}
+
+		log.Printf("%s trigger on %s pot - %s", trigger, potKey, event.Message)
+		go func(potName string, reason string) {
+			if err := collectPot(ctx, sensor, potName, reason); err != nil {
+				log.Printf("error while collecting %s pot - %s", middleware.PotKey(sensor.EventHost(), potName), err)
+			}
+		}(event.Pot, fmt.Sprintf("%s: %s", trigger, event.Message))
+	}
+}
+
+func collectSocketPath() string {
+	return filepath.Join(outputRoot, "collect.sock")
+}
+
+// listenCollectRequest serves collect --now requests of other processes while collect is running.
+// A request names the pot as <host>/<pot> for pots of fleet hosts.
+func listenCollectRequest(ctx context.Context, sensors []middleware.Sensor) {
+	socketPath := collectSocketPath()
+	_ = os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Printf("error while listening collect request - %s", err)
+		return
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("error while accepting collect request - %s", err)
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return
+			}
+			requestName := strings.TrimSpace(line)
+			host, requestPot := splitPotKey(requestName)
+			sensor, found := findSensor(sensors, host)
+			if !found {
+				_, _ = fmt.Fprintf(conn, "error: host %s not managed by collect\n", host)
+				return
+			}
+
+			log.Printf("%s trigger on %s pot", middleware.TriggerManual, requestName)
+			if err := collectPot(ctx, sensor, requestPot, middleware.TriggerManual); err != nil {
+				_, _ = fmt.Fprintf(conn, "error: %s\n", err)
+				return
+			}
+			_, _ = fmt.Fprintln(conn, "ok")
+		}(conn)
+	}
+}
+
+// requestCollectNow hands the pot of each sensor to a running collect process, or collects it
+// in place when none is running.
+func requestCollectNow(ctx context.Context, sensors []middleware.Sensor, potName string) {
+	if potName == "" {
+		log.Println("pot name is empty. terminating program")
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, sensor := range sensors {
+		if err := requestCollectPot(ctx, sensor, potName); err != nil {
+			log.Printf("error while collecting %s pot - %s", middleware.PotKey(sensor.EventHost(), potName), err)
+			failed = true
+		}
+	}
+	waitRunAnalysis()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func requestCollectPot(ctx context.Context, sensor middleware.Sensor, potName string) error {
+	potKey := middleware.PotKey(sensor.EventHost(), potName)
+
+	conn, err := net.Dial("unix", collectSocketPath())
+	if err != nil {
+		log.Printf("collect process not running, collecting %s pot in place", potKey)
+		return collectPot(ctx, sensor, potName, middleware.TriggerManual)
+	}
+	defer conn.Close()
+
+	_, _ = fmt.Fprintln(conn, potKey)
+
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || strings.TrimSpace(response) != "ok" {
+		return errors.New(strings.TrimSpace(strings.TrimPrefix(response, "error:")))
+	}
+
+	log.Printf("Successfully collected %s pot", potKey)
+	return nil
+}
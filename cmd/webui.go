@@ -0,0 +1,376 @@
+package cmd
+
+// webUIEventKinds is replaced with the event kinds the stream of the API sends.
+const webUIEventKinds = "EVENT_KINDS"
+
+// webUIDocument is the dashboard served by serve at /. It talks to the API with the token kept in the browser.
+const webUIDocument = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Honey-V</title>
+<style>
+  * { box-sizing: border-box; }
+  body { margin: 0; background: #111418; color: #d8dee9; font: 14px/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; }
+  header { display: flex; align-items: center; justify-content: space-between; padding: 10px 20px; background: #1b2027; border-bottom: 1px solid #2e3440; }
+  header h1 { margin: 0; font-size: 18px; color: #ebcb8b; }
+  header span { color: #81a1c1; font-size: 12px; }
+  main { display: grid; grid-template-columns: 3fr 2fr; gap: 16px; padding: 16px 20px; }
+  section { background: #1b2027; border: 1px solid #2e3440; border-radius: 4px; padding: 12px; min-width: 0; }
+  section h2 { margin: 0 0 8px; font-size: 13px; text-transform: uppercase; letter-spacing: .05em; color: #88c0d0; }
+  table { width: 100%; border-collapse: collapse; }
+  th, td { text-align: left; padding: 4px 6px; border-bottom: 1px solid #2e3440; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; max-width: 320px; }
+  th { color: #81a1c1; font-weight: normal; }
+  tr.selected td { background: #2e3440; }
+  tbody tr { cursor: pointer; }
+  .running { color: #a3be8c; }
+  .paused, .restarting { color: #ebcb8b; }
+  .exited, .dead { color: #bf616a; }
+  button { background: #3b4252; color: #eceff4; border: 1px solid #4c566a; border-radius: 3px; padding: 2px 8px; cursor: pointer; font-size: 12px; }
+  button:hover { background: #4c566a; }
+  button.danger { border-color: #bf616a; }
+  .charts { display: grid; grid-template-columns: repeat(3, 1fr); gap: 12px; }
+  .chart h3 { margin: 0 0 4px; font-size: 12px; font-weight: normal; color: #81a1c1; }
+  canvas { width: 100%; height: 120px; background: #111418; border: 1px solid #2e3440; }
+  #feed { height: 360px; overflow-y: auto; font-family: Menlo, Consolas, monospace; font-size: 12px; }
+  #feed div { padding: 2px 0; border-bottom: 1px solid #20252d; }
+  .kind { display: inline-block; min-width: 80px; color: #b48ead; }
+  .sev5, .sev8, .sev10 { color: #d08770; }
+  .sev8 .kind, .sev10 .kind { color: #bf616a; }
+  .wide { grid-column: 1 / -1; }
+  #login { position: fixed; inset: 0; background: rgba(0, 0, 0, .8); display: none; align-items: center; justify-content: center; }
+  #login form { background: #1b2027; padding: 20px; border: 1px solid #4c566a; border-radius: 4px; }
+  #login input { width: 280px; padding: 6px; background: #111418; color: #eceff4; border: 1px solid #4c566a; }
+  @media (max-width: 1000px) { main { grid-template-columns: 1fr; } .charts { grid-template-columns: 1fr; } }
+</style>
+</head>
+<body>
+<header><h1>Honey-V</h1><span id="status">connecting...</span></header>
+<main>
+  <section>
+    <h2>Pots</h2>
+    <table>
+      <thead><tr><th>Name</th><th>State</th><th>Uptime</th><th>Profile</th><th>CPU</th><th>Memory</th><th>Rx / Tx</th><th></th></tr></thead>
+      <tbody id="pots"></tbody>
+    </table>
+  </section>
+  <section>
+    <h2>Live events</h2>
+    <div id="feed"></div>
+  </section>
+  <section class="wide">
+    <h2 id="chart-title">Resource usage</h2>
+    <div class="charts">
+      <div class="chart"><h3>CPU %</h3><canvas id="cpu-chart"></canvas></div>
+      <div class="chart"><h3>Memory %</h3><canvas id="memory-chart"></canvas></div>
+      <div class="chart"><h3>Network bytes/s (rx, tx)</h3><canvas id="network-chart"></canvas></div>
+    </div>
+  </section>
+  <section>
+    <h2>Recent credentials</h2>
+    <table>
+      <thead><tr><th>Time</th><th>Pot</th><th>Source</th><th>Username</th><th>Password</th></tr></thead>
+      <tbody id="credentials"></tbody>
+    </table>
+  </section>
+  <section>
+    <h2>Recent commands</h2>
+    <table>
+      <thead><tr><th>Time</th><th>Pot</th><th>Source</th><th>Command</th></tr></thead>
+      <tbody id="commands"></tbody>
+    </table>
+  </section>
+</main>
+<div id="login"><form><p>API token</p><input id="token" type="password" autofocus> <button>Connect</button></form></div>
+<script>
+(function () {
+  "use strict";
+
+  var api = "/api/v1";
+  var historySize = 120;
+  var token = localStorage.getItem("honeyv.token") || "";
+  var selected = "";
+  var history = {};
+  var stream = null;
+
+  function $(id) { return document.getElementById(id); }
+
+  // escapes attacker controlled values for text and attributes
+  function text(value) {
+    var replacements = { "&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;", "'": "&#39;" };
+    return (value === undefined || value === null ? "" : String(value)).replace(/[&<>"']/g, function (c) {
+      return replacements[c];
+    });
+  }
+
+  function request(method, path) {
+    return fetch(api + path, { method: method, headers: { "Authorization": "Bearer " + token } }).then(function (response) {
+      if (response.status === 401) {
+        showLogin();
+        throw new Error("unauthorized");
+      }
+      if (response.status === 204) {
+        return null;
+      }
+      return response.json().then(function (body) {
+        if (!response.ok) {
+          throw new Error(body.error || response.statusText);
+        }
+        return body;
+      });
+    });
+  }
+
+  function showLogin() {
+    $("login").style.display = "flex";
+    $("status").textContent = "token required";
+  }
+
+  $("login").querySelector("form").addEventListener("submit", function (e) {
+    e.preventDefault();
+    token = $("token").value;
+    localStorage.setItem("honeyv.token", token);
+    $("login").style.display = "none";
+    start();
+  });
+
+  function formatBytes(value) {
+    var units = ["B", "KB", "MB", "GB", "TB"];
+    var index = 0;
+    while (value >= 1000 && index < units.length - 1) {
+      value /= 1000;
+      index++;
+    }
+    return value.toFixed(index ? 1 : 0) + units[index];
+  }
+
+  function formatTime(value) {
+    return new Date(value).toLocaleTimeString();
+  }
+
+  function source(event) {
+    return event.source_ip ? event.source_ip + ":" + (event.source_port || "") : "";
+  }
+
+  function action(name, verb) {
+    if (verb === "remove") {
+      if (!confirm("Remove " + name + " pot?")) {
+        return;
+      }
+      request("DELETE", "/pots/" + encodeURIComponent(name)).then(refreshPots, alertError);
+      return;
+    }
+    request("POST", "/pots/" + encodeURIComponent(name) + "/" + verb).then(refreshPots, alertError);
+  }
+
+  function alertError(error) {
+    if (error.message !== "unauthorized") {
+      alert(error.message);
+    }
+  }
+
+  function renderPots(pots) {
+    pots.sort(function (a, b) { return a.name < b.name ? -1 : 1; });
+    if (!selected && pots.length) {
+      selected = pots[0].name;
+    }
+
+    var rows = pots.map(function (pot) {
+      var last = (history[pot.name] || []).slice(-1)[0] || {};
+      return "<tr data-pot=\"" + text(pot.name) + "\"" + (pot.name === selected ? " class=\"selected\"" : "") + ">" +
+        "<td>" + text(pot.name) + "</td>" +
+        "<td class=\"" + text(pot.state) + "\">" + text(pot.collecting ? "collecting" : pot.state) + "</td>" +
+        "<td>" + text(pot.status) + "</td>" +
+        "<td>" + text(pot.profile) + "</td>" +
+        "<td>" + (last.cpu_percent !== undefined ? last.cpu_percent.toFixed(2) + "%" : "") + "</td>" +
+        "<td>" + (last.memory_percent !== undefined ? last.memory_percent.toFixed(2) + "%" : "") + "</td>" +
+        "<td>" + (last.network_rx !== undefined ? formatBytes(last.network_rx) + " / " + formatBytes(last.network_tx) : "") + "</td>" +
+        "<td><button data-action=\"collect\">Collect now</button> <button data-action=\"reset\">Reset</button> " +
+        "<button class=\"danger\" data-action=\"remove\">Remove</button></td></tr>";
+    });
+    $("pots").innerHTML = rows.join("") || "<tr><td colspan=\"8\">no pots deployed</td></tr>";
+  }
+
+  $("pots").addEventListener("click", function (e) {
+    var row = e.target.closest("tr[data-pot]");
+    if (!row) {
+      return;
+    }
+    var verb = e.target.getAttribute("data-action");
+    if (verb) {
+      action(row.getAttribute("data-pot"), verb);
+      return;
+    }
+    selected = row.getAttribute("data-pot");
+    Array.prototype.forEach.call($("pots").rows, function (r) {
+      r.className = r === row ? "selected" : "";
+    });
+    drawCharts();
+  });
+
+  function refreshPots() {
+    return request("GET", "/pots").then(function (pots) {
+      $("status").textContent = "updated " + new Date().toLocaleTimeString();
+      renderPots(pots);
+    });
+  }
+
+  function refreshStats() {
+    return request("GET", "/stats").then(function (stats) {
+      var now = Date.now();
+      stats.forEach(function (stat) {
+        var points = history[stat.pot] = history[stat.pot] || [];
+        var previous = points[points.length - 1];
+        stat.time = now;
+        stat.rx_rate = previous ? Math.max(0, (stat.network_rx - previous.network_rx) / ((now - previous.time) / 1000)) : 0;
+        stat.tx_rate = previous ? Math.max(0, (stat.network_tx - previous.network_tx) / ((now - previous.time) / 1000)) : 0;
+        points.push(stat);
+        if (points.length > historySize) {
+          points.shift();
+        }
+      });
+      drawCharts();
+    });
+  }
+
+  function drawChart(canvas, series, colors, maximum) {
+    var ratio = window.devicePixelRatio || 1;
+    canvas.width = canvas.clientWidth * ratio;
+    canvas.height = canvas.clientHeight * ratio;
+    var context = canvas.getContext("2d");
+    context.clearRect(0, 0, canvas.width, canvas.height);
+
+    var top = maximum || 1;
+    series.forEach(function (values) {
+      values.forEach(function (value) { top = Math.max(top, value); });
+    });
+
+    context.fillStyle = "#4c566a";
+    context.font = (10 * ratio) + "px sans-serif";
+    context.fillText(top > 1000 ? formatBytes(top) : top.toFixed(1), 4 * ratio, 12 * ratio);
+
+    series.forEach(function (values, index) {
+      context.strokeStyle = colors[index];
+      context.lineWidth = 1.5 * ratio;
+      context.beginPath();
+      values.forEach(function (value, i) {
+        var x = canvas.width * i / (historySize - 1);
+        var y = canvas.height - canvas.height * value / top;
+        if (i === 0) {
+          context.moveTo(x, y);
+        } else {
+          context.lineTo(x, y);
+        }
+      });
+      context.stroke();
+    });
+  }
+
+  function drawCharts() {
+    var points = history[selected] || [];
+    $("chart-title").textContent = "Resource usage" + (selected ? " - " + selected : "");
+    drawChart($("cpu-chart"), [points.map(function (p) { return p.cpu_percent; })], ["#a3be8c"], 100);
+    drawChart($("memory-chart"), [points.map(function (p) { return p.memory_percent; })], ["#88c0d0"], 100);
+    drawChart($("network-chart"), [
+      points.map(function (p) { return p.rx_rate; }),
+      points.map(function (p) { return p.tx_rate; })
+    ], ["#ebcb8b", "#b48ead"], 0);
+  }
+
+  function addFeed(event) {
+    var line = document.createElement("div");
+    line.className = "sev" + event.severity;
+    var detail = event.message || event.command || event.path || "";
+    line.innerHTML = text(formatTime(event.time)) + " <span class=\"kind\">" + text(event.kind) + "</span> " +
+      text(event.pot) + " " + text(source(event)) + " " + text(detail);
+    var feed = $("feed");
+    feed.insertBefore(line, feed.firstChild);
+    while (feed.childNodes.length > 500) {
+      feed.removeChild(feed.lastChild);
+    }
+  }
+
+  var credentials = [];
+  var commands = [];
+
+  function renderActivity() {
+    $("credentials").innerHTML = credentials.slice(0, 25).map(function (event) {
+      return "<tr><td>" + text(formatTime(event.time)) + "</td><td>" + text(event.pot) + "</td><td>" + text(source(event)) +
+        "</td><td>" + text(event.username) + "</td><td>" + text(event.password) + "</td></tr>";
+    }).join("");
+    $("commands").innerHTML = commands.slice(0, 25).map(function (event) {
+      return "<tr><td>" + text(formatTime(event.time)) + "</td><td>" + text(event.pot) + "</td><td>" + text(source(event)) +
+        "</td><td title=\"" + text(event.command) + "\">" + text(event.command) + "</td></tr>";
+    }).join("");
+  }
+
+  function receive(event) {
+    addFeed(event);
+    if (event.kind === "login") {
+      credentials.unshift(event);
+      renderActivity();
+    } else if (event.kind === "command") {
+      commands.unshift(event);
+      renderActivity();
+    }
+    if (event.kind === "collect" || event.kind === "reset") {
+      refreshPots();
+    }
+  }
+
+  function loadActivity() {
+    return request("GET", "/events?kind=login,command&since=24h").then(function (events) {
+      events.reverse();
+      credentials = events.filter(function (e) { return e.kind === "login"; });
+      commands = events.filter(function (e) { return e.kind === "command"; });
+      renderActivity();
+      events.slice(0, 100).reverse().forEach(addFeed);
+    });
+  }
+
+  function connectStream() {
+    if (stream) {
+      stream.close();
+    }
+    stream = new EventSource(api + "/events/stream?token=" + encodeURIComponent(token));
+    stream.onmessage = function (e) { receive(JSON.parse(e.data)); };
+    // events are sent under their kind, filled in by serve from the kinds of the build
+    ` + webUIEventKinds + `.forEach(function (kind) {
+      stream.addEventListener(kind, function (e) { receive(JSON.parse(e.data)); });
+    });
+  }
+
+  var started = false;
+
+  function pollStats() {
+    // docker takes a second or more per pot, so the next poll waits for this one
+    refreshStats().then(null, function () {}).then(function () {
+      setTimeout(pollStats, 5000);
+    });
+  }
+
+  function start() {
+    refreshPots().then(function () {
+      loadActivity();
+      connectStream();
+      if (!started) {
+        started = true;
+        setInterval(function () { refreshPots().then(null, function () {}); }, 10000);
+        pollStats();
+      }
+    }, function () {});
+  }
+
+  window.addEventListener("resize", drawCharts);
+
+  if (token) {
+    start();
+  } else {
+    showLogin();
+  }
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,862 @@
+package middleware
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths of the agent API of the server.
+const (
+	AgentEventsPath    = "/agent/v1/events"
+	AgentRunsPath      = "/agent/v1/runs/"
+	AgentHeartbeatPath = "/agent/v1/heartbeat"
+)
+
+// Actions of commands sent from the server to agents.
+const (
+	CommandDeploy  = "deploy"  // deploy a pot from an image
+	CommandReset   = "reset"   // replace a pot with a clean container without collecting
+	CommandCollect = "collect" // collect and reset a pot now
+)
+
+var CommandActions = []string{CommandDeploy, CommandReset, CommandCollect}
+
+// maxAgentResults limits the command results kept per agent.
+const maxAgentResults = 20
+
+// AgentCommand is an action queued on the server and run by an agent.
+type AgentCommand struct {
+	ID           string    `json:"id"`
+	Action       string    `json:"action"`
+	Pot          string    `json:"pot"`
+	Image        string    `json:"image,omitempty"`
+	Ports        []string  `json:"ports,omitempty"`
+	Environments []string  `json:"environments,omitempty"`
+	Profile      string    `json:"profile,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// CommandResult is the outcome of a command reported by the agent.
+type CommandResult struct {
+	ID       string    `json:"id"`
+	Action   string    `json:"action"`
+	Pot      string    `json:"pot"`
+	Error    string    `json:"error,omitempty"`
+	Finished time.Time `json:"finished"`
+}
+
+// Heartbeat reports the state of an agent, answered with the commands queued for it.
+type Heartbeat struct {
+	Version  string          `json:"version"`
+	Hostname string          `json:"hostname"`
+	Pots     []string        `json:"pots"`
+	Spooled  int             `json:"spooled"`
+	Results  []CommandResult `json:"results,omitempty"`
+}
+
+// NewTLSConfig returns the mutual TLS settings of the server, requiring client certificates
+// signed by the CA, or of an agent, verifying the server against the CA.
+func NewTLSConfig(caFile string, certFile string, keyFile string, server bool) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caData, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	if server {
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// AgentConfig sets where and how an agent pushes to the server.
+type AgentConfig struct {
+	URL               string      // base URL of the server, e.g. https://collector:8443
+	TLS               *tls.Config // client certificate naming the agent, CA of the server
+	Version           string
+	Hostname          string
+	SpoolDir          string // event batches and run bundles kept until the server accepts them
+	BatchSize         int
+	FlushInterval     time.Duration
+	HeartbeatInterval time.Duration
+	RetryMax          time.Duration // longest wait between retries
+	Timeout           time.Duration
+
+	// Pots lists the pots reported in heartbeats, optional
+	Pots func() []string
+	// Execute runs a command sent by the server, optional
+	Execute func(AgentCommand) error
+}
+
+// Agent pushes events and run bundles of a sensor to the server, spooling them to disk while
+// the server is unreachable, and runs the commands the server answers heartbeats with.
+type Agent struct {
+	config AgentConfig
+	client *http.Client
+
+	mutex    sync.Mutex
+	batch    []Event
+	results  []CommandResult
+	taken    map[string]bool // commands queued, running or with a result not yet reported
+	commands chan AgentCommand
+	flush    chan struct{}
+	closed   chan struct{}
+	done     chan struct{}
+}
+
+func NewAgent(config AgentConfig) (*Agent, error) {
+	if config.URL == "" {
+		return nil, errors.New("server url required")
+	}
+	if config.SpoolDir == "" {
+		return nil, errors.New("spool directory required")
+	}
+	config.URL = strings.TrimRight(config.URL, "/")
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = 30 * time.Second
+	}
+	if config.RetryMax <= 0 {
+		config.RetryMax = 5 * time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Minute
+	}
+	if err := os.MkdirAll(config.SpoolDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	agent := &Agent{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout, Transport: &http.Transport{TLSClientConfig: config.TLS}},
+		taken:    make(map[string]bool),
+		commands: make(chan AgentCommand, 64),
+		flush:    make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go agent.execute()
+	go agent.deliver()
+	return agent, nil
+}
+
+// SendEvent queues the event for the next push.
+func (a *Agent) SendEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	a.mutex.Lock()
+	a.batch = append(a.batch, event)
+	full := len(a.batch) >= a.config.BatchSize
+	a.mutex.Unlock()
+
+	if full {
+		a.wake()
+	}
+}
+
+// SendRun spools the tar.gz bundle of the run written by write and pushes it after the
+// events queued before it.
+func (a *Agent) SendRun(runName string, write func(io.Writer) error) error {
+	if events := a.takeBatch(); len(events) > 0 {
+		a.spool(encodeEvents(events))
+	}
+
+	fileName := filepath.Join(a.config.SpoolDir, fmt.Sprintf("%020d_%s%s", time.Now().UnixNano(), runName, CompressedExtension))
+	file, err := os.Create(fileName + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		_ = os.Remove(fileName + ".tmp")
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(fileName+".tmp", fileName); err != nil {
+		return err
+	}
+
+	a.wake()
+	return nil
+}
+
+// Run sends published events until the channel is closed.
+func (a *Agent) Run(events <-chan Event) {
+	for event := range events {
+		a.SendEvent(event)
+	}
+}
+
+// Close spools queued events and stops pushing.
+func (a *Agent) Close() {
+	close(a.closed)
+	<-a.done
+}
+
+// Spooled returns the number of event batches and run bundles waiting for the server.
+func (a *Agent) Spooled() int {
+	return len(a.spoolFiles())
+}
+
+func (a *Agent) wake() {
+	select {
+	case a.flush <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Agent) takeBatch() []Event {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	batch := a.batch
+	a.batch = nil
+	return batch
+}
+
+func (a *Agent) spoolFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(a.config.SpoolDir, "*"))
+
+	var spooled []string
+	for _, fileName := range files {
+		if strings.HasSuffix(fileName, ".ndjson") || strings.HasSuffix(fileName, CompressedExtension) {
+			spooled = append(spooled, fileName)
+		}
+	}
+	sort.Strings(spooled)
+	return spooled
+}
+
+// encodeEvents returns the events as newline delimited JSON.
+func encodeEvents(events []Event) []byte {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, event := range events {
+		_ = encoder.Encode(event)
+	}
+	return body.Bytes()
+}
+
+// spool keeps the event batch for a later retry.
+func (a *Agent) spool(body []byte) {
+	fileName := filepath.Join(a.config.SpoolDir, fmt.Sprintf("%020d.ndjson", time.Now().UnixNano()))
+	if err := writeFileAtomic(fileName, body, 0600); err != nil {
+		log.Printf("error while spooling agent events - %s", err)
+	}
+}
+
+func (a *Agent) request(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequest(method, a.config.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	return a.client.Do(request)
+}
+
+// push sends the body, dropping it when the server rejects it for good.
+func (a *Agent) push(method string, path string, contentType string, body io.Reader) error {
+	response, err := a.request(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	message, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	if response.StatusCode/100 != 2 {
+		log.Printf("error while pushing %s, dropped - %s %s", path, response.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// replay pushes spooled batches and bundles oldest first, stopping at the first failure.
+func (a *Agent) replay() error {
+	for _, fileName := range a.spoolFiles() {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(fileName, ".ndjson") {
+			err = a.push(http.MethodPost, AgentEventsPath, "application/x-ndjson", file)
+		} else {
+			runName := strings.TrimSuffix(filepath.Base(fileName), CompressedExtension)
+			runName = runName[strings.Index(runName, "_")+1:]
+			err = a.push(http.MethodPut, AgentRunsPath+runName, "application/gzip", file)
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+		_ = os.Remove(fileName)
+	}
+	return nil
+}
+
+// heartbeat reports the agent with the results of finished commands and queues the commands
+// of the answer.
+func (a *Agent) heartbeat() error {
+	a.mutex.Lock()
+	results := a.results
+	a.mutex.Unlock()
+
+	heartbeat := Heartbeat{Version: a.config.Version, Hostname: a.config.Hostname, Spooled: a.Spooled(), Results: results}
+	if a.config.Pots != nil {
+		heartbeat.Pots = a.config.Pots()
+	}
+	body, _ := json.Marshal(heartbeat)
+
+	response, err := a.request(http.MethodPost, AgentHeartbeatPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	var commands []AgentCommand
+	if err := json.NewDecoder(response.Body).Decode(&commands); err != nil {
+		return err
+	}
+
+	// the server sends commands until their results arrive, so those already taken are skipped
+	a.mutex.Lock()
+	a.results = a.results[len(results):]
+	for _, result := range results {
+		delete(a.taken, result.ID)
+	}
+	var fresh []AgentCommand
+	for _, command := range commands {
+		if !a.taken[command.ID] {
+			a.taken[command.ID] = true
+			fresh = append(fresh, command)
+		}
+	}
+	a.mutex.Unlock()
+
+	for _, command := range fresh {
+		select {
+		case a.commands <- command:
+		default:
+			a.finish(command, errors.New("agent busy, command dropped"))
+		}
+	}
+	return nil
+}
+
+func (a *Agent) finish(command AgentCommand, err error) {
+	result := CommandResult{ID: command.ID, Action: command.Action, Pot: command.Pot, Finished: time.Now()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	a.mutex.Lock()
+	a.results = append(a.results, result)
+	a.mutex.Unlock()
+}
+
+// execute runs commands one at a time until the agent is closed.
+func (a *Agent) execute() {
+	for {
+		select {
+		case <-a.closed:
+			return
+		case command := <-a.commands:
+			var err error
+			if a.config.Execute == nil {
+				err = errors.New("commands not supported by agent")
+			} else {
+				err = a.config.Execute(command)
+			}
+			if err != nil {
+				log.Printf("error while running %s command on %s pot - %s", command.Action, command.Pot, err)
+			} else {
+				log.Printf("Run %s command on %s pot", command.Action, command.Pot)
+			}
+			a.finish(command, err)
+		}
+	}
+}
+
+func (a *Agent) deliver() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+	heartbeatTicker := time.NewTicker(a.config.HeartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	initial := time.Second
+	if initial > a.config.RetryMax {
+		initial = a.config.RetryMax
+	}
+	backoff := initial
+	var nextRetry time.Time
+	beat := true
+
+	for {
+		closing := false
+		if !beat {
+			select {
+			case <-ticker.C:
+			case <-heartbeatTicker.C:
+				beat = true
+			case <-a.flush:
+			case <-a.closed:
+				closing = true
+			}
+		}
+
+		if events := a.takeBatch(); len(events) > 0 {
+			body := encodeEvents(events)
+
+			// keep order behind spooled batches and do not hammer a failing server
+			if closing || time.Now().Before(nextRetry) || len(a.spoolFiles()) > 0 {
+				a.spool(body)
+			} else if err := a.push(http.MethodPost, AgentEventsPath, "application/x-ndjson", bytes.NewReader(body)); err != nil {
+				log.Printf("error while pushing agent events, spooled - %s", err)
+				a.spool(body)
+				nextRetry = time.Now().Add(backoff)
+			}
+		}
+
+		if closing {
+			return
+		}
+
+		if beat {
+			beat = false
+			if err := a.heartbeat(); err != nil {
+				log.Printf("error while sending agent heartbeat - %s", err)
+			}
+		}
+
+		if !time.Now().Before(nextRetry) {
+			if err := a.replay(); err != nil {
+				log.Printf("error while replaying agent spool, retrying in %s - %s", backoff, err)
+				nextRetry = time.Now().Add(backoff)
+				if backoff *= 2; backoff > a.config.RetryMax {
+					backoff = a.config.RetryMax
+				}
+			} else {
+				backoff = initial
+				nextRetry = time.Time{}
+			}
+		}
+	}
+}
+
+// AgentStatus is what the server knows of an agent.
+type AgentStatus struct {
+	Name      string          `json:"name"`
+	Version   string          `json:"version"`
+	Hostname  string          `json:"hostname"`
+	Address   string          `json:"address"`
+	Pots      []string        `json:"pots"`
+	Spooled   int             `json:"spooled"`
+	FirstSeen time.Time       `json:"first_seen"`
+	LastSeen  time.Time       `json:"last_seen"`
+	Events    int             `json:"events"`
+	Runs      int             `json:"runs"`
+	Pending   []AgentCommand  `json:"pending,omitempty"`
+	Results   []CommandResult `json:"results,omitempty"` // latest results, oldest first
+}
+
+// Online reports whether the agent sent a heartbeat within the timeout.
+func (s AgentStatus) Online(now time.Time, timeout time.Duration) bool {
+	return now.Sub(s.LastSeen) <= timeout
+}
+
+// AgentServerConfig sets where the server keeps what agents push.
+type AgentServerConfig struct {
+	Root      string // runs of an agent are stored under <root>/<agent>
+	StateFile string // agents kept across restarts, optional
+
+	// OnEvents receives the events of an agent, optional
+	OnEvents func(agent string, events []Event)
+	// OnRun receives each stored run of an agent, optional
+	OnRun func(agent string, runPath string)
+}
+
+// AgentServer receives events and run bundles of agents authenticated by the common name of
+// their client certificate, tracks their heartbeats and queues commands for them.
+type AgentServer struct {
+	config AgentServerConfig
+
+	mutex  sync.Mutex
+	agents map[string]*AgentStatus
+	serial int
+}
+
+func NewAgentServer(config AgentServerConfig) (*AgentServer, error) {
+	if err := os.MkdirAll(config.Root, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	server := &AgentServer{config: config, agents: make(map[string]*AgentStatus)}
+	if config.StateFile != "" {
+		data, err := ioutil.ReadFile(config.StateFile)
+		if err == nil {
+			var agents []AgentStatus
+			if err := json.Unmarshal(data, &agents); err != nil {
+				return nil, err
+			}
+			for index := range agents {
+				server.agents[agents[index].Name] = &agents[index]
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// Agents returns the agents seen by the server by name.
+func (s *AgentServer) Agents() []AgentStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.listAgents()
+}
+
+func (s *AgentServer) listAgents() []AgentStatus {
+	agents := []AgentStatus{}
+	for _, agent := range s.agents {
+		agents = append(agents, *agent)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+	return agents
+}
+
+// saveState writes the agents to the state file, with the lock held.
+func (s *AgentServer) saveState() {
+	if s.config.StateFile == "" {
+		return
+	}
+
+	data, _ := json.MarshalIndent(s.listAgents(), "", "  ")
+	if err := writeFileAtomic(s.config.StateFile, data, 0600); err != nil {
+		log.Printf("error while writing agent state - %s", err)
+	}
+}
+
+// Enqueue queues the command for the next heartbeat of the agent.
+func (s *AgentServer) Enqueue(agentName string, command AgentCommand) (AgentCommand, error) {
+	if !containsName(CommandActions, command.Action) {
+		return AgentCommand{}, fmt.Errorf("unknown %s command. available commands: %s", command.Action, strings.Join(CommandActions, ", "))
+	}
+	if command.Pot == "" {
+		return AgentCommand{}, errors.New("pot name required")
+	}
+	if command.Action == CommandDeploy && command.Image == "" {
+		return AgentCommand{}, errors.New("image required to deploy")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	agent, found := s.agents[agentName]
+	if !found {
+		return AgentCommand{}, fmt.Errorf("agent %s not found", agentName)
+	}
+
+	s.serial++
+	command.ID = fmt.Sprintf("%s-%d-%d", agentName, time.Now().Unix(), s.serial)
+	command.Created = time.Now()
+	agent.Pending = append(agent.Pending, command)
+	s.saveState()
+	return command, nil
+}
+
+// agentName returns the agent of the request, the common name of its verified client certificate.
+func agentName(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", errors.New("client certificate required")
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if !hostNamePattern.MatchString(name) || name == LocalHostName {
+		return "", fmt.Errorf("invalid agent name %q", name)
+	}
+	return name, nil
+}
+
+// seen returns the status of the agent, adding it on first contact, with the lock held.
+func (s *AgentServer) seen(name string, r *http.Request) *AgentStatus {
+	agent, found := s.agents[name]
+	if !found {
+		agent = &AgentStatus{Name: name, FirstSeen: time.Now()}
+		s.agents[name] = agent
+		log.Printf("new %s agent from %s", name, r.RemoteAddr)
+	}
+	agent.Address = r.RemoteAddr
+	agent.LastSeen = time.Now()
+	return agent
+}
+
+func (s *AgentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, err := agentName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == AgentHeartbeatPath && r.Method == http.MethodPost:
+		s.handleHeartbeat(w, r, name)
+	case r.URL.Path == AgentEventsPath && r.Method == http.MethodPost:
+		s.handleEvents(w, r, name)
+	case strings.HasPrefix(r.URL.Path, AgentRunsPath) && r.Method == http.MethodPut:
+		s.handleRun(w, r, name, strings.TrimPrefix(r.URL.Path, AgentRunsPath))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *AgentServer) handleHeartbeat(w http.ResponseWriter, r *http.Request, name string) {
+	var heartbeat Heartbeat
+	if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	agent := s.seen(name, r)
+	if agent.Version != heartbeat.Version && agent.Version != "" {
+		log.Printf("%s agent updated from %s to %s", name, agent.Version, heartbeat.Version)
+	}
+	agent.Version = heartbeat.Version
+	agent.Hostname = heartbeat.Hostname
+	agent.Pots = heartbeat.Pots
+	agent.Spooled = heartbeat.Spooled
+	// commands stay pending until their result is reported, so answers lost on the way are
+	// sent again, and results sent again are counted once
+	for _, result := range heartbeat.Results {
+		index := -1
+		for position, command := range agent.Pending {
+			if command.ID == result.ID {
+				index = position
+			}
+		}
+		if index < 0 {
+			continue
+		}
+		agent.Pending = append(agent.Pending[:index], agent.Pending[index+1:]...)
+
+		if result.Error != "" {
+			log.Printf("error while running %s command on %s pot of %s agent - %s", result.Action, result.Pot, name, result.Error)
+		}
+		agent.Results = append(agent.Results, result)
+	}
+	if len(agent.Results) > maxAgentResults {
+		agent.Results = agent.Results[len(agent.Results)-maxAgentResults:]
+	}
+	commands := append([]AgentCommand{}, agent.Pending...)
+	s.saveState()
+	s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(commands)
+}
+
+func (s *AgentServer) handleEvents(w http.ResponseWriter, r *http.Request, name string) {
+	var events []Event
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		event.Host = name
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	s.seen(name, r).Events += len(events)
+	s.mutex.Unlock()
+
+	if s.config.OnEvents != nil && len(events) > 0 {
+		s.config.OnEvents(name, events)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *AgentServer) handleRun(w http.ResponseWriter, r *http.Request, name string, runName string) {
+	if match := runNamePattern.FindStringSubmatch(runName); match == nil || match[3] != "" || strings.ContainsAny(runName, `/\`) || strings.HasPrefix(runName, ".") {
+		http.Error(w, fmt.Sprintf("invalid run name %q", runName), http.StatusBadRequest)
+		return
+	}
+
+	root := filepath.Join(s.config.Root, name)
+	runPath := filepath.Join(root, runName)
+	if _, err := os.Stat(runPath); err == nil {
+		// bundle pushed again after a lost answer
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := extractRunBundle(r.Body, root, runName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if manifest, err := ReadManifest(runPath); err == nil {
+		manifest.Host = name
+		_ = WriteManifest(runPath, manifest)
+	}
+
+	s.mutex.Lock()
+	s.seen(name, r).Runs++
+	s.mutex.Unlock()
+
+	log.Printf("Receive run %s of %s agent", runName, name)
+	if s.config.OnRun != nil {
+		s.config.OnRun(name, runPath)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// extractRunBundle extracts the tar.gz bundle of the run into the root, refusing entries
+// outside the run directory.
+func extractRunBundle(reader io.Reader, root string, runName string) error {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	temporary := filepath.Join(root, "."+runName+".tmp")
+	_ = os.RemoveAll(temporary)
+	if err := os.MkdirAll(temporary, os.ModePerm); err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = os.RemoveAll(temporary)
+			return err
+		}
+
+		name := path.Clean(header.Name)
+		if !strings.HasPrefix(name, runName+"/") {
+			_ = os.RemoveAll(temporary)
+			return fmt.Errorf("entry %s outside run %s", header.Name, runName)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		fileName := filepath.Join(temporary, filepath.FromSlash(strings.TrimPrefix(name, runName+"/")))
+		if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+			_ = os.RemoveAll(temporary)
+			return err
+		}
+		file, err := os.Create(fileName)
+		if err != nil {
+			_ = os.RemoveAll(temporary)
+			return err
+		}
+		_, err = io.Copy(file, tarReader)
+		file.Close()
+		if err != nil {
+			_ = os.RemoveAll(temporary)
+			return err
+		}
+	}
+
+	return os.Rename(temporary, filepath.Join(root, runName))
+}
+
+// AdminHandler serves the agents and their command queues to the operator:
+// GET /agents and POST /agents/<name>/commands.
+func (s *AgentServer) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/agents" && r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode(s.Agents())
+			return
+		}
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) == 3 && parts[0] == "agents" && parts[2] == "commands" && r.Method == http.MethodPost {
+			var command AgentCommand
+			if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			queued, err := s.Enqueue(parts[1], command)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(queued)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+	})
+}
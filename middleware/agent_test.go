@@ -0,0 +1,276 @@
+package middleware
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTestCertificates writes a CA with a server certificate for 127.0.0.1 and a client
+// certificate for each agent name into the directory.
+func writeTestCertificates(t *testing.T, dir string, agents ...string) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "honeypot ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	_ = ioutil.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		_ = ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+		_ = ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	}
+
+	issue("server", 2, x509.ExtKeyUsageServerAuth)
+	for index, agent := range agents {
+		issue(agent, int64(3+index), x509.ExtKeyUsageClientAuth)
+	}
+}
+
+// writeRunBundle writes the tar.gz bundle of a run with the files, named like the bundles of collect.
+func writeRunBundle(w io.Writer, runName string, files map[string]string) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: runName + "/" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// agentServerStandIn answers 503 in front of the server while down, like a server being restarted.
+type agentServerStandIn struct {
+	server *AgentServer
+	down   int32
+}
+
+func (s *agentServerStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.down) == 1 {
+		http.Error(w, "restarting", http.StatusServiceUnavailable)
+		return
+	}
+	s.server.ServeHTTP(w, r)
+}
+
+func TestAgentServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestCertificates(t, dir, "seoul")
+
+	var mutex sync.Mutex
+	var received []Event
+	var runs []string
+	server, err := NewAgentServer(AgentServerConfig{
+		Root:      filepath.Join(dir, "runs"),
+		StateFile: filepath.Join(dir, "agents.json"),
+		OnEvents: func(agent string, events []Event) {
+			mutex.Lock()
+			received = append(received, events...)
+			mutex.Unlock()
+		},
+		OnRun: func(agent string, runPath string) {
+			mutex.Lock()
+			runs = append(runs, runPath)
+			mutex.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	standIn := &agentServerStandIn{server: server, down: 1}
+	httpServer := httptest.NewUnstartedServer(standIn)
+	if httpServer.TLS, err = NewTLSConfig(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), true); err != nil {
+		t.Fatal(err)
+	}
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	clientTLS, err := NewTLSConfig(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "seoul.pem"), filepath.Join(dir, "seoul-key.pem"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var executed []AgentCommand
+	agent, err := NewAgent(AgentConfig{
+		URL:               httpServer.URL,
+		TLS:               clientTLS,
+		Version:           "1.2.0",
+		Hostname:          "sensor-01",
+		SpoolDir:          filepath.Join(dir, "spool"),
+		FlushInterval:     20 * time.Millisecond,
+		HeartbeatInterval: 50 * time.Millisecond,
+		RetryMax:          50 * time.Millisecond,
+		Pots:              func() []string { return []string{"ssh"} },
+		Execute: func(command AgentCommand) error {
+			mutex.Lock()
+			executed = append(executed, command)
+			mutex.Unlock()
+			// outlasts heartbeats sending the command again
+			time.Sleep(150 * time.Millisecond)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	// server down, events and the run wait in the spool
+	agent.SendEvent(Event{Pot: "ssh", Kind: EventLogin, Username: "root", Password: "admin", Message: "login root"})
+	runName := "ssh_1600000000"
+	manifest := mustMarshal(Manifest{Pot: "ssh", Container: "a1"})
+	if err := agent.SendRun(runName, func(w io.Writer) error {
+		return writeRunBundle(w, runName, map[string]string{ManifestFileName: string(manifest), "container.diff": "A /tmp/miner"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	agent.SendEvent(Event{Pot: "ssh", Kind: EventCollect, Message: "collected"})
+	waitFor(t, func() bool { return agent.Spooled() == 3 })
+
+	atomic.StoreInt32(&standIn.down, 0)
+	waitFor(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(received) == 2 && len(runs) == 1
+	})
+	waitFor(t, func() bool { return agent.Spooled() == 0 })
+
+	mutex.Lock()
+	if received[0].Host != "seoul" || received[0].Username != "root" || received[1].Kind != EventCollect {
+		t.Errorf("received events not match\nexpected: login then collect from seoul, actual: %+v", received)
+	}
+	mutex.Unlock()
+	stored, err := ReadManifest(filepath.Join(dir, "runs", "seoul", runName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Host != "seoul" {
+		t.Errorf("stored run host not match\nexpected: seoul, actual: %s", stored.Host)
+	}
+
+	// commands are fetched by heartbeats and their results reported by the next ones
+	queued, err := server.Enqueue("seoul", AgentCommand{Action: CommandCollect, Pot: "ssh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Enqueue("seoul", AgentCommand{Action: "format", Pot: "ssh"}); err == nil {
+		t.Errorf("unknown command not match\nexpected: error, actual: nil")
+	}
+	waitFor(t, func() bool {
+		agents := server.Agents()
+		return len(agents) == 1 && len(agents[0].Results) == 1
+	})
+	time.Sleep(150 * time.Millisecond)
+	status := server.Agents()[0]
+	if status.Version != "1.2.0" || status.Hostname != "sensor-01" || status.Runs != 1 || len(status.Results) != 1 || status.Results[0].ID != queued.ID || !status.Online(time.Now(), time.Second) {
+		t.Errorf("agent status not match\nexpected: 1.2.0 on sensor-01 with result of %s, actual: %+v", queued.ID, status)
+	}
+	if len(status.Pending) != 0 {
+		t.Errorf("pending commands not match\nexpected: [], actual: %+v", status.Pending)
+	}
+	mutex.Lock()
+	if len(executed) != 1 || executed[0].ID != queued.ID {
+		t.Errorf("executed commands not match\nexpected: %s, actual: %+v", queued.ID, executed)
+	}
+	mutex.Unlock()
+
+	restarted, err := NewAgentServer(AgentServerConfig{Root: filepath.Join(dir, "runs"), StateFile: filepath.Join(dir, "agents.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agents := restarted.Agents(); len(agents) != 1 || agents[0].Name != "seoul" {
+		t.Errorf("restored agents not match\nexpected: seoul, actual: %+v", agents)
+	}
+
+	// clients without a certificate of the CA are refused
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: clientTLS.RootCAs}}}
+	if response, err := anonymous.Post(httpServer.URL+AgentEventsPath, "application/x-ndjson", bytes.NewReader(nil)); err == nil {
+		response.Body.Close()
+		t.Errorf("anonymous agent not match\nexpected: handshake error, actual: %s", response.Status)
+	}
+}
+
+func TestExtractRunBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var bundle bytes.Buffer
+	if err := writeRunBundle(&bundle, "ssh_1600000000", map[string]string{"../../evil": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractRunBundle(&bundle, dir, "ssh_1600000000"); err == nil {
+		t.Errorf("escaping entry not match\nexpected: error, actual: nil")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evil")); err == nil {
+		t.Errorf("escaping entry written outside %s", dir)
+	}
+
+	bundle.Reset()
+	if err := writeRunBundle(&bundle, "ssh_1600000000", map[string]string{"tty/a.cast": "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractRunBundle(&bundle, dir, "ssh_1600000000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ssh_1600000000", "tty", "a.cast")); err != nil {
+		t.Errorf("extracted file not match\nexpected: tty/a.cast, actual: %s", err)
+	}
+}
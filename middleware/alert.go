@@ -0,0 +1,369 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AlertRule fires when Threshold matching events of one group arrive within Window.
+type AlertRule struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	Kinds       []string          `yaml:"kinds"`        // event kinds, empty for all
+	Match       map[string]string `yaml:"match"`        // event field -> regular expression
+	MinSeverity int               `yaml:"min_severity"` // ignore events below this severity
+	GroupBy     []string          `yaml:"group_by"`     // event fields counted separately, default pot
+	Threshold   int               `yaml:"threshold"`    // events within window, default 1
+	Window      time.Duration     `yaml:"window"`
+	Suppress    time.Duration     `yaml:"suppress"` // quiet time of a group after it fired
+	Severity    string            `yaml:"severity"` // info, low, medium, high or critical
+	Notify      []string          `yaml:"notify"`   // notifier names, empty for all
+
+	patterns map[string]*regexp.Regexp
+	severity int
+}
+
+// AlertConfig is the YAML file of alert rules and notifiers.
+type AlertConfig struct {
+	Rules     []AlertRule      `yaml:"rules"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+}
+
+// Alert is a fired rule with the events which made it fire.
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Description string    `json:"description,omitempty"`
+	Severity    int       `json:"severity"`
+	Group       string    `json:"group"`
+	Count       int       `json:"count"`
+	Suppressed  int       `json:"suppressed"` // matching events dropped during the previous suppression
+	Time        time.Time `json:"time"`
+	Events      []Event   `json:"events"`
+	Message     string    `json:"message"`
+
+	notify []string
+}
+
+// maxAlertEvents limits the sample of events attached to an alert.
+const maxAlertEvents = 10
+
+// maxPendingAlerts limits the alerts waiting for the notifiers, later ones are dropped.
+const maxPendingAlerts = 100
+
+var severityNames = map[string]int{
+	"info":     SeverityInfo,
+	"low":      SeverityLow,
+	"medium":   SeverityMedium,
+	"high":     SeverityHigh,
+	"critical": SeverityCritical,
+}
+
+// SeverityName returns the name of the severity band the value falls in.
+func SeverityName(severity int) string {
+	switch {
+	case severity >= SeverityCritical:
+		return "critical"
+	case severity >= SeverityHigh:
+		return "high"
+	case severity >= SeverityMedium:
+		return "medium"
+	case severity >= SeverityLow:
+		return "low"
+	}
+	return "info"
+}
+
+// LoadAlertConfig reads and validates the rules and notifiers of the YAML file.
+func LoadAlertConfig(fileName string) (AlertConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return AlertConfig{}, err
+	}
+
+	return ParseAlertConfig(data)
+}
+
+func ParseAlertConfig(data []byte) (AlertConfig, error) {
+	var config AlertConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return AlertConfig{}, err
+	}
+
+	notifiers := make(map[string]bool)
+	for _, notifier := range config.Notifiers {
+		if notifier.Name == "" {
+			return AlertConfig{}, errors.New("notifier name required")
+		}
+		notifiers[notifier.Name] = true
+	}
+
+	for index := range config.Rules {
+		rule := &config.Rules[index]
+		if err := rule.compile(); err != nil {
+			return AlertConfig{}, fmt.Errorf("rule %s - %s", rule.Name, err)
+		}
+		for _, name := range rule.Notify {
+			if !notifiers[name] {
+				return AlertConfig{}, fmt.Errorf("rule %s - %s notifier not found", rule.Name, name)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+func (r *AlertRule) compile() error {
+	if r.Name == "" {
+		return errors.New("name required")
+	}
+
+	if r.Threshold <= 0 {
+		r.Threshold = 1
+	}
+	if r.Threshold > 1 && r.Window <= 0 {
+		return errors.New("window required with threshold")
+	}
+	if len(r.GroupBy) == 0 {
+		r.GroupBy = []string{"pot"}
+	}
+
+	r.severity = SeverityHigh
+	if r.Severity != "" {
+		severity, found := severityNames[strings.ToLower(r.Severity)]
+		if !found {
+			return fmt.Errorf("unknown %s severity", r.Severity)
+		}
+		r.severity = severity
+	}
+
+	r.patterns = make(map[string]*regexp.Regexp)
+	for field, expression := range r.Match {
+		pattern, err := regexp.Compile(expression)
+		if err != nil {
+			return err
+		}
+		r.patterns[field] = pattern
+	}
+
+	return nil
+}
+
+func (r *AlertRule) matches(event Event) bool {
+	if len(r.Kinds) > 0 {
+		found := false
+		for _, kind := range r.Kinds {
+			if kind == event.Kind {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if event.Severity < r.MinSeverity {
+		return false
+	}
+
+	for field, pattern := range r.patterns {
+		if !pattern.MatchString(EventKey(event, field)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *AlertRule) group(event Event) string {
+	var values []string
+	for _, field := range r.GroupBy {
+		values = append(values, field+"="+EventKey(event, field))
+	}
+	return strings.Join(values, ",")
+}
+
+type alertGroup struct {
+	window        time.Duration
+	events        []Event
+	arrivals      []time.Time
+	suppressUntil time.Time
+	suppressed    int
+}
+
+// idle reports whether the group holds no events within its window and nothing suppressed.
+func (g *alertGroup) idle(now time.Time) bool {
+	if now.Before(g.suppressUntil) || g.suppressed > 0 {
+		return false
+	}
+	return len(g.arrivals) == 0 || now.Sub(g.arrivals[len(g.arrivals)-1]) >= g.window
+}
+
+// AlertEngine evaluates events against the rules and hands fired alerts to notifiers.
+type AlertEngine struct {
+	sync.Mutex
+	rules     []AlertRule
+	notifiers []Notifier
+	groups    map[string]*alertGroup
+	lastPrune time.Time
+}
+
+func NewAlertEngine(rules []AlertRule, notifiers []Notifier) *AlertEngine {
+	return &AlertEngine{rules: rules, notifiers: notifiers, groups: make(map[string]*alertGroup)}
+}
+
+// NewAlertEngineFromConfig builds the notifiers of the config and the engine over its rules.
+func NewAlertEngineFromConfig(config AlertConfig) (*AlertEngine, error) {
+	var notifiers []Notifier
+	for _, notifierConfig := range config.Notifiers {
+		notifier, err := NewNotifier(notifierConfig)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s - %s", notifierConfig.Name, err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	return NewAlertEngine(config.Rules, notifiers), nil
+}
+
+// Process returns the alerts the event fires at now.
+func (e *AlertEngine) Process(event Event, now time.Time) []Alert {
+	e.Lock()
+	defer e.Unlock()
+
+	// forget groups of addresses gone quiet
+	if now.Sub(e.lastPrune) > time.Minute {
+		for key, group := range e.groups {
+			if group.idle(now) {
+				delete(e.groups, key)
+			}
+		}
+		e.lastPrune = now
+	}
+
+	var alerts []Alert
+	for index := range e.rules {
+		rule := &e.rules[index]
+		if !rule.matches(event) {
+			continue
+		}
+
+		groupName := rule.group(event)
+		key := rule.Name + "|" + groupName
+		group, found := e.groups[key]
+		if !found {
+			group = &alertGroup{window: rule.Window}
+			e.groups[key] = group
+		}
+
+		if now.Before(group.suppressUntil) {
+			group.suppressed++
+			continue
+		}
+
+		// drop events which arrived before the window
+		start := 0
+		for start < len(group.arrivals) && now.Sub(group.arrivals[start]) >= rule.Window {
+			start++
+		}
+		group.events = append(group.events[start:], event)
+		group.arrivals = append(group.arrivals[start:], now)
+
+		if len(group.events) < rule.Threshold {
+			continue
+		}
+
+		alert := Alert{
+			Rule:        rule.Name,
+			Description: rule.Description,
+			Severity:    rule.severity,
+			Group:       groupName,
+			Count:       len(group.events),
+			Suppressed:  group.suppressed,
+			Time:        now,
+			Events:      group.events,
+			notify:      rule.Notify,
+		}
+		if len(alert.Events) > maxAlertEvents {
+			alert.Events = alert.Events[len(alert.Events)-maxAlertEvents:]
+		}
+		alert.Message = formatAlertMessage(alert, rule.Window)
+		alerts = append(alerts, alert)
+
+		group.events = nil
+		group.arrivals = nil
+		group.suppressed = 0
+		group.suppressUntil = now.Add(rule.Suppress)
+	}
+
+	return alerts
+}
+
+func formatAlertMessage(alert Alert, window time.Duration) string {
+	last := alert.Events[len(alert.Events)-1]
+
+	message := fmt.Sprintf("[%s] %s on %s pot", strings.ToUpper(SeverityName(alert.Severity)), alert.Rule, last.Pot)
+	if alert.Count > 1 {
+		message += fmt.Sprintf(": %d events in %s (%s)", alert.Count, window, alert.Group)
+	} else if last.Message != "" {
+		message += ": " + last.Message
+	}
+	if alert.Suppressed > 0 {
+		message += fmt.Sprintf(", %d suppressed", alert.Suppressed)
+	}
+	return message
+}
+
+// Notify sends the alert to the notifiers named by its rule, or to all of them.
+func (e *AlertEngine) Notify(alert Alert) {
+	for _, notifier := range e.notifiers {
+		if len(alert.notify) > 0 && !containsName(alert.notify, notifier.Name()) {
+			continue
+		}
+		if err := notifier.Notify(alert); err != nil {
+			log.Printf("error while sending %s alert to %s - %s", alert.Rule, notifier.Name(), err)
+		}
+	}
+}
+
+// Run evaluates published events until the channel is closed. Alerts are sent by a worker, so
+// slow notifiers do not hold up the events.
+func (e *AlertEngine) Run(events <-chan Event) {
+	pending := make(chan Alert, maxPendingAlerts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for alert := range pending {
+			e.Notify(alert)
+		}
+	}()
+
+	for event := range events {
+		for _, alert := range e.Process(event, time.Now()) {
+			log.Println(alert.Message)
+			select {
+			case pending <- alert:
+			default:
+				log.Printf("alert queue full, %s alert not sent", alert.Rule)
+			}
+		}
+	}
+	close(pending)
+	<-done
+}
+
+func containsName(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,378 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const alertConfig = `
+rules:
+  - name: outbound
+    kinds: [outbound]
+    severity: critical
+    suppress: 10m
+  - name: flood
+    kinds: [connection]
+    group_by: [ip]
+    threshold: 3
+    window: 1m
+    suppress: 5m
+    notify: [hook]
+  - name: ssh-login
+    kinds: [login]
+    match:
+      pot: ^ssh
+notifiers:
+  - name: hook
+    type: webhook
+    url: http://127.0.0.1/
+`
+
+func TestAlertEngine(t *testing.T) {
+	config, err := ParseAlertConfig([]byte(alertConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewAlertEngine(config.Rules, nil)
+
+	now := time.Unix(1600000000, 0)
+	connection := Event{Pot: "web", Kind: EventConnection, SourceIP: "10.0.0.1"}
+
+	fired := func(event Event, at time.Time) []string {
+		var rules []string
+		for _, alert := range engine.Process(event, at) {
+			rules = append(rules, alert.Rule)
+		}
+		return rules
+	}
+
+	if rules := fired(connection, now); len(rules) != 0 {
+		t.Errorf("alert fired under threshold\nexpected: [], actual: %v", rules)
+	}
+	if rules := fired(connection, now.Add(30*time.Second)); len(rules) != 0 {
+		t.Errorf("alert fired under threshold\nexpected: [], actual: %v", rules)
+	}
+	// first connection falls out of the window
+	if rules := fired(connection, now.Add(70*time.Second)); len(rules) != 0 {
+		t.Errorf("alert fired out of window\nexpected: [], actual: %v", rules)
+	}
+	if rules := fired(connection, now.Add(80*time.Second)); len(rules) != 1 || rules[0] != "flood" {
+		t.Errorf("flood alert not match\nexpected: [flood], actual: %v", rules)
+	}
+
+	// suppressed, and counted in the next alert
+	for index := 0; index < 3; index++ {
+		if rules := fired(connection, now.Add(90*time.Second)); len(rules) != 0 {
+			t.Errorf("suppressed alert fired\nexpected: [], actual: %v", rules)
+		}
+	}
+	for index := 0; index < 2; index++ {
+		fired(connection, now.Add(10*time.Minute))
+	}
+	alerts := engine.Process(connection, now.Add(10*time.Minute))
+	if len(alerts) != 1 || alerts[0].Suppressed != 3 || alerts[0].Count != 3 {
+		t.Errorf("alert after suppression not match\nexpected: 3 events, 3 suppressed, actual: %+v", alerts)
+	}
+
+	// other source ip is a separate group
+	other := Event{Pot: "web", Kind: EventConnection, SourceIP: "10.0.0.2"}
+	if rules := fired(other, now.Add(10*time.Minute)); len(rules) != 0 {
+		t.Errorf("alert fired for other group\nexpected: [], actual: %v", rules)
+	}
+
+	if rules := fired(Event{Pot: "web", Kind: EventLogin}, now); len(rules) != 0 {
+		t.Errorf("login alert fired on unmatched pot\nexpected: [], actual: %v", rules)
+	}
+	if rules := fired(Event{Pot: "ssh-1", Kind: EventLogin, Username: "root"}, now); len(rules) != 1 || rules[0] != "ssh-login" {
+		t.Errorf("login alert not match\nexpected: [ssh-login], actual: %v", rules)
+	}
+
+	outbound := Event{Pot: "web", Kind: EventOutbound, Message: "outbound tcp connection to 1.2.3.4:80"}
+	alerts = engine.Process(outbound, now)
+	if len(alerts) != 1 || alerts[0].Severity != SeverityCritical {
+		t.Fatalf("outbound alert not match\nexpected: critical alert, actual: %+v", alerts)
+	}
+	if expected := "[CRITICAL] outbound on web pot: outbound tcp connection to 1.2.3.4:80"; alerts[0].Message != expected {
+		t.Errorf("alert message not match\nexpected: %s, actual: %s", expected, alerts[0].Message)
+	}
+	if rules := fired(Event{Pot: "db", Kind: EventOutbound}, now); len(rules) != 1 {
+		t.Errorf("outbound alert of other pot suppressed\nexpected: [outbound], actual: %v", rules)
+	}
+}
+
+// blockingNotifier holds every alert until released.
+type blockingNotifier struct {
+	release <-chan struct{}
+	sent    chan<- Alert
+}
+
+func (n blockingNotifier) Name() string {
+	return "blocking"
+}
+
+func (n blockingNotifier) Notify(alert Alert) error {
+	<-n.release
+	n.sent <- alert
+	return nil
+}
+
+func TestAlertEngineRun(t *testing.T) {
+	config, err := ParseAlertConfig([]byte(alertConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	sent := make(chan Alert, 3)
+	engine := NewAlertEngine(config.Rules, []Notifier{blockingNotifier{release: release, sent: sent}})
+
+	events := make(chan Event)
+	finished := make(chan struct{})
+	go func() {
+		engine.Run(events)
+		close(finished)
+	}()
+
+	// events keep being taken while the notifier is stuck
+	for _, pot := range []string{"web", "db", "ftp"} {
+		select {
+		case events <- Event{Pot: pot, Kind: EventOutbound}:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("alert engine blocked by notifier")
+		}
+	}
+
+	close(release)
+	close(events)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("alert engine not finished")
+	}
+	if len(sent) != 3 {
+		t.Errorf("sent alerts not match\nexpected: 3, actual: %d", len(sent))
+	}
+}
+
+func TestParseAlertConfig(t *testing.T) {
+	invalid := map[string]string{
+		"unknown notifier": "rules:\n  - name: a\n    notify: [missing]\n",
+		"no window":        "rules:\n  - name: a\n    threshold: 5\n",
+		"bad severity":     "rules:\n  - name: a\n    severity: urgent\n",
+		"bad pattern":      "rules:\n  - name: a\n    match:\n      path: \"(\"\n",
+		"unknown field":    "rules:\n  - name: a\n    treshold: 5\n",
+	}
+
+	for name, config := range invalid {
+		if _, err := ParseAlertConfig([]byte(config)); err == nil {
+			t.Errorf("%s config accepted", name)
+		}
+	}
+}
+
+var testAlert = Alert{
+	Rule:     "outbound",
+	Severity: SeverityHigh,
+	Count:    1,
+	Time:     time.Unix(1600000000, 0),
+	Events:   []Event{{Pot: "web", Kind: EventOutbound, SourceIP: "172.18.0.2", Message: "outbound tcp connection"}},
+	Message:  "[HIGH] outbound on web pot: outbound tcp connection",
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	received := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var alert Alert
+		_ = json.NewDecoder(r.Body).Decode(&alert)
+		received <- alert
+	}))
+	defer server.Close()
+
+	notifier, err := NewNotifier(NotifierConfig{Name: "hook", Type: "webhook", URL: server.URL, Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	if alert := <-received; alert.Rule != testAlert.Rule || alert.Message != testAlert.Message {
+		t.Errorf("webhook alert not match\nexpected: %s, actual: %s", testAlert.Message, alert.Message)
+	}
+
+	failing, _ := NewNotifier(NotifierConfig{Name: "hook", Type: "webhook", URL: server.URL})
+	if err := failing.Notify(testAlert); err == nil {
+		t.Errorf("webhook error status not reported")
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	received := make(chan map[string]string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := make(map[string]string)
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	notifier, err := NewNotifier(NotifierConfig{Name: "slack", Type: "slack", URL: server.URL, Channel: "#honeypot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := <-received
+	if !strings.HasPrefix(payload["text"], testAlert.Message+"\n") || payload["channel"] != "#honeypot" {
+		t.Errorf("slack payload not match\nexpected: %s, actual: %v", testAlert.Message, payload)
+	}
+}
+
+// serveSMTP accepts one mail on the listener and sends its data to received.
+func serveSMTP(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+
+	var data []string
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if inData {
+			if line == "." {
+				inData = false
+				received <- strings.Join(data, "\n")
+				fmt.Fprint(conn, "250 OK\r\n")
+			} else {
+				data = append(data, line)
+			}
+			continue
+		}
+
+		switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+		case "EHLO", "HELO":
+			fmt.Fprint(conn, "250 localhost\r\n")
+		case "DATA":
+			inData = true
+			fmt.Fprint(conn, "354 go ahead\r\n")
+		case "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 OK\r\n")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go serveSMTP(listener, received)
+
+	notifier, err := NewNotifier(NotifierConfig{Name: "mail", Type: "smtp", Address: listener.Addr().String(), From: "honeypot@localhost", To: []string{"soc@localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case mail := <-received:
+		if !strings.Contains(mail, "Subject: "+testAlert.Message) || !strings.Contains(mail, "from 172.18.0.2") {
+			t.Errorf("mail not match\nexpected: %s, actual: %s", testAlert.Message, mail)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("mail not received")
+	}
+}
+
+func TestSMTPNotifierTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// accepts and never greets
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	notifier, err := NewNotifier(NotifierConfig{Name: "mail", Type: "smtp", Address: listener.Addr().String(), From: "honeypot@localhost", To: []string{"soc@localhost"}, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if err := notifier.Notify(testAlert); err == nil {
+		t.Errorf("smtp timeout not reported")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("smtp timeout not match\nexpected: 200ms, actual: %s", elapsed)
+	}
+}
+
+func TestSyslogNotifier(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	notifier, err := NewNotifier(NotifierConfig{Name: "syslog", Type: "syslog", Network: "udp", Address: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 2048)
+	size, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// daemon facility with err severity
+	message := string(buffer[:size])
+	if !strings.HasPrefix(message, "<27>") || !strings.Contains(message, "honeypot") || !strings.Contains(message, testAlert.Message) {
+		t.Errorf("syslog message not match\nexpected: <27>... %s, actual: %s", testAlert.Message, message)
+	}
+}
+
+func TestNotifierTypes(t *testing.T) {
+	if _, err := NewNotifier(NotifierConfig{Name: "pager", Type: "pager"}); err == nil {
+		t.Errorf("unknown notifier type accepted")
+	}
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	CompressedExtension = ".tar.gz"
+	FlagExtension       = ".keep" // marker keeping a run forever
+)
+
+var runNamePattern = regexp.MustCompile(`^(.+)_(\d{9,})(\.tar\.gz)?$`)
+
+// ArtifactRun is one collection run under the output root, as a directory or a compressed archive.
+type ArtifactRun struct {
+	Name       string    `json:"name"`
+	Pot        string    `json:"pot"`
+	Time       time.Time `json:"time"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	Compressed bool      `json:"compressed"`
+	Flagged    bool      `json:"flagged"`
+}
+
+// RetentionPolicy decides which runs are pruned. Zero values disable a rule and flagged runs are never pruned.
+type RetentionPolicy struct {
+	MaxAge       time.Duration // prune runs older than this
+	MaxTotalSize int64         // prune oldest runs until the total size fits
+	KeepLast     int           // keep only the newest runs of each pot
+}
+
+// ReadArtifactRuns lists the runs under root, oldest first.
+func ReadArtifactRuns(root string) ([]ArtifactRun, error) {
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []ArtifactRun
+	for _, file := range files {
+		match := runNamePattern.FindStringSubmatch(file.Name())
+		if match == nil || (match[3] == "") != file.IsDir() {
+			continue
+		}
+
+		unix, err := strconv.ParseInt(match[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		name := match[1] + "_" + match[2]
+		run := ArtifactRun{
+			Name:       name,
+			Pot:        match[1],
+			Time:       time.Unix(unix, 0),
+			Path:       filepath.Join(root, file.Name()),
+			Size:       file.Size(),
+			Compressed: match[3] != "",
+		}
+
+		if file.IsDir() {
+			run.Size = directorySize(run.Path)
+		}
+		if _, err := os.Stat(filepath.Join(root, name+FlagExtension)); err == nil {
+			run.Flagged = true
+		}
+
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Time.Before(runs[j].Time)
+	})
+
+	return runs, nil
+}
+
+// ReadArtifactRun finds a run by name, with or without the archive extension.
+func ReadArtifactRun(root string, name string) (ArtifactRun, error) {
+	runs, err := ReadArtifactRuns(root)
+	if err != nil {
+		return ArtifactRun{}, err
+	}
+
+	for _, run := range runs {
+		if run.Name == name || run.Name+CompressedExtension == name {
+			return run, nil
+		}
+	}
+
+	return ArtifactRun{}, errors.New("artifact run not found")
+}
+
+func directorySize(path string) int64 {
+	var size int64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Expired returns the runs the policy prunes at now.
+func (p RetentionPolicy) Expired(runs []ArtifactRun, now time.Time) []ArtifactRun {
+	expired := make(map[string]bool)
+
+	if p.MaxAge > 0 {
+		for _, run := range runs {
+			if now.Sub(run.Time) > p.MaxAge {
+				expired[run.Name] = true
+			}
+		}
+	}
+
+	if p.KeepLast > 0 {
+		kept := make(map[string]int)
+		for index := len(runs) - 1; index >= 0; index-- {
+			kept[runs[index].Pot]++
+			if kept[runs[index].Pot] > p.KeepLast {
+				expired[runs[index].Name] = true
+			}
+		}
+	}
+
+	if p.MaxTotalSize > 0 {
+		var total int64
+		for _, run := range runs {
+			if !expired[run.Name] || run.Flagged {
+				total += run.Size
+			}
+		}
+		for _, run := range runs {
+			if total <= p.MaxTotalSize {
+				break
+			}
+			if !expired[run.Name] && !run.Flagged {
+				expired[run.Name] = true
+				total -= run.Size
+			}
+		}
+	}
+
+	var result []ArtifactRun
+	for _, run := range runs {
+		if expired[run.Name] && !run.Flagged {
+			result = append(result, run)
+		}
+	}
+	return result
+}
+
+// FlagArtifactRun keeps the run forever, or releases it to the retention policy again.
+func FlagArtifactRun(root string, name string, flagged bool) error {
+	run, err := ReadArtifactRun(root, name)
+	if err != nil {
+		return err
+	}
+
+	marker := filepath.Join(root, run.Name+FlagExtension)
+	if !flagged {
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return ioutil.WriteFile(marker, []byte(time.Now().Format(time.RFC3339)), 0644)
+}
+
+func RemoveArtifactRun(run ArtifactRun) error {
+	if run.Flagged {
+		return errors.New("flagged artifact run is kept forever")
+	}
+
+	return os.RemoveAll(run.Path)
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyExpired(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	runs := []ArtifactRun{
+		{Name: "web_1", Pot: "web", Time: now.Add(-10 * day), Size: 100, Flagged: true},
+		{Name: "web_2", Pot: "web", Time: now.Add(-9 * day), Size: 100},
+		{Name: "ssh_1", Pot: "ssh", Time: now.Add(-3 * day), Size: 100},
+		{Name: "web_3", Pot: "web", Time: now.Add(-2 * day), Size: 100},
+		{Name: "web_4", Pot: "web", Time: now.Add(-1 * day), Size: 100},
+	}
+
+	expectExpired := func(policy RetentionPolicy, expected ...string) {
+		expired := policy.Expired(runs, now)
+		if len(expired) != len(expected) {
+			t.Errorf("%+v expired %v, expected %v", policy, expired, expected)
+			return
+		}
+		for index, run := range expired {
+			if run.Name != expected[index] {
+				t.Errorf("%+v expired %s, expected %s", policy, run.Name, expected[index])
+			}
+		}
+	}
+
+	expectExpired(RetentionPolicy{MaxAge: 5 * day}, "web_2")
+	expectExpired(RetentionPolicy{KeepLast: 1}, "web_2", "web_3")
+	expectExpired(RetentionPolicy{MaxTotalSize: 300}, "web_2", "ssh_1")
+	expectExpired(RetentionPolicy{})
+}
+
+func TestReadArtifactRuns(t *testing.T) {
+	root, err := ioutil.TempDir("", "artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	_ = os.MkdirAll(filepath.Join(root, "my_pot_1600000000"), os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(root, "my_pot_1600000000", "container.log"), []byte("hello"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(root, "my_pot_1500000000.tar.gz"), []byte("archive"), 0644)
+	_ = os.MkdirAll(filepath.Join(root, "my_pot"), os.ModePerm)
+
+	if err := FlagArtifactRun(root, "my_pot_1500000000", true); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := ReadArtifactRuns(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != 2 {
+		t.Fatalf("run count not match\nexpected: 2, actual: %d", len(runs))
+	}
+
+	if runs[0].Pot != "my_pot" || !runs[0].Compressed || !runs[0].Flagged {
+		t.Errorf("compressed run not read - %+v", runs[0])
+	}
+
+	if runs[1].Compressed || runs[1].Flagged || runs[1].Size != 5 {
+		t.Errorf("run directory not read - %+v", runs[1])
+	}
+
+	if err := RemoveArtifactRun(runs[0]); err == nil {
+		t.Errorf("flagged run should not be removed")
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+const (
+	CheckpointLabel   = "pot.checkpoint" // host directory holding the clean checkpoint of pot
+	CleanCheckpointID = "clean"
+	MemoryCheckpoint  = "memory"
+)
+
+type memoryCollector struct{}
+
+func (memoryCollector) Name() string {
+	return "memory"
+}
+
+// Collect checkpoints the running processes of the container into dir/memory, keeping it running.
+func (memoryCollector) Collect(context context.Context, runtime Runtime, pot Pot, container types.Container, dir string) error {
+	docker, ok := runtime.(DockerRuntime)
+	if !ok {
+		return fmt.Errorf("checkpoint not supported by %s runtime", runtime.Name())
+	}
+	client := docker.Client
+	if !IsCheckpointSupported(context, client) {
+		return errors.New("checkpoint not supported by docker daemon")
+	}
+
+	checkpointDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	return client.CheckpointCreate(context, container.ID, types.CheckpointCreateOptions{
+		CheckpointID:  MemoryCheckpoint,
+		CheckpointDir: checkpointDir,
+		Exit:          false,
+	})
+}
+
+func init() {
+	RegisterCollector(memoryCollector{})
+}
+
+// checkpointRoot is the directory holding the clean checkpoints of pots, the only place they
+// are removed from as their path comes from a container label.
+var checkpointRoot = struct {
+	sync.RWMutex
+	path string
+}{}
+
+// SetCheckpointRoot sets the directory holding the clean checkpoints of pots.
+func SetCheckpointRoot(path string) error {
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	checkpointRoot.Lock()
+	defer checkpointRoot.Unlock()
+
+	checkpointRoot.path = root
+	return nil
+}
+
+// IsInCheckpointRoot reports whether the path lies inside the checkpoint root, symbolic links
+// resolved.
+func IsInCheckpointRoot(path string) bool {
+	checkpointRoot.RLock()
+	root := checkpointRoot.path
+	checkpointRoot.RUnlock()
+	if root == "" {
+		return false
+	}
+
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return false
+	}
+
+	relative, err := filepath.Rel(root, path)
+	return err == nil && relative != "." && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// IsCheckpointSupported reports whether the docker daemon runs with experimental features, needed for CRIU checkpoints.
+func IsCheckpointSupported(context context.Context, client *client.Client) bool {
+	ping, err := client.Ping(context)
+	if err != nil {
+		return false
+	}
+	return ping.Experimental
+}
+
+func IsCheckpointPot(labels map[string]string) bool {
+	return labels[CheckpointLabel] != ""
+}
+
+// CreateCleanCheckpoint saves the freshly deployed container state which every reset restores.
+func CreateCleanCheckpoint(context context.Context, client *client.Client, containerId string, checkpointDir string) error {
+	if !IsCheckpointSupported(context, client) {
+		return errors.New("checkpoint not supported by docker daemon")
+	}
+
+	if err := os.MkdirAll(checkpointDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	return client.CheckpointCreate(context, containerId, types.CheckpointCreateOptions{
+		CheckpointID:  CleanCheckpointID,
+		CheckpointDir: checkpointDir,
+		Exit:          false,
+	})
+}
+
+// StartPotContainer restores the clean checkpoint of the pot into the container when there is one,
+// and falls back to a plain start. It reports whether the container was restored.
+func StartPotContainer(context context.Context, client *client.Client, containerId string, labels map[string]string) (bool, error) {
+	checkpointDir := labels[CheckpointLabel]
+	if checkpointDir != "" && IsCheckpointSupported(context, client) {
+		if _, err := os.Stat(filepath.Join(checkpointDir, CleanCheckpointID)); err == nil {
+			err := client.ContainerStart(context, containerId, types.ContainerStartOptions{
+				CheckpointID:  CleanCheckpointID,
+				CheckpointDir: checkpointDir,
+			})
+			if err == nil {
+				return true, nil
+			}
+			log.Printf("error while restoring clean checkpoint, starting from image - %s", err)
+		}
+	}
+
+	return false, client.ContainerStart(context, containerId, types.ContainerStartOptions{})
+}
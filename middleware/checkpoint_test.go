@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// checkpointStandIn answers the checkpoint and start requests of the Engine API like an
+// experimental Docker daemon, recording them.
+type checkpointStandIn struct {
+	experimental bool
+	failRestore  bool
+
+	mutex       sync.Mutex
+	checkpoints []types.CheckpointCreateOptions
+	starts      []string // checkpoint of each start, empty for a plain start
+	removed     []string
+}
+
+func (d *checkpointStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	path := apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	switch {
+	case path == "/_ping":
+		w.Header().Set("API-Version", "1.40")
+		if d.experimental {
+			w.Header().Set("Docker-Experimental", "true")
+		}
+		_, _ = w.Write([]byte("OK"))
+	case strings.HasSuffix(path, "/checkpoints") && r.Method == http.MethodPost:
+		var options types.CheckpointCreateOptions
+		_ = json.NewDecoder(r.Body).Decode(&options)
+		d.checkpoints = append(d.checkpoints, options)
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasSuffix(path, "/start"):
+		checkpoint := r.URL.Query().Get("checkpoint")
+		d.starts = append(d.starts, checkpoint)
+		if checkpoint != "" && d.failRestore {
+			http.Error(w, `{"message":"criu restore failed"}`, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/containers/") && r.Method == http.MethodDelete:
+		d.removed = append(d.removed, strings.TrimPrefix(path, "/containers/"))
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/networks/") && r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newCheckpointClient(t *testing.T, standIn *checkpointStandIn) (*client.Client, func()) {
+	server := httptest.NewServer(standIn)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli, server.Close
+}
+
+func TestCreateCleanCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	standIn := &checkpointStandIn{experimental: true}
+	cli, closeServer := newCheckpointClient(t, standIn)
+	defer closeServer()
+
+	checkpointDir := filepath.Join(dir, "ssh")
+	if err := CreateCleanCheckpoint(context.Background(), cli, "a1", checkpointDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(checkpointDir); err != nil {
+		t.Errorf("checkpoint directory not created - %s", err)
+	}
+	if len(standIn.checkpoints) != 1 || standIn.checkpoints[0].CheckpointID != CleanCheckpointID || standIn.checkpoints[0].CheckpointDir != checkpointDir || standIn.checkpoints[0].Exit {
+		t.Errorf("clean checkpoint not match\nexpected: clean in %s, actual: %+v", checkpointDir, standIn.checkpoints)
+	}
+
+	// daemons without experimental features cannot checkpoint
+	plain := &checkpointStandIn{}
+	plainClient, closePlain := newCheckpointClient(t, plain)
+	defer closePlain()
+	if err := CreateCleanCheckpoint(context.Background(), plainClient, "a1", checkpointDir); err == nil || len(plain.checkpoints) != 0 {
+		t.Errorf("checkpoint without experimental daemon not match\nexpected: error, actual: %v", err)
+	}
+}
+
+func TestStartPotContainer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_ = os.MkdirAll(filepath.Join(dir, CleanCheckpointID), os.ModePerm)
+	labels := map[string]string{"pot.name": "ssh", CheckpointLabel: dir}
+
+	cases := []struct {
+		name     string
+		standIn  *checkpointStandIn
+		labels   map[string]string
+		restored bool
+		starts   string
+	}{
+		{"restore", &checkpointStandIn{experimental: true}, labels, true, "clean"},
+		{"restore failing", &checkpointStandIn{experimental: true, failRestore: true}, labels, false, "clean,"},
+		{"not experimental", &checkpointStandIn{}, labels, false, ""},
+		{"no checkpoint", &checkpointStandIn{experimental: true}, map[string]string{"pot.name": "ssh"}, false, ""},
+	}
+	for _, c := range cases {
+		cli, closeServer := newCheckpointClient(t, c.standIn)
+		restored, err := StartPotContainer(context.Background(), cli, "a1", c.labels)
+		closeServer()
+
+		if err != nil {
+			t.Errorf("%s start error - %s", c.name, err)
+		}
+		if restored != c.restored || strings.Join(c.standIn.starts, ",") != c.starts {
+			t.Errorf("%s start not match\nexpected: %t %q, actual: %t %q", c.name, c.restored, c.starts, restored, strings.Join(c.standIn.starts, ","))
+		}
+	}
+}
+
+func TestMemoryCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	standIn := &checkpointStandIn{experimental: true}
+	cli, closeServer := newCheckpointClient(t, standIn)
+	defer closeServer()
+
+	container := potContainer("a1", "ssh")
+	pot := Pot{Name: "ssh", Containers: []types.Container{container}}
+	results := RunCollectors(context.Background(), DockerRuntime{Client: cli}, pot, container, dir, []string{"memory"})
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("memory collector not match\nexpected: success, actual: %+v", results)
+	}
+	if len(standIn.checkpoints) != 1 || standIn.checkpoints[0].CheckpointID != MemoryCheckpoint || standIn.checkpoints[0].CheckpointDir != dir || standIn.checkpoints[0].Exit {
+		t.Errorf("memory checkpoint not match\nexpected: memory in %s keeping container, actual: %+v", dir, standIn.checkpoints)
+	}
+
+	results = RunCollectors(context.Background(), &ContainerdRuntime{}, pot, container, dir, []string{"memory"})
+	if len(results) != 1 || results[0].Success || !strings.Contains(results[0].Error, "not supported by containerd") {
+		t.Errorf("memory collector of containerd not match\nexpected: not supported, actual: %+v", results)
+	}
+}
+
+func TestRemovePotCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "checkpoints")
+	inside := filepath.Join(root, "ssh")
+	outside := filepath.Join(dir, "etc")
+	for _, path := range []string{inside, outside} {
+		_ = os.MkdirAll(filepath.Join(path, CleanCheckpointID), os.ModePerm)
+	}
+	_ = os.Symlink(outside, filepath.Join(root, "link"))
+	if err := SetCheckpointRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCheckpointRoot("")
+
+	standIn := &checkpointStandIn{experimental: true}
+	cli, closeServer := newCheckpointClient(t, standIn)
+	defer closeServer()
+
+	var containers []types.Container
+	for index, path := range []string{inside, outside, filepath.Join(root, ".."), filepath.Join(root, "link")} {
+		container := potContainer(string(rune('a'+index))+"1", "ssh")
+		container.Labels[CheckpointLabel] = path
+		containers = append(containers, container)
+	}
+	if err := (DockerRuntime{Client: cli}).RemovePot(context.Background(), Pot{Name: "ssh", Containers: containers}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(inside); !os.IsNotExist(err) {
+		t.Errorf("checkpoint inside root not removed")
+	}
+	if _, err := os.Stat(filepath.Join(outside, CleanCheckpointID)); err != nil {
+		t.Errorf("directory outside checkpoint root removed - %s", err)
+	}
+	if len(standIn.removed) != 4 {
+		t.Errorf("removed containers not match\nexpected: 4, actual: %v", standIn.removed)
+	}
+}
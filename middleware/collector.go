@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	ProfileLabel    = "pot.profile"    // name of collection profile
+	CollectorsLabel = "pot.collectors" // comma separated collector names, overrides profile
+
+	DefaultProfile = "full"
+)
+
+// Collector gathers one kind of artifact from a pot container into the run directory.
+type Collector interface {
+	Name() string
+	Collect(context context.Context, runtime Runtime, pot Pot, container types.Container, dir string) error
+}
+
+type fileCollector struct {
+	name     string
+	fileName string
+	collect  func(context.Context, Runtime, string, string) error
+}
+
+func (c fileCollector) Name() string {
+	return c.name
+}
+
+func (c fileCollector) Collect(context context.Context, runtime Runtime, pot Pot, container types.Container, dir string) error {
+	return c.collect(context, runtime, container.ID, filepath.Join(dir, c.fileName))
+}
+
+type pcapCollector struct{}
+
+func (pcapCollector) Name() string {
+	return "pcap"
+}
+
+// Collect moves the capture so far into the run and keeps capturing the pot into a new file.
+func (pcapCollector) Collect(context context.Context, runtime Runtime, pot Pot, container types.Container, dir string) error {
+	if runtime.Name() == RuntimeKubernetes {
+		return errors.New("packet capture not supported by kubernetes runtime")
+	}
+	if err := RotateCapture(pot.Name, filepath.Join(dir, "network.pcap")); err != nil {
+		return fmt.Errorf("network capture not found - %s", err)
+	}
+	return nil
+}
+
+var registry = struct {
+	sync.RWMutex
+	collectors map[string]Collector
+	profiles   map[string][]string
+}{
+	collectors: make(map[string]Collector),
+	profiles: map[string][]string{
+		"light":    {"log", "diff", "top"},
+		"standard": {"log", "diff", "top", "pcap"},
+		"full":     {"log", "diff", "top", "dump", "pcap"},
+	},
+}
+
+func init() {
+	RegisterCollector(fileCollector{"log", "container.log", CollectContainerLog})
+	RegisterCollector(fileCollector{"diff", "container.diff", CollectContainerDiff})
+	RegisterCollector(fileCollector{"top", "container.top", CollectContainerTop})
+	RegisterCollector(fileCollector{"dump", "dump.tar", CollectContainerDump})
+	RegisterCollector(pcapCollector{})
+}
+
+// RegisterCollector makes the collector available to profiles under its name.
+func RegisterCollector(collector Collector) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.collectors[collector.Name()] = collector
+}
+
+// RegisterProfile defines or replaces a profile as an ordered list of collector names.
+func RegisterProfile(name string, collectorNames []string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.profiles[name] = collectorNames
+}
+
+func IsExistProfile(name string) bool {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	_, found := registry.profiles[name]
+	return found
+}
+
+func ListProfiles() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var names []string
+	for name := range registry.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ReadPotProfile returns the profile name and collector names selected by the container labels.
+func ReadPotProfile(labels map[string]string) (string, []string) {
+	if value := strings.TrimSpace(labels[CollectorsLabel]); value != "" {
+		var names []string
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		return "custom", names
+	}
+
+	profile := labels[ProfileLabel]
+	if profile == "" {
+		profile = DefaultProfile
+	}
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	names, found := registry.profiles[profile]
+	if !found {
+		return DefaultProfile, registry.profiles[DefaultProfile]
+	}
+
+	return profile, names
+}
+
+// HasCollector reports whether the container labels select the named collector.
+func HasCollector(labels map[string]string, name string) bool {
+	_, names := ReadPotProfile(labels)
+	for _, value := range names {
+		if value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RunCollectors runs every named collector in order and records each outcome.
+// A failing or panicking collector never stops the following ones.
+func RunCollectors(context context.Context, runtime Runtime, pot Pot, container types.Container, dir string, names []string) []CollectorResult {
+	var results []CollectorResult
+
+	for _, name := range names {
+		registry.RLock()
+		collector, found := registry.collectors[name]
+		registry.RUnlock()
+
+		result := CollectorResult{Name: name, StartedAt: time.Now()}
+		if !found {
+			result.Error = "collector not registered"
+		} else if err := runCollector(context, runtime, collector, pot, container, dir); err != nil {
+			result.Error = err.Error()
+		}
+		result.FinishedAt = time.Now()
+		result.Success = result.Error == ""
+		if untrusted, ok := runtime.(untrustedRuntime); ok {
+			result.Untrusted = containsName(untrusted.UntrustedCollectors(), name)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// untrustedRuntime is a runtime taking the artifacts of some collectors with commands run in the
+// pot container, so with binaries of the container an attacker may have replaced.
+type untrustedRuntime interface {
+	UntrustedCollectors() []string
+}
+
+func runCollector(context context.Context, runtime Runtime, collector Collector, pot Pot, container types.Container, dir string) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.New(fmt.Sprint("collector panic: ", recovered))
+		}
+	}()
+
+	return collector.Collect(context, runtime, pot, container, dir)
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+type testCollector struct {
+	name string
+	err  error
+	boom bool
+}
+
+func (c testCollector) Name() string {
+	return c.name
+}
+
+func (c testCollector) Collect(context context.Context, runtime Runtime, pot Pot, container types.Container, dir string) error {
+	if c.boom {
+		panic("collector exploded")
+	}
+	if c.err != nil {
+		return c.err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, c.name), []byte(pot.Name), 0644)
+}
+
+func TestRunCollectors(t *testing.T) {
+	RegisterCollector(testCollector{name: "test.ok"})
+	RegisterCollector(testCollector{name: "test.fail", err: errors.New("failed")})
+	RegisterCollector(testCollector{name: "test.panic", boom: true})
+
+	dir, err := ioutil.TempDir("", "collector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{"test.fail", "test.panic", "test.missing", "test.ok"}
+	results := RunCollectors(context.Background(), nil, Pot{Name: "test"}, types.Container{}, dir, names)
+	if len(results) != len(names) {
+		t.Fatalf("result count not match\nexpected: %d, actual: %d", len(names), len(results))
+	}
+
+	for index, success := range []bool{false, false, false, true} {
+		if results[index].Success != success {
+			t.Errorf("%s collector success not match\nexpected: %t, actual: %t", names[index], success, results[index].Success)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "test.ok")); err != nil {
+		t.Errorf("collector after failures did not run - %s", err)
+	}
+
+	if !(Manifest{Collectors: results}).Failed() {
+		t.Errorf("manifest should be marked as failed")
+	}
+}
+
+func TestReadPotProfile(t *testing.T) {
+	profile, names := ReadPotProfile(map[string]string{ProfileLabel: "light"})
+	if profile != "light" || len(names) != 3 {
+		t.Errorf("light profile not match - %s %v", profile, names)
+	}
+
+	profile, _ = ReadPotProfile(map[string]string{})
+	if profile != DefaultProfile {
+		t.Errorf("default profile not match\nexpected: %s, actual: %s", DefaultProfile, profile)
+	}
+
+	profile, names = ReadPotProfile(map[string]string{ProfileLabel: "light", CollectorsLabel: "log, pcap"})
+	if profile != "custom" || len(names) != 2 || names[1] != "pcap" {
+		t.Errorf("custom collectors not match - %s %v", profile, names)
+	}
+
+	if !HasCollector(map[string]string{ProfileLabel: "standard"}, "pcap") {
+		t.Errorf("standard profile should capture network")
+	}
+}
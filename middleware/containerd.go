@@ -0,0 +1,696 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/archive"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/identifiers"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/continuity/fs"
+	cni "github.com/containerd/go-cni"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	DefaultContainerdAddress = "/run/containerd/containerd.sock"
+	DefaultCNIPath           = "/opt/cni/bin"
+	DefaultContainerdLogDir  = "/var/log/honeypot"
+
+	// ContainerdLoggerCommand is the command of the honeypot binary containerd runs to log pot output.
+	ContainerdLoggerCommand = "containerd-logger"
+
+	containerdNetworkLabel = "pot.network" // index of pot network, naming its bridge and subnet
+	containerdPortsLabel   = "pot.ports"   // comma separated host:container port specs
+)
+
+// ContainerdRuntime runs pots as containers labelled by pot.name in a namespace of containerd.
+// Every pot has a CNI bridge network of its own, ports published by the portmap plugin, and the
+// output of containers is written to the log directory by the honeypot binary.
+type ContainerdRuntime struct {
+	Client    *containerd.Client
+	Namespace string
+	// CNIPath is the directories of the bridge, host-local and portmap plugins, like CNI_PATH.
+	CNIPath string
+	LogDir  string
+	// Logger is the path of the honeypot binary logging the output of containers.
+	Logger string
+}
+
+// NewContainerdRuntime connects to containerd at address, running pots in the namespace or
+// DefaultNamespace.
+func NewContainerdRuntime(address string, namespace string, cniPath string) (*ContainerdRuntime, error) {
+	if address == "" {
+		address = DefaultContainerdAddress
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	if cniPath == "" {
+		cniPath = DefaultCNIPath
+	}
+
+	logger, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := containerd.New(strings.TrimPrefix(address, "unix://"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ContainerdRuntime{
+		Client:    client,
+		Namespace: namespace,
+		CNIPath:   cniPath,
+		LogDir:    DefaultContainerdLogDir,
+		Logger:    logger,
+	}, nil
+}
+
+func (*ContainerdRuntime) Name() string {
+	return RuntimeContainerd
+}
+
+func (c *ContainerdRuntime) ListContainers(context context.Context) ([]types.Container, error) {
+	ctx := namespaces.WithNamespace(context, c.Namespace)
+	items, err := c.Client.Containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []types.Container
+	for _, item := range items {
+		info, err := item.Info(ctx)
+		if errdefs.IsNotFound(err) {
+			// removed while listing
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		state := "created"
+		if task, err := item.Task(ctx, nil); err == nil {
+			if status, err := task.Status(ctx); err == nil {
+				state = containerdState(status.Status)
+			}
+		}
+		_, ports, _ := containerdPorts(info.Labels[containerdPortsLabel])
+
+		containers = append(containers, types.Container{
+			ID:      info.ID,
+			Names:   []string{"/" + info.ID},
+			Image:   info.Image,
+			Labels:  info.Labels,
+			State:   state,
+			Status:  state,
+			Created: info.CreatedAt.Unix(),
+			Ports:   ports,
+		})
+	}
+
+	return containers, nil
+}
+
+// containerdState names the status of the task like the state of a docker container.
+func containerdState(status containerd.ProcessStatus) string {
+	if status == containerd.Stopped {
+		return "exited"
+	}
+	return string(status)
+}
+
+// containerdPorts reads host:container port specs into the mappings of the portmap plugin and the
+// ports of the container, leaving out ports without a host port.
+func containerdPorts(value string) ([]cni.PortMapping, []types.Port, error) {
+	if value == "" {
+		return nil, nil, nil
+	}
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(strings.Split(value, ","))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mappings []cni.PortMapping
+	var ports []types.Port
+	for port := range exposedPorts {
+		for _, binding := range portBindings[port] {
+			if binding.HostPort == "" {
+				continue
+			}
+			hostPort, err := nat.ParsePort(binding.HostPort)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			mappings = append(mappings, cni.PortMapping{
+				HostPort:      int32(hostPort),
+				ContainerPort: int32(port.Int()),
+				Protocol:      port.Proto(),
+				HostIP:        binding.HostIP,
+			})
+			ports = append(ports, types.Port{
+				IP:          binding.HostIP,
+				PrivatePort: uint16(port.Int()),
+				PublicPort:  uint16(hostPort),
+				Type:        port.Proto(),
+			})
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].HostPort < mappings[j].HostPort })
+	sort.Slice(ports, func(i, j int) bool { return ports[i].PublicPort < ports[j].PublicPort })
+
+	return mappings, ports, nil
+}
+
+func (c *ContainerdRuntime) CreatePot(context context.Context, spec PotSpec) error {
+	if err := identifiers.Validate(spec.Name); err != nil || len(spec.Name) > 63 {
+		return fmt.Errorf("pot name %s is not a valid containerd name", spec.Name)
+	}
+	if spec.Image == "" {
+		return errors.New("image name required")
+	}
+	if _, _, err := containerdPorts(strings.Join(spec.Ports, ",")); err != nil {
+		return err
+	}
+
+	containers, err := c.ListContainers(context)
+	if err != nil {
+		return err
+	}
+	for _, item := range containers {
+		if item.Labels["pot.name"] == spec.Name {
+			return errors.New("pot name already exist")
+		}
+	}
+	index, err := allocatePotNetwork(containers)
+	if err != nil {
+		return err
+	}
+
+	reference, err := docker.ParseDockerRef(spec.Image)
+	if err != nil {
+		return err
+	}
+	ctx := namespaces.WithNamespace(context, c.Namespace)
+	image, err := c.Client.Pull(ctx, reference.String(), containerd.WithPullUnpack)
+	if err != nil {
+		return err
+	}
+
+	var labels = make(map[string]string)
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+	labels["pot.name"] = spec.Name
+	labels[containerdNetworkLabel] = strconv.Itoa(index)
+	if len(spec.Ports) > 0 {
+		labels[containerdPortsLabel] = strings.Join(spec.Ports, ",")
+	}
+
+	return c.startContainer(ctx, spec.Name, image, spec.Environments, labels)
+}
+
+// allocatePotNetwork returns the lowest index of pot network none of the containers are on.
+func allocatePotNetwork(containers []types.Container) (int, error) {
+	used := make(map[string]bool)
+	for _, item := range containers {
+		used[item.Labels[containerdNetworkLabel]] = true
+	}
+
+	for index := 1; index < 255; index++ {
+		if !used[strconv.Itoa(index)] {
+			return index, nil
+		}
+	}
+	return 0, errors.New("no pot network left")
+}
+
+func containerdBridge(index int) string {
+	return fmt.Sprintf("honeypot%d", index)
+}
+
+func containerdSubnet(index int) string {
+	return fmt.Sprintf("10.77.%d.0/24", index)
+}
+
+// containerdNetworkConfig is the CNI network of the pot network, a bridge of its own holding the
+// gateway of the subnet with the published ports mapped by portmap.
+func containerdNetworkConfig(index int) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"cniVersion": "0.4.0",
+		"name":       containerdBridge(index),
+		"plugins": []map[string]interface{}{
+			{
+				"type":        "bridge",
+				"bridge":      containerdBridge(index),
+				"isGateway":   true,
+				"ipMasq":      true,
+				"hairpinMode": true,
+				"ipam": map[string]interface{}{
+					"type":   "host-local",
+					"ranges": [][]map[string]string{{{"subnet": containerdSubnet(index)}}},
+					"routes": []map[string]string{{"dst": "0.0.0.0/0"}},
+				},
+			},
+			{
+				"type":         "portmap",
+				"capabilities": map[string]bool{"portMappings": true},
+			},
+		},
+	})
+}
+
+// potNetwork loads the CNI network of the pot network the labels put the container on.
+func (c *ContainerdRuntime) potNetwork(labels map[string]string) (cni.CNI, error) {
+	index, err := strconv.Atoi(labels[containerdNetworkLabel])
+	if err != nil {
+		return nil, fmt.Errorf("network of %s pot not found", labels["pot.name"])
+	}
+
+	config, err := containerdNetworkConfig(index)
+	if err != nil {
+		return nil, err
+	}
+	return cni.New(cni.WithPluginDir(filepath.SplitList(c.CNIPath)), cni.WithConfListBytes(config))
+}
+
+// containerdNetns is the network namespace of the task, living as long as its process.
+func containerdNetns(pid uint32) string {
+	return fmt.Sprintf("/proc/%d/ns/net", pid)
+}
+
+// startContainer creates and starts a container of the pot from the image on the pot network.
+func (c *ContainerdRuntime) startContainer(ctx context.Context, potName string, image containerd.Image, environments []string, labels map[string]string) error {
+	network, err := c.potNetwork(labels)
+	if err != nil {
+		return err
+	}
+	mappings, _, err := containerdPorts(labels[containerdPortsLabel])
+	if err != nil {
+		return err
+	}
+
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	id := potName + "-" + hex.EncodeToString(random)
+
+	potContainer, err := c.Client.NewContainer(ctx, id,
+		containerd.WithImage(image),
+		containerd.WithNewSnapshot(id, image),
+		containerd.WithNewSpec(
+			oci.WithImageConfig(image),
+			oci.WithHostname(potName),
+			oci.WithEnv(environments),
+			oci.WithMounts([]specs.Mount{{
+				Destination: "/etc/resolv.conf",
+				Type:        "bind",
+				Source:      "/etc/resolv.conf",
+				Options:     []string{"rbind", "ro"},
+			}}),
+		),
+		containerd.WithContainerLabels(labels),
+	)
+	if err != nil {
+		return err
+	}
+
+	task, err := potContainer.NewTask(ctx, cio.BinaryIO(c.Logger, map[string]string{ContainerdLoggerCommand: c.LogDir}))
+	if err != nil {
+		_ = potContainer.Delete(ctx, containerd.WithSnapshotCleanup)
+		return err
+	}
+
+	if _, err := network.Setup(ctx, id, containerdNetns(task.Pid()), cni.WithCapabilityPortMap(mappings)); err != nil {
+		_ = c.removeContainer(ctx, potContainer)
+		return err
+	}
+
+	if err := task.Start(ctx); err != nil {
+		_ = c.removeContainer(ctx, potContainer)
+		return err
+	}
+	return nil
+}
+
+// removeContainer tears down the network of the container, then kills and removes it with its
+// snapshot and log.
+func (c *ContainerdRuntime) removeContainer(ctx context.Context, potContainer containerd.Container) error {
+	labels, err := potContainer.Labels(ctx)
+	if err != nil {
+		return err
+	}
+
+	task, err := potContainer.Task(ctx, nil)
+	if err == nil {
+		// the network namespace goes with the process, so the network is removed first
+		if network, err := c.potNetwork(labels); err == nil {
+			mappings, _, _ := containerdPorts(labels[containerdPortsLabel])
+			_ = network.Remove(ctx, potContainer.ID(), containerdNetns(task.Pid()), cni.WithCapabilityPortMap(mappings))
+		}
+
+		if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	} else if !errdefs.IsNotFound(err) {
+		return err
+	}
+
+	if err := potContainer.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+		return err
+	}
+
+	_ = os.Remove(c.logFile(potContainer.ID()))
+	return nil
+}
+
+func (c *ContainerdRuntime) RemovePot(context context.Context, pot Pot) error {
+	ctx := namespaces.WithNamespace(context, c.Namespace)
+	for _, item := range pot.Containers {
+		potContainer, err := c.Client.LoadContainer(ctx, item.ID)
+		if errdefs.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := c.removeContainer(ctx, potContainer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetContainer replaces the container with a new one from its image, keeping its environment,
+// labels and pot network.
+func (c *ContainerdRuntime) ResetContainer(context context.Context, pot Pot, container types.Container) error {
+	ctx := namespaces.WithNamespace(context, c.Namespace)
+	previous, err := c.Client.LoadContainer(ctx, container.ID)
+	if err != nil {
+		return err
+	}
+
+	image, err := previous.Image(ctx)
+	if err != nil {
+		return err
+	}
+	spec, err := previous.Spec(ctx)
+	if err != nil {
+		return err
+	}
+	labels, err := previous.Labels(ctx)
+	if err != nil {
+		return err
+	}
+
+	var environments []string
+	if spec.Process != nil {
+		environments = spec.Process.Env
+	}
+
+	if err := c.removeContainer(ctx, previous); err != nil {
+		return err
+	}
+	return c.startContainer(ctx, pot.Name, image, environments, labels)
+}
+
+func (c *ContainerdRuntime) logFile(containerId string) string {
+	return filepath.Join(c.LogDir, containerId+".log")
+}
+
+func (c *ContainerdRuntime) ContainerLogs(context context.Context, containerId string, since time.Time) (io.ReadCloser, error) {
+	logFile, err := os.Open(c.logFile(containerId))
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	var output bytes.Buffer
+	if err := readContainerdLog(logFile, since, &output); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(&output), nil
+}
+
+// WriteContainerdLog logs the output of a container as the logging binary of containerd, which
+// names the container by CONTAINER_ID, passes its stdout and stderr as fd 3 and 4, and starts the
+// container once fd 5 is closed.
+func WriteContainerdLog(dir string) error {
+	containerId := os.Getenv("CONTAINER_ID")
+	if containerId == "" {
+		return errors.New("container id not found")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, containerId+".log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	_ = os.NewFile(5, "ready").Close()
+
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	for _, fd := range []uintptr{3, 4} {
+		wait.Add(1)
+		go func(output *os.File) {
+			defer wait.Done()
+			if err := writeContainerdLog(output, logFile, &lock); err != nil {
+				log.Printf("error while logging %s container - %s", containerId, err)
+			}
+		}(os.NewFile(fd, "output"))
+	}
+	wait.Wait()
+
+	return nil
+}
+
+// writeContainerdLog copies the lines of the output to the log file, each after the time it was read.
+func writeContainerdLog(output io.Reader, logFile io.Writer, lock *sync.Mutex) error {
+	reader := bufio.NewReader(output)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			lock.Lock()
+			_, writeErr := fmt.Fprintf(logFile, "%s %s", time.Now().UTC().Format(time.RFC3339Nano), line)
+			lock.Unlock()
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// readContainerdLog writes the lines of the log file written since the time, without their times.
+func readContainerdLog(logFile io.Reader, since time.Time, output io.Writer) error {
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if !since.IsZero() {
+			written, err := time.Parse(time.RFC3339Nano, parts[0])
+			if err != nil || written.Before(since) {
+				continue
+			}
+		}
+		if _, err := fmt.Fprintln(output, parts[1]); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// containerSnapshot returns the snapshotter and key of the snapshot of the container.
+func (c *ContainerdRuntime) containerSnapshot(ctx context.Context, containerId string) (snapshots.Snapshotter, string, error) {
+	potContainer, err := c.Client.LoadContainer(ctx, containerId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	info, err := potContainer.Info(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return c.Client.SnapshotService(info.Snapshotter), info.SnapshotKey, nil
+}
+
+// ContainerDiff compares the snapshot of the container with its parent, the image it started from.
+func (c *ContainerdRuntime) ContainerDiff(context context.Context, containerId string) ([]container.ContainerChangeResponseItem, error) {
+	ctx := namespaces.WithNamespace(context, c.Namespace)
+	snapshotter, key, err := c.containerSnapshot(ctx, containerId)
+	if err != nil {
+		return nil, err
+	}
+
+	upper, err := snapshotter.Mounts(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := snapshotter.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	viewKey := fmt.Sprintf("%s-diff-%d", key, time.Now().UnixNano())
+	lower, err := snapshotter.View(ctx, viewKey, snapshot.Parent)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshotter.Remove(ctx, viewKey)
+
+	var changes []container.ContainerChangeResponseItem
+	err = mount.WithTempMount(ctx, lower, func(lowerRoot string) error {
+		return mount.WithTempMount(ctx, upper, func(upperRoot string) error {
+			return fs.Changes(ctx, lowerRoot, upperRoot, func(kind fs.ChangeKind, path string, _ os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if change, changed := containerdChange(kind, path); changed {
+					changes = append(changes, change)
+				}
+				return nil
+			})
+		})
+	})
+	return changes, err
+}
+
+// containerdChange describes the change of a snapshot like a change of docker diff.
+func containerdChange(kind fs.ChangeKind, path string) (container.ContainerChangeResponseItem, bool) {
+	switch kind {
+	case fs.ChangeKindModify:
+		return container.ContainerChangeResponseItem{Kind: 0, Path: path}, true
+	case fs.ChangeKindAdd:
+		return container.ContainerChangeResponseItem{Kind: 1, Path: path}, true
+	case fs.ChangeKindDelete:
+		return container.ContainerChangeResponseItem{Kind: 2, Path: path}, true
+	}
+	return container.ContainerChangeResponseItem{}, false
+}
+
+// ContainerTop lists the processes of the task with their command lines, read from /proc as
+// containerd is reached on its own host.
+func (c *ContainerdRuntime) ContainerTop(context context.Context, containerId string) (container.ContainerTopOKBody, error) {
+	ctx := namespaces.WithNamespace(context, c.Namespace)
+	potContainer, err := c.Client.LoadContainer(ctx, containerId)
+	if err != nil {
+		return container.ContainerTopOKBody{}, err
+	}
+	task, err := potContainer.Task(ctx, nil)
+	if err != nil {
+		return container.ContainerTopOKBody{}, err
+	}
+	processes, err := task.Pids(ctx)
+	if err != nil {
+		return container.ContainerTopOKBody{}, err
+	}
+
+	top := container.ContainerTopOKBody{Titles: []string{"PID", "CMD"}}
+	for _, process := range processes {
+		command, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", process.Pid))
+		top.Processes = append(top.Processes, []string{
+			strconv.Itoa(int(process.Pid)),
+			strings.TrimSpace(strings.ReplaceAll(string(command), "\x00", " ")),
+		})
+	}
+	return top, nil
+}
+
+// ContainerExport writes the filesystem of the container as tar from a mount of its snapshot.
+func (c *ContainerdRuntime) ContainerExport(context context.Context, containerId string) (io.ReadCloser, error) {
+	ctx := namespaces.WithNamespace(context, c.Namespace)
+	snapshotter, key, err := c.containerSnapshot(ctx, containerId)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := snapshotter.Mounts(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(mount.WithTempMount(ctx, mounts, func(root string) error {
+			return archive.WriteDiff(ctx, writer, "", root)
+		}))
+	}()
+	return reader, nil
+}
+
+func (*ContainerdRuntime) ContainerStats(context context.Context, containerId string) (types.ContainerStats, error) {
+	return types.ContainerStats{}, errors.New("stats not supported by containerd runtime")
+}
+
+// ReadPotNetworks returns the pot networks by bridge, one per pot as the pot network is kept
+// across resets.
+func (c *ContainerdRuntime) ReadPotNetworks(context context.Context) (map[string]PotNetwork, error) {
+	containers, err := c.ListContainers(context)
+	if err != nil {
+		return nil, err
+	}
+
+	potNetworks := make(map[string]PotNetwork)
+	for _, item := range containers {
+		index, err := strconv.Atoi(item.Labels[containerdNetworkLabel])
+		if err != nil {
+			continue
+		}
+
+		bridge := containerdBridge(index)
+		potNetworks[bridge] = PotNetwork{
+			ID:        bridge,
+			Name:      item.Labels["pot.name"],
+			Labels:    item.Labels,
+			Subnets:   []string{containerdSubnet(index)},
+			Interface: bridge,
+		}
+	}
+	return potNetworks, nil
+}
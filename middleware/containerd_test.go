@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/docker/docker/api/types"
+)
+
+func TestContainerdPorts(t *testing.T) {
+	mappings, ports, err := containerdPorts("8080:80,2222:22/tcp,53/udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 2 || mappings[0].HostPort != 2222 || mappings[0].ContainerPort != 22 || mappings[1].Protocol != "tcp" {
+		t.Errorf("port mappings not match\nexpected: 2222 to 22 and 8080 to 80, actual: %+v", mappings)
+	}
+	if len(ports) != 2 || ports[1].PublicPort != 8080 || ports[1].PrivatePort != 80 {
+		t.Errorf("container ports not match\nexpected: 2222 to 22 and 8080 to 80, actual: %+v", ports)
+	}
+
+	if _, _, err := containerdPorts("http:80"); err == nil {
+		t.Errorf("invalid port not match\nexpected: error, actual: nil")
+	}
+}
+
+func TestContainerdNetwork(t *testing.T) {
+	containers := []types.Container{
+		{Labels: map[string]string{"pot.name": "ssh", containerdNetworkLabel: "1"}},
+		{Labels: map[string]string{"pot.name": "web", containerdNetworkLabel: "3"}},
+		{Labels: map[string]string{}},
+	}
+	index, err := allocatePotNetwork(containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 2 {
+		t.Errorf("pot network not match\nexpected: 2, actual: %d", index)
+	}
+
+	data, err := containerdNetworkConfig(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Name    string `json:"name"`
+		Plugins []struct {
+			Type   string `json:"type"`
+			Bridge string `json:"bridge"`
+			IPAM   struct {
+				Ranges [][]struct {
+					Subnet string `json:"subnet"`
+				} `json:"ranges"`
+			} `json:"ipam"`
+		} `json:"plugins"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	bridge := config.Plugins[0]
+	if bridge.Type != "bridge" || bridge.Bridge != "honeypot2" || bridge.IPAM.Ranges[0][0].Subnet != "10.77.2.0/24" {
+		t.Errorf("pot network config not match\nexpected: honeypot2 bridge on 10.77.2.0/24, actual: %s", data)
+	}
+	if config.Plugins[1].Type != "portmap" {
+		t.Errorf("port mapping plugin not match\nexpected: portmap, actual: %s", config.Plugins[1].Type)
+	}
+
+	if change, changed := containerdChange(fs.ChangeKindAdd, "/tmp/miner"); !changed || change.Kind != 1 {
+		t.Errorf("added file not match\nexpected: kind 1, actual: %+v", change)
+	}
+	if _, changed := containerdChange(fs.ChangeKindUnmodified, "/etc"); changed {
+		t.Errorf("unmodified directory not match\nexpected: no change, actual: changed")
+	}
+}
+
+func TestContainerdLog(t *testing.T) {
+	var logFile bytes.Buffer
+	var lock sync.Mutex
+	if err := writeContainerdLog(strings.NewReader("sshd started\nAccepted password for root\nno newline"), &logFile, &lock); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	logFile.WriteString(before + " Failed password for admin\n")
+
+	var output bytes.Buffer
+	if err := readContainerdLog(bytes.NewReader(logFile.Bytes()), time.Time{}, &output); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sshd started\nAccepted password for root\nno newline\nFailed password for admin\n"; output.String() != expected {
+		t.Errorf("container log not match\nexpected: %q, actual: %q", expected, output.String())
+	}
+
+	output.Reset()
+	if err := readContainerdLog(bytes.NewReader(logFile.Bytes()), time.Now().Add(-time.Second), &output); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "Failed password") || !strings.Contains(output.String(), "Accepted password") {
+		t.Errorf("container log since not match\nexpected: lines of last second, actual: %q", output.String())
+	}
+}
+
+func TestParseContainerdFleet(t *testing.T) {
+	fleet, err := ParseFleet([]byte("hosts:\n- name: busan\n  runtime: containerd\n  url: unix:///run/containerd/containerd.sock\n  namespace: pots\n  cni_path: /usr/libexec/cni\n- name: daegu\n  runtime: podman\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := fleet.Hosts[0]; host.Runtime != RuntimeContainerd || host.Namespace != "pots" || host.CNIPath != "/usr/libexec/cni" {
+		t.Errorf("containerd host not match\nexpected: pots namespace with cni path, actual: %+v", host)
+	}
+	if host := fleet.Hosts[1]; host.Runtime != RuntimePodman || host.URL != "" {
+		t.Errorf("podman host not match\nexpected: podman on its socket, actual: %+v", host)
+	}
+}
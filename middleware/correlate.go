@@ -0,0 +1,453 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// credential lists shorter than this are common defaults and do not identify an actor
+	minCredentialList = 3
+	// dropped files are attributed to the addresses logged in or typing on the pot this long before
+	dropAttribution = time.Hour
+	// client payload buffered per flow to read the ssh banner and key exchange
+	sshFingerprintBuffer = 16 * 1024
+)
+
+// sshFingerprints reads the client banner and HASSH of ssh connections to the pot network. HASSH is
+// the md5 of the key exchange, encryption, mac and compression algorithms the client offers.
+type sshFingerprints struct {
+	buffers map[string][]byte
+	banners map[string]string
+	hassh   map[string]string
+}
+
+func newSSHFingerprints() *sshFingerprints {
+	return &sshFingerprints{buffers: make(map[string][]byte), banners: make(map[string]string), hassh: make(map[string]string)}
+}
+
+func flowKey(protocol string, srcIP string, srcPort int, dstIP string, dstPort int) string {
+	return fmt.Sprintf("%s|%s|%d|%s|%d", protocol, srcIP, srcPort, dstIP, dstPort)
+}
+
+// observe buffers the payload of a client packet of a connection event seen before.
+func (s *sshFingerprints) observe(events map[string]int, packet gopacket.Packet) {
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok || len(tcp.Payload) == 0 {
+		return
+	}
+	var srcIP, dstIP string
+	if ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		srcIP, dstIP = ipLayer.SrcIP.String(), ipLayer.DstIP.String()
+	} else if ipLayer, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		srcIP, dstIP = ipLayer.SrcIP.String(), ipLayer.DstIP.String()
+	} else {
+		return
+	}
+
+	key := flowKey("tcp", srcIP, int(tcp.SrcPort), dstIP, int(tcp.DstPort))
+	if _, found := events[key]; !found || s.hassh[key] != "" {
+		return
+	}
+	buffer := s.buffers[key]
+	if len(buffer) == 0 && !bytes.HasPrefix(tcp.Payload, []byte("SSH-")) {
+		return
+	}
+	if len(buffer)+len(tcp.Payload) > sshFingerprintBuffer {
+		return
+	}
+	buffer = append(buffer, tcp.Payload...)
+	s.buffers[key] = buffer
+
+	end := bytes.IndexByte(buffer, '\n')
+	if end < 0 {
+		return
+	}
+	s.banners[key] = strings.TrimSpace(string(buffer[:end]))
+	if hassh, ok := readKexInit(buffer[end+1:]); ok {
+		s.hassh[key] = hassh
+		delete(s.buffers, key)
+	}
+}
+
+// readKexInit returns the HASSH of the first binary packet when it is a complete SSH_MSG_KEXINIT.
+func readKexInit(data []byte) (string, bool) {
+	if len(data) < 6 {
+		return "", false
+	}
+	length := binary.BigEndian.Uint32(data[:4])
+	padding := uint32(data[4])
+	// padding length byte, message type and cookie come before the name-lists
+	if length > 35000 || length < 1+padding+17 || len(data) < int(4+length) {
+		return "", false
+	}
+	payload := data[5 : 4+length-padding]
+	if payload[0] != 20 {
+		return "", false
+	}
+
+	var lists []string
+	rest := payload[17:]
+	for len(lists) < 8 {
+		if len(rest) < 4 {
+			return "", false
+		}
+		size := binary.BigEndian.Uint32(rest[:4])
+		if uint32(len(rest)-4) < size {
+			return "", false
+		}
+		lists = append(lists, string(rest[4:4+size]))
+		rest = rest[4+size:]
+	}
+
+	// kex, encryption, mac and compression from client to server
+	hash := md5.Sum([]byte(strings.Join([]string{lists[0], lists[2], lists[4], lists[6]}, ";")))
+	return hex.EncodeToString(hash[:]), true
+}
+
+// annotate sets the ssh_banner and hassh fields of the connection events.
+func (s *sshFingerprints) annotate(events []Event, index map[string]int) {
+	for key, position := range index {
+		banner, hassh := s.banners[key], s.hassh[key]
+		if banner == "" {
+			continue
+		}
+		if events[position].Fields == nil {
+			events[position].Fields = make(map[string]string)
+		}
+		events[position].Fields["ssh_banner"] = banner
+		if hassh != "" {
+			events[position].Fields["hassh"] = hassh
+		}
+	}
+}
+
+// Actor is a set of source addresses behaving as one attacker: an address alone, or the addresses
+// running the same credential list in the same order.
+type Actor struct {
+	ID          string    `json:"id"`
+	IPs         []string  `json:"ips"`
+	Countries   []string  `json:"countries,omitempty"`
+	Pots        []string  `json:"pots"`
+	First       time.Time `json:"first"`
+	Last        time.Time `json:"last"`
+	Events      int       `json:"events"`
+	Banners     []string  `json:"ssh_banners,omitempty"`
+	HASSH       []string  `json:"hassh,omitempty"`
+	Credentials int       `json:"credentials"`
+	Playbooks   []string  `json:"playbooks,omitempty"`
+	Files       []string  `json:"files,omitempty"`
+	URLs        []string  `json:"urls,omitempty"`
+	Campaign    string    `json:"campaign,omitempty"`
+}
+
+// Campaign is a set of actors sharing dropped files, download urls or command playbooks.
+type Campaign struct {
+	ID        string    `json:"id"`
+	Actors    []string  `json:"actors"`
+	IPs       []string  `json:"ips"`
+	Pots      []string  `json:"pots"`
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+	Events    int       `json:"events"`
+	Playbooks []string  `json:"playbooks,omitempty"`
+	Files     []string  `json:"files,omitempty"`
+	URLs      []string  `json:"urls,omitempty"`
+}
+
+// Correlation is the actors and campaigns of a set of events.
+type Correlation struct {
+	Actors    []Actor
+	Campaigns []Campaign
+
+	actorByIP      map[string]int
+	campaignByFile map[string]string
+}
+
+type unionFind map[string]string
+
+func (u unionFind) find(key string) string {
+	if _, found := u[key]; !found {
+		u[key] = key
+	}
+	for u[key] != key {
+		u[key] = u[u[key]]
+		key = u[key]
+	}
+	return key
+}
+
+func (u unionFind) union(a string, b string) {
+	if rootA, rootB := u.find(a), u.find(b); rootA != rootB {
+		if rootB < rootA {
+			rootA, rootB = rootB, rootA
+		}
+		u[rootB] = rootA
+	}
+}
+
+func correlationID(prefix string, values []string) string {
+	hash := sha256.Sum256([]byte(strings.Join(values, "\n")))
+	return prefix + hex.EncodeToString(hash[:4])
+}
+
+// Correlate groups the source addresses of the events into actors and the actors into campaigns.
+// Dropped files, which have no source, are attributed to the addresses that logged in or typed
+// commands on the same pot in the hour before.
+func Correlate(events []Event) *Correlation {
+	type activity struct {
+		ip   string
+		time time.Time
+	}
+
+	actors := make(map[string]*Actor)
+	credentials := make(map[string][]string)
+	sessions := make(map[string][]string)
+	sessionIP := make(map[string]string)
+	active := make(map[string][]activity)
+	var drops []Event
+
+	for _, event := range events {
+		if event.SourceIP == "" || event.Kind == EventOutbound {
+			if event.Hash != "" && (event.Kind == EventFileChange || event.Kind == EventYara) {
+				drops = append(drops, event)
+			}
+			continue
+		}
+
+		actor, found := actors[event.SourceIP]
+		if !found {
+			actor = &Actor{IPs: []string{event.SourceIP}, First: event.Time, Pots: []string{}}
+			actors[event.SourceIP] = actor
+		}
+		mergeActorEvent(actor, event)
+
+		switch event.Kind {
+		case EventLogin:
+			credentials[event.SourceIP] = appendUnique(credentials[event.SourceIP], event.Username+":"+event.Password)
+			active[event.Pot] = append(active[event.Pot], activity{event.SourceIP, event.Time})
+		case EventCommand:
+			key := sessionKey(event)
+			sessions[key] = append(sessions[key], NormalizeCommand(event.Command))
+			sessionIP[key] = event.SourceIP
+			for _, value := range ExtractURLs(event.Command) {
+				actor.URLs = appendUnique(actor.URLs, value)
+			}
+			active[event.Pot] = append(active[event.Pot], activity{event.SourceIP, event.Time})
+		}
+	}
+
+	for key, commands := range sessions {
+		if len(commands) >= 2 {
+			actor := actors[sessionIP[key]]
+			actor.Playbooks = appendUnique(actor.Playbooks, PlaybookID(commands))
+		}
+	}
+	for _, drop := range drops {
+		for _, seen := range active[drop.Pot] {
+			if !seen.time.After(drop.Time) && drop.Time.Sub(seen.time) <= dropAttribution {
+				actors[seen.ip].Files = appendUnique(actors[seen.ip].Files, drop.Hash)
+			}
+		}
+	}
+
+	// actors: addresses running the same credential list
+	addresses := unionFind{}
+	lists := make(map[string]string)
+	for ip := range actors {
+		addresses.find(ip)
+		if list := credentials[ip]; len(list) >= minCredentialList {
+			signature := strings.Join(list, "\n")
+			if other, found := lists[signature]; found {
+				addresses.union(ip, other)
+			} else {
+				lists[signature] = ip
+			}
+		}
+	}
+
+	merged := make(map[string]*Actor)
+	for ip, actor := range actors {
+		root := addresses.find(ip)
+		target, found := merged[root]
+		if !found {
+			target = &Actor{First: actor.First, Pots: []string{}}
+			merged[root] = target
+		}
+		mergeActor(target, *actor)
+		target.Credentials += len(credentials[ip])
+	}
+
+	// campaigns: actors sharing files, urls or playbooks
+	groups := unionFind{}
+	owners := make(map[string]string)
+	for root, actor := range merged {
+		for _, indicators := range [][]string{prefixed("file:", actor.Files), prefixed("url:", actor.URLs), prefixed("playbook:", actor.Playbooks)} {
+			for _, indicator := range indicators {
+				groups.find(root)
+				if owner, found := owners[indicator]; found {
+					groups.union(root, owner)
+				} else {
+					owners[indicator] = root
+				}
+			}
+		}
+	}
+
+	correlation := &Correlation{Actors: []Actor{}, Campaigns: []Campaign{}, actorByIP: make(map[string]int), campaignByFile: make(map[string]string)}
+	campaigns := make(map[string]*Campaign)
+	for root, actor := range merged {
+		sortLists(&actor.IPs, &actor.Countries, &actor.Pots, &actor.Banners, &actor.HASSH, &actor.Playbooks, &actor.Files, &actor.URLs)
+		actor.ID = correlationID("A-", actor.IPs)
+		if _, grouped := groups[root]; grouped {
+			campaign, found := campaigns[groups.find(root)]
+			if !found {
+				campaign = &Campaign{First: actor.First, Pots: []string{}}
+				campaigns[groups.find(root)] = campaign
+			}
+			mergeCampaign(campaign, *actor)
+		}
+		correlation.Actors = append(correlation.Actors, *actor)
+	}
+
+	for _, campaign := range campaigns {
+		sortLists(&campaign.Actors, &campaign.IPs, &campaign.Pots, &campaign.Playbooks, &campaign.Files, &campaign.URLs)
+		indicators := append(append(prefixed("file:", campaign.Files), prefixed("url:", campaign.URLs)...), prefixed("playbook:", campaign.Playbooks)...)
+		sort.Strings(indicators)
+		campaign.ID = correlationID("C-", indicators)
+		for _, hash := range campaign.Files {
+			correlation.campaignByFile[hash] = campaign.ID
+		}
+		correlation.Campaigns = append(correlation.Campaigns, *campaign)
+	}
+	for index := range correlation.Actors {
+		for _, campaign := range correlation.Campaigns {
+			if containsName(campaign.Actors, correlation.Actors[index].ID) {
+				correlation.Actors[index].Campaign = campaign.ID
+			}
+		}
+	}
+
+	sort.Slice(correlation.Actors, func(i, j int) bool {
+		a, b := correlation.Actors[i], correlation.Actors[j]
+		if len(a.Pots) != len(b.Pots) {
+			return len(a.Pots) > len(b.Pots)
+		}
+		if a.Events != b.Events {
+			return a.Events > b.Events
+		}
+		return a.ID < b.ID
+	})
+	sort.Slice(correlation.Campaigns, func(i, j int) bool {
+		a, b := correlation.Campaigns[i], correlation.Campaigns[j]
+		if len(a.Pots) != len(b.Pots) {
+			return len(a.Pots) > len(b.Pots)
+		}
+		if a.Events != b.Events {
+			return a.Events > b.Events
+		}
+		return a.ID < b.ID
+	})
+	for index, actor := range correlation.Actors {
+		for _, ip := range actor.IPs {
+			correlation.actorByIP[ip] = index
+		}
+	}
+	return correlation
+}
+
+// Annotate sets the actor and campaign of the events, by source address or dropped file.
+func (c *Correlation) Annotate(events []Event) {
+	for index := range events {
+		if position, found := c.actorByIP[events[index].SourceIP]; found && events[index].Kind != EventOutbound {
+			events[index].Actor = c.Actors[position].ID
+			events[index].Campaign = c.Actors[position].Campaign
+		} else if campaign := c.campaignByFile[events[index].Hash]; campaign != "" {
+			events[index].Campaign = campaign
+		}
+	}
+}
+
+func mergeActorEvent(actor *Actor, event Event) {
+	actor.Events++
+	actor.Pots = appendUnique(actor.Pots, event.Pot)
+	if event.Time.Before(actor.First) {
+		actor.First = event.Time
+	}
+	if event.Time.After(actor.Last) {
+		actor.Last = event.Time
+	}
+	if event.Geo != nil {
+		actor.Countries = appendUnique(actor.Countries, event.Geo.CountryCode)
+	}
+	actor.Banners = appendUnique(actor.Banners, event.Fields["ssh_banner"])
+	actor.HASSH = appendUnique(actor.HASSH, event.Fields["hassh"])
+}
+
+func mergeActor(target *Actor, actor Actor) {
+	target.Events += actor.Events
+	if actor.First.Before(target.First) {
+		target.First = actor.First
+	}
+	if actor.Last.After(target.Last) {
+		target.Last = actor.Last
+	}
+	for _, values := range []struct {
+		target *[]string
+		source []string
+	}{
+		{&target.IPs, actor.IPs}, {&target.Countries, actor.Countries}, {&target.Pots, actor.Pots},
+		{&target.Banners, actor.Banners}, {&target.HASSH, actor.HASSH}, {&target.Playbooks, actor.Playbooks},
+		{&target.Files, actor.Files}, {&target.URLs, actor.URLs},
+	} {
+		for _, value := range values.source {
+			*values.target = appendUnique(*values.target, value)
+		}
+	}
+}
+
+func mergeCampaign(campaign *Campaign, actor Actor) {
+	campaign.Actors = append(campaign.Actors, actor.ID)
+	campaign.Events += actor.Events
+	if actor.First.Before(campaign.First) {
+		campaign.First = actor.First
+	}
+	if actor.Last.After(campaign.Last) {
+		campaign.Last = actor.Last
+	}
+	for _, values := range []struct {
+		target *[]string
+		source []string
+	}{
+		{&campaign.IPs, actor.IPs}, {&campaign.Pots, actor.Pots}, {&campaign.Playbooks, actor.Playbooks},
+		{&campaign.Files, actor.Files}, {&campaign.URLs, actor.URLs},
+	} {
+		for _, value := range values.source {
+			*values.target = appendUnique(*values.target, value)
+		}
+	}
+}
+
+func prefixed(prefix string, values []string) []string {
+	var result []string
+	for _, value := range values {
+		result = append(result, prefix+value)
+	}
+	return result
+}
+
+func sortLists(lists ...*[]string) {
+	for _, list := range lists {
+		sort.Strings(*list)
+	}
+}
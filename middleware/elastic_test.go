@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// elasticStandIn accepts _bulk and template requests like an Elasticsearch node.
+type elasticStandIn struct {
+	sync.Mutex
+	down      bool           // answer 503 to every bulk request
+	reject    map[string]int // status of documents by pot
+	templates []string
+	documents []map[string]interface{}
+	indices   []string
+}
+
+func (s *elasticStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if r.Header.Get("Authorization") != "ApiKey secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_index_template/"):
+		body, _ := ioutil.ReadAll(r.Body)
+		s.templates = append(s.templates, string(body))
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var items []string
+		errors := false
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			_ = json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			var document map[string]interface{}
+			_ = json.Unmarshal(scanner.Bytes(), &document)
+
+			pot := document["honeypot"].(map[string]interface{})["pot"].(string)
+			if status, found := s.reject[pot]; found {
+				delete(s.reject, pot)
+				errors = true
+				items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"rejected"}}}`, status))
+				continue
+			}
+
+			s.documents = append(s.documents, document)
+			s.indices = append(s.indices, action["index"]["_index"])
+			items = append(items, `{"index":{"status":201}}`)
+		}
+		fmt.Fprintf(w, `{"errors":%t,"items":[%s]}`, errors, strings.Join(items, ","))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *elasticStandIn) received() []map[string]interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]map[string]interface{}(nil), s.documents...)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestElasticExporter(t *testing.T) {
+	standIn := &elasticStandIn{reject: map[string]int{"web-2": 429, "web-3": 400}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	spoolDir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	exporter, err := NewElasticExporter(ElasticConfig{
+		URL:            server.URL,
+		APIKey:         "secret",
+		BatchSize:      2,
+		FlushInterval:  50 * time.Millisecond,
+		SpoolDir:       spoolDir,
+		RetryMax:       100 * time.Millisecond,
+		ContainerImage: func(string) string { return "cowrie/cowrie" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close(time.Second)
+
+	if err := exporter.InstallTemplate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(standIn.templates) != 1 || !strings.Contains(standIn.templates[0], `"index_patterns": ["honeypot-*"]`) {
+		t.Errorf("index template not match\nexpected: honeypot-* pattern, actual: %v", standIn.templates)
+	}
+
+	now := time.Unix(1600000000, 0)
+	exporter.SendEvent(Event{Time: now, Pot: "web-1", Container: "abc", Kind: EventConnection, SourceIP: "10.0.0.1", DestinationPort: 80, Protocol: "TCP"})
+	exporter.SendEvent(Event{Time: now, Pot: "web-2", Kind: EventLogin, Username: "root"})
+	exporter.SendEvent(Event{Time: now, Pot: "web-3", Kind: EventLogin, Username: "admin"})
+	exporter.SendManifest("web-1_1600000000", Manifest{Pot: "web-1", Container: "abc", Image: "nginx", StartedAt: now, FinishedAt: now.Add(time.Second)})
+
+	// web-2 is retried after 429, web-3 dropped after 400
+	waitFor(t, func() bool { return len(standIn.received()) == 3 })
+	waitFor(t, func() bool { return exporter.Pending() == 0 })
+
+	documents := standIn.received()
+	first := documents[0]
+	expected := map[string]interface{}{
+		"source":    map[string]interface{}{"ip": "10.0.0.1"},
+		"container": map[string]interface{}{"id": "abc", "image": map[string]interface{}{"name": "cowrie/cowrie"}},
+	}
+	for field, value := range expected {
+		if actual, _ := json.Marshal(first[field]); !bytes.Equal(actual, mustMarshal(value)) {
+			t.Errorf("%s field not match\nexpected: %s, actual: %s", field, mustMarshal(value), actual)
+		}
+	}
+	if port := first["destination"].(map[string]interface{})["port"]; port != float64(80) {
+		t.Errorf("destination.port not match\nexpected: 80, actual: %v", port)
+	}
+	if category := first["event"].(map[string]interface{})["category"]; fmt.Sprint(category) != "[network]" {
+		t.Errorf("event.category not match\nexpected: [network], actual: %v", category)
+	}
+
+	if standIn.indices[0] != "honeypot-events-2020.09.13" {
+		t.Errorf("event index not match\nexpected: honeypot-events-2020.09.13, actual: %s", standIn.indices[0])
+	}
+	if index, run := standIn.indices[1], documents[1]["honeypot"].(map[string]interface{})["run"]; index != "honeypot-runs-2020.09.13" || run != "web-1_1600000000" {
+		t.Errorf("manifest document not match\nexpected: honeypot-runs-2020.09.13 web-1_1600000000, actual: %s %v", index, run)
+	}
+	if pot := documents[2]["honeypot"].(map[string]interface{})["pot"]; pot != "web-2" {
+		t.Errorf("retried document not match\nexpected: web-2, actual: %v", pot)
+	}
+}
+
+func TestElasticExporterSpool(t *testing.T) {
+	standIn := &elasticStandIn{down: true}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	spoolDir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spoolDir)
+
+	config := ElasticConfig{URL: server.URL, APIKey: "secret", FlushInterval: 50 * time.Millisecond, SpoolDir: spoolDir, RetryMax: 100 * time.Millisecond}
+	exporter, err := NewElasticExporter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exporter.SendEvent(Event{Pot: "web-1", Kind: EventConnection})
+	waitFor(t, func() bool { files, _ := filepath.Glob(filepath.Join(spoolDir, "*.ndjson")); return len(files) == 1 })
+	exporter.Close(0)
+
+	// spooled batch survives a restart and is sent once the cluster is back
+	standIn.Lock()
+	standIn.down = false
+	standIn.Unlock()
+
+	exporter, err = NewElasticExporter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close(time.Second)
+
+	waitFor(t, func() bool { return len(standIn.received()) == 1 })
+	waitFor(t, func() bool { return exporter.Pending() == 0 })
+}
+
+func mustMarshal(value interface{}) []byte {
+	data, _ := json.Marshal(value)
+	return data
+}
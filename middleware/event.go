@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	EventConnection = "connection" // inbound connection to a pot
+	EventOutbound   = "outbound"   // connection initiated by a pot
+	EventFileChange = "file"       // file added or changed in a pot
+	EventProcess    = "process"    // new process in a pot
+	EventCPUSpike   = "cpu"        // cpu usage over threshold
+	EventLogin      = "login"      // login on an emulated service
+	EventCommand    = "command"    // command typed in a session
+	EventCollect    = "collect"    // collection run finished
+	EventReset      = "reset"      // pot replaced with clean container
+	EventYara       = "yara"       // yara rule matched a collected file
+	EventDocker     = "docker"     // lifecycle event of a pot container from the docker daemon
+)
+
+// EventKinds lists every event kind.
+var EventKinds = []string{
+	EventConnection, EventOutbound, EventFileChange, EventProcess, EventCPUSpike, EventLogin,
+	EventCommand, EventCollect, EventReset, EventYara, EventDocker,
+}
+
+const (
+	SeverityInfo     = 1
+	SeverityLow      = 3
+	SeverityMedium   = 5
+	SeverityHigh     = 8
+	SeverityCritical = 10
+)
+
+// Event is a single observation on a pot, shared by triggers, stores and exporters.
+type Event struct {
+	Time            time.Time         `json:"time"`
+	Host            string            `json:"host,omitempty"`
+	Pot             string            `json:"pot"`
+	Container       string            `json:"container,omitempty"`
+	Kind            string            `json:"kind"`
+	Severity        int               `json:"severity"`
+	Protocol        string            `json:"protocol,omitempty"`
+	SourceIP        string            `json:"source_ip,omitempty"`
+	SourcePort      int               `json:"source_port,omitempty"`
+	DestinationIP   string            `json:"destination_ip,omitempty"`
+	DestinationPort int               `json:"destination_port,omitempty"`
+	Username        string            `json:"username,omitempty"`
+	Password        string            `json:"password,omitempty"`
+	Command         string            `json:"command,omitempty"`
+	Path            string            `json:"path,omitempty"`
+	Hash            string            `json:"hash,omitempty"`
+	Message         string            `json:"message,omitempty"`
+	Geo             *Geo              `json:"geo,omitempty"`
+	Actor           string            `json:"actor,omitempty"`
+	Campaign        string            `json:"campaign,omitempty"`
+	Fields          map[string]string `json:"fields,omitempty"`
+}
+
+var subscribers = struct {
+	sync.Mutex
+	channels map[chan Event]struct{}
+}{channels: make(map[chan Event]struct{})}
+
+// SubscribeEvents returns a channel receiving every published event.
+// Events are dropped for a subscriber whose buffer is full.
+func SubscribeEvents(size int) chan Event {
+	channel := make(chan Event, size)
+
+	subscribers.Lock()
+	subscribers.channels[channel] = struct{}{}
+	subscribers.Unlock()
+
+	return channel
+}
+
+func UnsubscribeEvents(channel chan Event) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+
+	if _, found := subscribers.channels[channel]; found {
+		delete(subscribers.channels, channel)
+		close(channel)
+	}
+}
+
+func PublishEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Severity == 0 {
+		event.Severity = SeverityInfo
+	}
+	EnrichEvent(&event)
+
+	subscribers.Lock()
+	defer subscribers.Unlock()
+
+	for channel := range subscribers.channels {
+		select {
+		case channel <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/identifiers"
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v2"
+)
+
+// LocalHostName names the runtime of the environment, the docker daemon of DOCKER_HOST or the
+// local socket unless another runtime is selected.
+const LocalHostName = "local"
+
+var hostNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Host is a Docker daemon running pots, reached by its DOCKER_HOST url with TLS client
+// certificates, or a Kubernetes cluster reached by its kubeconfig, the url and certificates
+// overriding those of the kubeconfig. Podman is reached like docker at its socket by default,
+// containerd at the url of its socket. Paths are relative to the fleet file.
+type Host struct {
+	Name       string `yaml:"name" json:"name"`
+	Runtime    string `yaml:"runtime" json:"runtime,omitempty"`
+	URL        string `yaml:"url" json:"url"`
+	CA         string `yaml:"ca" json:"ca,omitempty"`
+	Cert       string `yaml:"cert" json:"cert,omitempty"`
+	Key        string `yaml:"key" json:"key,omitempty"`
+	Kubeconfig string `yaml:"kubeconfig" json:"kubeconfig,omitempty"`
+	Context    string `yaml:"context" json:"context,omitempty"`
+	Namespace  string `yaml:"namespace" json:"namespace,omitempty"`
+	CNIPath    string `yaml:"cni_path" json:"cni_path,omitempty"`
+}
+
+// NewClient connects to the daemon of the host.
+func (h Host) NewClient() (*client.Client, error) {
+	if h.URL == "" {
+		return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	}
+
+	options := []client.Opt{client.WithHost(h.URL), client.WithAPIVersionNegotiation()}
+	if h.CA != "" || h.Cert != "" || h.Key != "" {
+		options = append(options, client.WithTLSClientConfig(h.CA, h.Cert, h.Key))
+	}
+	return client.NewClientWithOpts(options...)
+}
+
+// NewRuntime connects to the runtime of the host, with the client of the daemon for docker and
+// podman hosts. Runtimes of hosts without url are reached like their own tools do, podman at
+// CONTAINER_HOST and containerd at CONTAINERD_ADDRESS in CONTAINERD_NAMESPACE with CNI_PATH.
+func (h Host) NewRuntime() (Runtime, *client.Client, error) {
+	switch h.Runtime {
+	case "", RuntimeDocker:
+		cli, err := h.NewClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		return DockerRuntime{Client: cli}, cli, nil
+
+	case RuntimePodman:
+		host := h
+		if host.URL == "" {
+			host.URL = podmanURL()
+		}
+		cli, err := host.NewClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewPodmanRuntime(cli, host.URL), cli, nil
+
+	case RuntimeContainerd:
+		address, namespace, cniPath := h.URL, h.Namespace, h.CNIPath
+		if address == "" {
+			address = os.Getenv("CONTAINERD_ADDRESS")
+		}
+		if namespace == "" {
+			namespace = os.Getenv("CONTAINERD_NAMESPACE")
+		}
+		if cniPath == "" {
+			cniPath = os.Getenv("CNI_PATH")
+		}
+		runtime, err := NewContainerdRuntime(address, namespace, cniPath)
+		return runtime, nil, err
+
+	case RuntimeKubernetes:
+		config, err := h.kubernetesConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		runtime, err := NewKubernetesRuntime(config, h.Namespace)
+		return runtime, nil, err
+	}
+
+	return nil, nil, fmt.Errorf("unknown %s runtime", h.Runtime)
+}
+
+// Fleet is the named sensor hosts of a fleet file.
+type Fleet struct {
+	Hosts []Host `yaml:"hosts"`
+}
+
+// LoadFleet reads and validates the hosts of the YAML file.
+func LoadFleet(fileName string) (Fleet, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return Fleet{}, err
+	}
+
+	fleet, err := ParseFleet(data)
+	if err != nil {
+		return Fleet{}, err
+	}
+
+	dir := filepath.Dir(fileName)
+	for index := range fleet.Hosts {
+		host := &fleet.Hosts[index]
+		for _, path := range []*string{&host.CA, &host.Cert, &host.Key, &host.Kubeconfig} {
+			if *path != "" && !filepath.IsAbs(*path) {
+				*path = filepath.Join(dir, *path)
+			}
+		}
+	}
+	return fleet, nil
+}
+
+// ParseFleet parses and validates the hosts of a fleet file.
+func ParseFleet(data []byte) (Fleet, error) {
+	var fleet Fleet
+	if err := yaml.UnmarshalStrict(data, &fleet); err != nil {
+		return Fleet{}, err
+	}
+
+	names := make(map[string]bool)
+	for _, host := range fleet.Hosts {
+		if !hostNamePattern.MatchString(host.Name) {
+			return Fleet{}, fmt.Errorf("invalid host name %q", host.Name)
+		}
+		if host.Name == LocalHostName {
+			return Fleet{}, fmt.Errorf("host name %s is reserved for the daemon of the environment", LocalHostName)
+		}
+		if names[host.Name] {
+			return Fleet{}, fmt.Errorf("host %s defined twice", host.Name)
+		}
+		names[host.Name] = true
+
+		switch host.Runtime {
+		case "", RuntimeDocker, RuntimePodman:
+			if host.URL == "" && host.Runtime != RuntimePodman {
+				return Fleet{}, fmt.Errorf("host %s - url required", host.Name)
+			}
+			if host.URL != "" {
+				if _, err := client.ParseHostURL(host.URL); err != nil {
+					return Fleet{}, fmt.Errorf("host %s - %s", host.Name, err)
+				}
+			}
+			if host.Kubeconfig != "" || host.Context != "" || host.Namespace != "" || host.CNIPath != "" {
+				runtimeName := host.Runtime
+				if runtimeName == "" {
+					runtimeName = RuntimeDocker
+				}
+				return Fleet{}, fmt.Errorf("host %s - kubeconfig, context, namespace and cni_path not supported by %s runtime", host.Name, runtimeName)
+			}
+		case RuntimeContainerd:
+			if host.URL != "" && !strings.HasPrefix(host.URL, "unix://") && !filepath.IsAbs(host.URL) {
+				return Fleet{}, fmt.Errorf("host %s - url of containerd socket must be unix:// or absolute path", host.Name)
+			}
+			if host.Kubeconfig != "" || host.Context != "" || host.CA != "" || host.Cert != "" || host.Key != "" {
+				return Fleet{}, fmt.Errorf("host %s - kubeconfig, context and certificates not supported by containerd runtime", host.Name)
+			}
+			if host.Namespace != "" && identifiers.Validate(host.Namespace) != nil {
+				return Fleet{}, fmt.Errorf("host %s - invalid namespace %q", host.Name, host.Namespace)
+			}
+		case RuntimeKubernetes:
+			if host.Namespace != "" && !kubernetesNamePattern.MatchString(host.Namespace) {
+				return Fleet{}, fmt.Errorf("host %s - invalid namespace %q", host.Name, host.Namespace)
+			}
+			if host.CNIPath != "" {
+				return Fleet{}, fmt.Errorf("host %s - cni_path not supported by kubernetes runtime", host.Name)
+			}
+		default:
+			return Fleet{}, fmt.Errorf("host %s - unknown %s runtime", host.Name, host.Runtime)
+		}
+		if (host.Cert == "") != (host.Key == "") {
+			return Fleet{}, fmt.Errorf("host %s - cert and key go together", host.Name)
+		}
+	}
+	return fleet, nil
+}
+
+// Select returns the named hosts, every host of the fleet with all, or the local daemon when
+// neither is given. The local daemon is selected by its name too.
+func (f Fleet) Select(names []string, all bool) ([]Host, error) {
+	if all {
+		if len(f.Hosts) == 0 {
+			return nil, errors.New("no host in fleet")
+		}
+		return f.Hosts, nil
+	}
+	if len(names) == 0 {
+		return []Host{{Name: LocalHostName}}, nil
+	}
+
+	var hosts []Host
+	for _, name := range names {
+		if name == LocalHostName {
+			hosts = append(hosts, Host{Name: LocalHostName})
+			continue
+		}
+		found := false
+		for _, host := range f.Hosts {
+			if host.Name == name {
+				hosts, found = append(hosts, host), true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("host %s not found in fleet", name)
+		}
+	}
+	return hosts, nil
+}
+
+// Sensor is a host of the fleet with its runtime. Client is the docker daemon of the host, or the
+// docker compatible API of podman, nil on other runtimes, serving what only docker does like
+// dockerfiles, checkpoints and the addresses of pots for ingress.
+type Sensor struct {
+	Host    Host
+	Runtime Runtime
+	Client  *client.Client
+}
+
+// ConnectHosts creates the runtimes of the hosts.
+func ConnectHosts(hosts []Host) ([]Sensor, error) {
+	var sensors []Sensor
+	for _, host := range hosts {
+		runtime, cli, err := host.NewRuntime()
+		if err != nil {
+			return nil, fmt.Errorf("host %s - %s", host.Name, err)
+		}
+		sensors = append(sensors, Sensor{Host: host, Runtime: runtime, Client: cli})
+	}
+	return sensors, nil
+}
+
+// EventHost is the host of the events of the sensor, empty for the local daemon.
+func (s Sensor) EventHost() string {
+	if s.Host.Name == LocalHostName {
+		return ""
+	}
+	return s.Host.Name
+}
+
+// PotKey names a pot across the fleet, <host>/<pot> or the pot name on the local daemon.
+func PotKey(host string, potName string) string {
+	if host == "" || host == LocalHostName {
+		return potName
+	}
+	return host + "/" + potName
+}
+
+// ArtifactRoot is the directory of the runs of the sensor under the output path, a directory
+// per host and the output path itself for the local daemon.
+func (s Sensor) ArtifactRoot(root string) string {
+	if s.EventHost() == "" {
+		return root
+	}
+	return filepath.Join(root, s.Host.Name)
+}
+
+// HostPot is a pot with the host running it.
+type HostPot struct {
+	Host string `json:"host"`
+	Pot
+}
+
+// ReadFleetPots returns the pots of every sensor by host and name. Unreachable hosts are
+// reported in the error and the pots of the others are still returned.
+func ReadFleetPots(context context.Context, sensors []Sensor) ([]HostPot, error) {
+	var pots []HostPot
+	var failures []string
+	for _, sensor := range sensors {
+		hostPots, err := ReadAllPots(context, sensor.Runtime)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("host %s - %s", sensor.Host.Name, err))
+			continue
+		}
+		for _, pot := range hostPots {
+			pots = append(pots, HostPot{Host: sensor.Host.Name, Pot: pot})
+		}
+	}
+
+	sort.Slice(pots, func(i, j int) bool {
+		if pots[i].Host != pots[j].Host {
+			return pots[i].Host < pots[j].Host
+		}
+		return pots[i].Name < pots[j].Name
+	})
+	if len(failures) > 0 {
+		return pots, errors.New(strings.Join(failures, ", "))
+	}
+	return pots, nil
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// dockerStandIn answers the Engine API requests of listing and collecting pots like a Docker daemon.
+type dockerStandIn struct {
+	containers []types.Container
+	changes    []container.ContainerChangeResponseItem
+	networks   []types.NetworkResource
+	archive    []byte // root directory of every container, export not being served
+}
+
+func (d *dockerStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/_ping" {
+		w.Header().Set("API-Version", "1.40")
+		_, _ = w.Write([]byte("OK"))
+		return
+	}
+
+	path := apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	switch {
+	case path == "/containers/json":
+		_ = json.NewEncoder(w).Encode(d.containers)
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/changes"):
+		_ = json.NewEncoder(w).Encode(d.changes)
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/archive") && d.archive != nil:
+		stat, _ := json.Marshal(types.ContainerPathStat{Name: "/", Mode: os.ModeDir})
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+		_, _ = w.Write(d.archive)
+	case path == "/networks":
+		_ = json.NewEncoder(w).Encode(d.networks)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func potContainer(id string, potName string) types.Container {
+	return types.Container{ID: id, Names: []string{"/" + potName + "_" + id}, State: "running", Labels: map[string]string{"pot.name": potName}}
+}
+
+func TestParseFleet(t *testing.T) {
+	invalid := map[string]string{
+		"reserved":  "hosts:\n- name: local\n  url: tcp://10.0.0.1:2376\n",
+		"duplicate": "hosts:\n- name: a\n  url: tcp://10.0.0.1:2376\n- name: a\n  url: tcp://10.0.0.2:2376\n",
+		"url":       "hosts:\n- name: a\n",
+		"name":      "hosts:\n- name: a/b\n  url: tcp://10.0.0.1:2376\n",
+		"key":       "hosts:\n- name: a\n  url: tcp://10.0.0.1:2376\n  cert: cert.pem\n",
+		"unknown":   "hosts:\n- name: a\n  url: tcp://10.0.0.1:2376\n  tls: true\n",
+		"runtime":   "hosts:\n- name: a\n  runtime: lxc\n",
+		"context":   "hosts:\n- name: a\n  url: tcp://10.0.0.1:2376\n  context: prod\n",
+		"cni":       "hosts:\n- name: a\n  runtime: podman\n  cni_path: /opt/cni/bin\n",
+		"socket":    "hosts:\n- name: a\n  runtime: containerd\n  url: tcp://10.0.0.1:2376\n",
+		"namespace": "hosts:\n- name: a\n  runtime: containerd\n  namespace: pots/a\n",
+	}
+	for name, data := range invalid {
+		if _, err := ParseFleet([]byte(data)); err == nil {
+			t.Errorf("%s fleet not match\nexpected: error, actual: nil", name)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "fleet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "fleet.yml")
+	data := "hosts:\n- name: seoul\n  url: tcp://10.0.0.1:2376\n  ca: certs/ca.pem\n  cert: /etc/honeypot/cert.pem\n  key: certs/key.pem\n- name: tokyo\n  url: tcp://10.0.0.2:2375\n"
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fleet, err := LoadFleet(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fleet.Hosts[0].CA != filepath.Join(dir, "certs", "ca.pem") || fleet.Hosts[0].Cert != "/etc/honeypot/cert.pem" {
+		t.Errorf("certificate paths not match\nexpected: %s, actual: %s", filepath.Join(dir, "certs", "ca.pem"), fleet.Hosts[0].CA)
+	}
+
+	selections := []struct {
+		names    []string
+		all      bool
+		expected string
+	}{
+		{nil, false, "local"},
+		{nil, true, "seoul, tokyo"},
+		{[]string{"tokyo", "local"}, false, "tokyo, local"},
+	}
+	for _, selection := range selections {
+		hosts, err := fleet.Select(selection.names, selection.all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, host := range hosts {
+			names = append(names, host.Name)
+		}
+		if strings.Join(names, ", ") != selection.expected {
+			t.Errorf("selected hosts not match\nexpected: %s, actual: %s", selection.expected, strings.Join(names, ", "))
+		}
+	}
+	if _, err := fleet.Select([]string{"osaka"}, false); err == nil {
+		t.Errorf("unknown host not match\nexpected: error, actual: nil")
+	}
+}
+
+func TestReadFleetPots(t *testing.T) {
+	seoul := httptest.NewServer(&dockerStandIn{containers: []types.Container{potContainer("b1", "web"), potContainer("a1", "ssh"), {ID: "c1", Names: []string{"/other"}}}})
+	defer seoul.Close()
+	tokyo := httptest.NewServer(&dockerStandIn{containers: []types.Container{potContainer("d1", "ssh")}})
+	defer tokyo.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	hosts := []Host{
+		{Name: "tokyo", URL: "tcp://" + tokyo.Listener.Addr().String()},
+		{Name: "seoul", URL: "tcp://" + seoul.Listener.Addr().String()},
+		{Name: "osaka", URL: "tcp://" + down.Listener.Addr().String()},
+	}
+	sensors, err := ConnectHosts(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pots, err := ReadFleetPots(context.Background(), sensors)
+	if err == nil || !strings.Contains(err.Error(), "host osaka") {
+		t.Errorf("unreachable host not match\nexpected: host osaka error, actual: %v", err)
+	}
+	var names []string
+	for _, pot := range pots {
+		names = append(names, PotKey(pot.Host, pot.Name))
+	}
+	expected := "seoul/ssh, seoul/web, tokyo/ssh"
+	if strings.Join(names, ", ") != expected {
+		t.Errorf("fleet pots not match\nexpected: %s, actual: %s", expected, strings.Join(names, ", "))
+	}
+}
+
+func TestCollectFleetHost(t *testing.T) {
+	standIn := &dockerStandIn{
+		containers: []types.Container{potContainer("a1", "ssh")},
+		changes:    []container.ContainerChangeResponseItem{{Kind: 1, Path: "/tmp/miner"}},
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	sensors, err := ConnectHosts([]Host{{Name: "seoul", URL: "tcp://" + server.Listener.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sensor := sensors[0]
+
+	dir, err := ioutil.TempDir("", "fleet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pot, err := ReadPot(context.Background(), sensor.Runtime, "ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runPath := filepath.Join(sensor.ArtifactRoot(dir), "ssh_1600000000")
+	if err := os.MkdirAll(runPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	results := RunCollectors(context.Background(), sensor.Runtime, pot, pot.Containers[0], runPath, []string{"diff"})
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("collector result not match\nexpected: diff succeeded, actual: %+v", results)
+	}
+	if err := WriteManifest(runPath, Manifest{Host: sensor.EventHost(), Pot: pot.Name, Container: "a1", Collectors: results}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := ReadArtifactRuns(filepath.Join(dir, "seoul"))
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("runs of host not match\nexpected: 1 run under seoul, actual: %d (%v)", len(runs), err)
+	}
+	events, err := IndexArtifactRun(runs[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if event.Host != "seoul" {
+			t.Errorf("event host not match\nexpected: seoul, actual: %s", event.Host)
+		}
+	}
+	if len(events) != 2 || events[1].Path != "/tmp/miner" {
+		t.Errorf("events not match\nexpected: collect and /tmp/miner added, actual: %+v", events)
+	}
+}
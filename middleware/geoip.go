@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Geo is the location and network owner of an address, read from MMDB files.
+type Geo struct {
+	CountryCode  string  `json:"country_code,omitempty"`
+	Country      string  `json:"country,omitempty"`
+	City         string  `json:"city,omitempty"`
+	Latitude     float64 `json:"latitude,omitempty"`
+	Longitude    float64 `json:"longitude,omitempty"`
+	ASN          uint    `json:"asn,omitempty"`
+	Organization string  `json:"organization,omitempty"`
+}
+
+type mmdbCity struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type mmdbASN struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// maxGeoCache limits the addresses remembered by a GeoIP.
+const maxGeoCache = 65536
+
+// GeoIP looks up addresses in local MaxMind format databases, without network access.
+type GeoIP struct {
+	city *maxminddb.Reader // GeoLite2-City or Country compatible
+	asn  *maxminddb.Reader // GeoLite2-ASN compatible
+
+	mutex sync.Mutex
+	cache map[string]*Geo
+}
+
+// OpenGeoIP opens the city and ASN databases. Either path may be empty.
+func OpenGeoIP(cityPath string, asnPath string) (*GeoIP, error) {
+	geoIP := &GeoIP{cache: make(map[string]*Geo)}
+
+	var err error
+	if cityPath != "" {
+		if geoIP.city, err = maxminddb.Open(cityPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if geoIP.asn, err = maxminddb.Open(asnPath); err != nil {
+			geoIP.Close()
+			return nil, err
+		}
+	}
+
+	return geoIP, nil
+}
+
+func (g *GeoIP) Close() {
+	if g.city != nil {
+		_ = g.city.Close()
+	}
+	if g.asn != nil {
+		_ = g.asn.Close()
+	}
+}
+
+// Lookup returns the location and network owner of the address, nil when neither is known.
+func (g *GeoIP) Lookup(address string) *Geo {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if geo, found := g.cache[address]; found {
+		return geo
+	}
+
+	var geo Geo
+	if g.city != nil {
+		var record mmdbCity
+		if err := g.city.Lookup(ip, &record); err == nil {
+			geo.CountryCode = record.Country.ISOCode
+			geo.Country = record.Country.Names["en"]
+			geo.City = record.City.Names["en"]
+			geo.Latitude = record.Location.Latitude
+			geo.Longitude = record.Location.Longitude
+		}
+	}
+	if g.asn != nil {
+		var record mmdbASN
+		if err := g.asn.Lookup(ip, &record); err == nil {
+			geo.ASN = record.Number
+			geo.Organization = record.Organization
+		}
+	}
+
+	var result *Geo
+	if geo != (Geo{}) {
+		result = &geo
+	}
+
+	if len(g.cache) >= maxGeoCache {
+		g.cache = make(map[string]*Geo)
+	}
+	g.cache[address] = result
+	return result
+}
+
+var geoIP struct {
+	sync.RWMutex
+	db *GeoIP
+}
+
+// SetGeoIP enriches published and indexed events from the databases, nil to stop.
+func SetGeoIP(db *GeoIP) {
+	geoIP.Lock()
+	defer geoIP.Unlock()
+
+	geoIP.db = db
+}
+
+// EnrichEvent sets the geo of the event source address when a GeoIP is set and the event has none.
+func EnrichEvent(event *Event) {
+	if event.Geo != nil || event.SourceIP == "" {
+		return
+	}
+
+	geoIP.RLock()
+	db := geoIP.db
+	geoIP.RUnlock()
+
+	if db != nil {
+		event.Geo = db.Lookup(event.SourceIP)
+	}
+}
+
+// geoKey returns the country, city, asn or org of the geo for aggregations.
+func geoKey(geo *Geo, field string) string {
+	if geo == nil {
+		return ""
+	}
+
+	switch field {
+	case "country":
+		if geo.CountryCode != "" {
+			return geo.CountryCode
+		}
+		return geo.Country
+	case "city":
+		return geo.City
+	case "asn":
+		if geo.ASN == 0 {
+			return ""
+		}
+		return strings.TrimSpace(fmt.Sprintf("AS%d %s", geo.ASN, geo.Organization))
+	case "org":
+		return geo.Organization
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type mmdbUint16 uint16
+type mmdbUint32 uint32
+
+// encodeMMDB encodes a value in the MaxMind DB data section format.
+func encodeMMDB(value interface{}) []byte {
+	var buffer bytes.Buffer
+	control := func(kind byte, size int) {
+		// sizes from 29 to 284 take one more byte
+		sizeBits, extra := byte(size), []byte(nil)
+		if size >= 29 {
+			sizeBits, extra = 29, []byte{byte(size - 29)}
+		}
+		if kind > 7 {
+			buffer.Write([]byte{sizeBits, kind - 7})
+		} else {
+			buffer.WriteByte(kind<<5 | sizeBits)
+		}
+		buffer.Write(extra)
+	}
+
+	switch value := value.(type) {
+	case string:
+		control(2, len(value))
+		buffer.WriteString(value)
+	case float64:
+		control(3, 8)
+		_ = binary.Write(&buffer, binary.BigEndian, math.Float64bits(value))
+	case mmdbUint16:
+		control(5, 2)
+		_ = binary.Write(&buffer, binary.BigEndian, uint16(value))
+	case mmdbUint32:
+		control(6, 4)
+		_ = binary.Write(&buffer, binary.BigEndian, uint32(value))
+	case []string:
+		control(11, len(value))
+		for _, item := range value {
+			buffer.Write(encodeMMDB(item))
+		}
+	case map[string]interface{}:
+		control(7, len(value))
+		var keys []string
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			buffer.Write(encodeMMDB(key))
+			buffer.Write(encodeMMDB(value[key]))
+		}
+	}
+	return buffer.Bytes()
+}
+
+// writeTestMMDB writes an IPv4 database holding the record for every address of the /24 network.
+func writeTestMMDB(t *testing.T, path string, network string, record map[string]interface{}) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := ipNet.IP.To4()
+
+	// one node per bit of the prefix, the other branch of each node is empty
+	const nodeCount = 24
+	var tree bytes.Buffer
+	for bit := 0; bit < nodeCount; bit++ {
+		next := uint32(bit + 1)
+		if bit == nodeCount-1 {
+			next = nodeCount + 16 // data section offset 0
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[(prefix[bit/8]>>(7-uint(bit%8)))&1] = next
+		_ = binary.Write(&tree, binary.BigEndian, records)
+	}
+
+	var file bytes.Buffer
+	file.Write(tree.Bytes())
+	file.Write(make([]byte, 16))
+	file.Write(encodeMMDB(record))
+	file.WriteString("\xAB\xCD\xEFMaxMind.com")
+	file.Write(encodeMMDB(map[string]interface{}{
+		"binary_format_major_version": mmdbUint16(2),
+		"binary_format_minor_version": mmdbUint16(0),
+		"database_type":               "Test",
+		"ip_version":                  mmdbUint16(4),
+		"languages":                   []string{"en"},
+		"node_count":                  mmdbUint32(nodeCount),
+		"record_size":                 mmdbUint16(32),
+	}))
+
+	if err := ioutil.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeoIP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cityPath := filepath.Join(dir, "city.mmdb")
+	writeTestMMDB(t, cityPath, "203.0.113.0/24", map[string]interface{}{
+		"country":  map[string]interface{}{"iso_code": "KR", "names": map[string]interface{}{"en": "South Korea"}},
+		"city":     map[string]interface{}{"names": map[string]interface{}{"en": "Seoul"}},
+		"location": map[string]interface{}{"latitude": 37.5, "longitude": 127.0},
+	})
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	writeTestMMDB(t, asnPath, "203.0.113.0/24", map[string]interface{}{
+		"autonomous_system_number":       mmdbUint32(64500),
+		"autonomous_system_organization": "Example Net",
+	})
+
+	db, err := OpenGeoIP(cityPath, asnPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expected := Geo{CountryCode: "KR", Country: "South Korea", City: "Seoul", Latitude: 37.5, Longitude: 127.0, ASN: 64500, Organization: "Example Net"}
+	if geo := db.Lookup("203.0.113.9"); geo == nil || *geo != expected {
+		t.Errorf("geo not match\nexpected: %+v, actual: %+v", expected, geo)
+	}
+	if geo := db.Lookup("198.51.100.1"); geo != nil {
+		t.Errorf("geo of unknown address not match\nexpected: nil, actual: %+v", geo)
+	}
+
+	SetGeoIP(db)
+	defer SetGeoIP(nil)
+
+	events := []Event{
+		{Pot: "ssh", Kind: EventConnection, SourceIP: "203.0.113.9"},
+		{Pot: "web", Kind: EventConnection, SourceIP: "203.0.113.10"},
+		{Pot: "web", Kind: EventConnection, SourceIP: "198.51.100.1"},
+	}
+	for index := range events {
+		EnrichEvent(&events[index])
+	}
+
+	for _, field := range []string{"country", "asn"} {
+		counts := CountEvents(events, field)
+		key := map[string]string{"country": "KR", "asn": "AS64500 Example Net"}[field]
+		if len(counts) != 1 || counts[0].Key != key || counts[0].Count != 2 || counts[0].Pots != 2 {
+			t.Errorf("%s aggregation not match\nexpected: %s seen twice on 2 pots, actual: %+v", field, key, counts)
+		}
+	}
+}
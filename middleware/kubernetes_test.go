@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// startDeploymentPod creates the pod the deployment controller would start for the pot.
+func startDeploymentPod(t *testing.T, runtime *KubernetesRuntime, potName string, podName string) {
+	deployment, err := runtime.Clientset.AppsV1().Deployments(runtime.Namespace).Get(context.Background(), potName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: deployment.Spec.Template.ObjectMeta,
+		Spec:       deployment.Spec.Template.Spec,
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	pod.Name = podName
+	if _, err := runtime.Clientset.CoreV1().Pods(runtime.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKubernetesRuntime(t *testing.T) {
+	ctx := context.Background()
+	runtime := NewKubernetesRuntimeForClientset(fake.NewSimpleClientset(), "")
+	runtime.Exec = func(context context.Context, podName string, command []string, stdout io.Writer) error {
+		switch command[0] {
+		case "ps":
+			_, _ = io.WriteString(stdout, "PID   USER     TIME  COMMAND\n    1 root      0:00 sshd -D\n   42 root      0:00 /tmp/miner --pool x\n")
+		case "find":
+			_, _ = io.WriteString(stdout, "/etc/hosts\n/tmp/miner\n")
+		}
+		return nil
+	}
+
+	spec := PotSpec{
+		Name:         "ssh",
+		Image:        "honeypot/ssh:latest",
+		Ports:        []string{"30022:22"},
+		Environments: []string{"BANNER=OpenSSH_7.4"},
+		Labels:       map[string]string{CollectorsLabel: "log,top,diff"},
+	}
+	if err := runtime.CreatePot(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.CreatePot(ctx, spec); err == nil {
+		t.Errorf("duplicated pot not match\nexpected: error, actual: nil")
+	}
+	if err := runtime.CreatePot(ctx, PotSpec{Name: "SSH_pot", Image: "honeypot/ssh:latest"}); err == nil {
+		t.Errorf("invalid pot name not match\nexpected: error, actual: nil")
+	}
+
+	if _, err := runtime.Clientset.CoreV1().Namespaces().Get(ctx, DefaultNamespace, metav1.GetOptions{}); err != nil {
+		t.Errorf("pot namespace not created - %s", err)
+	}
+	service, err := runtime.Clientset.CoreV1().Services(DefaultNamespace).Get(ctx, "ssh", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.Spec.Type != corev1.ServiceTypeNodePort || service.Spec.Ports[0].NodePort != 30022 || service.Spec.Ports[0].Port != 22 {
+		t.Errorf("pot service not match\nexpected: node port 30022 to 22, actual: %+v", service.Spec)
+	}
+
+	startDeploymentPod(t, runtime, "ssh", "ssh-7d9f8-x2k9p")
+	pot, err := ReadPot(ctx, runtime, "ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := pot.Containers[0]
+	if container.ID != "ssh-7d9f8-x2k9p" || container.State != "running" || container.Image != spec.Image || container.Labels[CollectorsLabel] != "log,top,diff" {
+		t.Errorf("pot container not match\nexpected: running ssh-7d9f8-x2k9p with labels, actual: %+v", container)
+	}
+	if len(container.Ports) != 1 || container.Ports[0].PublicPort != 30022 || container.Ports[0].PrivatePort != 22 {
+		t.Errorf("pot ports not match\nexpected: 30022 to 22, actual: %+v", container.Ports)
+	}
+	if !IsExistPotName(ctx, runtime, "ssh") {
+		t.Errorf("ssh pot not found")
+	}
+
+	dir, err := ioutil.TempDir("", "kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, names := ReadPotProfile(container.Labels)
+	for _, result := range RunCollectors(ctx, runtime, pot, container, dir, names) {
+		if !result.Success {
+			t.Errorf("%s collector not match\nexpected: success, actual: %s", result.Name, result.Error)
+		}
+		// only the log is read from outside the pot container
+		if result.Untrusted != (result.Name != "log") {
+			t.Errorf("%s collector untrusted not match\nexpected: %t, actual: %t", result.Name, result.Name != "log", result.Untrusted)
+		}
+	}
+	top, _ := ioutil.ReadFile(filepath.Join(dir, "container.top"))
+	if !strings.Contains(string(top), "/tmp/miner --pool x") {
+		t.Errorf("collected processes not match\nexpected: /tmp/miner --pool x, actual: %s", top)
+	}
+	diff, _ := ioutil.ReadFile(filepath.Join(dir, "container.diff"))
+	if string(diff) != "A /tmp/miner" {
+		t.Errorf("collected diff not match\nexpected: A /tmp/miner, actual: %s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "container.log")); err != nil {
+		t.Errorf("collected log not found - %s", err)
+	}
+
+	if err := runtime.ResetContainer(ctx, pot, container); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPot(ctx, runtime, "ssh"); err == nil {
+		t.Errorf("reset pod not match\nexpected: deleted, actual: found")
+	}
+
+	if !RemovePot(ctx, runtime, "ssh") {
+		t.Errorf("ssh pot not removed")
+	}
+	if deployments, _ := runtime.Clientset.AppsV1().Deployments(DefaultNamespace).List(ctx, metav1.ListOptions{}); len(deployments.Items) != 0 {
+		t.Errorf("deployments not match\nexpected: none, actual: %d", len(deployments.Items))
+	}
+	if services, _ := runtime.Clientset.CoreV1().Services(DefaultNamespace).List(ctx, metav1.ListOptions{}); len(services.Items) != 0 {
+		t.Errorf("services not match\nexpected: none, actual: %d", len(services.Items))
+	}
+}
+
+func TestKubernetesContainerStats(t *testing.T) {
+	ctx := context.Background()
+	runtime := NewKubernetesRuntimeForClientset(fake.NewSimpleClientset(), "")
+	runtime.Summary = func(context context.Context, nodeName string) ([]byte, error) {
+		if nodeName != "node-1" {
+			return nil, fmt.Errorf("unknown %s node", nodeName)
+		}
+		return []byte(`{"pods": [
+			{"podRef": {"name": "ssh-7d9f8-x2k9p", "namespace": "other"}, "containers": [{"name": "pot", "cpu": {"usageNanoCores": 900000000, "usageCoreNanoSeconds": 9000000000}}]},
+			{"podRef": {"name": "ssh-7d9f8-x2k9p", "namespace": "honeypot"},
+			 "containers": [{"name": "pot", "cpu": {"usageNanoCores": 250000000, "usageCoreNanoSeconds": 4000000000}, "memory": {"availableBytes": 75, "workingSetBytes": 25}}],
+			 "network": {"interfaces": [{"name": "eth0", "rxBytes": 1024, "txBytes": 2048}]}}
+		]}`), nil
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ssh-7d9f8-x2k9p"}, Spec: corev1.PodSpec{NodeName: "node-1"}}
+	if _, err := runtime.Clientset.CoreV1().Pods(DefaultNamespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := runtime.ContainerStats(ctx, "ssh-7d9f8-x2k9p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stats.Body.Close()
+	var containerStat types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&containerStat); err != nil {
+		t.Fatal(err)
+	}
+	if percent := CalculateCPUPercent(&containerStat); percent != 25 {
+		t.Errorf("cpu percent not match\nexpected: 25, actual: %0.2f", percent)
+	}
+	if containerStat.MemoryStats.Usage != 25 || containerStat.MemoryStats.Limit != 100 || containerStat.Networks["eth0"].TxBytes != 2048 {
+		t.Errorf("stats not match\nexpected: 25 of 100 bytes and 2048 sent, actual: %+v %+v", containerStat.MemoryStats, containerStat.Networks)
+	}
+
+	if _, err := runtime.ContainerStats(ctx, "missing"); err == nil {
+		t.Errorf("stats of missing pod not match\nexpected: error, actual: nil")
+	}
+
+	results := RunCollectors(ctx, runtime, Pot{Name: "ssh"}, types.Container{ID: "ssh-7d9f8-x2k9p"}, os.TempDir(), []string{"pcap"})
+	if len(results) != 1 || results[0].Error != "packet capture not supported by kubernetes runtime" {
+		t.Errorf("pcap collector not match\nexpected: not supported, actual: %+v", results)
+	}
+}
+
+func TestParseKubernetesFleet(t *testing.T) {
+	fleet, err := ParseFleet([]byte("hosts:\n- name: prod\n  runtime: kubernetes\n  kubeconfig: kube/config\n  context: prod\n  namespace: pots\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := fleet.Hosts[0]; host.Runtime != RuntimeKubernetes || host.Namespace != "pots" {
+		t.Errorf("kubernetes host not match\nexpected: pots namespace, actual: %+v", host)
+	}
+
+	if _, err := ParseFleet([]byte("hosts:\n- name: prod\n  runtime: kubernetes\n  namespace: Pots\n")); err == nil {
+		t.Errorf("invalid namespace not match\nexpected: error, actual: nil")
+	}
+}
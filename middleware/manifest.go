@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+const ManifestFileName = "manifest.json"
+
+type CollectorResult struct {
+	Name       string    `json:"name"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	Untrusted  bool      `json:"untrusted,omitempty"` // taken by commands in the pot container, which an attacker may have replaced
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Manifest describes one collection run of a pot container.
+type Manifest struct {
+	Host       string            `json:"host,omitempty"` // fleet host running the pot, empty for the local daemon
+	Pot        string            `json:"pot"`
+	Container  string            `json:"container"`
+	Image      string            `json:"image"`
+	Profile    string            `json:"profile"`
+	Trigger    string            `json:"trigger,omitempty"`
+	Reset      string            `json:"reset,omitempty"`
+	Subnets    []string          `json:"subnets,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Collectors []CollectorResult `json:"collectors"`
+
+	YaraMatches []YaraMatch `json:"yara_matches,omitempty"`
+	YaraSkipped []string    `json:"yara_skipped,omitempty"` // rule files left out of the scan, with the reason
+	Samples     []string    `json:"samples,omitempty"`      // sha256 of files quarantined in the vault
+	Triage      []Triage    `json:"triage,omitempty"`
+	Sessions    []string    `json:"sessions,omitempty"` // terminal recordings under tty
+}
+
+// Failed reports whether any collector of the run failed.
+func (m Manifest) Failed() bool {
+	for _, result := range m.Collectors {
+		if !result.Success {
+			return true
+		}
+	}
+	return false
+}
+
+func WriteManifest(dir string, manifest Manifest) error {
+	jsonString, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, ManifestFileName), jsonString, 0644)
+}
+
+func ReadManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		return manifest, err
+	}
+
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
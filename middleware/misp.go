@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// mispAttribute returns a MISP attribute with a sighting for each pot the value was seen on.
+func mispAttribute(key string, kind string, category string, relation string, value string, toIDS bool, seen []Indicator) map[string]interface{} {
+	first, last := seen[0].FirstSeen, seen[0].LastSeen
+	var sightings []map[string]string
+	for _, indicator := range seen {
+		if indicator.FirstSeen.Before(first) {
+			first = indicator.FirstSeen
+		}
+		if indicator.LastSeen.After(last) {
+			last = indicator.LastSeen
+		}
+		sightings = append(sightings, map[string]string{
+			"type":          "0",
+			"source":        indicator.Pot,
+			"date_sighting": strconv.FormatInt(indicator.LastSeen.Unix(), 10),
+		})
+	}
+
+	attribute := map[string]interface{}{
+		"uuid":       uuidV5(intelNamespace, "misp|"+key+"|"+kind+"|"+relation),
+		"type":       kind,
+		"category":   category,
+		"value":      value,
+		"to_ids":     toIDS,
+		"comment":    fmt.Sprintf("%s, seen on %s", indicatorDescriptions[seen[0].Role], strings.Join(sortedPots(seen), ", ")),
+		"first_seen": first.UTC().Format(time.RFC3339),
+		"last_seen":  last.UTC().Format(time.RFC3339),
+		"Sighting":   sightings,
+	}
+	if relation != "" {
+		attribute["object_relation"] = relation
+	}
+	return attribute
+}
+
+// MISPEvent returns the indicators as a MISP event. Addresses and urls are attributes, files and
+// credentials objects, each with a sighting per pot.
+func MISPEvent(indicators []Indicator, info string, tlp string, now time.Time) (map[string]interface{}, error) {
+	if _, found := TLPMarkings[strings.ToLower(tlp)]; !found {
+		return nil, fmt.Errorf("unknown %s tlp, expected white, green, amber or red", tlp)
+	}
+
+	// indicators of one value on several pots are one attribute
+	var keys []string
+	byValue := make(map[string][]Indicator)
+	for _, indicator := range indicators {
+		key := indicator.Type + "|" + indicator.Value
+		if _, found := byValue[key]; !found {
+			keys = append(keys, key)
+		}
+		byValue[key] = append(byValue[key], indicator)
+	}
+
+	attributes := []interface{}{}
+	objects := []interface{}{}
+	for _, key := range keys {
+		seen := byValue[key]
+		indicator := seen[0]
+
+		switch indicator.Type {
+		case IndicatorIP:
+			kind := "ip-src"
+			if indicator.Role == "outbound" || indicator.Role == "embedded" {
+				kind = "ip-dst"
+			}
+			attributes = append(attributes, mispAttribute(key, kind, "Network activity", "", indicator.Value, true, seen))
+		case IndicatorURL:
+			attributes = append(attributes, mispAttribute(key, "url", "Payload delivery", "", indicator.Value, true, seen))
+		case IndicatorFile:
+			fileAttributes := []interface{}{mispAttribute(key, "sha256", "Payload delivery", "sha256", indicator.Value, true, seen)}
+			for _, name := range []string{"md5", "sha1"} {
+				if hash := indicator.Hashes[name]; hash != "" {
+					fileAttributes = append(fileAttributes, mispAttribute(key, name, "Payload delivery", name, hash, true, seen))
+				}
+			}
+			if indicator.Path != "" {
+				fileAttributes = append(fileAttributes, mispAttribute(key, "filename", "Payload delivery", "filename", indicator.Path, false, seen))
+			}
+			objects = append(objects, map[string]interface{}{
+				"uuid":          uuidV5(intelNamespace, "misp-object|"+key),
+				"name":          "file",
+				"meta-category": "file",
+				"description":   indicatorDescriptions[indicator.Role],
+				"Attribute":     fileAttributes,
+				"distribution":  "5",
+			})
+		case IndicatorCredential:
+			objects = append(objects, map[string]interface{}{
+				"uuid":          uuidV5(intelNamespace, "misp-object|"+key),
+				"name":          "credential",
+				"meta-category": "misc",
+				"description":   indicatorDescriptions[indicator.Role],
+				"Attribute": []interface{}{
+					mispAttribute(key, "text", "Other", "username", indicator.Username, false, seen),
+					mispAttribute(key, "text", "Other", "password", indicator.Password, false, seen),
+				},
+				"distribution": "5",
+			})
+		}
+	}
+
+	// the event itself is new on every export
+	eventID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"Event": map[string]interface{}{
+			"uuid":            eventID.String(),
+			"info":            info,
+			"date":            now.UTC().Format("2006-01-02"),
+			"timestamp":       strconv.FormatInt(now.Unix(), 10),
+			"threat_level_id": "2",
+			"analysis":        "2",
+			"distribution":    "0",
+			"published":       false,
+			"Tag":             []map[string]string{{"name": "tlp:" + strings.ToLower(tlp)}, {"name": "honeypot"}},
+			"Attribute":       attributes,
+			"Object":          objects,
+		},
+	}, nil
+}
+
+// sortedPots returns the pots of the indicators of one value.
+func sortedPots(indicators []Indicator) []string {
+	var pots []string
+	for _, indicator := range indicators {
+		pots = append(pots, indicator.Pot)
+	}
+	sort.Strings(pots)
+	return pots
+}
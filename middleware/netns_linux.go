@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// inNetworkNamespace runs f with the calling thread in the network namespace at path. Sockets
+// opened by f stay in that namespace after the thread is switched back.
+func inNetworkNamespace(path string, f func() error) error {
+	runtime.LockOSThread()
+
+	current, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer current.Close()
+
+	target, err := os.Open(path)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	err = f()
+
+	if restoreErr := unix.Setns(int(current.Fd()), unix.CLONE_NEWNET); restoreErr != nil {
+		// the thread stays locked so it exits with the goroutine instead of being reused
+		return restoreErr
+	}
+	runtime.UnlockOSThread()
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package middleware
+
+import "errors"
+
+func inNetworkNamespace(path string, f func() error) error {
+	return errors.New("network namespace only supported on linux")
+}
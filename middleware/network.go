@@ -1,48 +1,340 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/google/gopacket/pcapgo"
 )
 
-func DumpNetwork(stopCapture <-chan string, fileName string, network types.NetworkResource) {
-	f, _ := os.Create(fileName)
-	w := pcapgo.NewWriter(f)
-	_ = w.WriteFileHeader(1024, layers.LinkTypeEthernet)
+type capture struct {
+	fileName string
+	network  PotNetwork
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var captures = struct {
+	sync.Mutex
+	pots map[string]*capture
+}{pots: make(map[string]*capture)}
+
+// CaptureStats counts packets of the pot network since the process started, across rotations.
+type CaptureStats struct {
+	Received         int64
+	Dropped          int64
+	InterfaceDropped int64
+}
+
+var captureStats = struct {
+	sync.Mutex
+	pots map[string]*CaptureStats
+}{pots: make(map[string]*CaptureStats)}
+
+// ReadCaptureStats returns the packet counters of every pot captured so far.
+func ReadCaptureStats() map[string]CaptureStats {
+	captureStats.Lock()
+	defer captureStats.Unlock()
+
+	stats := make(map[string]CaptureStats)
+	for potName, value := range captureStats.pots {
+		stats[potName] = *value
+	}
+	return stats
+}
+
+// recordCaptureStats adds the handle counters grown since last to the pot totals.
+func recordCaptureStats(potName string, handle *pcap.Handle, last *pcap.Stats) {
+	current, err := handle.Stats()
+	if err != nil {
+		return
+	}
+
+	captureStats.Lock()
+	defer captureStats.Unlock()
+
+	total, found := captureStats.pots[potName]
+	if !found {
+		total = &CaptureStats{}
+		captureStats.pots[potName] = total
+	}
+	total.Received += int64(current.PacketsReceived - last.PacketsReceived)
+	total.Dropped += int64(current.PacketsDropped - last.PacketsDropped)
+	total.InterfaceDropped += int64(current.PacketsIfDropped - last.PacketsIfDropped)
+
+	*last = *current
+}
+
+// StartCapture begins writing the pot network traffic to fileName until StopCapture is called.
+func StartCapture(fileName string, network PotNetwork) error {
+	captures.Lock()
+	defer captures.Unlock()
+
+	if _, found := captures.pots[network.Name]; found {
+		return fmt.Errorf("%s pot is already capturing", network.Name)
+	}
+
+	current := &capture{
+		fileName: fileName,
+		network:  network,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	captures.pots[network.Name] = current
+
+	go func() {
+		defer close(current.done)
+
+		if err := DumpNetwork(current.stop, fileName, network); err != nil {
+			log.Printf("error while capturing %s packet - %s", network.Name, err)
+		}
+
+		captures.Lock()
+		if captures.pots[network.Name] == current {
+			delete(captures.pots, network.Name)
+		}
+		captures.Unlock()
+	}()
+
+	return nil
+}
+
+// StopCapture stops the running capture of the pot, waits for the file to be closed
+// and reports whether one was running.
+func StopCapture(potName string) bool {
+	captures.Lock()
+	current, found := captures.pots[potName]
+	if found {
+		delete(captures.pots, potName)
+	}
+	captures.Unlock()
+
+	if !found {
+		return false
+	}
+
+	close(current.stop)
+	<-current.done
+	return true
+}
+
+// RotateCapture moves the capture file of the pot to destination and continues capturing into a new file.
+func RotateCapture(potName string, destination string) error {
+	captures.Lock()
+	current, found := captures.pots[potName]
+	captures.Unlock()
+
+	if !found {
+		return fmt.Errorf("%s pot is not capturing", potName)
+	}
+
+	StopCapture(potName)
+
+	if err := os.Rename(current.fileName, destination); err != nil {
+		return err
+	}
+
+	return StartCapture(current.fileName, current.network)
+}
+
+// IsCapturing reports whether a capture is running for the pot.
+func IsCapturing(potName string) bool {
+	captures.Lock()
+	defer captures.Unlock()
+
+	_, found := captures.pots[potName]
+	return found
+}
+
+func DumpNetwork(stopCapture <-chan struct{}, fileName string, network PotNetwork) error {
+	if network.Interface == "" {
+		return errors.New("capture interface not found")
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
 	defer f.Close()
 
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(1024, layers.LinkTypeEthernet); err != nil {
+		return err
+	}
+
 	// Open the device for capturing
-	interfaceName := fmt.Sprintf("br-%s", network.ID[:12])
-	handle, err := pcap.OpenLive(interfaceName, 1024, false, -1*time.Second)
+	handle, err := openCaptureHandle(network)
 	if err != nil {
-		fmt.Printf("Error opening device %s: %v", interfaceName, err)
-		os.Exit(1)
+		return fmt.Errorf("error opening device %s: %v", network.Interface, err)
 	}
 	defer handle.Close()
 
 	var packetCount int64 = 0
+	subnets := parseSubnets(network.Subnets)
+	seenFlows := make(map[string]struct{})
+
+	var lastStats pcap.Stats
+	statsTicker := time.NewTicker(10 * time.Second)
+	defer statsTicker.Stop()
 
 	// Start processing packets
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	packets := packetSource.Packets()
 	for {
 		select {
-		case packet := <-packetSource.Packets():
+		case packet, ok := <-packets:
+			if !ok {
+				recordCaptureStats(network.Name, handle, &lastStats)
+				return nil
+			}
 			_ = w.WritePacket(packet.Metadata().CaptureInfo, packet.Data())
 			packetCount++
-		case message := <-stopCapture:
-			if message == network.Name {
-				log.Printf("stop capturing %s packet.", network.Name)
-				break
+			inspectPacket(network.Name, subnets, seenFlows, packet)
+		case <-statsTicker.C:
+			recordCaptureStats(network.Name, handle, &lastStats)
+		case <-stopCapture:
+			recordCaptureStats(network.Name, handle, &lastStats)
+			log.Printf("stop capturing %s packet. (%d packets)", network.Name, packetCount)
+			return nil
+		}
+	}
+}
+
+// openCaptureHandle opens the interface of the network, in its network namespace if it has one.
+func openCaptureHandle(network PotNetwork) (*pcap.Handle, error) {
+	if network.Namespace == "" {
+		return pcap.OpenLive(network.Interface, 1024, false, time.Second)
+	}
+
+	var handle *pcap.Handle
+	err := inNetworkNamespace(network.Namespace, func() error {
+		var err error
+		handle, err = pcap.OpenLive(network.Interface, 1024, false, time.Second)
+		return err
+	})
+	return handle, err
+}
+
+// findSubnetInterface returns the host interface with an address in one of the subnets, the
+// bridge of a network holding its gateway address.
+func findSubnetInterface(subnets []string) (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	networks := parseSubnets(subnets)
+	for _, networkInterface := range interfaces {
+		addresses, err := networkInterface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if ipNet, ok := address.(*net.IPNet); ok && containsIP(networks, ipNet.IP) {
+				return networkInterface.Name, nil
 			}
-		default:
 		}
 	}
+	return "", errors.New("interface of network not found")
+}
+
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var privateSubnets = parseSubnets([]string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"})
+
+func parseSubnets(values []string) []*net.IPNet {
+	var subnets []*net.IPNet
+	for _, value := range values {
+		if _, subnet, err := net.ParseCIDR(value); err == nil {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+// inspectPacket publishes connection events for TCP handshakes and new UDP flows crossing the pot network.
+func inspectPacket(potName string, subnets []*net.IPNet, seenFlows map[string]struct{}, packet gopacket.Packet) {
+	if event, found := readPacketEvent(potName, subnets, seenFlows, packet); found {
+		PublishEvent(event)
+	}
+}
+
+func readPacketEvent(potName string, subnets []*net.IPNet, seenFlows map[string]struct{}, packet gopacket.Packet) (Event, bool) {
+	if len(subnets) == 0 {
+		return Event{}, false
+	}
+
+	var srcIP, dstIP net.IP
+	if ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+	} else if ipLayer, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+		srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+	} else {
+		return Event{}, false
+	}
+
+	var protocol string
+	var srcPort, dstPort int
+
+	if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		if !tcp.SYN || tcp.ACK {
+			return Event{}, false
+		}
+		protocol, srcPort, dstPort = "tcp", int(tcp.SrcPort), int(tcp.DstPort)
+	} else if udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		flow := fmt.Sprintf("%s:%d-%s:%d", srcIP, udp.SrcPort, dstIP, udp.DstPort)
+		if _, found := seenFlows[flow]; found {
+			return Event{}, false
+		}
+		if len(seenFlows) > 4096 {
+			for key := range seenFlows {
+				delete(seenFlows, key)
+			}
+		}
+		seenFlows[flow] = struct{}{}
+		protocol, srcPort, dstPort = "udp", int(udp.SrcPort), int(udp.DstPort)
+	} else {
+		return Event{}, false
+	}
+
+	fromPot, toPot := containsIP(subnets, srcIP), containsIP(subnets, dstIP)
+
+	event := Event{
+		Time:            packet.Metadata().Timestamp,
+		Pot:             potName,
+		Protocol:        protocol,
+		SourceIP:        srcIP.String(),
+		SourcePort:      srcPort,
+		DestinationIP:   dstIP.String(),
+		DestinationPort: dstPort,
+	}
+
+	if fromPot && !toPot {
+		event.Kind = EventOutbound
+		event.Severity = SeverityHigh
+		event.Message = fmt.Sprintf("outbound %s connection to %s:%d", protocol, dstIP, dstPort)
+	} else if toPot && !fromPot {
+		event.Kind = EventConnection
+		event.Severity = SeverityLow
+		event.Message = fmt.Sprintf("inbound %s connection from %s to port %d", protocol, srcIP, dstPort)
+	} else {
+		return Event{}, false
+	}
+
+	return event, true
 }
@@ -0,0 +1,326 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier delivers fired alerts to people or other systems.
+type Notifier interface {
+	Name() string
+	Notify(alert Alert) error
+}
+
+// NotifierConfig is one notifier of the alert config. Fields not used by the type are ignored.
+type NotifierConfig struct {
+	Name     string            `yaml:"name"`
+	Type     string            `yaml:"type"`     // webhook, slack, smtp or syslog
+	URL      string            `yaml:"url"`      // webhook, slack
+	Headers  map[string]string `yaml:"headers"`  // webhook
+	Channel  string            `yaml:"channel"`  // slack
+	Address  string            `yaml:"address"`  // smtp host:port, syslog host:port
+	Network  string            `yaml:"network"`  // syslog udp or tcp
+	Tag      string            `yaml:"tag"`      // syslog
+	From     string            `yaml:"from"`     // smtp
+	To       []string          `yaml:"to"`       // smtp
+	Username string            `yaml:"username"` // smtp
+	Password string            `yaml:"password"` // smtp
+	Timeout  time.Duration     `yaml:"timeout"`
+}
+
+var notifierTypes = struct {
+	sync.RWMutex
+	factories map[string]func(NotifierConfig) (Notifier, error)
+}{factories: make(map[string]func(NotifierConfig) (Notifier, error))}
+
+func init() {
+	RegisterNotifierType("webhook", newWebhookNotifier)
+	RegisterNotifierType("slack", newSlackNotifier)
+	RegisterNotifierType("smtp", newSMTPNotifier)
+	RegisterNotifierType("syslog", newSyslogNotifier)
+}
+
+// RegisterNotifierType makes a notifier type available to alert configs.
+func RegisterNotifierType(name string, factory func(NotifierConfig) (Notifier, error)) {
+	notifierTypes.Lock()
+	defer notifierTypes.Unlock()
+
+	notifierTypes.factories[name] = factory
+}
+
+func ListNotifierTypes() []string {
+	notifierTypes.RLock()
+	defer notifierTypes.RUnlock()
+
+	var names []string
+	for name := range notifierTypes.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	notifierTypes.RLock()
+	factory, found := notifierTypes.factories[config.Type]
+	notifierTypes.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("unknown %s notifier type. available types: %s", config.Type, strings.Join(ListNotifierTypes(), ", "))
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return factory(config)
+}
+
+func postJSON(client *http.Client, url string, headers map[string]string, value interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+// webhookNotifier posts the alert as JSON.
+type webhookNotifier struct {
+	config NotifierConfig
+	client *http.Client
+}
+
+func newWebhookNotifier(config NotifierConfig) (Notifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("url required")
+	}
+	return webhookNotifier{config: config, client: &http.Client{Timeout: config.Timeout}}, nil
+}
+
+func (n webhookNotifier) Name() string {
+	return n.config.Name
+}
+
+func (n webhookNotifier) Notify(alert Alert) error {
+	return postJSON(n.client, n.config.URL, n.config.Headers, alert)
+}
+
+// slackNotifier posts the alert message to a Slack compatible incoming webhook.
+type slackNotifier struct {
+	config NotifierConfig
+	client *http.Client
+}
+
+func newSlackNotifier(config NotifierConfig) (Notifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("url required")
+	}
+	return slackNotifier{config: config, client: &http.Client{Timeout: config.Timeout}}, nil
+}
+
+func (n slackNotifier) Name() string {
+	return n.config.Name
+}
+
+func (n slackNotifier) Notify(alert Alert) error {
+	payload := map[string]string{"text": formatAlertText(alert)}
+	if n.config.Channel != "" {
+		payload["channel"] = n.config.Channel
+	}
+	return postJSON(n.client, n.config.URL, nil, payload)
+}
+
+// formatAlertText is the alert message followed by a line for each sampled event.
+func formatAlertText(alert Alert) string {
+	lines := []string{alert.Message}
+	for _, event := range alert.Events {
+		line := fmt.Sprintf("%s %s %s", event.Time.UTC().Format(time.RFC3339), event.Pot, event.Kind)
+		if event.SourceIP != "" {
+			line += " from " + event.SourceIP
+		}
+		if event.Message != "" {
+			line += " - " + event.Message
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// smtpNotifier mails the alert, authenticating when a username is given and using STARTTLS
+// when the server offers it.
+type smtpNotifier struct {
+	config NotifierConfig
+}
+
+func newSMTPNotifier(config NotifierConfig) (Notifier, error) {
+	if config.Address == "" || config.From == "" || len(config.To) == 0 {
+		return nil, errors.New("address, from and to required")
+	}
+	return smtpNotifier{config: config}, nil
+}
+
+func (n smtpNotifier) Name() string {
+	return n.config.Name
+}
+
+func (n smtpNotifier) Notify(alert Alert) error {
+	host, _, err := net.SplitHostPort(n.config.Address)
+	if err != nil {
+		return err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(alert.Message))
+	fmt.Fprintf(&message, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.Replace(formatAlertText(alert), "\n", "\r\n", -1))
+	message.WriteString("\r\n")
+
+	// smtp.SendMail has no timeout, so the whole conversation runs under the deadline
+	conn, err := net.DialTimeout("tcp", n.config.Address, n.config.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(n.config.Timeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.config.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("server does not support AUTH")
+		}
+		if err := client.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	for _, to := range n.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// syslogNotifier writes the alert message to a remote syslog daemon, or the local one without
+// an address.
+type syslogNotifier struct {
+	config NotifierConfig
+}
+
+func newSyslogNotifier(config NotifierConfig) (Notifier, error) {
+	if config.Network == "" {
+		config.Network = "udp"
+	}
+	if config.Tag == "" {
+		config.Tag = "honeypot"
+	}
+	return syslogNotifier{config: config}, nil
+}
+
+func (n syslogNotifier) Name() string {
+	return n.config.Name
+}
+
+// dial connects to the syslog daemon. log/syslog has no timeout, so the notifier writes the
+// same messages itself.
+func (n syslogNotifier) dial() (net.Conn, bool, error) {
+	if n.config.Address != "" {
+		conn, err := net.DialTimeout(n.config.Network, n.config.Address, n.config.Timeout)
+		return conn, false, err
+	}
+
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			if conn, err := net.DialTimeout(network, path, n.config.Timeout); err == nil {
+				return conn, true, nil
+			}
+		}
+	}
+	return nil, true, errors.New("local syslog not found")
+}
+
+func (n syslogNotifier) Notify(alert Alert) error {
+	conn, local, err := n.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(n.config.Timeout))
+
+	priority := syslog.LOG_DAEMON
+	switch {
+	case alert.Severity >= SeverityCritical:
+		priority |= syslog.LOG_CRIT
+	case alert.Severity >= SeverityHigh:
+		priority |= syslog.LOG_ERR
+	case alert.Severity >= SeverityMedium:
+		priority |= syslog.LOG_WARNING
+	default:
+		priority |= syslog.LOG_NOTICE
+	}
+
+	message := strings.TrimRight(alert.Message, "\n")
+	if local {
+		_, err = fmt.Fprintf(conn, "<%d>%s %s[%d]: %s\n", priority, time.Now().Format(time.Stamp), n.config.Tag, os.Getpid(), message)
+	} else {
+		hostname, _ := os.Hostname()
+		_, err = fmt.Fprintf(conn, "<%d>%s %s %s[%d]: %s\n", priority, time.Now().Format(time.RFC3339), hostname, n.config.Tag, os.Getpid(), message)
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/docker/docker/client"
+)
+
+var rootlessSocketPattern = regexp.MustCompile(`^unix://(/run/user/[0-9]+)/`)
+
+// rootlessNamespacePatterns are where podman 5 and podman 4 keep the network namespace of the
+// networks of rootless containers, under the runtime directory of the user.
+var rootlessNamespacePatterns = []string{
+	"containers/networks/rootless-netns/rootless-netns",
+	"netns/rootless-netns*",
+}
+
+// PodmanRuntime runs pots through the docker compatible API of podman, which serves pots like
+// a docker daemon except for the bridges of networks, export and checkpoints.
+type PodmanRuntime struct {
+	DockerRuntime
+	// RuntimeDir is the runtime directory of the user of rootless podman, empty for rootful.
+	RuntimeDir string
+}
+
+// podmanURL returns the url of the podman service, CONTAINER_HOST or the socket of the user.
+func podmanURL() string {
+	if url := os.Getenv("CONTAINER_HOST"); url != "" {
+		return url
+	}
+	if os.Geteuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Geteuid())
+	}
+	return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+}
+
+// NewPodmanRuntime runs pots with the client of the podman service at url, rootless when it is
+// the socket of a user.
+func NewPodmanRuntime(cli *client.Client, url string) PodmanRuntime {
+	runtime := PodmanRuntime{DockerRuntime: DockerRuntime{Client: cli}}
+	if match := rootlessSocketPattern.FindStringSubmatch(url); match != nil {
+		runtime.RuntimeDir = match[1]
+	}
+	return runtime
+}
+
+func (PodmanRuntime) Name() string {
+	return RuntimePodman
+}
+
+// ContainerExport exports the container, copying its root directory out instead when podman
+// fails to export it, as it exports from a mount of the container it cannot always make.
+func (p PodmanRuntime) ContainerExport(context context.Context, containerId string) (io.ReadCloser, error) {
+	responseBody, err := p.Client.ContainerExport(context, containerId)
+	if err == nil {
+		return responseBody, nil
+	}
+
+	responseBody, _, copyErr := p.Client.CopyFromContainer(context, containerId, "/")
+	if copyErr != nil {
+		return nil, fmt.Errorf("%s, %s", err, copyErr)
+	}
+	return responseBody, nil
+}
+
+// ReadPotNetworks returns the pot networks with their bridges. Podman 4 and later report the
+// bridge of a network, the cni bridge of older podman is found by the gateway address it holds.
+// Bridges of rootless podman are in its rootless network namespace.
+func (p PodmanRuntime) ReadPotNetworks(context context.Context) (map[string]PotNetwork, error) {
+	networks, err := ReadAllPotNetworks(context, p.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespace string
+	if p.RuntimeDir != "" {
+		if namespace, err = findRootlessNamespace(p.RuntimeDir); err != nil {
+			return nil, err
+		}
+	}
+
+	potNetworks := make(map[string]PotNetwork)
+	for id, network := range networks {
+		potNetwork := readPotNetwork(network)
+		potNetwork.Namespace = namespace
+		if potNetwork.Interface == "" && namespace == "" {
+			potNetwork.Interface, _ = findSubnetInterface(potNetwork.Subnets)
+		}
+		potNetworks[id] = potNetwork
+	}
+	return potNetworks, nil
+}
+
+// findRootlessNamespace returns the path of the rootless network namespace of podman.
+func findRootlessNamespace(runtimeDir string) (string, error) {
+	for _, pattern := range rootlessNamespacePatterns {
+		if matches, _ := filepath.Glob(filepath.Join(runtimeDir, pattern)); len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	return "", errors.New("rootless network namespace of podman not found")
+}
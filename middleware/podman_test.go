@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestReadPotNetworks(t *testing.T) {
+	standIn := &dockerStandIn{networks: []types.NetworkResource{
+		{
+			ID:      "3f2a9c1d7e5b4a8f9c0d1e2f3a4b5c6d",
+			Name:    "ssh",
+			Labels:  map[string]string{"pot.name": "ssh"},
+			Options: map[string]string{bridgeNameOption: "podman3"},
+			IPAM:    network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.89.2.0/24"}}},
+		},
+		{
+			ID:     "7b1e4d2c9a0f8e3d6c5b4a3f2e1d0c9b",
+			Name:   "web",
+			Labels: map[string]string{"pot.name": "web"},
+			IPAM:   network.IPAM{Config: []network.IPAMConfig{{Subnet: "198.18.77.0/24"}}},
+		},
+		{ID: "0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d", Name: "bridge"},
+	}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	cli, err := Host{Name: "seoul", URL: "tcp://" + server.Listener.Addr().String()}.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks, err := DockerRuntime{Client: cli}.ReadPotNetworks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 2 {
+		t.Errorf("pot networks not match\nexpected: 2, actual: %d", len(networks))
+	}
+	if value := networks["3f2a9c1d7e5b4a8f9c0d1e2f3a4b5c6d"]; value.Interface != "podman3" || value.Subnets[0] != "10.89.2.0/24" {
+		t.Errorf("named bridge not match\nexpected: podman3, actual: %+v", value)
+	}
+	if value := networks["7b1e4d2c9a0f8e3d6c5b4a3f2e1d0c9b"].Interface; value != "br-7b1e4d2c9a0f" {
+		t.Errorf("docker bridge not match\nexpected: br-7b1e4d2c9a0f, actual: %s", value)
+	}
+
+	runtime := NewPodmanRuntime(cli, "tcp://"+server.Listener.Addr().String())
+	networks, err = runtime.ReadPotNetworks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := networks["7b1e4d2c9a0f8e3d6c5b4a3f2e1d0c9b"].Interface; value != "" {
+		t.Errorf("podman bridge without gateway not match\nexpected: none, actual: %s", value)
+	}
+
+	runtimeDir, err := ioutil.TempDir("", "podman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runtimeDir)
+
+	runtime.RuntimeDir = runtimeDir
+	if _, err := runtime.ReadPotNetworks(context.Background()); err == nil {
+		t.Errorf("rootless namespace not match\nexpected: error, actual: nil")
+	}
+
+	namespace := filepath.Join(runtimeDir, "netns", "rootless-netns-5a1f")
+	_ = os.MkdirAll(filepath.Dir(namespace), os.ModePerm)
+	if err := ioutil.WriteFile(namespace, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	networks, err = runtime.ReadPotNetworks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := networks["3f2a9c1d7e5b4a8f9c0d1e2f3a4b5c6d"]; value.Interface != "podman3" || value.Namespace != namespace {
+		t.Errorf("rootless bridge not match\nexpected: podman3 in %s, actual: %+v", namespace, value)
+	}
+}
+
+func TestPodmanRuntime(t *testing.T) {
+	if runtime := NewPodmanRuntime(nil, "unix:///run/user/1000/podman/podman.sock"); runtime.RuntimeDir != "/run/user/1000" {
+		t.Errorf("rootless runtime dir not match\nexpected: /run/user/1000, actual: %s", runtime.RuntimeDir)
+	}
+	if runtime := NewPodmanRuntime(nil, "unix:///run/podman/podman.sock"); runtime.RuntimeDir != "" {
+		t.Errorf("rootful runtime dir not match\nexpected: none, actual: %s", runtime.RuntimeDir)
+	}
+
+	server := httptest.NewServer(&dockerStandIn{archive: []byte("root directory")})
+	defer server.Close()
+
+	cli, err := Host{Name: "seoul", URL: "tcp://" + server.Listener.Addr().String()}.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := NewPodmanRuntime(cli, "tcp://"+server.Listener.Addr().String())
+	if runtime.Name() != RuntimePodman {
+		t.Errorf("runtime name not match\nexpected: podman, actual: %s", runtime.Name())
+	}
+
+	responseBody, err := runtime.ContainerExport(context.Background(), "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responseBody.Close()
+
+	data, _ := ioutil.ReadAll(responseBody)
+	if string(data) != "root directory" {
+		t.Errorf("exported container not match\nexpected: root directory, actual: %s", data)
+	}
+}
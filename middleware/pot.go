@@ -8,10 +8,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -28,7 +32,7 @@ type Pot struct {
 func writeFile(buffer io.ReadCloser, fileName string) error {
 	outFile, err := os.Create(fileName)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer outFile.Close()
 
@@ -65,7 +69,7 @@ func tarRepository() (io.Reader, error) {
 	return &buffer, nil
 }
 
-func MakeNewPot(context context.Context, client *client.Client, potName string, imageName string, potPorts []string, potDockerfile string, potEnvironments []string) (Pot, error) {
+func MakeNewPot(context context.Context, client *client.Client, potName string, imageName string, potPorts []string, potDockerfile string, potEnvironments []string, potLabels map[string]string) (Pot, error) {
 	if potName == "" {
 		return Pot{}, errors.New("pot name not found")
 	}
@@ -75,6 +79,9 @@ func MakeNewPot(context context.Context, client *client.Client, potName string,
 	}
 
 	var labels = make(map[string]string)
+	for key, value := range potLabels {
+		labels[key] = value
+	}
 	labels["pot.name"] = potName
 
 	potNetwork, err := client.NetworkCreate(context, potName, types.NetworkCreate{CheckDuplicate: true, Labels: labels})
@@ -82,7 +89,7 @@ func MakeNewPot(context context.Context, client *client.Client, potName string,
 		return Pot{}, err
 	}
 
-	if dupCheck := IsExistPotName(context, client, potName); dupCheck {
+	if dupCheck := IsExistPotName(context, DockerRuntime{Client: client}, potName); dupCheck {
 		return Pot{}, errors.New("pot name already exist")
 	}
 
@@ -147,40 +154,28 @@ func MakeNewPot(context context.Context, client *client.Client, potName string,
 	}, nil
 }
 
-func RemoveAllPots(context context.Context, client *client.Client) bool {
-	pots, err := ReadAllPots(context, client)
+func RemoveAllPots(context context.Context, runtime Runtime) bool {
+	pots, err := ReadAllPots(context, runtime)
 	if err != nil {
 		return false
 	}
 
 	for _, pot := range pots {
-		for _, container := range pot.Containers {
-			_ = client.ContainerRemove(context, container.ID, types.ContainerRemoveOptions{Force: true})
-		}
-		_ = client.NetworkRemove(context, pot.Name)
+		_ = runtime.RemovePot(context, pot)
 	}
 
 	return true
 }
 
-func RemovePot(context context.Context, client *client.Client, potName string) bool {
-	pot, _ := ReadPot(context, client, potName)
-
-	for _, container := range pot.Containers {
-		err := client.ContainerRemove(context, container.ID, types.ContainerRemoveOptions{Force: true})
-		if err != nil {
-			return false
-		}
-	}
-
-	// remove network after delete all containers
-	_ = client.NetworkRemove(context, potName)
+func RemovePot(context context.Context, runtime Runtime, potName string) bool {
+	pot, _ := ReadPot(context, runtime, potName)
+	pot.Name = potName
 
-	return true
+	return runtime.RemovePot(context, pot) == nil
 }
 
-func ReadPot(context context.Context, client *client.Client, potName string) (Pot, error) {
-	containers, err := client.ContainerList(context, types.ContainerListOptions{All: true})
+func ReadPot(context context.Context, runtime Runtime, potName string) (Pot, error) {
+	containers, err := runtime.ListContainers(context)
 	if err != nil {
 		return Pot{}, err
 	}
@@ -202,8 +197,8 @@ func ReadPot(context context.Context, client *client.Client, potName string) (Po
 	return Pot{}, errors.New("pot not found")
 }
 
-func IsExistPotName(context context.Context, client *client.Client, potName string) bool {
-	pots, err := ReadAllPots(context, client)
+func IsExistPotName(context context.Context, runtime Runtime, potName string) bool {
+	pots, err := ReadAllPots(context, runtime)
 	if err != nil {
 		return true
 	}
@@ -217,9 +212,9 @@ func IsExistPotName(context context.Context, client *client.Client, potName stri
 	return false
 }
 
-func ReadAllPots(context context.Context, client *client.Client) ([]Pot, error) {
+func ReadAllPots(context context.Context, runtime Runtime) ([]Pot, error) {
 	var pots []Pot
-	containers, err := client.ContainerList(context, types.ContainerListOptions{All: true})
+	containers, err := runtime.ListContainers(context)
 	if err != nil {
 		return []Pot{{}}, err
 	}
@@ -244,16 +239,20 @@ func ReadAllPots(context context.Context, client *client.Client) ([]Pot, error)
 	return pots, nil
 }
 
-func ReadAllPotStatus(context context.Context, client *client.Client) (map[string]types.ContainerStats, error) {
+func ReadAllPotStatus(context context.Context, runtime Runtime) (map[string]types.ContainerStats, error) {
 	potStatusMap := make(map[string]types.ContainerStats)
-	pots, err := ReadAllPots(context, client)
+	pots, err := ReadAllPots(context, runtime)
 	if err != nil {
 		return potStatusMap, err
 	}
 
 	for _, pot := range pots {
 		for _, container := range pot.Containers {
-			stats, _ := client.ContainerStats(context, container.ID, false)
+			stats, err := runtime.ContainerStats(context, container.ID)
+			if err != nil {
+				// stats not served by every runtime
+				continue
+			}
 			potStatusMap[pot.Name] = stats
 		}
 	}
@@ -261,14 +260,14 @@ func ReadAllPotStatus(context context.Context, client *client.Client) (map[strin
 	return potStatusMap, nil
 }
 
-func ReadPotStatus(context context.Context, client *client.Client, potName string) (types.ContainerStats, error) {
-	pot, err := ReadPot(context, client, potName)
+func ReadPotStatus(context context.Context, runtime Runtime, potName string) (types.ContainerStats, error) {
+	pot, err := ReadPot(context, runtime, potName)
 	if err != nil {
 		return types.ContainerStats{}, err
 	}
 
 	for _, container := range pot.Containers {
-		stats, err := client.ContainerStats(context, container.ID, false)
+		stats, err := runtime.ContainerStats(context, container.ID)
 		return stats, err
 	}
 
@@ -315,6 +314,10 @@ func RestartCleanPot(context context.Context, client *client.Client, prevContain
 
 	var potPorts []string
 	for _, portMapping := range prevContainer.Ports {
+		if portMapping.PublicPort == 0 {
+			// not published by docker
+			continue
+		}
 		port := fmt.Sprintf("%d:%d", portMapping.PublicPort, portMapping.PrivatePort)
 		potPorts = append(potPorts, port)
 	}
@@ -354,22 +357,186 @@ func RestartCleanPot(context context.Context, client *client.Client, prevContain
 		return err
 	}
 
-	err = client.ContainerStart(context, response.ID, types.ContainerStartOptions{})
+	_, err = StartPotContainer(context, client, response.ID, prevContainer.Labels)
 	return err
 }
 
-func CollectContainerLog(context context.Context, client *client.Client, containerId string, fileName string) error {
-	responseBody, err := client.ContainerLogs(context, containerId, types.ContainerLogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-	})
+// CanSwapPot reports whether the container can be replaced without downtime, which needs
+// its ports to be served by the ingress proxy or no published ports at all.
+func CanSwapPot(prevContainer types.Container) bool {
+	if IsIngressPot(prevContainer.Labels) {
+		return true
+	}
+
+	for _, portMapping := range prevContainer.Ports {
+		if portMapping.PublicPort != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SwapCleanPot starts a clean replacement of prevContainer, waits for it to become healthy,
+// switches the pot ingress over and pauses prevContainer for collection.
+// The caller removes prevContainer once collected. It returns the replacement container ID, and
+// leaves no replacement running when it fails.
+func SwapCleanPot(context context.Context, client *client.Client, prevContainer types.Container, pot Pot, healthTimeout time.Duration) (string, error) {
+	var potNetwork = prevContainer.NetworkSettings.Networks[pot.Name]
+	if potNetwork == nil {
+		return "", fmt.Errorf("%s pot network not found", pot.Name)
+	}
+
+	var endpointsConfig = make(map[string]*network.EndpointSettings)
+	endpointsConfig[pot.Name] = &network.EndpointSettings{NetworkID: potNetwork.NetworkID}
+
+	containerInfo, err := client.ContainerInspect(context, prevContainer.ID)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := client.ContainerCreate(context,
+		&container.Config{
+			Image:        prevContainer.Image,
+			Labels:       prevContainer.Labels,
+			ExposedPorts: containerInfo.Config.ExposedPorts,
+			Tty:          true,
+			Env:          containerInfo.Config.Env,
+		},
+		&container.HostConfig{},
+		&network.NetworkingConfig{
+			EndpointsConfig: endpointsConfig,
+		},
+		"",
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := StartPotContainer(context, client, response.ID, prevContainer.Labels); err != nil {
+		_ = client.ContainerRemove(context, response.ID, types.ContainerRemoveOptions{Force: true})
+		return "", err
+	}
+
+	var ports []int
+	if IsIngressPot(prevContainer.Labels) {
+		ingressPorts, _ := ParseIngressPorts(strings.Split(prevContainer.Labels[IngressLabel], ","))
+		for _, port := range ingressPorts {
+			ports = append(ports, port.ContainerPort)
+		}
+	}
+
+	ipAddress, err := WaitPotHealthy(context, client, response.ID, pot.Name, ports, healthTimeout)
+	if err != nil {
+		_ = client.ContainerRemove(context, response.ID, types.ContainerRemoveOptions{Force: true})
+		return "", err
+	}
+
+	if IsIngressPot(prevContainer.Labels) {
+		if err := SwitchIngress(pot.Name, ipAddress); err != nil {
+			log.Printf("%s pot ingress not switched - %s", pot.Name, err)
+		}
+	}
+
+	if err := client.ContainerPause(context, prevContainer.ID); err != nil {
+		// prevContainer keeps serving the pot, so the replacement is undone
+		if IsIngressPot(prevContainer.Labels) {
+			if prevAddress, addressErr := ReadContainerAddress(context, client, prevContainer.ID, pot.Name); addressErr == nil {
+				_ = SwitchIngress(pot.Name, prevAddress)
+			}
+		}
+		_ = client.ContainerRemove(context, response.ID, types.ContainerRemoveOptions{Force: true})
+		return "", err
+	}
+
+	return response.ID, nil
+}
+
+// WaitPotHealthy waits until the container runs, passes its image health check and accepts
+// TCP connections on ports. It returns the container address on the pot network.
+func WaitPotHealthy(context context.Context, client *client.Client, containerId string, potName string, ports []int, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ipAddress, err := checkPotHealth(context, client, containerId, potName, ports)
+		if err == nil {
+			return ipAddress, nil
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("container %s not healthy - %s", containerId[:12], err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func checkPotHealth(context context.Context, client *client.Client, containerId string, potName string, ports []int) (string, error) {
+	containerInfo, err := client.ContainerInspect(context, containerId)
+	if err != nil {
+		return "", err
+	}
+
+	if containerInfo.State == nil || !containerInfo.State.Running {
+		return "", errors.New("container not running")
+	}
+
+	if health := containerInfo.State.Health; health != nil && health.Status != types.Healthy {
+		return "", fmt.Errorf("health check %s", health.Status)
+	}
+
+	var ipAddress string
+	if settings := containerInfo.NetworkSettings; settings != nil {
+		if endpoint := settings.Networks[potName]; endpoint != nil {
+			ipAddress = endpoint.IPAddress
+		}
+	}
+	if ipAddress == "" {
+		return "", errors.New("container address not assigned")
+	}
+
+	for _, port := range ports {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ipAddress, strconv.Itoa(port)), time.Second)
+		if err != nil {
+			return "", err
+		}
+		_ = conn.Close()
+	}
+
+	return ipAddress, nil
+}
+
+// ReadContainerAddress returns the address of the container on the pot network.
+func ReadContainerAddress(context context.Context, client *client.Client, containerId string, potName string) (string, error) {
+	containerInfo, err := client.ContainerInspect(context, containerId)
+	if err != nil {
+		return "", err
+	}
+
+	if settings := containerInfo.NetworkSettings; settings != nil {
+		if endpoint := settings.Networks[potName]; endpoint != nil && endpoint.IPAddress != "" {
+			return endpoint.IPAddress, nil
+		}
+	}
+
+	return "", errors.New("container address not found")
+}
+
+func RemovePotContainer(context context.Context, client *client.Client, containerId string) error {
+	return client.ContainerRemove(context, containerId, types.ContainerRemoveOptions{Force: true})
+}
+
+func CollectContainerLog(context context.Context, runtime Runtime, containerId string, fileName string) error {
+	responseBody, err := runtime.ContainerLogs(context, containerId, time.Time{})
+	if err != nil {
+		return err
+	}
+	defer responseBody.Close()
 
 	err = writeFile(responseBody, fileName)
 	return err
 }
 
-func CollectContainerDiff(context context.Context, client *client.Client, containerId string, fileName string) error {
-	diff, err := client.ContainerDiff(context, containerId)
+func CollectContainerDiff(context context.Context, runtime Runtime, containerId string, fileName string) error {
+	diff, err := runtime.ContainerDiff(context, containerId)
 	if err != nil {
 		return err
 	}
@@ -382,6 +549,8 @@ func CollectContainerDiff(context context.Context, client *client.Client, contai
 			path = fmt.Sprintf("C %s", event.Path)
 		} else if event.Kind == 1 {
 			path = fmt.Sprintf("A %s", event.Path)
+		} else if event.Kind == 2 {
+			path = fmt.Sprintf("D %s", event.Path)
 		}
 
 		values = append(values, path)
@@ -391,24 +560,26 @@ func CollectContainerDiff(context context.Context, client *client.Client, contai
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	_, err = file.WriteString(strings.Join(values, "\n"))
 	return err
 }
 
-func CollectContainerDump(context context.Context, client *client.Client, containerId string, fileName string) error {
-	dump, err := client.ContainerExport(context, containerId)
+func CollectContainerDump(context context.Context, runtime Runtime, containerId string, fileName string) error {
+	dump, err := runtime.ContainerExport(context, containerId)
 	if err != nil {
 		return err
 	}
-	err = writeFile(dump, fileName)
 	defer dump.Close()
 
+	err = writeFile(dump, fileName)
+
 	return err
 }
 
-func CollectContainerTop(context context.Context, client *client.Client, containerId string, fileName string) error {
-	topList, err := client.ContainerTop(context, containerId, []string{})
+func CollectContainerTop(context context.Context, runtime Runtime, containerId string, fileName string) error {
+	topList, err := runtime.ContainerTop(context, containerId)
 	if err != nil {
 		return err
 	}
@@ -417,13 +588,12 @@ func CollectContainerTop(context context.Context, client *client.Client, contain
 	if err != nil {
 		return err
 	}
+	defer fp.Close()
 
 	w := tabwriter.NewWriter(fp, 20, 1, 3, ' ', 0)
 	_, _ = fmt.Fprintln(w, strings.Join(topList.Titles, "\t"))
 	for _, proc := range topList.Processes {
 		_, _ = fmt.Fprintln(w, strings.Join(proc, "\t"))
 	}
-	_ = w.Flush()
-
-	return nil
+	return w.Flush()
 }
@@ -43,7 +43,7 @@ func TestMakeNewPot(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pot, err := MakeNewPot(ctx, cli, potName, "nginx:latest", []string{}, "", []string{})
+	pot, err := MakeNewPot(ctx, cli, potName, "nginx:latest", []string{}, "", []string{}, nil)
 	if err != nil {
 		t.Errorf("error while creating pot: %s", err)
 	}
@@ -59,7 +59,7 @@ func TestReadAllPots(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pots, err := ReadAllPots(ctx, cli)
+	pots, err := ReadAllPots(ctx, DockerRuntime{Client: cli})
 	if err != nil {
 		t.Errorf("error while reading pots: %s", err)
 	}
@@ -77,7 +77,7 @@ func TestIsExistPotName(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pot := IsExistPotName(ctx, cli, potName)
+	pot := IsExistPotName(ctx, DockerRuntime{Client: cli}, potName)
 	if pot == false {
 		t.Errorf("%s pot not found", potName)
 	}
@@ -91,7 +91,7 @@ func TestReadPot(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pot, err := ReadPot(ctx, cli, potName)
+	pot, err := ReadPot(ctx, DockerRuntime{Client: cli}, potName)
 	if err != nil {
 		t.Errorf("failed to read pot information: %s", err)
 	}
@@ -110,7 +110,7 @@ func TestReadPotStatus(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	status, err := ReadPotStatus(ctx, cli, potName)
+	status, err := ReadPotStatus(ctx, DockerRuntime{Client: cli}, potName)
 	if err != nil {
 		t.Errorf("failed to read %s pot", potName)
 	}
@@ -145,13 +145,13 @@ func TestCollectContainerDiff(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pot, err := ReadPot(ctx, cli, potName)
+	pot, err := ReadPot(ctx, DockerRuntime{Client: cli}, potName)
 	if err != nil {
 		t.Errorf("error while reading pot information - %s", err)
 	}
 
 	fileName := "tmp.container.diff"
-	err = CollectContainerDiff(ctx, cli, pot.Containers[0].ID, fileName)
+	err = CollectContainerDiff(ctx, DockerRuntime{Client: cli}, pot.Containers[0].ID, fileName)
 	if err != nil {
 		t.Errorf("error while collecting container diff log - %s", err)
 	}
@@ -170,13 +170,13 @@ func TestCollectContainerLog(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pot, err := ReadPot(ctx, cli, potName)
+	pot, err := ReadPot(ctx, DockerRuntime{Client: cli}, potName)
 	if err != nil {
 		t.Errorf("error while reading pot information - %s", err)
 	}
 
 	fileName := "tmp.container.log"
-	err = CollectContainerLog(ctx, cli, pot.Containers[0].ID, fileName)
+	err = CollectContainerLog(ctx, DockerRuntime{Client: cli}, pot.Containers[0].ID, fileName)
 	if err != nil {
 		t.Errorf("error while collecting container log - %s", err)
 	}
@@ -195,13 +195,13 @@ func TestCollectContainerDump(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pot, err := ReadPot(ctx, cli, potName)
+	pot, err := ReadPot(ctx, DockerRuntime{Client: cli}, potName)
 	if err != nil {
 		t.Errorf("error while reading pot information - %s", err)
 	}
 
 	fileName := "tmp.container.dump"
-	err = CollectContainerDump(ctx, cli, pot.Containers[0].ID, fileName)
+	err = CollectContainerDump(ctx, DockerRuntime{Client: cli}, pot.Containers[0].ID, fileName)
 	if err != nil {
 		t.Errorf("error while collecting container dump - %s", err)
 	}
@@ -220,7 +220,7 @@ func TestRestartCleanPot(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	pot, err := ReadPot(ctx, cli, potName)
+	pot, err := ReadPot(ctx, DockerRuntime{Client: cli}, potName)
 	if err != nil {
 		t.Errorf("error while reading pot information - %s", err)
 	}
@@ -237,7 +237,7 @@ func TestRemovePot(t *testing.T) {
 		t.Error("fail to retrieve docker environment")
 	}
 
-	result := RemovePot(ctx, cli, potName)
+	result := RemovePot(ctx, DockerRuntime{Client: cli}, potName)
 	if result == false {
 		t.Errorf("fail to remove %s pot", potName)
 	}
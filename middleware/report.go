@@ -0,0 +1,276 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceCredentials is the password list one source address tried, in the order it tried them.
+type SourceCredentials struct {
+	IP        string    `json:"ip"`
+	Country   string    `json:"country,omitempty"`
+	Attempts  int       `json:"attempts"`
+	Successes int       `json:"successes"`
+	Pots      []string  `json:"pots"`
+	Usernames []string  `json:"usernames"`
+	Passwords []string  `json:"passwords"`
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+}
+
+// CredentialReport aggregates the login attempts of a time range.
+type CredentialReport struct {
+	Since      time.Time           `json:"since"`
+	Until      time.Time           `json:"until"`
+	Attempts   int                 `json:"attempts"`
+	Successes  int                 `json:"successes"`
+	Sources    int                 `json:"sources"`
+	Pairs      int                 `json:"pairs"`
+	NewPairs   int                 `json:"new_pairs"`
+	Usernames  []EventCount        `json:"usernames"`
+	Passwords  []EventCount        `json:"passwords"`
+	TopPairs   []EventCount        `json:"top_pairs"`
+	FirstSeen  []EventCount        `json:"first_seen"` // pairs never tried before the range
+	SourceList []SourceCredentials `json:"source_list"`
+}
+
+// BuildCredentialReport aggregates the login events of the range. Pairs of the history, the logins
+// before the range, are seen before. Lists are cut to the top entries, 0 for all.
+func BuildCredentialReport(events []Event, history []Event, since time.Time, until time.Time, top int) CredentialReport {
+	report := CredentialReport{Since: since, Until: until}
+
+	seenBefore := make(map[string]bool)
+	for _, event := range history {
+		if key := EventKey(event, "credential"); event.Kind == EventLogin && key != "" {
+			seenBefore[key] = true
+		}
+	}
+
+	var logins []Event
+	sources := make(map[string]*SourceCredentials)
+	for _, event := range events {
+		if event.Kind != EventLogin {
+			continue
+		}
+		logins = append(logins, event)
+
+		report.Attempts++
+		succeeded := event.Fields["result"] != "failed"
+		if succeeded {
+			report.Successes++
+		}
+
+		if event.SourceIP == "" {
+			continue
+		}
+		source, found := sources[event.SourceIP]
+		if !found {
+			source = &SourceCredentials{IP: event.SourceIP, First: event.Time, Pots: []string{}, Usernames: []string{}, Passwords: []string{}}
+			sources[event.SourceIP] = source
+		}
+		if event.Geo != nil {
+			source.Country = event.Geo.CountryCode
+		}
+		source.Attempts++
+		if succeeded {
+			source.Successes++
+		}
+		source.Pots = appendUnique(source.Pots, event.Pot)
+		source.Usernames = appendUnique(source.Usernames, event.Username)
+		source.Passwords = appendUnique(source.Passwords, event.Password)
+		if event.Time.Before(source.First) {
+			source.First = event.Time
+		}
+		if event.Time.After(source.Last) {
+			source.Last = event.Time
+		}
+	}
+
+	pairs := CountEvents(logins, "credential")
+	report.Pairs = len(pairs)
+	report.Sources = len(sources)
+	report.FirstSeen = []EventCount{}
+	for _, pair := range pairs {
+		if !seenBefore[pair.Key] {
+			report.NewPairs++
+			report.FirstSeen = append(report.FirstSeen, pair)
+		}
+	}
+
+	report.Usernames = topCounts(CountEvents(logins, "username"), top)
+	report.Passwords = topCounts(CountEvents(logins, "password"), top)
+	report.TopPairs = topCounts(pairs, top)
+	report.FirstSeen = topCounts(report.FirstSeen, top)
+
+	report.SourceList = []SourceCredentials{}
+	for _, source := range sources {
+		report.SourceList = append(report.SourceList, *source)
+	}
+	sort.Slice(report.SourceList, func(i, j int) bool {
+		if report.SourceList[i].Attempts != report.SourceList[j].Attempts {
+			return report.SourceList[i].Attempts > report.SourceList[j].Attempts
+		}
+		return report.SourceList[i].IP < report.SourceList[j].IP
+	})
+	if top > 0 && len(report.SourceList) > top {
+		report.SourceList = report.SourceList[:top]
+	}
+	return report
+}
+
+// Playbook is a sequence of normalised commands typed in sessions, a recurring script of a botnet.
+type Playbook struct {
+	ID       string    `json:"id"`
+	Commands []string  `json:"commands"`
+	Example  []string  `json:"example"` // commands of the first session
+	Sessions int       `json:"sessions"`
+	Sources  []string  `json:"sources"`
+	Pots     []string  `json:"pots"`
+	URLs     []string  `json:"urls,omitempty"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// CommandReport aggregates the commands of a time range.
+type CommandReport struct {
+	Since     time.Time    `json:"since"`
+	Until     time.Time    `json:"until"`
+	Commands  int          `json:"commands"`
+	Sessions  int          `json:"sessions"`
+	Top       []EventCount `json:"top"` // normalised commands
+	Playbooks []Playbook   `json:"playbooks"`
+}
+
+var commandNormalizers = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{urlPattern, "<url>"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{16,}\b`), "<hex>"},
+	{regexp.MustCompile(`\b\d+\b`), "<n>"},
+	{regexp.MustCompile(`\s+`), " "},
+}
+
+var base64Pattern = regexp.MustCompile(`[A-Za-z0-9+/]{32,}={0,2}`)
+
+// NormalizeCommand replaces the parts of a command that change between runs of the same script,
+// urls, addresses, hashes, encoded payloads and numbers, by placeholders.
+func NormalizeCommand(command string) string {
+	// payloads mix cases and digits, long paths do not
+	command = base64Pattern.ReplaceAllStringFunc(command, func(value string) string {
+		if strings.ContainsAny(value, "0123456789") && strings.ToLower(value) != value && strings.ToUpper(value) != value {
+			return "<base64>"
+		}
+		return value
+	})
+	for _, normalizer := range commandNormalizers {
+		command = normalizer.pattern.ReplaceAllString(command, normalizer.replacement)
+	}
+	return strings.TrimSpace(command)
+}
+
+// PlaybookID names a sequence of normalised commands.
+func PlaybookID(normalized []string) string {
+	hash := sha256.Sum256([]byte(strings.Join(normalized, "\n")))
+	return hex.EncodeToString(hash[:6])
+}
+
+// sessionKey groups the commands of one shell session, by the session number the emulator logs,
+// or by pot, container and source address.
+func sessionKey(event Event) string {
+	return strings.Join([]string{event.Pot, event.Container, event.SourceIP, event.Fields["session"]}, "|")
+}
+
+// BuildCommandReport aggregates the command events of the range and clusters the sessions typing
+// the same normalised commands into playbooks, most sessions first.
+func BuildCommandReport(events []Event, since time.Time, until time.Time, top int) CommandReport {
+	report := CommandReport{Since: since, Until: until, Top: []EventCount{}, Playbooks: []Playbook{}}
+
+	type session struct {
+		events     []Event
+		normalized []string
+	}
+	var order []string
+	sessions := make(map[string]*session)
+	var normalized []Event
+	for _, event := range events {
+		if event.Kind != EventCommand || event.Command == "" {
+			continue
+		}
+		report.Commands++
+
+		key := sessionKey(event)
+		current, found := sessions[key]
+		if !found {
+			current = &session{}
+			sessions[key] = current
+			order = append(order, key)
+		}
+		current.events = append(current.events, event)
+		current.normalized = append(current.normalized, NormalizeCommand(event.Command))
+
+		event.Command = NormalizeCommand(event.Command)
+		normalized = append(normalized, event)
+	}
+	report.Sessions = len(sessions)
+	report.Top = topCounts(CountEvents(normalized, "command"), top)
+
+	playbooks := make(map[string]*Playbook)
+	for _, key := range order {
+		current := sessions[key]
+		id := PlaybookID(current.normalized)
+
+		playbook, found := playbooks[id]
+		if !found {
+			playbook = &Playbook{ID: id, Commands: current.normalized, Sources: []string{}, Pots: []string{}, First: current.events[0].Time}
+			for _, event := range current.events {
+				playbook.Example = append(playbook.Example, event.Command)
+			}
+			playbooks[id] = playbook
+		}
+
+		playbook.Sessions++
+		for _, event := range current.events {
+			playbook.Sources = appendUnique(playbook.Sources, event.SourceIP)
+			playbook.Pots = appendUnique(playbook.Pots, event.Pot)
+			for _, value := range ExtractURLs(event.Command) {
+				playbook.URLs = appendUnique(playbook.URLs, value)
+			}
+			if event.Time.Before(playbook.First) {
+				playbook.First = event.Time
+			}
+			if event.Time.After(playbook.Last) {
+				playbook.Last = event.Time
+			}
+		}
+	}
+
+	for _, playbook := range playbooks {
+		report.Playbooks = append(report.Playbooks, *playbook)
+	}
+	sort.Slice(report.Playbooks, func(i, j int) bool {
+		if report.Playbooks[i].Sessions != report.Playbooks[j].Sessions {
+			return report.Playbooks[i].Sessions > report.Playbooks[j].Sessions
+		}
+		return report.Playbooks[i].First.Before(report.Playbooks[j].First)
+	})
+	if top > 0 && len(report.Playbooks) > top {
+		report.Playbooks = report.Playbooks[:top]
+	}
+	return report
+}
+
+func topCounts(counts []EventCount, top int) []EventCount {
+	if counts == nil {
+		return []EventCount{}
+	}
+	if top > 0 && len(counts) > top {
+		return counts[:top]
+	}
+	return counts
+}
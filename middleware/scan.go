@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultScanMaxFileSize is the size above which files are not scanned.
+const DefaultScanMaxFileSize = 32 * 1024 * 1024
+
+// YaraMatch is a rule matching a file of the container export of a run, or a rule whose scan of
+// the file was incomplete.
+type YaraMatch struct {
+	Rule       string   `json:"rule"`
+	Tags       []string `json:"tags,omitempty"`
+	Strings    []string `json:"strings,omitempty"`
+	Path       string   `json:"path"`
+	SHA256     string   `json:"sha256"`
+	Size       int64    `json:"size"`
+	Incomplete bool     `json:"incomplete,omitempty"` // not matched in the part of the file searched
+}
+
+// YaraScanOptions selects the files of dump.tar that are scanned.
+type YaraScanOptions struct {
+	Export      bool  // every file of the export, not only files added or changed in container.diff
+	MaxFileSize int64 // larger files are skipped, 0 for DefaultScanMaxFileSize
+}
+
+// walkRunFiles calls fn with the content of each regular file of dump.tar of the run that is
+// added or changed in container.diff, or of every file with export. Files are streamed from the
+// archive into memory one at a time, nothing is extracted to disk.
+func walkRunFiles(runPath string, export bool, maxFileSize int64, fn func(path string, data []byte) error) error {
+	file, err := os.Open(filepath.Join(runPath, "dump.tar"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var changed map[string]bool
+	if !export {
+		if changed = readAddedPaths(filepath.Join(runPath, "container.diff")); len(changed) == 0 {
+			return nil
+		}
+	}
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultScanMaxFileSize
+	}
+
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := "/" + strings.TrimPrefix(header.Name, "./")
+		if header.Typeflag != tar.TypeReg || header.Size > maxFileSize || (changed != nil && !changed[path]) {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if err := fn(path, data); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanArtifactRun scans files of dump.tar of the run with the rules.
+func ScanArtifactRun(rules *YaraRules, runPath string, options YaraScanOptions) ([]YaraMatch, error) {
+	if rules.binary != "" {
+		return scanArtifactRunBinary(rules, runPath, options)
+	}
+
+	var matches []YaraMatch
+	err := walkRunFiles(runPath, options.Export, options.MaxFileSize, func(path string, data []byte) error {
+		ruleMatches := rules.Scan(data)
+		if len(ruleMatches) == 0 {
+			return nil
+		}
+		hash := sha256.Sum256(data)
+		for _, ruleMatch := range ruleMatches {
+			matches = append(matches, YaraMatch{
+				Rule:       ruleMatch.Rule,
+				Tags:       ruleMatch.Tags,
+				Strings:    ruleMatch.Strings,
+				Path:       path,
+				SHA256:     hex.EncodeToString(hash[:]),
+				Size:       int64(len(data)),
+				Incomplete: ruleMatch.Incomplete,
+			})
+		}
+		return nil
+	})
+
+	sortYaraMatches(matches)
+	return matches, err
+}
+
+// scanArtifactRunBinary scans files of dump.tar of the run with the yara binary, which needs them
+// on disk. They are written to a temporary directory removed after the scan.
+func scanArtifactRunBinary(rules *YaraRules, runPath string, options YaraScanOptions) ([]YaraMatch, error) {
+	dir, err := ioutil.TempDir("", "yara")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var files []YaraMatch
+	err = walkRunFiles(runPath, options.Export, options.MaxFileSize, func(path string, data []byte) error {
+		hash := sha256.Sum256(data)
+		files = append(files, YaraMatch{Path: path, SHA256: hex.EncodeToString(hash[:]), Size: int64(len(data))})
+		return ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(len(files)-1)), data, 0600)
+	})
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	var matches []YaraMatch
+	ruleMatches, err := rules.scanDirectory(dir)
+	for name, fileMatches := range ruleMatches {
+		index, parseErr := strconv.Atoi(name)
+		if parseErr != nil || index < 0 || index >= len(files) {
+			continue
+		}
+		for _, ruleMatch := range fileMatches {
+			match := files[index]
+			match.Rule, match.Tags, match.Strings = ruleMatch.Rule, ruleMatch.Tags, ruleMatch.Strings
+			matches = append(matches, match)
+		}
+	}
+
+	sortYaraMatches(matches)
+	return matches, err
+}
+
+func sortYaraMatches(matches []YaraMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Path != matches[j].Path {
+			return matches[i].Path < matches[j].Path
+		}
+		return matches[i].Rule < matches[j].Rule
+	})
+}
+
+// YaraEvents returns a high severity event for each rule match recorded in the manifest, and a
+// medium severity event for each incomplete rule.
+func YaraEvents(manifest Manifest) []Event {
+	var events []Event
+	for _, match := range manifest.YaraMatches {
+		severity, message := SeverityHigh, fmt.Sprintf("yara rule %s matched %s", match.Rule, match.Path)
+		if match.Incomplete {
+			severity, message = SeverityMedium, fmt.Sprintf("yara rule %s incomplete on %s", match.Rule, match.Path)
+		}
+		events = append(events, Event{
+			Time:      manifest.FinishedAt,
+			Pot:       manifest.Pot,
+			Container: manifest.Container,
+			Kind:      EventYara,
+			Severity:  severity,
+			Path:      match.Path,
+			Hash:      match.SHA256,
+			Message:   message,
+			Fields: map[string]string{
+				"rule": match.Rule,
+				"tags": strings.Join(match.Tags, ","),
+			},
+		})
+	}
+	return events
+}
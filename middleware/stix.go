@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const stixTimeFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	// stixSCONamespace derives ids of cyber observables, 00abedb4-aa42-466c-9c01-fed23315a9b7 as
+	// given by STIX 2.1
+	stixSCONamespace = uuid.UUID{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+	// intelNamespace derives ids of the other objects so repeated exports keep them,
+	// 5d4c3d1f-3e5b-4c39-9f0e-6f6e65792d76
+	intelNamespace = uuid.UUID{0x5d, 0x4c, 0x3d, 0x1f, 0x3e, 0x5b, 0x4c, 0x39, 0x9f, 0x0e, 0x6f, 0x6e, 0x65, 0x79, 0x2d, 0x76}
+)
+
+// TLPMarkings are the STIX 2.1 marking definitions of the traffic light protocol.
+var TLPMarkings = map[string]string{
+	"white": "marking-definition--613f2e26-407d-48c7-9eca-b8e91df99dc9",
+	"green": "marking-definition--34098fce-860f-48ae-8e50-ebd3cc5e41da",
+	"amber": "marking-definition--f88d31f6-486f-44da-b317-01333bde0b82",
+	"red":   "marking-definition--5e57c739-391a-4eb3-b6be-7d15ca92d5ed",
+}
+
+// uuidV5 returns the name based uuid of name in namespace.
+func uuidV5(namespace uuid.UUID, name string) string {
+	return uuid.NewSHA1(namespace, []byte(name)).String()
+}
+
+func stixTime(t time.Time) string {
+	return t.UTC().Format(stixTimeFormat)
+}
+
+func escapeSTIXPattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+// stixObservable returns the cyber observable and the indicator pattern of the indicator.
+func stixObservable(indicator Indicator) (map[string]interface{}, string) {
+	var observable map[string]interface{}
+	var pattern string
+	var contributing map[string]interface{}
+
+	switch indicator.Type {
+	case IndicatorIP:
+		kind := "ipv4-addr"
+		if ip := net.ParseIP(indicator.Value); ip != nil && ip.To4() == nil {
+			kind = "ipv6-addr"
+		}
+		observable = map[string]interface{}{"type": kind, "value": indicator.Value}
+		contributing = map[string]interface{}{"value": indicator.Value}
+		pattern = fmt.Sprintf("[%s:value = '%s']", kind, escapeSTIXPattern(indicator.Value))
+	case IndicatorURL:
+		observable = map[string]interface{}{"type": "url", "value": indicator.Value}
+		contributing = map[string]interface{}{"value": indicator.Value}
+		pattern = fmt.Sprintf("[url:value = '%s']", escapeSTIXPattern(indicator.Value))
+	case IndicatorFile:
+		hashes := map[string]string{"SHA-256": indicator.Value}
+		if indicator.Hashes["md5"] != "" {
+			hashes["MD5"] = indicator.Hashes["md5"]
+		}
+		if indicator.Hashes["sha1"] != "" {
+			hashes["SHA-1"] = indicator.Hashes["sha1"]
+		}
+		observable = map[string]interface{}{"type": "file", "hashes": hashes}
+		contributing = map[string]interface{}{"hashes": hashes}
+		pattern = fmt.Sprintf("[file:hashes.'SHA-256' = '%s']", indicator.Value)
+	case IndicatorCredential:
+		observable = map[string]interface{}{"type": "user-account", "account_login": indicator.Username, "credential": indicator.Password}
+		// credential is not an id contributing property, but logins with other passwords are other observables
+		contributing = map[string]interface{}{"account_login": indicator.Username, "credential": indicator.Password}
+		pattern = fmt.Sprintf("[user-account:account_login = '%s' AND user-account:credential = '%s']",
+			escapeSTIXPattern(indicator.Username), escapeSTIXPattern(indicator.Password))
+	default:
+		return nil, ""
+	}
+
+	seed, _ := json.Marshal(contributing)
+	observable["id"] = observable["type"].(string) + "--" + uuidV5(stixSCONamespace, string(seed))
+	observable["spec_version"] = "2.1"
+	return observable, pattern
+}
+
+var indicatorDescriptions = map[string]string{
+	"attacker": "address connecting to a honeypot",
+	"outbound": "address contacted from a compromised honeypot",
+	"download": "url fetched by malware in a honeypot",
+	"dropped":  "file dropped in a honeypot",
+	"embedded": "address or url embedded in a file dropped in a honeypot",
+	"login":    "credential tried on a honeypot",
+}
+
+// STIXBundle returns the indicators as a STIX 2.1 bundle. Each value is an indicator and an observable,
+// sighted at the pots it was seen on with observed data of the pot.
+func STIXBundle(indicators []Indicator, tlp string, now time.Time) (map[string]interface{}, error) {
+	marking, found := TLPMarkings[strings.ToLower(tlp)]
+	if !found {
+		return nil, fmt.Errorf("unknown %s tlp, expected white, green, amber or red", tlp)
+	}
+
+	created := stixTime(now)
+	producer := "identity--" + uuidV5(intelNamespace, "producer")
+	common := func(kind string, id string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":                kind,
+			"spec_version":        "2.1",
+			"id":                  id,
+			"created":             created,
+			"modified":            created,
+			"created_by_ref":      producer,
+			"object_marking_refs": []string{marking},
+		}
+	}
+
+	objects := []interface{}{
+		map[string]interface{}{
+			"type":            "marking-definition",
+			"spec_version":    "2.1",
+			"id":              marking,
+			"created":         "2017-01-20T00:00:00.000Z",
+			"definition_type": "tlp",
+			"name":            "TLP:" + strings.ToUpper(tlp),
+			"definition":      map[string]string{"tlp": strings.ToLower(tlp)},
+		},
+		map[string]interface{}{
+			"type":           "identity",
+			"spec_version":   "2.1",
+			"id":             producer,
+			"created":        created,
+			"modified":       created,
+			"name":           "Honey-V",
+			"identity_class": "system",
+		},
+	}
+
+	pots := make(map[string]string)
+	indicatorIDs := make(map[string]string)
+	observableIDs := make(map[string]string)
+
+	for _, indicator := range indicators {
+		observable, pattern := stixObservable(indicator)
+		if observable == nil {
+			continue
+		}
+		key := indicator.Type + "|" + indicator.Value
+
+		if _, found := indicatorIDs[key]; !found {
+			indicatorID := "indicator--" + uuidV5(intelNamespace, "indicator|"+key)
+			indicatorType := "malicious-activity"
+			if indicator.Type == IndicatorCredential {
+				indicatorType = "anomalous-activity"
+			}
+
+			object := common("indicator", indicatorID)
+			object["name"] = fmt.Sprintf("%s %s", indicator.Type, indicator.Value)
+			object["description"] = indicatorDescriptions[indicator.Role]
+			object["indicator_types"] = []string{indicatorType}
+			object["pattern"] = pattern
+			object["pattern_type"] = "stix"
+			object["valid_from"] = stixTime(indicator.FirstSeen)
+			object["labels"] = []string{"honeypot", indicator.Role}
+			objects = append(objects, observable, object)
+
+			indicatorIDs[key] = indicatorID
+			observableIDs[key] = observable["id"].(string)
+		}
+
+		potID, found := pots[indicator.Pot]
+		if !found {
+			potID = "identity--" + uuidV5(intelNamespace, "pot|"+indicator.Pot)
+			object := common("identity", potID)
+			object["name"] = indicator.Pot
+			object["description"] = "honeypot"
+			object["identity_class"] = "system"
+			objects = append(objects, object)
+			pots[indicator.Pot] = potID
+		}
+
+		first, last := stixTime(indicator.FirstSeen), stixTime(indicator.LastSeen)
+		observedID := "observed-data--" + uuidV5(intelNamespace, "observed|"+key+"|"+indicator.Pot)
+		observed := common("observed-data", observedID)
+		observed["first_observed"] = first
+		observed["last_observed"] = last
+		observed["number_observed"] = indicator.Count
+		observed["object_refs"] = []string{observableIDs[key]}
+
+		sighting := common("sighting", "sighting--"+uuidV5(intelNamespace, "sighting|"+key+"|"+indicator.Pot))
+		sighting["sighting_of_ref"] = indicatorIDs[key]
+		sighting["observed_data_refs"] = []string{observedID}
+		sighting["where_sighted_refs"] = []string{potID}
+		sighting["first_seen"] = first
+		sighting["last_seen"] = last
+		sighting["count"] = indicator.Count
+
+		objects = append(objects, observed, sighting)
+	}
+
+	// the bundle itself is new on every export
+	bundleID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"type":    "bundle",
+		"id":      "bundle--" + bundleID.String(),
+		"objects": objects,
+	}, nil
+}
@@ -0,0 +1,450 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	bolt "go.etcd.io/bbolt"
+)
+
+const EventDBFileName = "events.db"
+
+var (
+	eventsBucket = []byte("events") // identity -> event json
+	timeBucket   = []byte("time")   // unix nano + identity -> identity
+)
+
+// Store is the embedded event database. The file is opened per operation, so collect
+// keeps recording while other commands query it.
+type Store struct {
+	Path    string
+	Timeout time.Duration
+}
+
+// EventFilter selects events from the store. Zero values match everything.
+type EventFilter struct {
+	Pot      string
+	SourceIP string
+	Kinds    []string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// EventCount is one row of an aggregation.
+type EventCount struct {
+	Key   string    `json:"key"`
+	Count int       `json:"count"`
+	Pots  int       `json:"pots"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+func NewStore(path string) *Store {
+	return &Store{Path: path, Timeout: 10 * time.Second}
+}
+
+func (s *Store) open(readOnly bool) (*bolt.DB, error) {
+	if readOnly {
+		if _, err := os.Stat(s.Path); err != nil {
+			return nil, err
+		}
+	}
+	return bolt.Open(s.Path, 0600, &bolt.Options{Timeout: s.Timeout, ReadOnly: readOnly})
+}
+
+// eventIdentity names an event by what it observed, so the same observation recorded live
+// and indexed later from the artifacts is stored once.
+func eventIdentity(event Event) []byte {
+	var identity string
+	switch event.Kind {
+	case EventFileChange:
+		identity = strings.Join([]string{event.Kind, event.Pot, event.Container, event.Path}, "|")
+	case EventProcess:
+		identity = strings.Join([]string{event.Kind, event.Pot, event.Container, event.Command}, "|")
+	case EventLogin:
+		identity = strings.Join([]string{event.Kind, event.Pot, event.Container, event.Message}, "|")
+	case EventCollect:
+		identity = strings.Join([]string{event.Kind, event.Pot, filepath.Base(event.Path)}, "|")
+	default:
+		identity = strings.Join([]string{
+			event.Kind, event.Pot, event.Container, event.Time.UTC().Format(time.RFC3339Nano),
+			event.Protocol, event.SourceIP, strconv.Itoa(event.SourcePort), event.DestinationIP, strconv.Itoa(event.DestinationPort),
+			event.Username, event.Password, event.Command, event.Path, event.Message,
+		}, "|")
+	}
+	if event.Host != "" {
+		// pots of different hosts share names
+		identity = event.Host + "|" + identity
+	}
+
+	hash := sha256.Sum256([]byte(identity))
+	return hash[:16]
+}
+
+// PutEvents stores events, keeping the first record of an observation seen more than once.
+func (s *Store) PutEvents(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		eventBucket, err := tx.CreateBucketIfNotExists(eventsBucket)
+		if err != nil {
+			return err
+		}
+		indexBucket, err := tx.CreateBucketIfNotExists(timeBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if event.Time.IsZero() {
+				event.Time = time.Now()
+			}
+
+			identity := eventIdentity(event)
+			if eventBucket.Get(identity) != nil {
+				continue
+			}
+
+			value, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := eventBucket.Put(identity, value); err != nil {
+				return err
+			}
+
+			timeKey := make([]byte, 8, 8+len(identity))
+			binary.BigEndian.PutUint64(timeKey, uint64(event.Time.UnixNano()))
+			if err := indexBucket.Put(append(timeKey, identity...), identity); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Match reports whether the event passes the pot, source and kind filters.
+func (f EventFilter) Match(event Event) bool {
+	if f.Pot != "" && event.Pot != f.Pot {
+		return false
+	}
+	if f.SourceIP != "" && event.SourceIP != f.SourceIP {
+		return false
+	}
+	if len(f.Kinds) > 0 {
+		found := false
+		for _, kind := range f.Kinds {
+			if kind == event.Kind {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryEvents returns the events matching the filter in time order.
+func (s *Store) QueryEvents(filter EventFilter) ([]Event, error) {
+	var events []Event
+
+	db, err := s.open(true)
+	if os.IsNotExist(err) {
+		return events, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		eventBucket, indexBucket := tx.Bucket(eventsBucket), tx.Bucket(timeBucket)
+		if eventBucket == nil || indexBucket == nil {
+			return nil
+		}
+
+		start := make([]byte, 8)
+		if !filter.Since.IsZero() {
+			binary.BigEndian.PutUint64(start, uint64(filter.Since.UnixNano()))
+		}
+
+		cursor := indexBucket.Cursor()
+		for key, identity := cursor.Seek(start); key != nil; key, identity = cursor.Next() {
+			if !filter.Until.IsZero() && int64(binary.BigEndian.Uint64(key[:8])) > filter.Until.UnixNano() {
+				break
+			}
+
+			var event Event
+			if err := json.Unmarshal(eventBucket.Get(identity), &event); err != nil {
+				continue
+			}
+
+			if filter.Match(event) {
+				events = append(events, event)
+				if filter.Limit > 0 && len(events) >= filter.Limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+
+	return events, err
+}
+
+// EventKey returns the aggregation key of the event, empty when the event has none.
+func EventKey(event Event, field string) string {
+	switch field {
+	case "ip":
+		return event.SourceIP
+	case "port":
+		if event.DestinationPort == 0 {
+			return ""
+		}
+		return strconv.Itoa(event.DestinationPort)
+	case "credential":
+		if event.Username == "" && event.Password == "" {
+			return ""
+		}
+		return event.Username + ":" + event.Password
+	case "username":
+		return event.Username
+	case "password":
+		return event.Password
+	case "host":
+		return event.Host
+	case "pot":
+		return event.Pot
+	case "kind":
+		return event.Kind
+	case "container":
+		return event.Container
+	case "protocol":
+		return event.Protocol
+	case "destination_ip":
+		return event.DestinationIP
+	case "command":
+		return event.Command
+	case "path":
+		return event.Path
+	case "hash":
+		return event.Hash
+	case "message":
+		return event.Message
+	case "actor":
+		return event.Actor
+	case "campaign":
+		return event.Campaign
+	case "country", "city", "asn", "org":
+		return geoKey(event.Geo, field)
+	}
+	return event.Fields[field]
+}
+
+// CountEvents aggregates events by field, most frequent first.
+func CountEvents(events []Event, field string) []EventCount {
+	counts := make(map[string]*EventCount)
+	pots := make(map[string]map[string]struct{})
+
+	for _, event := range events {
+		key := EventKey(event, field)
+		if key == "" {
+			continue
+		}
+
+		count, found := counts[key]
+		if !found {
+			count = &EventCount{Key: key, First: event.Time}
+			counts[key] = count
+			pots[key] = make(map[string]struct{})
+		}
+
+		count.Count++
+		if event.Time.Before(count.First) {
+			count.First = event.Time
+		}
+		if event.Time.After(count.Last) {
+			count.Last = event.Time
+		}
+		pots[key][event.Pot] = struct{}{}
+		count.Pots = len(pots[key])
+	}
+
+	var result []EventCount
+	for _, count := range counts {
+		result = append(result, *count)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+// RecordEvents writes published events to the store in batches until the channel is closed.
+func RecordEvents(store *Store, events <-chan Event, interval time.Duration) {
+	var batch []Event
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if err := store.PutEvents(batch); err != nil {
+			log.Printf("error while recording %d event(s) - %s", len(batch), err)
+			return
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// IndexArtifactRun reads the events of a collection run directory: the run itself,
+// connections of network.pcap, files added in container.diff, logins and commands in container.log and yara matches.
+func IndexArtifactRun(runPath string) ([]Event, error) {
+	manifest, err := ReadManifest(runPath)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{{
+		Time:      manifest.FinishedAt,
+		Pot:       manifest.Pot,
+		Container: manifest.Container,
+		Kind:      EventCollect,
+		Path:      runPath,
+		Message:   fmt.Sprintf("collected %s by %s trigger", filepath.Base(runPath), manifest.Trigger),
+		Fields: map[string]string{
+			"profile": manifest.Profile,
+			"trigger": manifest.Trigger,
+			"reset":   manifest.Reset,
+			"failed":  strconv.FormatBool(manifest.Failed()),
+		},
+	}}
+
+	if file, err := os.Open(filepath.Join(runPath, "container.diff")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "A ") {
+				continue
+			}
+			path := strings.TrimPrefix(line, "A ")
+			events = append(events, Event{
+				Time:      manifest.StartedAt,
+				Pot:       manifest.Pot,
+				Container: manifest.Container,
+				Kind:      EventFileChange,
+				Severity:  SeverityMedium,
+				Path:      path,
+				Message:   fmt.Sprintf("file %s added", path),
+			})
+		}
+		_ = file.Close()
+	}
+
+	if file, err := os.Open(filepath.Join(runPath, "container.log")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if event, matched := ParseLogLine(scanner.Text()); matched {
+				if event.Time.IsZero() {
+					event.Time = manifest.StartedAt
+				}
+				event.Pot = manifest.Pot
+				event.Container = manifest.Container
+				events = append(events, event)
+			}
+		}
+		_ = file.Close()
+	}
+
+	events = append(events, YaraEvents(manifest)...)
+
+	pcapEvents, _ := ReadPcapEvents(filepath.Join(runPath, "network.pcap"), manifest.Pot, parseSubnets(manifest.Subnets))
+	events = append(events, pcapEvents...)
+
+	for index := range events {
+		events[index].Host = manifest.Host
+		EnrichEvent(&events[index])
+	}
+
+	return events, nil
+}
+
+// ReadPcapEvents returns the connection events of a capture file. Without subnets the pot
+// network is taken to be the private address ranges. Inbound ssh connections carry the client
+// banner and HASSH in the ssh_banner and hassh fields.
+func ReadPcapEvents(fileName string, potName string, subnets []*net.IPNet) ([]Event, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if subnets == nil {
+		subnets = privateSubnets
+	}
+
+	var events []Event
+	seenFlows := make(map[string]struct{})
+	source := gopacket.NewPacketSource(reader, reader.LinkType())
+	source.NoCopy = true
+
+	// inbound tcp connections by flow, to attach the ssh fingerprint of the client
+	connections := make(map[string]int)
+	fingerprints := newSSHFingerprints()
+	for packet := range source.Packets() {
+		if event, found := readPacketEvent(potName, subnets, seenFlows, packet); found {
+			if event.Kind == EventConnection && event.Protocol == "tcp" {
+				connections[flowKey(event.Protocol, event.SourceIP, event.SourcePort, event.DestinationIP, event.DestinationPort)] = len(events)
+			}
+			events = append(events, event)
+			continue
+		}
+		fingerprints.observe(connections, packet)
+	}
+	fingerprints.annotate(events, connections)
+
+	return events, nil
+}
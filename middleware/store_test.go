@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreQueryEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewStore(filepath.Join(dir, EventDBFileName))
+	now := time.Now()
+
+	events := []Event{
+		{Time: now.Add(-3 * time.Hour), Pot: "web", Kind: EventConnection, SourceIP: "198.51.100.1", DestinationPort: 80},
+		{Time: now.Add(-2 * time.Hour), Pot: "ssh", Kind: EventConnection, SourceIP: "198.51.100.1", DestinationPort: 22},
+		{Time: now.Add(-2 * time.Hour), Pot: "ssh", Kind: EventLogin, SourceIP: "198.51.100.1", Username: "root", Password: "root", Message: "login 1"},
+		{Time: now.Add(-1 * time.Hour), Pot: "ssh", Kind: EventConnection, SourceIP: "203.0.113.9", DestinationPort: 22},
+		{Time: now.Add(-1 * time.Hour), Pot: "web", Kind: EventFileChange, Container: "abc", Path: "/tmp/x"},
+	}
+
+	if err := store.PutEvents(events); err != nil {
+		t.Fatal(err)
+	}
+
+	// same file seen again later is the same observation
+	if err := store.PutEvents([]Event{{Time: now, Pot: "web", Kind: EventFileChange, Container: "abc", Path: "/tmp/x"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := store.QueryEvents(EventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(events) {
+		t.Errorf("event count not match\nexpected: %d, actual: %d", len(events), len(all))
+	}
+
+	connections, _ := store.QueryEvents(EventFilter{Kinds: []string{EventConnection}, Since: now.Add(-150 * time.Minute)})
+	if len(connections) != 2 {
+		t.Errorf("filtered event count not match\nexpected: 2, actual: %d", len(connections))
+	}
+
+	counts := CountEvents(all, "ip")
+	if len(counts) != 2 || counts[0].Key != "198.51.100.1" || counts[0].Count != 3 || counts[0].Pots != 2 {
+		t.Errorf("count by ip not match - %+v", counts)
+	}
+
+	credentials := CountEvents(all, "credential")
+	if len(credentials) != 1 || credentials[0].Key != "root:root" {
+		t.Errorf("count by credential not match - %+v", credentials)
+	}
+}
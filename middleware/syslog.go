@@ -0,0 +1,401 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogVendor  = "Honey-V"
+	syslogProduct = "honeypot"
+	syslogVersion = "1.0"
+
+	syslogFacilityLocal0 = 16
+)
+
+// DefaultCEFMapping names the CEF extension key of each event field. Custom string keys get a label with the field name.
+var DefaultCEFMapping = map[string]string{
+	"pot":              "cs1",
+	"container":        "cs2",
+	"command":          "cs3",
+	"kind":             "cat",
+	"source_ip":        "src",
+	"source_port":      "spt",
+	"destination_ip":   "dst",
+	"destination_port": "dpt",
+	"protocol":         "proto",
+	"username":         "suser",
+	"password":         "cs4",
+	"path":             "filePath",
+	"hash":             "fileHash",
+	"message":          "msg",
+}
+
+// DefaultLEEFMapping names the LEEF attribute of each event field.
+var DefaultLEEFMapping = map[string]string{
+	"pot":              "pot",
+	"container":        "container",
+	"command":          "command",
+	"kind":             "cat",
+	"source_ip":        "src",
+	"source_port":      "srcPort",
+	"destination_ip":   "dst",
+	"destination_port": "dstPort",
+	"protocol":         "proto",
+	"username":         "usrName",
+	"password":         "password",
+	"path":             "filePath",
+	"hash":             "fileHash",
+	"message":          "msg",
+}
+
+var cefCustomKey = regexp.MustCompile(`^c[sn][1-6]$`)
+
+// eventFieldValue returns the value of an event field by its JSON name.
+func eventFieldValue(event Event, field string) string {
+	switch field {
+	case "source_ip":
+		return event.SourceIP
+	case "source_port":
+		if event.SourcePort == 0 {
+			return ""
+		}
+		return strconv.Itoa(event.SourcePort)
+	case "destination_port":
+		return EventKey(event, "port")
+	case "severity":
+		return strconv.Itoa(event.Severity)
+	}
+	return EventKey(event, field)
+}
+
+func sortedFields(mapping map[string]string) []string {
+	var fields []string
+	for field := range mapping {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func escapeCEFHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+func escapeCEFExtension(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+// FormatCEF returns the event as an ArcSight CEF record with the extension keys of mapping.
+func FormatCEF(event Event, mapping map[string]string) string {
+	name := event.Message
+	if name == "" {
+		name = event.Kind
+	}
+
+	extensions := []string{"rt=" + strconv.FormatInt(event.Time.UnixNano()/int64(time.Millisecond), 10)}
+	for _, field := range sortedFields(mapping) {
+		value := eventFieldValue(event, field)
+		if value == "" {
+			continue
+		}
+		key := mapping[field]
+		extensions = append(extensions, key+"="+escapeCEFExtension(value))
+		if cefCustomKey.MatchString(key) {
+			extensions = append(extensions, key+"Label="+escapeCEFExtension(field))
+		}
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		syslogVendor, syslogProduct, syslogVersion,
+		escapeCEFHeader(event.Kind), escapeCEFHeader(name), event.Severity, strings.Join(extensions, " "))
+}
+
+func escapeLEEF(value string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(value)
+}
+
+// FormatLEEF returns the event as a QRadar LEEF 1.0 record with tab separated attributes of mapping.
+func FormatLEEF(event Event, mapping map[string]string) string {
+	attributes := []string{
+		"devTime=" + strconv.FormatInt(event.Time.UnixNano()/int64(time.Millisecond), 10),
+		"devTimeFormat=epoch",
+		"sev=" + strconv.Itoa(event.Severity),
+	}
+	for _, field := range sortedFields(mapping) {
+		if value := eventFieldValue(event, field); value != "" {
+			attributes = append(attributes, mapping[field]+"="+escapeLEEF(value))
+		}
+	}
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		syslogVendor, syslogProduct, syslogVersion, strings.Replace(escapeLEEF(event.Kind), "|", " ", -1), strings.Join(attributes, "\t"))
+}
+
+// syslogSeverity maps event severity to the RFC 5424 severity.
+func syslogSeverity(severity int) int {
+	switch {
+	case severity >= SeverityCritical:
+		return 2 // critical
+	case severity >= SeverityHigh:
+		return 3 // error
+	case severity >= SeverityMedium:
+		return 4 // warning
+	case severity >= SeverityLow:
+		return 5 // notice
+	}
+	return 6 // informational
+}
+
+// SyslogConfig sets where and how the syslog sink forwards events.
+type SyslogConfig struct {
+	Network    string // udp, tcp or tls
+	Address    string // host:port
+	Format     string // cef or leef
+	Mapping    map[string]string
+	TLS        *tls.Config
+	Hostname   string
+	AppName    string
+	Facility   int
+	BufferSize int           // events kept while the collector is unreachable
+	RetryMax   time.Duration // longest wait between reconnects
+}
+
+// SyslogSink forwards events as RFC 5424 syslog messages, keeping them in memory while the collector is unreachable.
+type SyslogSink struct {
+	config SyslogConfig
+	format func(Event, map[string]string) string
+
+	mutex   sync.Mutex
+	pending [][]byte
+	dropped int
+	signal  chan struct{}
+	closed  chan struct{}
+	done    chan struct{}
+}
+
+func NewSyslogSink(config SyslogConfig) (*SyslogSink, error) {
+	sink := &SyslogSink{
+		config: config,
+		signal: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	switch config.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unknown %s syslog network, expected udp, tcp or tls", config.Network)
+	}
+	if config.Address == "" {
+		return nil, errors.New("syslog address required")
+	}
+
+	switch strings.ToLower(config.Format) {
+	case "", "cef":
+		sink.format = FormatCEF
+		if sink.config.Mapping == nil {
+			sink.config.Mapping = DefaultCEFMapping
+		}
+	case "leef":
+		sink.format = FormatLEEF
+		if sink.config.Mapping == nil {
+			sink.config.Mapping = DefaultLEEFMapping
+		}
+	default:
+		return nil, fmt.Errorf("unknown %s syslog format, expected cef or leef", config.Format)
+	}
+
+	if sink.config.Hostname == "" {
+		sink.config.Hostname, _ = os.Hostname()
+	}
+	if sink.config.AppName == "" {
+		sink.config.AppName = syslogProduct
+	}
+	if sink.config.Facility == 0 {
+		sink.config.Facility = syslogFacilityLocal0
+	}
+	if sink.config.BufferSize <= 0 {
+		sink.config.BufferSize = 10000
+	}
+	if sink.config.RetryMax <= 0 {
+		sink.config.RetryMax = time.Minute
+	}
+
+	go sink.deliver()
+	return sink, nil
+}
+
+// Format returns the name of the message format, cef or leef.
+func (s *SyslogSink) Format() string {
+	if strings.ToLower(s.config.Format) == "leef" {
+		return "leef"
+	}
+	return "cef"
+}
+
+// Message returns the RFC 5424 message of the event.
+func (s *SyslogSink) Message(event Event) []byte {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	hostname := s.config.Hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+	msgID := event.Kind
+	if msgID == "" {
+		msgID = "-"
+	}
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		s.config.Facility*8+syslogSeverity(event.Severity),
+		event.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname, s.config.AppName, os.Getpid(), msgID, s.format(event, s.config.Mapping)))
+}
+
+// Send queues the event, dropping the oldest queued one when the buffer is full.
+func (s *SyslogSink) Send(event Event) {
+	message := s.Message(event)
+
+	s.mutex.Lock()
+	if len(s.pending) >= s.config.BufferSize {
+		s.pending = s.pending[1:]
+		s.dropped++
+	}
+	s.pending = append(s.pending, message)
+	s.mutex.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends published events until the channel is closed.
+func (s *SyslogSink) Run(events <-chan Event) {
+	for event := range events {
+		s.Send(event)
+	}
+}
+
+// Close stops delivery, giving queued events up to timeout to be sent.
+func (s *SyslogSink) Close(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && s.Pending() > 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(s.closed)
+	<-s.done
+}
+
+// Pending returns the number of queued events.
+func (s *SyslogSink) Pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.pending)
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if s.config.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.config.Address, s.config.TLS)
+	}
+	return dialer.Dial(s.config.Network, s.config.Address)
+}
+
+func (s *SyslogSink) write(conn net.Conn, message []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if s.config.Network == "udp" {
+		_, err := conn.Write(message)
+		return err
+	}
+
+	// octet counting framing of RFC 6587
+	_, err := conn.Write(append([]byte(strconv.Itoa(len(message))+" "), message...))
+	return err
+}
+
+func (s *SyslogSink) deliver() {
+	defer close(s.done)
+
+	var conn net.Conn
+	initial := time.Second
+	if initial > s.config.RetryMax {
+		initial = s.config.RetryMax
+	}
+	backoff := initial
+
+	for {
+		select {
+		case <-s.signal:
+		case <-s.closed:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+
+		for {
+			s.mutex.Lock()
+			if len(s.pending) == 0 {
+				s.mutex.Unlock()
+				break
+			}
+			message := s.pending[0]
+			dropped := s.dropped
+			s.dropped = 0
+			s.mutex.Unlock()
+
+			if dropped > 0 {
+				log.Printf("syslog buffer full, %d event(s) dropped", dropped)
+			}
+
+			var err error
+			if conn == nil {
+				conn, err = s.dial()
+			}
+			if err == nil {
+				err = s.write(conn, message)
+			}
+
+			if err != nil {
+				log.Printf("error while sending syslog to %s, retrying in %s - %s", s.config.Address, backoff, err)
+				if conn != nil {
+					_ = conn.Close()
+					conn = nil
+				}
+
+				select {
+				case <-time.After(backoff):
+				case <-s.closed:
+					return
+				}
+				if backoff *= 2; backoff > s.config.RetryMax {
+					backoff = s.config.RetryMax
+				}
+				continue
+			}
+
+			backoff = initial
+			s.mutex.Lock()
+			// the head may have been dropped by Send while writing
+			if len(s.pending) > 0 && &s.pending[0][0] == &message[0] {
+				s.pending = s.pending[1:]
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSyslogEvent = Event{
+	Time:            time.Unix(1600000000, 0),
+	Pot:             "ssh-1",
+	Kind:            EventLogin,
+	Severity:        SeverityHigh,
+	SourceIP:        "10.0.0.1",
+	DestinationPort: 22,
+	Username:        "root",
+	Password:        "a=b|c",
+	Message:         "login root",
+}
+
+func TestFormatCEF(t *testing.T) {
+	record := FormatCEF(testSyslogEvent, DefaultCEFMapping)
+
+	expected := "CEF:0|Honey-V|honeypot|1.0|login|login root|8|rt=1600000000000 "
+	if !strings.HasPrefix(record, expected) {
+		t.Errorf("cef header not match\nexpected: %s, actual: %s", expected, record)
+	}
+	for _, extension := range []string{"cat=login", "src=10.0.0.1", "dpt=22", "cs1=ssh-1", "cs1Label=pot", `cs4=a\=b|c`, "suser=root"} {
+		if !strings.Contains(record, " "+extension) {
+			t.Errorf("cef extension not match\nexpected: %s, actual: %s", extension, record)
+		}
+	}
+
+	record = FormatCEF(testSyslogEvent, map[string]string{"pot": "dvchost"})
+	if !strings.HasSuffix(record, "|rt=1600000000000 dvchost=ssh-1") {
+		t.Errorf("cef custom mapping not match\nexpected: dvchost=ssh-1, actual: %s", record)
+	}
+}
+
+func TestFormatLEEF(t *testing.T) {
+	record := FormatLEEF(testSyslogEvent, DefaultLEEFMapping)
+
+	expected := "LEEF:1.0|Honey-V|honeypot|1.0|login|devTime=1600000000000\tdevTimeFormat=epoch\tsev=8\t"
+	if !strings.HasPrefix(record, expected) {
+		t.Errorf("leef header not match\nexpected: %s, actual: %s", expected, record)
+	}
+	for _, attribute := range []string{"cat=login", "src=10.0.0.1", "dstPort=22", "pot=ssh-1", "usrName=root"} {
+		if !strings.Contains(record, "\t"+attribute) {
+			t.Errorf("leef attribute not match\nexpected: %s, actual: %s", attribute, record)
+		}
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewSyslogSink(SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), Format: "leef", Hostname: "sensor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close(time.Second)
+	sink.Send(testSyslogEvent)
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 4096)
+	size, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// local0 facility with error severity
+	expected := "<131>1 2020-09-13T12:26:40.000000Z sensor honeypot "
+	if message := string(buffer[:size]); !strings.HasPrefix(message, expected) || !strings.Contains(message, " login - LEEF:1.0|") {
+		t.Errorf("syslog message not match\nexpected: %s..., actual: %s", expected, message)
+	}
+}
+
+// readOctetCounted reads one RFC 6587 octet counted message.
+func readOctetCounted(reader *bufio.Reader) (string, error) {
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		return "", err
+	}
+
+	message := make([]byte, size)
+	for read := 0; read < size; {
+		count, err := reader.Read(message[read:])
+		if err != nil {
+			return "", err
+		}
+		read += count
+	}
+	return string(message), nil
+}
+
+func TestSyslogSinkRetry(t *testing.T) {
+	// reserve a port and leave it closed until events are queued
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	sink, err := NewSyslogSink(SyslogConfig{Network: "tcp", Address: address, RetryMax: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close(time.Second)
+
+	for _, pot := range []string{"web-1", "web-2"} {
+		event := testSyslogEvent
+		event.Pot = pot
+		sink.Send(event)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if pending := sink.Pending(); pending != 2 {
+		t.Errorf("pending events not match\nexpected: 2, actual: %d", pending)
+	}
+
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("port %s taken again - %s", address, err)
+	}
+	defer listener.Close()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	for _, pot := range []string{"web-1", "web-2"} {
+		message, err := readOctetCounted(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(message, "cs1="+pot+" ") {
+			t.Errorf("retried message not match\nexpected: cs1=%s, actual: %s", pot, message)
+		}
+	}
+}
+
+func TestSyslogSinkConfig(t *testing.T) {
+	invalid := map[string]SyslogConfig{
+		"unknown network": {Network: "http", Address: "127.0.0.1:514"},
+		"no address":      {Network: "udp"},
+		"unknown format":  {Network: "udp", Address: "127.0.0.1:514", Format: "json"},
+	}
+
+	for name, config := range invalid {
+		if _, err := NewSyslogSink(config); err == nil {
+			t.Errorf("%s config accepted", name)
+		}
+	}
+}
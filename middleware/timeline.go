@@ -0,0 +1,325 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	TimelineEvents = "events" // recorded in the event database
+	TimelineRun    = "run"    // collection and collectors of manifest.json
+	TimelinePcap   = "pcap"   // flows of network.pcap
+	TimelineLog    = "log"    // logins and commands of container.log
+	TimelineDiff   = "diff"   // file changes of container.diff
+	TimelineTop    = "top"    // processes of container.top
+	TimelineYara   = "yara"   // yara matches of manifest.json
+
+	// TimelineFlow is the kind of timeline entries summarising a flow of the capture.
+	TimelineFlow = "flow"
+)
+
+// Flow is the packets exchanged between a pot and another address on one protocol and port pair.
+type Flow struct {
+	Protocol        string    `json:"protocol"`
+	SourceIP        string    `json:"source_ip"`
+	SourcePort      int       `json:"source_port"`
+	DestinationIP   string    `json:"destination_ip"`
+	DestinationPort int       `json:"destination_port"`
+	Outbound        bool      `json:"outbound"`
+	First           time.Time `json:"first"`
+	Last            time.Time `json:"last"`
+	Packets         int       `json:"packets"`
+	Bytes           int       `json:"bytes"`
+}
+
+func (f Flow) key() string {
+	return fmt.Sprintf("%s|%s|%d|%s|%d", f.Protocol, f.SourceIP, f.SourcePort, f.DestinationIP, f.DestinationPort)
+}
+
+// ReadPcapFlows returns the flows of a capture file between the pot network and other addresses,
+// in order of their first packet. The source of a flow is the side sending its first packet.
+func ReadPcapFlows(fileName string, subnets []*net.IPNet) ([]Flow, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	if subnets == nil {
+		subnets = privateSubnets
+	}
+
+	var flows []*Flow
+	index := make(map[string]*Flow)
+	source := gopacket.NewPacketSource(reader, reader.LinkType())
+	source.NoCopy = true
+	for packet := range source.Packets() {
+		var srcIP, dstIP net.IP
+		if ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+			srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+		} else if ipLayer, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+			srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+		} else {
+			continue
+		}
+
+		flow := Flow{SourceIP: srcIP.String(), DestinationIP: dstIP.String()}
+		if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+			flow.Protocol, flow.SourcePort, flow.DestinationPort = "tcp", int(tcp.SrcPort), int(tcp.DstPort)
+		} else if udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+			flow.Protocol, flow.SourcePort, flow.DestinationPort = "udp", int(udp.SrcPort), int(udp.DstPort)
+		} else {
+			continue
+		}
+
+		fromPot, toPot := containsIP(subnets, srcIP), containsIP(subnets, dstIP)
+		if fromPot == toPot {
+			continue
+		}
+
+		reply := flow
+		reply.SourceIP, reply.SourcePort, reply.DestinationIP, reply.DestinationPort = flow.DestinationIP, flow.DestinationPort, flow.SourceIP, flow.SourcePort
+		existing, found := index[flow.key()]
+		if !found {
+			existing, found = index[reply.key()]
+		}
+		if !found {
+			flow.Outbound = fromPot
+			flow.First = packet.Metadata().Timestamp
+			existing = &flow
+			index[flow.key()] = existing
+			flows = append(flows, existing)
+		}
+
+		existing.Packets++
+		existing.Bytes += packet.Metadata().Length
+		existing.Last = packet.Metadata().Timestamp
+	}
+
+	result := make([]Flow, 0, len(flows))
+	for _, flow := range flows {
+		result = append(result, *flow)
+	}
+	return result, nil
+}
+
+// FlowEvent returns the timeline event of a flow, at its first packet.
+func FlowEvent(potName string, flow Flow) Event {
+	direction, severity := "inbound", SeverityLow
+	if flow.Outbound {
+		direction, severity = "outbound", SeverityHigh
+	}
+	return Event{
+		Time:            flow.First,
+		Pot:             potName,
+		Kind:            TimelineFlow,
+		Severity:        severity,
+		Protocol:        flow.Protocol,
+		SourceIP:        flow.SourceIP,
+		SourcePort:      flow.SourcePort,
+		DestinationIP:   flow.DestinationIP,
+		DestinationPort: flow.DestinationPort,
+		Message: fmt.Sprintf("%s %s flow %s:%d -> %s:%d, %d packet(s), %s over %s", direction, flow.Protocol,
+			flow.SourceIP, flow.SourcePort, flow.DestinationIP, flow.DestinationPort, flow.Packets,
+			units.HumanSize(float64(flow.Bytes)), flow.Last.Sub(flow.First).Round(time.Millisecond)),
+		Fields: map[string]string{
+			"packets": strconv.Itoa(flow.Packets),
+			"bytes":   strconv.Itoa(flow.Bytes),
+			"last":    flow.Last.UTC().Format(time.RFC3339Nano),
+		},
+	}
+}
+
+// TimelineEntry is an event of the timeline with the data source it was read from.
+type TimelineEntry struct {
+	Event
+	Source string `json:"source"`
+}
+
+// Timeline merges the events of one pot in a time window from the event database and the
+// artifact runs, keeping one entry for an observation found in several sources.
+type Timeline struct {
+	Pot   string
+	Since time.Time
+	Until time.Time
+
+	entries []TimelineEntry
+	seen    map[string]bool
+	flows   map[string]bool
+}
+
+func NewTimeline(potName string, since time.Time, until time.Time) *Timeline {
+	return &Timeline{Pot: potName, Since: since, Until: until, seen: make(map[string]bool), flows: make(map[string]bool)}
+}
+
+// Add adds the events of the pot in the window read from the source.
+func (t *Timeline) Add(source string, events ...Event) {
+	for _, event := range events {
+		if (t.Pot != "" && event.Pot != t.Pot) || (!t.Since.IsZero() && event.Time.Before(t.Since)) || (!t.Until.IsZero() && event.Time.After(t.Until)) {
+			continue
+		}
+
+		identity := string(eventIdentity(event))
+		if event.Kind == EventCollect && event.Path == "" {
+			// starts and collectors of a run are not stored, the identity of collections is their run
+			identity = event.Message + "|" + event.Time.String()
+		}
+		if t.seen[identity] {
+			continue
+		}
+		t.seen[identity] = true
+
+		if event.Kind == TimelineFlow {
+			flow := Flow{Protocol: event.Protocol, SourceIP: event.SourceIP, SourcePort: event.SourcePort, DestinationIP: event.DestinationIP, DestinationPort: event.DestinationPort}
+			t.flows[flow.key()] = true
+		}
+		t.entries = append(t.entries, TimelineEntry{Event: event, Source: source})
+	}
+}
+
+// AddRun adds the collection, flows, logins and commands, file changes, processes and yara matches
+// of an artifact run. Changes and processes have no time of their own and are placed at the start
+// of the collection that found them.
+func (t *Timeline) AddRun(runPath string) error {
+	manifest, err := ReadManifest(runPath)
+	if err != nil {
+		return err
+	}
+	run := filepath.Base(runPath)
+	seen := Event{Pot: manifest.Pot, Container: manifest.Container, Time: manifest.StartedAt}
+
+	started := seen
+	started.Kind, started.Severity = EventCollect, SeverityInfo
+	started.Message = fmt.Sprintf("collection %s started by %s trigger", run, manifest.Trigger)
+	t.Add(TimelineRun, started)
+	for _, result := range manifest.Collectors {
+		collected := seen
+		collected.Time, collected.Kind, collected.Severity = result.FinishedAt, EventCollect, SeverityInfo
+		collected.Message = fmt.Sprintf("collector %s of %s succeeded", result.Name, run)
+		if result.Untrusted {
+			collected.Message += " in the pot container, untrusted"
+		}
+		if !result.Success {
+			collected.Severity = SeverityMedium
+			collected.Message = fmt.Sprintf("collector %s of %s failed - %s", result.Name, run, result.Error)
+		}
+		t.Add(TimelineRun, collected)
+	}
+	finished := seen
+	finished.Time, finished.Kind, finished.Path = manifest.FinishedAt, EventCollect, runPath
+	finished.Message = fmt.Sprintf("collected %s by %s trigger", run, manifest.Trigger)
+	t.Add(TimelineRun, finished)
+
+	if flows, err := ReadPcapFlows(filepath.Join(runPath, "network.pcap"), parseSubnets(manifest.Subnets)); err == nil {
+		for _, flow := range flows {
+			event := FlowEvent(manifest.Pot, flow)
+			event.Container = manifest.Container
+			t.Add(TimelinePcap, event)
+		}
+	}
+
+	if file, err := os.Open(filepath.Join(runPath, "container.log")); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if event, matched := ParseLogLine(scanner.Text()); matched {
+				if event.Time.IsZero() {
+					event.Time = manifest.StartedAt
+				}
+				event.Pot, event.Container = manifest.Pot, manifest.Container
+				t.Add(TimelineLog, event)
+			}
+		}
+		_ = file.Close()
+	}
+
+	if file, err := os.Open(filepath.Join(runPath, "container.diff")); err == nil {
+		actions := map[string]string{"A": "added", "C": "changed", "D": "deleted"}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.SplitN(scanner.Text(), " ", 2)
+			if len(fields) != 2 || actions[fields[0]] == "" {
+				continue
+			}
+			event := seen
+			event.Kind, event.Severity, event.Path = EventFileChange, SeverityMedium, fields[1]
+			event.Message = fmt.Sprintf("file %s %s", fields[1], actions[fields[0]])
+			t.Add(TimelineDiff, event)
+		}
+		_ = file.Close()
+	}
+
+	for _, command := range readTopCommands(filepath.Join(runPath, "container.top")) {
+		event := seen
+		event.Kind, event.Severity, event.Command = EventProcess, SeverityInfo, command
+		event.Message = fmt.Sprintf("process %s running", command)
+		t.Add(TimelineTop, event)
+	}
+
+	t.Add(TimelineYara, YaraEvents(manifest)...)
+	return nil
+}
+
+// readTopCommands returns the CMD column of the process table written by the top collector.
+func readTopCommands(fileName string) []string {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var commands []string
+	column := -1
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if column < 0 {
+			if column = strings.Index(line, "CMD"); column < 0 {
+				return nil
+			}
+			continue
+		}
+		if len(line) > column {
+			if command := strings.TrimSpace(line[column:]); command != "" {
+				commands = append(commands, command)
+			}
+		}
+	}
+	return commands
+}
+
+// Entries returns the entries in time order. Connections recorded in the event database are left
+// out when the capture flow they started is in the timeline.
+func (t *Timeline) Entries() []TimelineEntry {
+	entries := []TimelineEntry{}
+	for _, entry := range t.entries {
+		if entry.Kind == EventConnection || entry.Kind == EventOutbound {
+			flow := Flow{Protocol: entry.Protocol, SourceIP: entry.SourceIP, SourcePort: entry.SourcePort, DestinationIP: entry.DestinationIP, DestinationPort: entry.DestinationPort}
+			if t.flows[flow.key()] {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries
+}
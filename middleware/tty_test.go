@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func ttyLogRecord(op uint32, direction uint32, at time.Time, data string) []byte {
+	record := make([]byte, ttyLogHeaderSize, ttyLogHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record[0:4], op)
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[12:16], direction)
+	binary.LittleEndian.PutUint32(record[16:20], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(record[20:24], uint32(at.Nanosecond()/1000))
+	return append(record, data...)
+}
+
+func TestReadTTYLog(t *testing.T) {
+	started := time.Unix(1600000000, 0)
+	var data []byte
+	data = append(data, ttyLogRecord(ttyLogOpen, 0, started, "")...)
+	data = append(data, ttyLogRecord(ttyLogWrite, ttyLogOutput, started.Add(100*time.Millisecond), "root@svr04:~# ")...)
+	data = append(data, ttyLogRecord(ttyLogWrite, ttyLogInput, started.Add(2*time.Second), "uname -a\r")...)
+	data = append(data, ttyLogRecord(ttyLogWrite, ttyLogOutput, started.Add(2500*time.Millisecond), "Linux svr04\r\n")...)
+	data = append(data, ttyLogRecord(ttyLogClose, 0, started.Add(3*time.Second), "")...)
+
+	recording, err := ReadTTYLog(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording.Header.Timestamp != 1600000000 || len(recording.Events) != 3 || recording.Events[1].Type != "i" || recording.Events[2].Time != 2.5 {
+		t.Errorf("recording not match\nexpected: 3 events from 1600000000, actual: %+v", recording)
+	}
+	if _, err := ReadTTYLog(data[:50]); err == nil {
+		t.Errorf("truncated ttylog not match\nexpected: error, actual: nil")
+	}
+
+	dir, err := ioutil.TempDir("", "tty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "session.cast")
+	if err := WriteCast(fileName, recording); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCast(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, recording) {
+		t.Errorf("read recording not match\nexpected: %+v, actual: %+v", recording, read)
+	}
+	content, _ := ioutil.ReadFile(fileName)
+	if !strings.Contains(string(content), `[2,"i","uname -a\r"]`) {
+		t.Errorf("cast event not match\nexpected: [2,\"i\",\"uname -a\\r\"], actual: %s", content)
+	}
+
+	var output bytes.Buffer
+	if err := read.Play(context.Background(), &output, 1000, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "root@svr04:~# Linux svr04\r\n" {
+		t.Errorf("played output not match\nexpected: %q, actual: %q", "root@svr04:~# Linux svr04\r\n", output.String())
+	}
+}
+
+// writeTelnetCapture writes a telnet session to the pot, with a window size negotiation and the
+// prompt sent out of order.
+func writeTelnetCapture(t *testing.T, fileName string, started time.Time) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := pcapgo.NewWriter(file)
+	_ = writer.WriteFileHeader(65536, layers.LinkTypeEthernet)
+
+	client, pot := net.ParseIP("192.0.2.1"), net.ParseIP("172.18.0.2")
+	segments := []struct {
+		fromPot bool
+		tcp     layers.TCP
+		payload string
+	}{
+		{false, layers.TCP{SrcPort: 50000, DstPort: 23, Seq: 99, SYN: true}, ""},
+		{true, layers.TCP{SrcPort: 23, DstPort: 50000, Seq: 499, SYN: true, ACK: true}, ""},
+		{true, layers.TCP{SrcPort: 23, DstPort: 50000, Seq: 500, ACK: true}, "\xff\xfd\x1f\xff\xfb\x01"},
+		{false, layers.TCP{SrcPort: 50000, DstPort: 23, Seq: 100, ACK: true}, "\xff\xfb\x1f\xff\xfa\x1f\x00\x84\x00\x28\xff\xf0"},
+		{true, layers.TCP{SrcPort: 23, DstPort: 50000, Seq: 513, ACK: true}, "# "},
+		{true, layers.TCP{SrcPort: 23, DstPort: 50000, Seq: 506, ACK: true}, "login: "},
+		{true, layers.TCP{SrcPort: 23, DstPort: 50000, Seq: 506, ACK: true}, "login: "},
+		{false, layers.TCP{SrcPort: 50000, DstPort: 23, Seq: 112, ACK: true}, "id\r\x00"},
+		{true, layers.TCP{SrcPort: 23, DstPort: 50000, Seq: 515, ACK: true}, "uid=0(root)\r\n"},
+	}
+	for index, segment := range segments {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: client, DstIP: pot}
+		if segment.fromPot {
+			ip.SrcIP, ip.DstIP = pot, client
+		}
+		tcp := segment.tcp
+		_ = tcp.SetNetworkLayerForChecksum(ip)
+		ethernet := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+		buffer := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ethernet, ip, &tcp, gopacket.Payload(segment.payload)); err != nil {
+			t.Fatal(err)
+		}
+		data := buffer.Bytes()
+		_ = writer.WritePacket(gopacket.CaptureInfo{Timestamp: started.Add(time.Duration(index) * time.Second), CaptureLength: len(data), Length: len(data)}, data)
+	}
+}
+
+func TestRecordRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runPath := filepath.Join(dir, "telnet_1600000000")
+	if err := os.MkdirAll(runPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Unix(1600000000, 0)
+	if err := WriteManifest(runPath, Manifest{Pot: "telnet", StartedAt: started, Subnets: []string{"172.18.0.0/16"}}); err != nil {
+		t.Fatal(err)
+	}
+	writeTelnetCapture(t, filepath.Join(runPath, "network.pcap"), started)
+
+	ttyLog := "/cowrie/cowrie-git/var/lib/cowrie/tty/e1d8c5a5d2b5f8a0c1e2d3f4a5b6c7d8"
+	if err := ioutil.WriteFile(filepath.Join(runPath, "container.diff"), []byte("A "+ttyLog+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := os.Create(filepath.Join(runPath, "dump.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := append(ttyLogRecord(ttyLogOpen, 0, started, ""), ttyLogRecord(ttyLogWrite, ttyLogOutput, started, "$ ")...)
+	writer := tar.NewWriter(dump)
+	_ = writer.WriteHeader(&tar.Header{Name: strings.TrimPrefix(ttyLog, "/"), Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	_, _ = writer.Write(content)
+	_ = writer.Close()
+	_ = dump.Close()
+
+	names, err := RecordRun(runPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "telnet-192.0.2.1-50000-1600000000, tty-e1d8c5a5d2b5f8a0"
+	if strings.Join(names, ", ") != expected {
+		t.Errorf("sessions not match\nexpected: %s, actual: %s", expected, strings.Join(names, ", "))
+	}
+	if listed, _ := ReadRunRecordings(runPath); strings.Join(listed, ", ") != expected {
+		t.Errorf("listed sessions not match\nexpected: %s, actual: %s", expected, strings.Join(listed, ", "))
+	}
+
+	recording, err := ReadCast(filepath.Join(runPath, TTYDirName, names[0]+CastExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output, input []string
+	for _, event := range recording.Events {
+		if event.Type == "o" {
+			output = append(output, event.Data)
+		} else {
+			input = append(input, event.Data)
+		}
+	}
+	if strings.Join(output, "") != "login: # uid=0(root)\r\n" || strings.Join(input, "") != "id\r" {
+		t.Errorf("telnet session not match\nexpected: %q and %q, actual: %q and %q", "login: # uid=0(root)\r\n", "id\r", strings.Join(output, ""), strings.Join(input, ""))
+	}
+	if recording.Header.Width != 132 || recording.Header.Height != 40 || recording.Header.Title != "telnet 192.0.2.1:50000 -> 172.18.0.2:23" {
+		t.Errorf("header not match\nexpected: 132x40 telnet 192.0.2.1:50000 -> 172.18.0.2:23, actual: %dx%d %s", recording.Header.Width, recording.Header.Height, recording.Header.Title)
+	}
+}
@@ -0,0 +1,549 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VaultZip = "zip" // password protected zip, readable by unzip and 7-Zip
+	VaultXOR = "xor" // content xored with the password
+
+	DefaultVaultPassword = "infected"
+)
+
+// Sample is the metadata of a file quarantined in the vault.
+type Sample struct {
+	SHA256    string    `json:"sha256"`
+	SHA1      string    `json:"sha1"`
+	MD5       string    `json:"md5"`
+	Size      int64     `json:"size"`
+	FileType  string    `json:"file_type"`
+	Format    string    `json:"format"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Pots      []string  `json:"pots"`
+	Paths     []string  `json:"paths"`
+	Runs      []string  `json:"runs"`
+	YaraRules []string  `json:"yara_rules,omitempty"`
+}
+
+// SampleSighting is where and when a sample was collected.
+type SampleSighting struct {
+	Time      time.Time
+	Pot       string
+	Path      string
+	Run       string
+	YaraRules []string
+}
+
+// Vault stores samples by SHA-256 under <root>/<first two hex digits>/ in a form that can not be
+// run or opened by accident, next to a JSON metadata record.
+type Vault struct {
+	Root     string
+	Format   string
+	Password string
+
+	mutex sync.Mutex
+}
+
+func NewVault(root string, format string, password string) (*Vault, error) {
+	switch format {
+	case "":
+		format = VaultZip
+	case VaultZip, VaultXOR:
+	default:
+		return nil, fmt.Errorf("unknown %s vault format, expected zip or xor", format)
+	}
+	if password == "" {
+		password = DefaultVaultPassword
+	}
+	return &Vault{Root: root, Format: format, Password: password}, nil
+}
+
+func (v *Vault) samplePath(hash string, extension string) string {
+	return filepath.Join(v.Root, hash[:2], hash+extension)
+}
+
+// Put quarantines the content and records the sighting, returning the metadata and whether the
+// sample is new to the vault.
+func (v *Vault) Put(data []byte, sighting SampleSighting) (Sample, bool, error) {
+	sha256Sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sha256Sum[:])
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	sample, err := v.readSample(hash)
+	created := os.IsNotExist(err)
+	if err != nil && !created {
+		return sample, false, err
+	}
+
+	if created {
+		sha1Sum, md5Sum := sha1.Sum(data), md5.Sum(data)
+		sample = Sample{
+			SHA256:    hash,
+			SHA1:      hex.EncodeToString(sha1Sum[:]),
+			MD5:       hex.EncodeToString(md5Sum[:]),
+			Size:      int64(len(data)),
+			FileType:  DetectFileType(data),
+			Format:    v.Format,
+			FirstSeen: sighting.Time,
+			LastSeen:  sighting.Time,
+		}
+
+		var content []byte
+		if v.Format == VaultXOR {
+			content = xorContent(data, v.Password)
+		} else if content, err = encryptZip(hash, data, v.Password, sighting.Time); err != nil {
+			return sample, false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(v.samplePath(hash, "")), 0700); err != nil {
+			return sample, false, err
+		}
+		if err := writeFileAtomic(v.samplePath(hash, "."+v.Format), content, 0600); err != nil {
+			return sample, false, err
+		}
+	}
+
+	if sighting.Time.Before(sample.FirstSeen) {
+		sample.FirstSeen = sighting.Time
+	}
+	if sighting.Time.After(sample.LastSeen) {
+		sample.LastSeen = sighting.Time
+	}
+	sample.Pots = appendUnique(sample.Pots, sighting.Pot)
+	sample.Paths = appendUnique(sample.Paths, sighting.Path)
+	sample.Runs = appendUnique(sample.Runs, sighting.Run)
+	for _, rule := range sighting.YaraRules {
+		sample.YaraRules = appendUnique(sample.YaraRules, rule)
+	}
+
+	metadata, err := json.MarshalIndent(sample, "", "  ")
+	if err != nil {
+		return sample, false, err
+	}
+	return sample, created, writeFileAtomic(v.samplePath(hash, ".json"), metadata, 0600)
+}
+
+func (v *Vault) readSample(hash string) (Sample, error) {
+	var sample Sample
+	data, err := ioutil.ReadFile(v.samplePath(hash, ".json"))
+	if err != nil {
+		return sample, err
+	}
+	err = json.Unmarshal(data, &sample)
+	return sample, err
+}
+
+// Samples returns the metadata of every sample, most recently seen first.
+func (v *Vault) Samples() ([]Sample, error) {
+	files, err := filepath.Glob(filepath.Join(v.Root, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for _, file := range files {
+		sample, err := v.readSample(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return samples, fmt.Errorf("%s - %s", filepath.Base(file), err)
+		}
+		samples = append(samples, sample)
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		if !samples[i].LastSeen.Equal(samples[j].LastSeen) {
+			return samples[i].LastSeen.After(samples[j].LastSeen)
+		}
+		return samples[i].SHA256 < samples[j].SHA256
+	})
+	return samples, nil
+}
+
+// Sample returns the metadata of the sample with the hash, or an unambiguous prefix of it.
+func (v *Vault) Sample(hash string) (Sample, error) {
+	hash = strings.ToLower(hash)
+	if len(hash) < 4 {
+		return Sample{}, errors.New("sample hash prefix shorter than 4 digits")
+	}
+	if _, err := hex.DecodeString(hash[:len(hash)/2*2]); err != nil || len(hash) > sha256.Size*2 {
+		return Sample{}, fmt.Errorf("invalid sample hash %s", hash)
+	}
+
+	files, err := filepath.Glob(filepath.Join(v.Root, hash[:2], hash+"*.json"))
+	if err != nil {
+		return Sample{}, err
+	}
+	switch len(files) {
+	case 0:
+		return Sample{}, fmt.Errorf("sample %s not found", hash)
+	case 1:
+		return v.readSample(strings.TrimSuffix(filepath.Base(files[0]), ".json"))
+	}
+	return Sample{}, fmt.Errorf("sample %s is ambiguous, %d samples match", hash, len(files))
+}
+
+// Read returns the original content of the sample.
+func (v *Vault) Read(sample Sample) ([]byte, error) {
+	content, err := ioutil.ReadFile(v.samplePath(sample.SHA256, "."+sample.Format))
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch sample.Format {
+	case VaultXOR:
+		data = xorContent(content, v.Password)
+	case VaultZip:
+		if data, err = decryptZip(content, v.Password); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown %s vault format", sample.Format)
+	}
+
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != sample.SHA256 {
+		return nil, fmt.Errorf("sample %s does not match its hash, wrong password?", sample.SHA256)
+	}
+	return data, nil
+}
+
+// ExportZip writes the sample as a zip protected by the vault password, named by its hash.
+func (v *Vault) ExportZip(w io.Writer, sample Sample) error {
+	if sample.Format == VaultZip {
+		file, err := os.Open(v.samplePath(sample.SHA256, ".zip"))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		return err
+	}
+
+	data, err := v.Read(sample)
+	if err != nil {
+		return err
+	}
+	content, err := encryptZip(sample.SHA256, data, v.Password, sample.FirstSeen)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// QuarantineRun stores the files of dump.tar of the run added or changed in container.diff in the
+// vault with the yara rules of the manifest they matched, and returns their metadata.
+func QuarantineRun(vault *Vault, runPath string, manifest Manifest, maxFileSize int64) ([]Sample, error) {
+	var samples []Sample
+	err := walkRunFiles(runPath, false, maxFileSize, func(path string, data []byte) error {
+		var rules []string
+		for _, match := range manifest.YaraMatches {
+			if match.Path == path && !match.Incomplete {
+				rules = append(rules, match.Rule)
+			}
+		}
+
+		sample, _, err := vault.Put(data, SampleSighting{
+			Time:      manifest.StartedAt,
+			Pot:       manifest.Pot,
+			Path:      path,
+			Run:       filepath.Base(runPath),
+			YaraRules: rules,
+		})
+		if err != nil {
+			return err
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	return samples, err
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func writeFileAtomic(fileName string, data []byte, mode os.FileMode) error {
+	temporary := fileName + ".tmp"
+	if err := ioutil.WriteFile(temporary, data, mode); err != nil {
+		return err
+	}
+	return os.Rename(temporary, fileName)
+}
+
+func xorContent(data []byte, password string) []byte {
+	result := make([]byte, len(data))
+	for index, b := range data {
+		result[index] = b ^ password[index%len(password)]
+	}
+	return result
+}
+
+// zipCrypto is the traditional PKWARE encryption of zip, the one every unzip tool reads.
+type zipCrypto struct {
+	keys [3]uint32
+}
+
+func newZipCrypto(password string) *zipCrypto {
+	crypto := &zipCrypto{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for index := 0; index < len(password); index++ {
+		crypto.update(password[index])
+	}
+	return crypto
+}
+
+func crc32Byte(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ (crc >> 8)
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.keys[0] = crc32Byte(z.keys[0], b)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crc32Byte(z.keys[2], byte(z.keys[1]>>24))
+}
+
+func (z *zipCrypto) stream() byte {
+	temp := uint16(z.keys[2] | 2)
+	return byte((uint32(temp) * uint32(temp^1)) >> 8)
+}
+
+func (z *zipCrypto) encrypt(data []byte) []byte {
+	result := make([]byte, len(data))
+	for index, b := range data {
+		result[index] = b ^ z.stream()
+		z.update(b)
+	}
+	return result
+}
+
+func (z *zipCrypto) decrypt(data []byte) []byte {
+	result := make([]byte, len(data))
+	for index, b := range data {
+		result[index] = b ^ z.stream()
+		z.update(result[index])
+	}
+	return result
+}
+
+type zipLocalHeader struct {
+	Signature        uint32
+	Version          uint16
+	Flags            uint16
+	Method           uint16
+	ModifiedTime     uint16
+	ModifiedDate     uint16
+	CRC32            uint32
+	CompressedSize   uint32
+	UncompressedSize uint32
+	NameLength       uint16
+	ExtraLength      uint16
+}
+
+type zipCentralHeader struct {
+	Signature        uint32
+	VersionMadeBy    uint16
+	Version          uint16
+	Flags            uint16
+	Method           uint16
+	ModifiedTime     uint16
+	ModifiedDate     uint16
+	CRC32            uint32
+	CompressedSize   uint32
+	UncompressedSize uint32
+	NameLength       uint16
+	ExtraLength      uint16
+	CommentLength    uint16
+	DiskStart        uint16
+	InternalAttrs    uint16
+	ExternalAttrs    uint32
+	Offset           uint32
+}
+
+type zipEndRecord struct {
+	Signature     uint32
+	Disk          uint16
+	DirectoryDisk uint16
+	DiskEntries   uint16
+	Entries       uint16
+	DirectorySize uint32
+	Offset        uint32
+	CommentLength uint16
+}
+
+// encryptZip returns a zip holding the data stored, without compression, under name and
+// encrypted with the password.
+func encryptZip(name string, data []byte, password string, modified time.Time) ([]byte, error) {
+	if uint64(len(data)) >= 0xffffffff-12 {
+		return nil, errors.New("sample too large for zip")
+	}
+
+	checksum := crc32.ChecksumIEEE(data)
+	header := make([]byte, 12)
+	if _, err := rand.Read(header[:11]); err != nil {
+		return nil, err
+	}
+	header[11] = byte(checksum >> 24)
+
+	crypto := newZipCrypto(password)
+	encrypted := append(crypto.encrypt(header), crypto.encrypt(data)...)
+
+	if modified.Year() < 1980 {
+		modified = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	modifiedTime := uint16(modified.Hour()<<11 | modified.Minute()<<5 | modified.Second()/2)
+	modifiedDate := uint16((modified.Year()-1980)<<9 | int(modified.Month())<<5 | modified.Day())
+
+	var buffer bytes.Buffer
+	local := zipLocalHeader{
+		Signature: 0x04034b50, Version: 20, Flags: 1, ModifiedTime: modifiedTime, ModifiedDate: modifiedDate,
+		CRC32: checksum, CompressedSize: uint32(len(encrypted)), UncompressedSize: uint32(len(data)), NameLength: uint16(len(name)),
+	}
+	_ = binary.Write(&buffer, binary.LittleEndian, local)
+	buffer.WriteString(name)
+	buffer.Write(encrypted)
+
+	directoryOffset := buffer.Len()
+	central := zipCentralHeader{
+		Signature: 0x02014b50, VersionMadeBy: 20, Version: 20, Flags: 1, ModifiedTime: modifiedTime, ModifiedDate: modifiedDate,
+		CRC32: checksum, CompressedSize: uint32(len(encrypted)), UncompressedSize: uint32(len(data)), NameLength: uint16(len(name)),
+	}
+	_ = binary.Write(&buffer, binary.LittleEndian, central)
+	buffer.WriteString(name)
+
+	end := zipEndRecord{
+		Signature: 0x06054b50, DiskEntries: 1, Entries: 1,
+		DirectorySize: uint32(buffer.Len() - directoryOffset), Offset: uint32(directoryOffset),
+	}
+	_ = binary.Write(&buffer, binary.LittleEndian, end)
+	return buffer.Bytes(), nil
+}
+
+// decryptZip returns the first entry of a zip written by encryptZip.
+func decryptZip(content []byte, password string) ([]byte, error) {
+	var local zipLocalHeader
+	if err := binary.Read(bytes.NewReader(content), binary.LittleEndian, &local); err != nil || local.Signature != 0x04034b50 {
+		return nil, errors.New("not a zip")
+	}
+	if local.Flags&1 == 0 || local.Method != 0 {
+		return nil, errors.New("zip entry is not encrypted and stored")
+	}
+
+	start := binary.Size(local) + int(local.NameLength) + int(local.ExtraLength)
+	end := start + int(local.CompressedSize)
+	if local.CompressedSize < 12 || end > len(content) {
+		return nil, errors.New("truncated zip")
+	}
+
+	plain := newZipCrypto(password).decrypt(content[start:end])
+	if plain[11] != byte(local.CRC32>>24) {
+		return nil, errors.New("wrong zip password")
+	}
+	data := plain[12:]
+	if crc32.ChecksumIEEE(data) != local.CRC32 {
+		return nil, errors.New("zip checksum mismatch")
+	}
+	return data, nil
+}
+
+// fileMagics are the leading bytes of common file types, checked in order.
+var fileMagics = []struct {
+	offset int
+	magic  string
+	kind   string
+}{
+	{0, "MZ", "PE executable"},
+	{0, "\xca\xfe\xba\xbe", "Mach-O universal binary"},
+	{0, "\xcf\xfa\xed\xfe", "Mach-O 64-bit executable"},
+	{0, "\xce\xfa\xed\xfe", "Mach-O executable"},
+	{0, "#!", "script"},
+	{0, "\x1f\x8b", "gzip compressed data"},
+	{0, "BZh", "bzip2 compressed data"},
+	{0, "\xfd7zXZ\x00", "xz compressed data"},
+	{0, "PK\x03\x04", "zip archive"},
+	{0, "7z\xbc\xaf\x27\x1c", "7-zip archive"},
+	{0, "Rar!", "rar archive"},
+	{257, "ustar", "tar archive"},
+	{0, "%PDF-", "PDF document"},
+	{0, "\x89PNG", "PNG image"},
+	{0, "\xff\xd8\xff", "JPEG image"},
+	{0, "GIF8", "GIF image"},
+	{0, "\x00asm", "WebAssembly binary"},
+}
+
+var elfMachines = map[uint16]string{
+	3: "Intel 80386", 8: "MIPS", 20: "PowerPC", 21: "PowerPC64", 40: "ARM", 42: "SuperH",
+	62: "x86-64", 183: "ARM aarch64", 243: "RISC-V", 2: "SPARC", 43: "SPARC V9", 22: "S/390",
+}
+
+// DetectFileType names the type of the content from its magic bytes.
+func DetectFileType(data []byte) string {
+	if len(data) >= 20 && bytes.HasPrefix(data, []byte("\x7fELF")) {
+		class := map[byte]string{1: "32-bit", 2: "64-bit"}[data[4]]
+		var order binary.ByteOrder = binary.LittleEndian
+		endian := "LSB"
+		if data[5] == 2 {
+			order, endian = binary.BigEndian, "MSB"
+		}
+		kind := map[uint16]string{1: "relocatable", 2: "executable", 3: "shared object", 4: "core file"}[order.Uint16(data[16:18])]
+		machine := elfMachines[order.Uint16(data[18:20])]
+		return strings.Join(strings.Fields(fmt.Sprintf("ELF %s %s %s %s", class, endian, kind, machine)), " ")
+	}
+
+	for _, entry := range fileMagics {
+		if len(data) >= entry.offset+len(entry.magic) && string(data[entry.offset:entry.offset+len(entry.magic)]) == entry.magic {
+			if entry.magic == "#!" {
+				line := string(data)
+				if end := strings.IndexByte(line, '\n'); end >= 0 {
+					line = line[:end]
+				}
+				fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+				if len(fields) > 0 {
+					interpreter := filepath.Base(fields[0])
+					if interpreter == "env" && len(fields) > 1 {
+						interpreter = fields[1]
+					}
+					return interpreter + " script"
+				}
+			}
+			return entry.kind
+		}
+	}
+
+	if len(data) == 0 {
+		return "empty"
+	}
+	for _, b := range data {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' {
+			return "data"
+		}
+	}
+	return "text"
+}
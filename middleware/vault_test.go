@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := []byte("\x7fELF\x01\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x28\x00mirai")
+	first, last := time.Unix(1600000000, 0), time.Unix(1600086400, 0)
+
+	for _, format := range []string{VaultZip, VaultXOR} {
+		vault, err := NewVault(filepath.Join(dir, format), format, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sample, created, err := vault.Put(data, SampleSighting{Time: last, Pot: "telnet", Path: "/tmp/x", Run: "telnet_1600086400"})
+		if err != nil || !created {
+			t.Fatalf("sample not created - %v", err)
+		}
+		sample, created, err = vault.Put(data, SampleSighting{Time: first, Pot: "ssh", Path: "/var/tmp/.x", Run: "ssh_1600000000", YaraRules: []string{"mirai"}})
+		if err != nil || created {
+			t.Fatalf("sample created twice - %v", err)
+		}
+
+		if !sample.FirstSeen.Equal(first) || !sample.LastSeen.Equal(last) || strings.Join(sample.Pots, ",") != "telnet,ssh" ||
+			strings.Join(sample.Paths, ",") != "/tmp/x,/var/tmp/.x" || strings.Join(sample.YaraRules, ",") != "mirai" {
+			t.Errorf("%s sample metadata not match\nactual: %+v", format, sample)
+		}
+		if sample.FileType != "ELF 32-bit LSB executable ARM" {
+			t.Errorf("file type not match\nexpected: ELF 32-bit LSB executable ARM, actual: %s", sample.FileType)
+		}
+
+		stored, _ := ioutil.ReadFile(filepath.Join(dir, format, sample.SHA256[:2], sample.SHA256+"."+format))
+		if bytes.Contains(stored, []byte("mirai")) {
+			t.Errorf("%s sample stored in plain", format)
+		}
+
+		found, err := vault.Sample(sample.SHA256[:8])
+		if err != nil || found.SHA256 != sample.SHA256 {
+			t.Errorf("sample by prefix not found - %v", err)
+		}
+		content, err := vault.Read(found)
+		if err != nil || !bytes.Equal(content, data) {
+			t.Errorf("%s sample content not match - %v", format, err)
+		}
+
+		var exported bytes.Buffer
+		if err := vault.ExportZip(&exported, found); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := decryptZip(exported.Bytes(), DefaultVaultPassword); err != nil || !bytes.Equal(content, data) {
+			t.Errorf("%s exported zip not match - %v", format, err)
+		}
+		if _, err := decryptZip(exported.Bytes(), "wrong"); err == nil {
+			t.Errorf("zip opened with wrong password")
+		}
+
+		samples, err := vault.Samples()
+		if err != nil || len(samples) != 1 {
+			t.Errorf("samples not match\nexpected: 1, actual: %d - %v", len(samples), err)
+		}
+	}
+
+	if _, err := NewVault(dir, "rot13", ""); err == nil {
+		t.Errorf("unknown vault format accepted")
+	}
+}
+
+func TestQuarantineRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runPath := writeTestRun(t, dir)
+	vault, _ := NewVault(filepath.Join(dir, "vault"), VaultZip, "")
+	manifest, _ := ReadManifest(runPath)
+	manifest.YaraMatches = []YaraMatch{{Rule: "dropper", Path: "/tmp/x"}, {Rule: "passwd", Path: "/etc/passwd"}}
+
+	samples, err := QuarantineRun(vault, runPath, manifest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || samples[0].Paths[0] != "/tmp/x" || samples[0].Runs[0] != "ssh_1600000000" || strings.Join(samples[0].YaraRules, ",") != "dropper" || samples[0].FileType != "text" {
+		t.Errorf("quarantined samples not match\nexpected: /tmp/x matching dropper, actual: %+v", samples)
+	}
+}
+
+func TestDetectFileType(t *testing.T) {
+	types := map[string]string{
+		"MZ\x90\x00":                      "PE executable",
+		"#!/usr/bin/env python3\nprint()": "python3 script",
+		"#!/bin/sh\nwget x":               "sh script",
+		"\x1f\x8b\x08\x00":                "gzip compressed data",
+		"\x00\x01\x02":                    "data",
+		"":                                "empty",
+	}
+	for data, expected := range types {
+		if actual := DetectFileType([]byte(data)); actual != expected {
+			t.Errorf("file type not match\nexpected: %s, actual: %s", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,1709 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rules are read by a matcher for the common subset of YARA: text, hex and regular expression
+// strings with nocase, wide, ascii, fullword and private modifiers, and conditions on strings,
+// counts, offsets, filesize, integer reads, "of" sets and earlier rules. Modules, includes and
+// loops are rejected when the rules are compiled, and need rules loaded for the yara binary.
+
+// maxStringMatches limits the matches remembered for one string in one file.
+const maxStringMatches = 10000
+
+// maxHexOffsetSteps limits the backtracking of one hex string at one offset, as jumps can
+// otherwise try every combination of skips.
+const maxHexOffsetSteps = 1 << 12
+
+// maxHexSteps and maxHexStepsPerByte limit the backtracking of one hex string in one file.
+const (
+	maxHexSteps        = 1 << 24
+	maxHexStepsPerByte = 32
+)
+
+// YaraRules is a compiled set of rules, or the rule files scanned with the yara binary.
+type YaraRules struct {
+	rules   []*yaraRule
+	names   map[string]int
+	skipped []string // rule files left out, with the reason
+
+	binary string
+	files  []string
+	count  int
+}
+
+// YaraRuleMatch is a rule matching scanned data, or a rule left incomplete when a hex string ran
+// out of backtracking steps before the data was fully searched.
+type YaraRuleMatch struct {
+	Rule       string
+	Tags       []string
+	Strings    []string // identifiers of matched strings
+	Incomplete bool     // the rule did not match the part of the data searched
+}
+
+type yaraRule struct {
+	name      string
+	tags      []string
+	meta      map[string]string
+	private   bool
+	global    bool
+	strings   []*yaraString
+	condition yaraExpr
+}
+
+type yaraString struct {
+	id       string
+	text     []byte
+	hex      []hexElement
+	regex    *regexp.Regexp
+	nocase   bool
+	wide     bool
+	ascii    bool
+	fullword bool
+}
+
+// hexElement is a masked byte, a jump or a set of alternatives of a hex string.
+type hexElement struct {
+	value        byte
+	mask         byte
+	jump         bool
+	jumpMin      int
+	jumpMax      int // -1 for unbounded
+	alternatives [][]hexElement
+}
+
+type yaraHit struct {
+	offset int
+	length int
+}
+
+// LoadYaraRules compiles the .yar and .yara files under the directory, or the single rule file.
+// Files of the directory that fail to compile are skipped with a warning.
+func LoadYaraRules(path string) (*YaraRules, error) {
+	files, isDir, err := yaraRuleFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &YaraRules{names: make(map[string]int)}
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		loaded := len(rules.rules)
+		if err := rules.compile(string(source)); err != nil {
+			if !isDir {
+				return nil, fmt.Errorf("%s %s", file, err)
+			}
+			// a file using what the matcher does not support is left out with its rules
+			for _, rule := range rules.rules[loaded:] {
+				delete(rules.names, rule.name)
+			}
+			rules.rules = rules.rules[:loaded]
+			rules.skip(file, err)
+		}
+	}
+	if len(rules.rules) == 0 {
+		return nil, fmt.Errorf("no rules found in %s", path)
+	}
+	return rules, nil
+}
+
+// LoadYaraBinaryRules checks the .yar and .yara files under the directory, or the single rule
+// file, with the yara binary, which then scans with the full rule language. Files of the
+// directory that fail to compile are skipped with a warning.
+func LoadYaraBinaryRules(path string, binary string) (*YaraRules, error) {
+	files, isDir, err := yaraRuleFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &YaraRules{binary: binary}
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if output, err := exec.Command(binary, "-w", file, os.DevNull).CombinedOutput(); err != nil {
+			if message := strings.TrimSpace(string(output)); message != "" {
+				err = errors.New(message)
+			}
+			if !isDir {
+				return nil, fmt.Errorf("%s %s", file, err)
+			}
+			rules.skip(file, err)
+			continue
+		}
+		rules.files = append(rules.files, file)
+		rules.count += len(yaraRuleDeclaration.FindAll(source, -1))
+	}
+	if len(rules.files) == 0 {
+		return nil, fmt.Errorf("no rules found in %s", path)
+	}
+	return rules, nil
+}
+
+// yaraRuleDeclaration counts the rules of a file scanned with the yara binary.
+var yaraRuleDeclaration = regexp.MustCompile(`(?m)^\s*(?:(?:private|global)\s+)*rule\s+\w+`)
+
+// yaraRuleFiles returns the .yar and .yara files under the directory, or the single rule file.
+func yaraRuleFiles(path string) ([]string, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return []string{path}, false, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		extension := strings.ToLower(filepath.Ext(file))
+		if !info.IsDir() && (extension == ".yar" || extension == ".yara") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, true, err
+}
+
+func (r *YaraRules) skip(file string, err error) {
+	r.skipped = append(r.skipped, fmt.Sprintf("%s - %s", file, err))
+	log.Printf("Skipped yara rules of %s - %s", file, err)
+}
+
+// CompileYaraRules compiles rules from source.
+func CompileYaraRules(source string) (*YaraRules, error) {
+	rules := &YaraRules{names: make(map[string]int)}
+	if err := rules.compile(source); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Len returns the number of rules.
+func (r *YaraRules) Len() int {
+	if r.binary != "" {
+		return r.count
+	}
+	return len(r.rules)
+}
+
+// Skipped returns the rule files left out of a directory, with the reason.
+func (r *YaraRules) Skipped() []string {
+	return r.skipped
+}
+
+// scanDirectory scans the files of the directory with the yara binary and returns the public rules
+// matching each of them by file name.
+func (r *YaraRules) scanDirectory(dir string) (map[string][]YaraRuleMatch, error) {
+	arguments := []string{"-w", "-g", "-s", "-a", strconv.Itoa(yaraBinaryTimeout)}
+	for index, file := range r.files {
+		// each file gets its own namespace, as the built-in matcher keeps them apart
+		arguments = append(arguments, fmt.Sprintf("file%d:%s", index, file))
+	}
+	arguments = append(arguments, dir)
+
+	var stderr bytes.Buffer
+	command := exec.Command(r.binary, arguments...)
+	command.Stderr = &stderr
+	output, err := command.Output()
+	if err == nil && stderr.Len() > 0 {
+		// files failing to scan, like on a timeout, are reported without failing the scan
+		err = errors.New("scan failed")
+	}
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			err = fmt.Errorf("%s - %s", err, message)
+		}
+	}
+	return parseYaraOutput(output), err
+}
+
+// yaraBinaryTimeout is the seconds the yara binary scans one file.
+const yaraBinaryTimeout = 60
+
+// parseYaraOutput reads the matches printed by yara -g -s, a rule line with its tags and the file
+// followed by a line for each string found.
+func parseYaraOutput(output []byte) map[string][]YaraRuleMatch {
+	matches := make(map[string][]YaraRuleMatch)
+	var file string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "0x") {
+			fields := strings.SplitN(line, ":", 3)
+			if file == "" || len(fields) < 3 {
+				continue
+			}
+			match := &matches[file][len(matches[file])-1]
+			if len(match.Strings) == 0 || match.Strings[len(match.Strings)-1] != fields[1] {
+				match.Strings = append(match.Strings, fields[1])
+			}
+			continue
+		}
+
+		start, end := strings.Index(line, " ["), strings.Index(line, "] ")
+		if start <= 0 || end < start {
+			file = ""
+			continue
+		}
+		match := YaraRuleMatch{Rule: line[:start]}
+		if tags := line[start+2 : end]; tags != "" {
+			match.Tags = strings.Split(tags, ",")
+		}
+		file = filepath.Base(line[end+2:])
+		matches[file] = append(matches[file], match)
+	}
+	return matches
+}
+
+func (r *YaraRules) compile(source string) error {
+	parser := &yaraParser{lexer: &yaraLexer{source: source, line: 1}, rules: r}
+	if err := parser.advance(); err != nil {
+		return err
+	}
+	for parser.token.kind != tokenEOF {
+		rule, err := parser.parseRule()
+		if err != nil {
+			return err
+		}
+		if _, found := r.names[rule.name]; found {
+			return fmt.Errorf("line %d: duplicated rule %s", parser.token.line, rule.name)
+		}
+		r.names[rule.name] = len(r.rules)
+		r.rules = append(r.rules, rule)
+	}
+	return nil
+}
+
+// Scan returns the public rules matching the data, and the public rules not matching it whose
+// strings could not be searched completely as incomplete.
+func (r *YaraRules) Scan(data []byte) []YaraRuleMatch {
+	scan := &yaraScan{data: data, results: make([]bool, len(r.rules))}
+	var lower []byte
+	scan.lower = func() []byte {
+		if lower == nil {
+			lower = toLowerASCII(data)
+		}
+		return lower
+	}
+
+	var matches []YaraRuleMatch
+	globalFailed := false
+	for index, rule := range r.rules {
+		scan.hits = make([][]yaraHit, len(rule.strings))
+		incomplete := false
+		for stringIndex, yaraString := range rule.strings {
+			hits, complete := yaraString.find(data, scan.lower)
+			scan.hits[stringIndex] = hits
+			incomplete = incomplete || !complete
+		}
+
+		value, defined := rule.condition.eval(scan)
+		scan.results[index] = defined && value != 0
+		if rule.global && !scan.results[index] {
+			globalFailed = true
+		}
+		if rule.private {
+			continue
+		}
+		if !scan.results[index] {
+			if incomplete {
+				matches = append(matches, YaraRuleMatch{Rule: rule.name, Tags: rule.tags, Incomplete: true})
+			}
+			continue
+		}
+
+		match := YaraRuleMatch{Rule: rule.name, Tags: rule.tags}
+		for stringIndex, yaraString := range rule.strings {
+			if len(scan.hits[stringIndex]) > 0 {
+				match.Strings = append(match.Strings, yaraString.id)
+			}
+		}
+		matches = append(matches, match)
+	}
+
+	if globalFailed {
+		return nil
+	}
+	return matches
+}
+
+type yaraScan struct {
+	data    []byte
+	lower   func() []byte
+	hits    [][]yaraHit
+	results []bool
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func toLowerASCII(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for index, b := range data {
+		if b >= 'A' && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		lower[index] = b
+	}
+	return lower
+}
+
+func widen(text []byte) []byte {
+	wide := make([]byte, 0, len(text)*2)
+	for _, b := range text {
+		wide = append(wide, b, 0)
+	}
+	return wide
+}
+
+// find returns the matches of the string in the data, and whether the data was searched completely.
+func (s *yaraString) find(data []byte, lower func() []byte) ([]yaraHit, bool) {
+	var hits []yaraHit
+	complete := true
+	fullword := func(offset int, length int, step int) bool {
+		if !s.fullword {
+			return true
+		}
+		if offset-step >= 0 && isWordByte(data[offset-step]) {
+			return false
+		}
+		return offset+length >= len(data) || !isWordByte(data[offset+length])
+	}
+
+	switch {
+	case s.text != nil:
+		haystack := data
+		if s.nocase {
+			haystack = lower()
+		}
+		var variants [][]byte
+		if s.ascii || !s.wide {
+			variants = append(variants, s.text)
+		}
+		if s.wide {
+			variants = append(variants, widen(s.text))
+		}
+		for variantIndex, needle := range variants {
+			step := 1
+			if s.wide && (variantIndex == 1 || !s.ascii) {
+				step = 2
+			}
+			for start := 0; start <= len(haystack)-len(needle) && len(hits) < maxStringMatches; {
+				index := bytes.Index(haystack[start:], needle)
+				if index < 0 {
+					break
+				}
+				if fullword(start+index, len(needle), step) {
+					hits = append(hits, yaraHit{start + index, len(needle)})
+				}
+				start += index + 1
+			}
+		}
+		sort.Slice(hits, func(i, j int) bool { return hits[i].offset < hits[j].offset })
+	case s.hex != nil:
+		total := maxHexSteps + maxHexStepsPerByte*len(data)
+		for offset := 0; offset < len(data) && len(hits) < maxStringMatches; offset++ {
+			if first := s.hex[0]; !first.jump && first.alternatives == nil && first.mask == 0xff {
+				index := bytes.IndexByte(data[offset:], first.value)
+				if index < 0 {
+					break
+				}
+				offset += index
+			}
+			if total <= 0 {
+				return hits, false
+			}
+			budget := maxHexOffsetSteps
+			if budget > total {
+				budget = total
+			}
+			steps := budget
+			end, matched := matchHex(s.hex, data, offset, &steps)
+			total -= budget - steps
+			if matched {
+				hits = append(hits, yaraHit{offset, end - offset})
+			} else if steps <= 0 {
+				complete = false
+			}
+		}
+	case s.regex != nil:
+		for _, index := range s.regex.FindAllIndex(data, maxStringMatches) {
+			if index[1] > index[0] && fullword(index[0], index[1]-index[0], 1) {
+				hits = append(hits, yaraHit{index[0], index[1] - index[0]})
+			}
+		}
+	}
+	return hits, complete
+}
+
+// matchHex matches the elements at the position, with shortest jumps first, and returns the end.
+// It gives up once the steps are used up, leaving them at zero.
+func matchHex(elements []hexElement, data []byte, position int, steps *int) (int, bool) {
+	if len(elements) == 0 {
+		return position, true
+	}
+	if *steps <= 0 {
+		return 0, false
+	}
+	*steps--
+
+	element := elements[0]
+	switch {
+	case element.alternatives != nil:
+		for _, alternative := range element.alternatives {
+			sequence := make([]hexElement, 0, len(alternative)+len(elements)-1)
+			sequence = append(append(sequence, alternative...), elements[1:]...)
+			if end, matched := matchHex(sequence, data, position, steps); matched {
+				return end, true
+			}
+		}
+	case element.jump:
+		maximum := element.jumpMax
+		if maximum < 0 || position+maximum > len(data) {
+			maximum = len(data) - position
+		}
+		for skip := element.jumpMin; skip <= maximum; skip++ {
+			if end, matched := matchHex(elements[1:], data, position+skip, steps); matched {
+				return end, true
+			}
+		}
+	default:
+		if position < len(data) && data[position]&element.mask == element.value {
+			return matchHex(elements[1:], data, position+1, steps)
+		}
+	}
+	return 0, false
+}
+
+// yaraExpr is a condition expression. Values are integers, booleans are 0 or 1 and undefined
+// values, like reads past the end of the data, make comparisons false.
+type yaraExpr interface {
+	eval(scan *yaraScan) (int64, bool)
+}
+
+type yaraConst int64
+
+func (c yaraConst) eval(scan *yaraScan) (int64, bool) {
+	return int64(c), true
+}
+
+type yaraFilesize struct{}
+
+func (yaraFilesize) eval(scan *yaraScan) (int64, bool) {
+	return int64(len(scan.data)), true
+}
+
+type yaraRuleRef int
+
+func (r yaraRuleRef) eval(scan *yaraScan) (int64, bool) {
+	return boolValue(scan.results[r]), true
+}
+
+// yaraStringRef is $a, $a at offset or $a in (from..to).
+type yaraStringRef struct {
+	index    int
+	at       yaraExpr
+	from, to yaraExpr
+}
+
+func (r yaraStringRef) eval(scan *yaraScan) (int64, bool) {
+	hits := scan.hits[r.index]
+	switch {
+	case r.at != nil:
+		at, defined := r.at.eval(scan)
+		if !defined {
+			return 0, false
+		}
+		for _, hit := range hits {
+			if int64(hit.offset) == at {
+				return 1, true
+			}
+		}
+		return 0, true
+	case r.from != nil:
+		from, fromDefined := r.from.eval(scan)
+		to, toDefined := r.to.eval(scan)
+		if !fromDefined || !toDefined {
+			return 0, false
+		}
+		for _, hit := range hits {
+			if int64(hit.offset) >= from && int64(hit.offset) <= to {
+				return 1, true
+			}
+		}
+		return 0, true
+	}
+	return boolValue(len(hits) > 0), true
+}
+
+// yaraCount is #a.
+type yaraCount int
+
+func (c yaraCount) eval(scan *yaraScan) (int64, bool) {
+	return int64(len(scan.hits[c])), true
+}
+
+// yaraOffset is @a[n] or !a[n], the offset or length of the nth match.
+type yaraOffset struct {
+	index  int
+	nth    yaraExpr
+	length bool
+}
+
+func (o yaraOffset) eval(scan *yaraScan) (int64, bool) {
+	nth := int64(1)
+	if o.nth != nil {
+		var defined bool
+		if nth, defined = o.nth.eval(scan); !defined {
+			return 0, false
+		}
+	}
+	hits := scan.hits[o.index]
+	if nth < 1 || nth > int64(len(hits)) {
+		return 0, false
+	}
+	if o.length {
+		return int64(hits[nth-1].length), true
+	}
+	return int64(hits[nth-1].offset), true
+}
+
+// yaraRead is uint8(offset) and the other integer reads.
+type yaraRead struct {
+	size      int
+	signed    bool
+	bigEndian bool
+	offset    yaraExpr
+}
+
+func (r yaraRead) eval(scan *yaraScan) (int64, bool) {
+	offset, defined := r.offset.eval(scan)
+	if !defined || offset < 0 || offset+int64(r.size) > int64(len(scan.data)) {
+		return 0, false
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if r.bigEndian {
+		order = binary.BigEndian
+	}
+	data := scan.data[offset : offset+int64(r.size)]
+	switch r.size {
+	case 1:
+		if r.signed {
+			return int64(int8(data[0])), true
+		}
+		return int64(data[0]), true
+	case 2:
+		if r.signed {
+			return int64(int16(order.Uint16(data))), true
+		}
+		return int64(order.Uint16(data)), true
+	default:
+		if r.signed {
+			return int64(int32(order.Uint32(data))), true
+		}
+		return int64(order.Uint32(data)), true
+	}
+}
+
+// yaraOf is "any of them", "2 of ($a*)" and the like.
+type yaraOf struct {
+	minimum yaraExpr // nil for all
+	none    bool
+	strings []int
+}
+
+func (o yaraOf) eval(scan *yaraScan) (int64, bool) {
+	matched := 0
+	for _, index := range o.strings {
+		if len(scan.hits[index]) > 0 {
+			matched++
+		}
+	}
+
+	switch {
+	case o.none:
+		return boolValue(matched == 0), true
+	case o.minimum == nil:
+		return boolValue(matched == len(o.strings)), true
+	}
+	minimum, defined := o.minimum.eval(scan)
+	if !defined {
+		return 0, false
+	}
+	return boolValue(int64(matched) >= minimum), true
+}
+
+type yaraUnary struct {
+	operator string
+	operand  yaraExpr
+}
+
+func (u yaraUnary) eval(scan *yaraScan) (int64, bool) {
+	value, defined := u.operand.eval(scan)
+	if !defined {
+		return 0, false
+	}
+	switch u.operator {
+	case "not":
+		return boolValue(value == 0), true
+	case "-":
+		return -value, true
+	default:
+		return ^value, true
+	}
+}
+
+type yaraBinary struct {
+	operator    string
+	left, right yaraExpr
+}
+
+func (b yaraBinary) eval(scan *yaraScan) (int64, bool) {
+	left, leftDefined := b.left.eval(scan)
+	switch b.operator {
+	case "and":
+		if !leftDefined || left == 0 {
+			return 0, true
+		}
+		right, rightDefined := b.right.eval(scan)
+		return boolValue(rightDefined && right != 0), true
+	case "or":
+		if leftDefined && left != 0 {
+			return 1, true
+		}
+		right, rightDefined := b.right.eval(scan)
+		return boolValue(rightDefined && right != 0), true
+	}
+
+	right, rightDefined := b.right.eval(scan)
+	if !leftDefined || !rightDefined {
+		return 0, false
+	}
+
+	switch b.operator {
+	case "==":
+		return boolValue(left == right), true
+	case "!=":
+		return boolValue(left != right), true
+	case "<":
+		return boolValue(left < right), true
+	case "<=":
+		return boolValue(left <= right), true
+	case ">":
+		return boolValue(left > right), true
+	case ">=":
+		return boolValue(left >= right), true
+	case "+":
+		return left + right, true
+	case "-":
+		return left - right, true
+	case "*":
+		return left * right, true
+	case `\`:
+		if right == 0 {
+			return 0, false
+		}
+		return left / right, true
+	case "%":
+		if right == 0 {
+			return 0, false
+		}
+		return left % right, true
+	case "&":
+		return left & right, true
+	case "|":
+		return left | right, true
+	case "^":
+		return left ^ right, true
+	case "<<":
+		if right < 0 {
+			return 0, false
+		}
+		return left << uint64(right), true
+	case ">>":
+		if right < 0 {
+			return 0, false
+		}
+		return left >> uint64(right), true
+	}
+	return 0, false
+}
+
+func boolValue(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+type yaraTokenKind int
+
+const (
+	tokenEOF yaraTokenKind = iota
+	tokenIdentifier
+	tokenText
+	tokenRegex
+	tokenNumber
+	tokenStringID // $a
+	tokenCountID  // #a
+	tokenOffsetID // @a
+	tokenLengthID // !a
+	tokenPunct
+)
+
+type yaraToken struct {
+	kind  yaraTokenKind
+	text  string
+	flags string // modifiers of regular expressions
+	value int64
+	line  int
+}
+
+type yaraLexer struct {
+	source string
+	pos    int
+	line   int
+}
+
+func (l *yaraLexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips white space and comments.
+func (l *yaraLexer) skipSpace() error {
+	for l.pos < len(l.source) {
+		switch {
+		case l.source[l.pos] == '\n':
+			l.line++
+			l.pos++
+		case l.source[l.pos] == ' ' || l.source[l.pos] == '\t' || l.source[l.pos] == '\r':
+			l.pos++
+		case strings.HasPrefix(l.source[l.pos:], "//"):
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.source[l.pos:], "/*"):
+			end := strings.Index(l.source[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.source[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *yaraLexer) next() (yaraToken, error) {
+	if err := l.skipSpace(); err != nil {
+		return yaraToken{}, err
+	}
+	if l.pos >= len(l.source) {
+		return yaraToken{kind: tokenEOF, line: l.line}, nil
+	}
+
+	start := l.pos
+	c := l.source[l.pos]
+	identifierEnd := func(from int) int {
+		end := from
+		for end < len(l.source) && isWordByte(l.source[end]) {
+			end++
+		}
+		return end
+	}
+
+	switch {
+	case c == '$' || c == '#' || c == '@' || (c == '!' && l.pos+1 < len(l.source) && isWordByte(l.source[l.pos+1])):
+		end := identifierEnd(l.pos + 1)
+		if end < len(l.source) && l.source[end] == '*' && c == '$' {
+			end++
+		}
+		l.pos = end
+		kind := map[byte]yaraTokenKind{'$': tokenStringID, '#': tokenCountID, '@': tokenOffsetID, '!': tokenLengthID}[c]
+		return yaraToken{kind: kind, text: l.source[start+1 : end], line: l.line}, nil
+	case isWordByte(c) && !(c >= '0' && c <= '9'):
+		l.pos = identifierEnd(l.pos)
+		return yaraToken{kind: tokenIdentifier, text: l.source[start:l.pos], line: l.line}, nil
+	case c >= '0' && c <= '9':
+		end := identifierEnd(l.pos)
+		text := l.source[start:end]
+		multiplier := int64(1)
+		if strings.HasSuffix(text, "KB") {
+			text, multiplier = strings.TrimSuffix(text, "KB"), 1024
+		} else if strings.HasSuffix(text, "MB") {
+			text, multiplier = strings.TrimSuffix(text, "MB"), 1024*1024
+		}
+		value, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			return yaraToken{}, l.errorf("invalid number %s", l.source[start:end])
+		}
+		l.pos = end
+		return yaraToken{kind: tokenNumber, value: value * multiplier, line: l.line}, nil
+	case c == '"':
+		text, err := l.readText()
+		return yaraToken{kind: tokenText, text: text, line: l.line}, err
+	case c == '/':
+		return l.readRegex()
+	}
+
+	for _, punct := range []string{"..", "==", "!=", "<=", ">=", "<<", ">>"} {
+		if strings.HasPrefix(l.source[l.pos:], punct) {
+			l.pos += len(punct)
+			return yaraToken{kind: tokenPunct, text: punct, line: l.line}, nil
+		}
+	}
+	if strings.ContainsRune(`{}()[]:=,<>+-*\%&|^~`, rune(c)) {
+		l.pos++
+		return yaraToken{kind: tokenPunct, text: string(c), line: l.line}, nil
+	}
+	return yaraToken{}, l.errorf("unexpected %q", c)
+}
+
+// readText reads a double quoted string with \" \\ \t \n \r and \xNN escapes.
+func (l *yaraLexer) readText() (string, error) {
+	var text []byte
+	for l.pos++; l.pos < len(l.source); l.pos++ {
+		c := l.source[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return string(text), nil
+		case '\n':
+			return "", l.errorf("unterminated string")
+		case '\\':
+			if l.pos+1 >= len(l.source) {
+				return "", l.errorf("unterminated string")
+			}
+			l.pos++
+			switch l.source[l.pos] {
+			case 'n':
+				text = append(text, '\n')
+			case 't':
+				text = append(text, '\t')
+			case 'r':
+				text = append(text, '\r')
+			case '"', '\\':
+				text = append(text, l.source[l.pos])
+			case 'x':
+				if l.pos+2 >= len(l.source) {
+					return "", l.errorf("invalid escape")
+				}
+				value, err := strconv.ParseUint(l.source[l.pos+1:l.pos+3], 16, 8)
+				if err != nil {
+					return "", l.errorf("invalid escape \\x%s", l.source[l.pos+1:l.pos+3])
+				}
+				text = append(text, byte(value))
+				l.pos += 2
+			default:
+				return "", l.errorf("invalid escape \\%c", l.source[l.pos])
+			}
+		default:
+			text = append(text, c)
+		}
+	}
+	return "", l.errorf("unterminated string")
+}
+
+func (l *yaraLexer) readRegex() (yaraToken, error) {
+	var pattern []byte
+	for l.pos++; l.pos < len(l.source); l.pos++ {
+		c := l.source[l.pos]
+		switch c {
+		case '\n':
+			return yaraToken{}, l.errorf("unterminated regular expression")
+		case '\\':
+			if l.pos+1 < len(l.source) && l.source[l.pos+1] == '/' {
+				pattern = append(pattern, '/')
+				l.pos++
+				continue
+			}
+			if l.pos+1 < len(l.source) {
+				pattern = append(pattern, c, l.source[l.pos+1])
+				l.pos++
+				continue
+			}
+		case '/':
+			l.pos++
+			start := l.pos
+			for l.pos < len(l.source) && (l.source[l.pos] == 'i' || l.source[l.pos] == 's') {
+				l.pos++
+			}
+			return yaraToken{kind: tokenRegex, text: string(pattern), flags: l.source[start:l.pos], line: l.line}, nil
+		}
+		pattern = append(pattern, c)
+	}
+	return yaraToken{}, l.errorf("unterminated regular expression")
+}
+
+// readRaw returns the source up to the closing character, used for hex strings.
+func (l *yaraLexer) readRaw(closing byte) (string, error) {
+	end := strings.IndexByte(l.source[l.pos:], closing)
+	if end < 0 {
+		return "", l.errorf("missing %c", closing)
+	}
+	raw := l.source[l.pos : l.pos+end]
+	l.line += strings.Count(raw, "\n")
+	l.pos += end + 1
+	return raw, nil
+}
+
+type yaraParser struct {
+	lexer *yaraLexer
+	token yaraToken
+	rules *YaraRules
+	rule  *yaraRule
+}
+
+func (p *yaraParser) advance() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+func (p *yaraParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.token.line, fmt.Sprintf(format, args...))
+}
+
+func (p *yaraParser) is(text string) bool {
+	return (p.token.kind == tokenPunct || p.token.kind == tokenIdentifier) && p.token.text == text
+}
+
+func (p *yaraParser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %s", text)
+	}
+	return p.advance()
+}
+
+func (p *yaraParser) identifier() (string, error) {
+	if p.token.kind != tokenIdentifier {
+		return "", p.errorf("expected identifier")
+	}
+	name := p.token.text
+	return name, p.advance()
+}
+
+func (p *yaraParser) parseRule() (*yaraRule, error) {
+	if p.is("import") || p.is("include") {
+		return nil, p.errorf("%s is not supported", p.token.text)
+	}
+
+	rule := &yaraRule{meta: make(map[string]string)}
+	for p.is("private") || p.is("global") {
+		if p.token.text == "private" {
+			rule.private = true
+		} else {
+			rule.global = true
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("rule"); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if rule.name, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if p.is(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for p.token.kind == tokenIdentifier {
+			rule.tags = append(rule.tags, p.token.text)
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.rule = rule
+
+	if p.is("meta") {
+		if err := p.parseMeta(rule); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("strings") {
+		if err := p.parseStrings(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expect("condition"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if rule.condition, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (p *yaraParser) parseMeta(rule *yaraRule) error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+
+	for p.token.kind == tokenIdentifier && !p.is("strings") && !p.is("condition") {
+		name := p.token.text
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+
+		negative := p.is("-")
+		if negative {
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+		switch {
+		case p.token.kind == tokenText:
+			rule.meta[name] = p.token.text
+		case p.token.kind == tokenNumber && negative:
+			rule.meta[name] = strconv.FormatInt(-p.token.value, 10)
+		case p.token.kind == tokenNumber:
+			rule.meta[name] = strconv.FormatInt(p.token.value, 10)
+		case p.is("true") || p.is("false"):
+			rule.meta[name] = p.token.text
+		default:
+			return p.errorf("invalid meta value of %s", name)
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *yaraParser) parseStrings(rule *yaraRule) error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+
+	for p.token.kind == tokenStringID {
+		yaraString := &yaraString{id: "$" + p.token.text}
+		if p.token.text == "" {
+			// anonymous strings are only used through them
+			yaraString.id = fmt.Sprintf("$anonymous%d", len(rule.strings))
+		}
+		for _, existing := range rule.strings {
+			if existing.id == yaraString.id {
+				return p.errorf("duplicated string %s", yaraString.id)
+			}
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if !p.is("=") {
+			return p.errorf("expected =")
+		}
+
+		// hex strings are read raw, their braces would be taken as a rule body
+		if err := p.lexer.skipSpace(); err != nil {
+			return err
+		}
+		var regexPattern, regexFlags string
+		if p.lexer.pos < len(p.lexer.source) && p.lexer.source[p.lexer.pos] == '{' {
+			p.lexer.pos++
+			raw, err := p.lexer.readRaw('}')
+			if err != nil {
+				return err
+			}
+			if yaraString.hex, err = parseHexString(raw); err != nil {
+				return p.errorf("%s - %s", yaraString.id, err)
+			}
+			if err := p.advance(); err != nil {
+				return err
+			}
+		} else {
+			if err := p.advance(); err != nil {
+				return err
+			}
+			switch p.token.kind {
+			case tokenText:
+				if p.token.text == "" {
+					return p.errorf("empty string %s", yaraString.id)
+				}
+				yaraString.text = []byte(p.token.text)
+			case tokenRegex:
+				regexPattern, regexFlags = p.token.text, p.token.flags
+			default:
+				return p.errorf("expected string value of %s", yaraString.id)
+			}
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+
+		for p.token.kind == tokenIdentifier && !p.is("condition") {
+			switch p.token.text {
+			case "nocase":
+				yaraString.nocase = true
+			case "wide":
+				yaraString.wide = true
+			case "ascii":
+				yaraString.ascii = true
+			case "fullword":
+				yaraString.fullword = true
+			case "private":
+			default:
+				return p.errorf("%s modifier is not supported", p.token.text)
+			}
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case yaraString.hex != nil && (yaraString.nocase || yaraString.wide || yaraString.ascii || yaraString.fullword):
+			return p.errorf("hex string %s takes no modifiers", yaraString.id)
+		case regexPattern != "":
+			if yaraString.wide {
+				return p.errorf("wide regular expression %s is not supported", yaraString.id)
+			}
+			if err := compileYaraRegex(yaraString, regexPattern, regexFlags); err != nil {
+				return p.errorf("%s - %s", yaraString.id, err)
+			}
+		case yaraString.text != nil && yaraString.nocase:
+			yaraString.text = toLowerASCII(yaraString.text)
+		}
+		rule.strings = append(rule.strings, yaraString)
+	}
+	return nil
+}
+
+var highByteEscape = regexp.MustCompile(`\\x[89a-fA-F][0-9a-fA-F]`)
+
+func compileYaraRegex(yaraString *yaraString, pattern string, flags string) error {
+	if highByteEscape.MatchString(pattern) {
+		return errors.New("byte escapes above \\x7f are not supported in regular expressions")
+	}
+	prefix := ""
+	if strings.Contains(flags, "i") || yaraString.nocase {
+		prefix += "i"
+	}
+	if strings.Contains(flags, "s") {
+		prefix += "s"
+	}
+	if prefix != "" {
+		pattern = "(?" + prefix + ")" + pattern
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	yaraString.regex = regex
+	return nil
+}
+
+// parseHexString parses the body of a hex string: bytes, ?? and nibble wildcards, [n-m] jumps
+// and (a | b) alternatives.
+func parseHexString(raw string) ([]hexElement, error) {
+	fields := strings.NewReplacer("[", " [ ", "]", " ] ", "(", " ( ", ")", " ) ", "|", " | ").Replace(raw)
+	tokens := strings.Fields(fields)
+
+	var parse func(position int, nested bool) ([][]hexElement, int, error)
+	parse = func(position int, nested bool) ([][]hexElement, int, error) {
+		var alternatives [][]hexElement
+		var sequence []hexElement
+		for position < len(tokens) {
+			token := tokens[position]
+			switch token {
+			case "(":
+				inner, next, err := parse(position+1, true)
+				if err != nil {
+					return nil, 0, err
+				}
+				sequence = append(sequence, hexElement{alternatives: inner})
+				position = next
+				continue
+			case ")":
+				if !nested {
+					return nil, 0, errors.New("unbalanced )")
+				}
+				return append(alternatives, sequence), position + 1, nil
+			case "|":
+				if !nested {
+					return nil, 0, errors.New("alternatives outside ()")
+				}
+				alternatives = append(alternatives, sequence)
+				sequence = nil
+			case "[":
+				end := position + 1
+				for end < len(tokens) && tokens[end] != "]" {
+					end++
+				}
+				if end == len(tokens) {
+					return nil, 0, errors.New("unterminated jump")
+				}
+				element, err := parseHexJump(strings.Join(tokens[position+1:end], ""))
+				if err != nil {
+					return nil, 0, err
+				}
+				sequence = append(sequence, element)
+				position = end
+			default:
+				if len(token)%2 != 0 {
+					return nil, 0, fmt.Errorf("invalid hex byte %s", token)
+				}
+				for index := 0; index < len(token); index += 2 {
+					element, err := parseHexByte(token[index : index+2])
+					if err != nil {
+						return nil, 0, err
+					}
+					sequence = append(sequence, element)
+				}
+			}
+			position++
+		}
+		if nested {
+			return nil, 0, errors.New("unbalanced (")
+		}
+		return [][]hexElement{sequence}, position, nil
+	}
+
+	result, _, err := parse(0, false)
+	if err != nil {
+		return nil, err
+	}
+	elements := result[0]
+	if len(elements) == 0 {
+		return nil, errors.New("empty hex string")
+	}
+	if elements[0].jump || elements[len(elements)-1].jump {
+		return nil, errors.New("hex string starts or ends with a jump")
+	}
+	return elements, nil
+}
+
+func parseHexByte(text string) (hexElement, error) {
+	var element hexElement
+	for index, nibble := range text {
+		shift := uint(4 * (1 - index))
+		if nibble == '?' {
+			continue
+		}
+		value, err := strconv.ParseUint(string(nibble), 16, 8)
+		if err != nil {
+			return element, fmt.Errorf("invalid hex byte %s", text)
+		}
+		element.value |= byte(value) << shift
+		element.mask |= 0xf << shift
+	}
+	return element, nil
+}
+
+func parseHexJump(text string) (hexElement, error) {
+	element := hexElement{jump: true, jumpMax: -1}
+	parts := strings.SplitN(text, "-", 2)
+
+	var err error
+	if parts[0] != "" {
+		if element.jumpMin, err = strconv.Atoi(parts[0]); err != nil {
+			return element, fmt.Errorf("invalid jump [%s]", text)
+		}
+	}
+	switch {
+	case len(parts) == 1:
+		element.jumpMax = element.jumpMin
+	case parts[1] != "":
+		if element.jumpMax, err = strconv.Atoi(parts[1]); err != nil || element.jumpMax < element.jumpMin {
+			return element, fmt.Errorf("invalid jump [%s]", text)
+		}
+	}
+	return element, nil
+}
+
+func (p *yaraParser) parseOr() (yaraExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("or") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = yaraBinary{"or", left, right}
+	}
+	return left, nil
+}
+
+func (p *yaraParser) parseAnd() (yaraExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("and") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = yaraBinary{"and", left, right}
+	}
+	return left, nil
+}
+
+func (p *yaraParser) parseNot() (yaraExpr, error) {
+	if p.is("not") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return yaraUnary{"not", operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// binaryLevels are the operators of arithmetic and bitwise expressions, loosest first.
+var binaryLevels = [][]string{
+	{"|"}, {"^"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", `\`, "%"},
+}
+
+func (p *yaraParser) parseComparison() (yaraExpr, error) {
+	left, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.token.kind == tokenPunct && p.token.text == operator {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			right, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			return yaraBinary{operator, left, right}, nil
+		}
+	}
+	if p.is("contains") || p.is("matches") || p.is("icontains") || p.is("startswith") || p.is("endswith") {
+		return nil, p.errorf("%s is not supported", p.token.text)
+	}
+	return left, nil
+}
+
+func (p *yaraParser) parseBinary(level int) (yaraExpr, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator := ""
+		for _, candidate := range binaryLevels[level] {
+			if p.token.kind == tokenPunct && p.token.text == candidate {
+				operator = candidate
+			}
+		}
+		if operator == "" {
+			return left, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = yaraBinary{operator, left, right}
+	}
+}
+
+func (p *yaraParser) parseUnary() (yaraExpr, error) {
+	if p.token.kind == tokenPunct && (p.token.text == "-" || p.token.text == "~") {
+		operator := p.token.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return yaraUnary{operator, operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// stringIndex returns the index of the string of the rule being parsed.
+func (p *yaraParser) stringIndex(name string) (int, error) {
+	for index, yaraString := range p.rule.strings {
+		if yaraString.id == "$"+name {
+			return index, nil
+		}
+	}
+	return 0, p.errorf("undefined string $%s", name)
+}
+
+// stringSet returns the strings of them or of a ($a, $b*) list.
+func (p *yaraParser) stringSet() ([]int, error) {
+	var set []int
+	if p.is("them") {
+		for index := range p.rule.strings {
+			set = append(set, index)
+		}
+		if len(set) == 0 {
+			return nil, p.errorf("rule has no strings")
+		}
+		return set, p.advance()
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.token.kind != tokenStringID {
+			return nil, p.errorf("expected string identifier")
+		}
+		if strings.HasSuffix(p.token.text, "*") {
+			prefix := "$" + strings.TrimSuffix(p.token.text, "*")
+			found := false
+			for index, yaraString := range p.rule.strings {
+				if strings.HasPrefix(yaraString.id, prefix) {
+					set = append(set, index)
+					found = true
+				}
+			}
+			if !found {
+				return nil, p.errorf("no strings match $%s", p.token.text)
+			}
+		} else {
+			index, err := p.stringIndex(p.token.text)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, index)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.is(")") {
+			return set, p.advance()
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readFunctions are the integer reads of the data.
+var readFunctions = map[string]yaraRead{
+	"uint8": {size: 1}, "uint16": {size: 2}, "uint32": {size: 4},
+	"int8": {size: 1, signed: true}, "int16": {size: 2, signed: true}, "int32": {size: 4, signed: true},
+	"uint8be": {size: 1, bigEndian: true}, "uint16be": {size: 2, bigEndian: true}, "uint32be": {size: 4, bigEndian: true},
+	"int8be": {size: 1, signed: true, bigEndian: true}, "int16be": {size: 2, signed: true, bigEndian: true}, "int32be": {size: 4, signed: true, bigEndian: true},
+}
+
+func (p *yaraParser) parsePrimary() (yaraExpr, error) {
+	token := p.token
+	switch token.kind {
+	case tokenNumber:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.is("of") {
+			return p.parseOf(yaraConst(token.value), false)
+		}
+		return yaraConst(token.value), nil
+	case tokenStringID:
+		index, err := p.stringIndex(token.text)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		reference := yaraStringRef{index: index}
+		switch {
+		case p.is("at"):
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if reference.at, err = p.parseUnary(); err != nil {
+				return nil, err
+			}
+		case p.is("in"):
+			if reference.from, reference.to, err = p.parseRange(); err != nil {
+				return nil, err
+			}
+		}
+		return reference, nil
+	case tokenCountID:
+		index, err := p.stringIndex(token.text)
+		if err != nil {
+			return nil, err
+		}
+		return yaraCount(index), p.advance()
+	case tokenOffsetID, tokenLengthID:
+		index, err := p.stringIndex(token.text)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		offset := yaraOffset{index: index, length: token.kind == tokenLengthID}
+		if p.is("[") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if offset.nth, err = p.parseOr(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		return offset, nil
+	case tokenPunct:
+		if token.text == "(" {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			expression, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			if p.is("of") {
+				return p.parseOf(expression, false)
+			}
+			return expression, nil
+		}
+	case tokenIdentifier:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		switch token.text {
+		case "true":
+			return yaraConst(1), nil
+		case "false":
+			return yaraConst(0), nil
+		case "filesize":
+			return yaraFilesize{}, nil
+		case "all", "any", "none":
+			if !p.is("of") {
+				return nil, p.errorf("expected of")
+			}
+			switch token.text {
+			case "all":
+				return p.parseOf(nil, false)
+			case "any":
+				return p.parseOf(yaraConst(1), false)
+			default:
+				return p.parseOf(nil, true)
+			}
+		}
+		if read, found := readFunctions[token.text]; found {
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			offset, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			read.offset = offset
+			return read, p.expect(")")
+		}
+		if index, found := p.rules.names[token.text]; found {
+			return yaraRuleRef(index), nil
+		}
+		return nil, fmt.Errorf("line %d: %s is not supported or not a rule defined before", token.line, token.text)
+	}
+	return nil, p.errorf("unexpected %s", token.text)
+}
+
+func (p *yaraParser) parseOf(minimum yaraExpr, none bool) (yaraExpr, error) {
+	if err := p.expect("of"); err != nil {
+		return nil, err
+	}
+	set, err := p.stringSet()
+	if err != nil {
+		return nil, err
+	}
+	if p.is("in") || p.is("at") {
+		return nil, p.errorf("of with %s is not supported", p.token.text)
+	}
+	return yaraOf{minimum: minimum, none: none, strings: set}, nil
+}
+
+func (p *yaraParser) parseRange() (yaraExpr, yaraExpr, error) {
+	if err := p.advance(); err != nil {
+		return nil, nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, nil, err
+	}
+	from, err := p.parseBinary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.expect(".."); err != nil {
+		return nil, nil, err
+	}
+	to, err := p.parseBinary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return from, to, p.expect(")")
+}
@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testYaraRules = `
+/* test rules */
+private rule is_elf {
+	condition:
+		uint32(0) == 0x464c457f
+}
+
+rule mirai : botnet linux {
+	meta:
+		author = "honey-v"
+		score = 80
+	strings:
+		$table = { 47 45 54 20 2F ?? ?? [0-8] 48 54 54 50 }
+		$busybox = "/bin/busybox MIRAI" nocase
+		$kill = /kill -9 [0-9]+/
+	condition:
+		is_elf and 2 of them and filesize < 1MB
+}
+
+rule wide_marker {
+	strings:
+		$marker = "evil" wide fullword
+	condition:
+		#marker == 1 and $marker in (0..100)
+}
+
+rule alternatives {
+	strings:
+		$a = { 4D 5A ( 90 00 | 50 00 ) 0? }
+	condition:
+		$a at 0 and @a[1] == 0 and !a[1] == 5
+}
+
+rule never {
+	strings:
+		$ = "nothing"
+		$ = "here"
+	condition:
+		any of them or not is_elf and false
+}
+`
+
+func TestYaraRules(t *testing.T) {
+	rules, err := CompileYaraRules(testYaraRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.Len() != 5 {
+		t.Errorf("rules not match\nexpected: 5, actual: %d", rules.Len())
+	}
+
+	elf := "\x7fELF\x02\x01\x01" + "GET /x86 HTTP/1.1\r\n" + "/bin/BusyBox mirai\n"
+	samples := map[string]string{
+		"elf":          elf,
+		"elf_one":      elf[:7] + "/bin/busybox MIRAI",
+		"wide":         "x\x00 \x00e\x00v\x00i\x00l\x00 \x00evil",
+		"wide_in_word": "x\x00e\x00v\x00i\x00l\x00s\x00",
+		"mz":           "MZ\x50\x00\x03rest",
+		"not_at_zero":  "_MZ\x90\x00\x00",
+	}
+	expected := map[string]string{
+		"elf":          "mirai [botnet linux] [$table $busybox]",
+		"elf_one":      "",
+		"wide":         "wide_marker [] [$marker]",
+		"wide_in_word": "",
+		"mz":           "alternatives [] [$a]",
+		"not_at_zero":  "",
+	}
+
+	for name, sample := range samples {
+		var actual []string
+		for _, match := range rules.Scan([]byte(sample)) {
+			actual = append(actual, match.Rule+" ["+strings.Join(match.Tags, " ")+"] ["+strings.Join(match.Strings, " ")+"]")
+		}
+		if strings.Join(actual, ", ") != expected[name] {
+			t.Errorf("matches of %s not match\nexpected: %s, actual: %s", name, expected[name], strings.Join(actual, ", "))
+		}
+	}
+
+	invalid := map[string]string{
+		`import "pe" rule a { condition: true }`:                                       "import is not supported",
+		`rule a { strings: $a = "x" xor condition: $a }`:                               "xor modifier is not supported",
+		`rule a { condition: $a }`:                                                     "undefined string $a",
+		`rule a { condition: b }`:                                                      "b is not supported or not a rule defined before",
+		"rule a { strings: $a = { 4D [2-] } condition: $a }":                           "starts or ends with a jump",
+		"rule a { condition: true }\nrule a { condition: true }":                       "duplicated rule a",
+		"rule a {\n strings:\n $a = \"x\"\n condition:\n for any i in (1..2) : ($a) }": "line 5: for is not supported",
+	}
+	for source, message := range invalid {
+		if _, err := CompileYaraRules(source); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("compile error not match\nexpected: %s, actual: %v", message, err)
+		}
+	}
+}
+
+func TestYaraRulesHexJumps(t *testing.T) {
+	rules, err := CompileYaraRules("rule jumps { strings: $a = { 4D [-] 5A [-] 00 } condition: $a }")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	if matches := rules.Scan(bytes.Repeat([]byte{0x4d, 0x5a}, 2048)); len(matches) != 1 || !matches[0].Incomplete {
+		t.Errorf("matches not match\nexpected: incomplete jumps, actual: %v", matches)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("scan of unbounded jumps not bounded\nexpected: < 5s, actual: %s", elapsed)
+	}
+	if matches := rules.Scan([]byte("MZ\x90\x00")); len(matches) != 1 || matches[0].Incomplete {
+		t.Errorf("matches not match\nexpected: 1, actual: %v", matches)
+	}
+
+	// backtracking is limited at each offset, so a match near the end of a large file is found
+	rules, err = CompileYaraRules("rule tail { strings: $a = { 00 [0-4] 41 42 43 44 } condition: $a }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 4*1024*1024)
+	copy(data[len(data)-8:], "\x00\x00\x00ABCD")
+	if matches := rules.Scan(data); len(matches) != 1 || matches[0].Incomplete {
+		t.Errorf("matches of large file not match\nexpected: tail, actual: %v", matches)
+	}
+}
+
+func TestLoadYaraRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yara")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a_pe.yar":      "import \"pe\"\nrule pe_dll { condition: pe.is_dll() }",
+		"b_loop.yar":    "rule before_loop { condition: true }\nrule loop { strings: $a = \"x\" condition: for any i in (1..2) : ($a) }",
+		"c_include.yar": "include \"other.yar\"",
+		"d_dropper.yar": "rule dropper { strings: $a = \"malware\" condition: $a }",
+		"e_xor.yara":    "rule xored { strings: $a = \"x\" xor condition: $a }",
+		"f_loop.yar":    "rule before_loop { strings: $a = \"loop\" condition: $a }",
+	}
+	for name, source := range files {
+		_ = ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644)
+	}
+
+	// files with unsupported features are skipped along with their other rules
+	rules, err := LoadYaraRules(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, match := range rules.Scan([]byte("malware loop")) {
+		names = append(names, match.Rule)
+	}
+	if expected := "dropper before_loop"; strings.Join(names, " ") != expected {
+		t.Errorf("loaded rules not match\nexpected: %s, actual: %s", expected, strings.Join(names, " "))
+	}
+	if skipped := rules.Skipped(); len(skipped) != 4 || !strings.Contains(skipped[0], "a_pe.yar - line 1: import is not supported") {
+		t.Errorf("skipped rule files not match\nexpected: a_pe, b_loop, c_include and e_xor, actual: %v", skipped)
+	}
+
+	if _, err := LoadYaraRules(filepath.Join(dir, "a_pe.yar")); err == nil {
+		t.Errorf("rule file error not match\nexpected: import is not supported, actual: nil")
+	}
+	_ = os.Remove(filepath.Join(dir, "d_dropper.yar"))
+	_ = os.Remove(filepath.Join(dir, "f_loop.yar"))
+	if _, err := LoadYaraRules(dir); err == nil || !strings.Contains(err.Error(), "no rules found") {
+		t.Errorf("rule directory error not match\nexpected: no rules found, actual: %v", err)
+	}
+}
+
+func TestScanArtifactRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runPath := writeTestRun(t, dir)
+	rulePath := filepath.Join(dir, "rules")
+	_ = os.MkdirAll(rulePath, os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(rulePath, "dropper.yar"), []byte(`rule dropper : dropped { strings: $ = "malware" condition: all of them }`), 0644)
+	_ = ioutil.WriteFile(filepath.Join(rulePath, "passwd.yara"), []byte(`rule passwd { strings: $root = "root:x:0:0" condition: $root }`), 0644)
+	_ = ioutil.WriteFile(filepath.Join(rulePath, "README"), []byte("not a rule"), 0644)
+
+	rules, err := LoadYaraRules(rulePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only files added or changed by the attacker
+	matches, err := ScanArtifactRun(rules, runPath, YaraScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Rule != "dropper" || matches[0].Path != "/tmp/x" || matches[0].SHA256 != "2f293f67aa33f2ce247b28d6fb2fef2623cfde731f96b3d7f84ae74e9e192bdd" {
+		t.Errorf("changed file matches not match\nexpected: dropper on /tmp/x, actual: %+v", matches)
+	}
+
+	// whole export, skipping files over the size limit
+	matches, _ = ScanArtifactRun(rules, runPath, YaraScanOptions{Export: true})
+	if len(matches) != 2 || matches[0].Path != "/etc/passwd" {
+		t.Errorf("export matches not match\nexpected: passwd and dropper, actual: %+v", matches)
+	}
+	matches, _ = ScanArtifactRun(rules, runPath, YaraScanOptions{Export: true, MaxFileSize: 8})
+	if len(matches) != 1 || matches[0].Path != "/tmp/x" {
+		t.Errorf("size limited matches not match\nexpected: dropper on /tmp/x, actual: %+v", matches)
+	}
+
+	manifest := Manifest{Pot: "ssh", FinishedAt: time.Unix(1600000060, 0), YaraMatches: matches}
+	events := YaraEvents(manifest)
+	if len(events) != 1 || events[0].Kind != EventYara || events[0].Severity != SeverityHigh || events[0].Fields["tags"] != "dropped" {
+		t.Errorf("yara events not match\nexpected: high severity yara event, actual: %+v", events)
+	}
+}
+
+// fakeYaraBinary stands in for the yara binary: rule files containing "broken" fail to compile and
+// files containing "malware" match the dropper rule.
+const fakeYaraBinary = `#!/bin/sh
+for last; do :; done
+if [ "$last" = /dev/null ]; then
+	if grep -q broken "$2"; then echo "$2(1): error: syntax error" >&2; exit 1; fi
+	exit 0
+fi
+for file in "$last"/*; do
+	if grep -q malware "$file"; then printf 'dropper [dropped,linux] %s\n0x0:$a: malware\n0x9:$a: malware\n0x2:$b: lw\n' "$file"; fi
+done
+`
+
+func TestYaraBinaryRules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	dir, err := ioutil.TempDir("", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "yara")
+	_ = ioutil.WriteFile(binary, []byte(fakeYaraBinary), 0755)
+	runPath := writeTestRun(t, dir)
+	rulePath := filepath.Join(dir, "rules")
+	_ = os.MkdirAll(rulePath, os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(rulePath, "broken.yar"), []byte("rule broken {"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(rulePath, "dropper.yar"), []byte("import \"pe\"\nrule dropper : dropped linux { strings: $a = \"malware\" $b = \"lw\" condition: all of them and pe.number_of_sections == 0 }"), 0644)
+
+	rules, err := LoadYaraBinaryRules(rulePath, binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.Len() != 1 || len(rules.Skipped()) != 1 || !strings.Contains(rules.Skipped()[0], "broken.yar - ") {
+		t.Errorf("binary rules not match\nexpected: 1 rule and broken.yar skipped, actual: %d %v", rules.Len(), rules.Skipped())
+	}
+
+	matches, err := ScanArtifactRun(rules, runPath, YaraScanOptions{Export: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Rule != "dropper" || matches[0].Path != "/tmp/x" || strings.Join(matches[0].Tags, " ") != "dropped linux" ||
+		strings.Join(matches[0].Strings, " ") != "$a $b" || matches[0].SHA256 != "2f293f67aa33f2ce247b28d6fb2fef2623cfde731f96b3d7f84ae74e9e192bdd" {
+		t.Errorf("binary matches not match\nexpected: dropper on /tmp/x, actual: %+v", matches)
+	}
+
+	if _, err := LoadYaraBinaryRules(filepath.Join(rulePath, "broken.yar"), binary); err == nil || !strings.Contains(err.Error(), "syntax error") {
+		t.Errorf("rule file error not match\nexpected: syntax error, actual: %v", err)
+	}
+}